@@ -22,6 +22,16 @@ func NewBooleanStorage(keys ...string) *booleanStorage {
 	return &booleanStorage{values, changeTimestamps}
 }
 
+// adds a boolean (initialized to false) if it doesn't exist yet
+func (b *booleanStorage) Define(key string) {
+	if _, exists := b.values[key]; exists {
+		return
+	}
+
+	b.values[key] = false
+	b.changeTimestamps[key] = time.Time{} // zero
+}
+
 func (b *booleanStorage) GetLastChangeTime(key string) (time.Time, error) {
 	changeTimestamp, exists := b.changeTimestamps[key]
 	if !exists {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"html/template"
 	"net/http"
 	"sort"
@@ -18,6 +19,16 @@ const tpl = `
 </head>
 <body>
 
+{{range .}}
+<h2>{{.Name}}</h2>
+
+{{if .Area}}
+<p>
+	occupied: {{.Area.Occupied}}
+	{{if .AverageTemperatureFormatted}}| avg temp: {{.AverageTemperatureFormatted}}{{end}}
+</p>
+{{end}}
+
 <table>
 <thead>
 <tr>
@@ -31,7 +42,7 @@ const tpl = `
 </tr>
 </thead>
 <tbody>
-{{range .}}
+{{range .Devices}}
 <tr>
 	<td>{{.Device.ProbablyTurnedOn}}</td>
 	<td>{{.Device.Conf.DeviceId}}</td>
@@ -52,6 +63,7 @@ const tpl = `
 {{end}}
 </tbody>
 </table>
+{{end}}
 
 </body>
 </html>
@@ -101,9 +113,38 @@ func makeHttpServer(app *Application, conf *hapitypes.ConfigFile) *http.Server {
 			LastOnlineFormatted string
 		}
 
+		type AreaWithDevices struct {
+			Name                        string
+			Area                        *hapitypes.Area // nil for devices without area
+			AverageTemperatureFormatted string
+			Devices                     []DeviceWithComputed
+		}
+
 		now := time.Now()
 
-		devicesComputed := []DeviceWithComputed{}
+		// areas in the order they were defined in, devices without area last
+		areas := []*AreaWithDevices{}
+		areaViewById := map[string]*AreaWithDevices{}
+		for _, areaConf := range conf.Areas {
+			area := app.areaById[areaConf.Id]
+
+			averageTemperatureFormatted := ""
+			if avg, ok := area.AverageTemperature(); ok {
+				averageTemperatureFormatted = fmt.Sprintf("%.1f °C", avg)
+			}
+
+			areaView := &AreaWithDevices{
+				Name:                        areaConf.Name,
+				Area:                        area,
+				AverageTemperatureFormatted: averageTemperatureFormatted,
+			}
+
+			areas = append(areas, areaView)
+			areaViewById[areaConf.Id] = areaView
+		}
+		noArea := &AreaWithDevices{Name: "No area"}
+		areas = append(areas, noArea)
+
 		for _, device := range devices {
 			lastOnlineFormatted := ""
 
@@ -111,13 +152,18 @@ func makeHttpServer(app *Application, conf *hapitypes.ConfigFile) *http.Server {
 				lastOnlineFormatted = now.Sub(*device.LastOnline).String()
 			}
 
-			devicesComputed = append(devicesComputed, DeviceWithComputed{
+			areaView, found := areaViewById[device.Conf.Area]
+			if !found {
+				areaView = noArea
+			}
+
+			areaView.Devices = append(areaView.Devices, DeviceWithComputed{
 				Device:              device,
 				LastOnlineFormatted: lastOnlineFormatted,
 			})
 		}
 
-		if err := tmpl.Execute(w, devicesComputed); err != nil {
+		if err := tmpl.Execute(w, areas); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -4,12 +4,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/function61/gokit/app/dynversion"
 	"github.com/function61/gokit/log/logex"
 	"github.com/function61/gokit/os/osutil"
 	"github.com/function61/gokit/os/systemdinstaller"
 	"github.com/function61/hautomo/pkg/ezstack/ezhub"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
 	"github.com/spf13/cobra"
 )
 
@@ -76,6 +78,7 @@ func ezhubEntrypoint() *cobra.Command {
 	packetCapture := ""
 	joinEnable := false
 	settingsFlash := false
+	serialSim := false
 	install := false
 
 	cmd := &cobra.Command{
@@ -107,14 +110,16 @@ func ezhubEntrypoint() *cobra.Command {
 				joinEnable,
 				packetCapture,
 				settingsFlash,
+				serialSim,
 				rootLogger))
 		},
 	}
 
 	cmd.Flags().BoolVarP(&install, "install", "", install, "Install Systemd unit file to start this on startup")
-	cmd.Flags().StringVarP(&packetCapture, "packet-capture", "", packetCapture, "Capture received UNP frames to a file")
+	cmd.Flags().StringVarP(&packetCapture, "packet-capture", "", packetCapture, "Capture ZNP frames to a pcap-ng file (open with Wireshark)")
 	cmd.Flags().BoolVarP(&joinEnable, "join-enable", "", joinEnable, "Enable devices joining this network (for a short time)")
 	cmd.Flags().BoolVarP(&settingsFlash, "settings-flash", "", settingsFlash, "Temporary flag to indicate flashing Zigbee radio settings")
+	cmd.Flags().BoolVarP(&serialSim, "serial-sim", "", serialSim, "Use a simulated Zigbee radio with demo devices (no hardware needed)")
 
 	cmd.AddCommand(&cobra.Command{
 		Use:   "new-config",
@@ -125,5 +130,73 @@ func ezhubEntrypoint() *cobra.Command {
 		},
 	})
 
+	cmd.AddCommand(&cobra.Command{
+		Use:   "backup [file]",
+		Short: "Back up Zigbee network from the radio (stop ezhub first)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			osutil.ExitIfError(ezhub.Backup(
+				osutil.CancelOnInterruptOrTerminate(logex.StandardLogger()),
+				args[0]))
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "restore [file]",
+		Short: "Restore Zigbee network to the radio from backup (stop ezhub first)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			osutil.ExitIfError(ezhub.Restore(
+				osutil.CancelOnInterruptOrTerminate(logex.StandardLogger()),
+				args[0]))
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "energy-scan",
+		Short: "Measure interference on each Zigbee channel (stop ezhub first)",
+		Args:  cobra.NoArgs,
+		Run: func(_ *cobra.Command, _ []string) {
+			osutil.ExitIfError(ezhub.EnergyScan(
+				osutil.CancelOnInterruptOrTerminate(logex.StandardLogger())))
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "channel-change [channel]",
+		Short: "Move Zigbee network to another channel, keeping devices paired (stop ezhub first)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			channel, err := strconv.ParseUint(args[0], 10, 8)
+			osutil.ExitIfError(err)
+
+			osutil.ExitIfError(ezhub.ChangeChannel(
+				osutil.CancelOnInterruptOrTerminate(logex.StandardLogger()),
+				uint8(channel)))
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "network-key-rotate",
+		Short: "Switch Zigbee network to a new network key, keeping devices paired (stop ezhub first)",
+		Args:  cobra.NoArgs,
+		Run: func(_ *cobra.Command, _ []string) {
+			osutil.ExitIfError(ezhub.RotateNetworkKey(
+				osutil.CancelOnInterruptOrTerminate(logex.StandardLogger())))
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "install-code-add [device] [installCode]",
+		Short: "Allow a device that requires an install code to join (stop ezhub first)",
+		Args:  cobra.ExactArgs(2),
+		Run: func(_ *cobra.Command, args []string) {
+			osutil.ExitIfError(ezhub.AddInstallCode(
+				osutil.CancelOnInterruptOrTerminate(logex.StandardLogger()),
+				zigbee.IEEEAddress(args[0]),
+				args[1]))
+		},
+	})
+
 	return cmd
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"time"
+
+	"github.com/function61/hautomo/pkg/constmetrics"
+	"github.com/function61/hautomo/pkg/hapitypes"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics about the hub's internals. device/sensor metrics are in constMetrics.
+type hubMetrics struct {
+	inboundEvents       *prometheus.CounterVec
+	actionFailures      *prometheus.CounterVec
+	subscriptionMatches *prometheus.CounterVec
+}
+
+func newHubMetrics() *hubMetrics {
+	return &hubMetrics{
+		inboundEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ha_inbound_events_total",
+			Help: "Inbound events handled, by event type",
+		}, []string{"type"}),
+		actionFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ha_action_failures_total",
+			Help: "Subscription actions that failed, by verb",
+		}, []string{"verb"}),
+		subscriptionMatches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ha_subscription_matches_total",
+			Help: "Published events that matched a subscription (whose conditions passed)",
+		}, []string{"event"}),
+	}
+}
+
+func (m *hubMetrics) register() {
+	prometheus.MustRegister(m.inboundEvents, m.actionFailures, m.subscriptionMatches)
+}
+
+// queue depth is read from the channel on scrape, so we don't need to observe it ourselves
+func (m *hubMetrics) registerOutboundQueueDepth(adapter *hapitypes.Adapter) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "ha_adapter_outbound_queue_depth",
+		Help:        "Outbound events waiting to be processed by the adapter",
+		ConstLabels: prometheus.Labels{"adapter": adapter.Conf.Id},
+	}, func() float64 {
+		return float64(len(adapter.Outbound))
+	}))
+}
+
+// metrics are only collected after the first observation, so it's safe to register metrics
+// that the device will never report
+func registerDeviceMetrics(device *hapitypes.Device, constMetrics *constmetrics.Collector) {
+	labels := prometheus.Labels{
+		"sensor": device.Conf.DeviceId,
+		"area":   device.Conf.Area,
+		"type":   device.Conf.Type,
+	}
+
+	device.LinkQualityMetric = constMetrics.Register(
+		"ha_link_quality",
+		"Link quality [%]",
+		labels)
+
+	if device.DeviceType.BatteryType != "" {
+		device.BatteryPctMetric = constMetrics.Register(
+			"ha_battery_pct",
+			"Battery [%]",
+			labels)
+	}
+
+	if device.DeviceType.Capabilities.ReportsTemperature {
+		device.TemperatureMetric = constMetrics.Register(
+			"ha_temperature",
+			"Temperature in Celsius",
+			labels)
+		device.HumidityMetric = constMetrics.Register(
+			"ha_humidity",
+			"Relative humidity [%]",
+			labels)
+		device.PressureMetric = constMetrics.Register(
+			"ha_pressure",
+			"Air pressure [hPa]",
+			labels)
+	}
+
+	device.IlluminanceMetric = constMetrics.Register(
+		"ha_illuminance",
+		"Illuminance [lux]",
+		labels)
+	device.MotionMetric = constMetrics.Register(
+		"ha_motion",
+		"Motion detected (1) or not (0)",
+		labels)
+	device.MotionCountMetric = constMetrics.RegisterCounter(
+		"ha_motion_total",
+		"Times motion was detected",
+		labels)
+	device.ContactMetric = constMetrics.Register(
+		"ha_contact",
+		"Contact (1 = closed) or no contact (0 = open)",
+		labels)
+	device.ContactCountMetric = constMetrics.RegisterCounter(
+		"ha_contact_changes_total",
+		"Times contact state changed",
+		labels)
+	device.WaterLeakMetric = constMetrics.Register(
+		"ha_water_leak",
+		"Water detected (1) or not (0)",
+		labels)
+
+	if device.DeviceType.Capabilities.Power {
+		device.PowerMetric = constMetrics.Register(
+			"ha_power_on",
+			"Device (probably) turned on (1) or off (0)",
+			labels)
+	}
+}
+
+func (a *Application) observePowerStates(now time.Time) {
+	for _, device := range a.deviceById {
+		if device.PowerMetric == nil {
+			continue
+		}
+
+		a.constMetrics.Observe(device.PowerMetric, boolToFloat(a.powerManager.GetActual(device.Conf.DeviceId)), now)
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
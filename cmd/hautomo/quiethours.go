@@ -0,0 +1,83 @@
+package main
+
+import (
+	"time"
+
+	"github.com/function61/hautomo/pkg/hapitypes"
+)
+
+// a boolean-driven window (e.g. guests sleeping) can stay on for days. only the newest are worth
+// delivering anyway
+const quietHoursMaxSuppressed = 50
+
+// decides whether speech/notifications should be held back so they don't disturb sleeping
+// people. held back messages are delivered once quiet hours end.
+type quietHours struct {
+	windows    []hapitypes.QuietHoursConfig
+	booleans   *booleanStorage
+	suppressed []hapitypes.InboundEvent // delivered in order when quiet hours end. oldest dropped when full
+}
+
+func newQuietHours(windows []hapitypes.QuietHoursConfig, booleans *booleanStorage) (*quietHours, error) {
+	if len(windows) == 0 {
+		windows = hapitypes.DefaultQuietHours
+	}
+
+	for _, window := range windows {
+		if err := window.Valid(); err != nil {
+			return nil, err
+		}
+
+		if window.Boolean != "" {
+			// so config doesn't need to separately declare the boolean
+			booleans.Define(window.Boolean)
+		}
+	}
+
+	return &quietHours{
+		windows:    windows,
+		booleans:   booleans,
+		suppressed: []hapitypes.InboundEvent{},
+	}, nil
+}
+
+func (q *quietHours) Active(now time.Time) bool {
+	for _, window := range q.windows {
+		if window.Boolean != "" {
+			if active, _ := q.booleans.Get(window.Boolean); active {
+				return true
+			}
+		} else if window.CoversTime(now) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// returns true if the event was queued for later delivery (= caller should not deliver it now)
+func (q *quietHours) Suppress(event hapitypes.InboundEvent, priority hapitypes.Priority, now time.Time) bool {
+	if priority == hapitypes.PriorityCritical || !q.Active(now) {
+		return false
+	}
+
+	if len(q.suppressed) >= quietHoursMaxSuppressed {
+		q.suppressed = q.suppressed[1:]
+	}
+
+	q.suppressed = append(q.suppressed, event)
+
+	return true
+}
+
+// if quiet hours have ended, returns (and forgets) the events that were suppressed
+func (q *quietHours) TakeDeliverable(now time.Time) []hapitypes.InboundEvent {
+	if len(q.suppressed) == 0 || q.Active(now) {
+		return nil
+	}
+
+	deliverable := q.suppressed
+	q.suppressed = []hapitypes.InboundEvent{}
+
+	return deliverable
+}
@@ -8,7 +8,6 @@ import (
 	"time"
 
 	"github.com/function61/gokit/app/dynversion"
-	"github.com/function61/gokit/encoding/jsonfile"
 	"github.com/function61/gokit/log/logex"
 	"github.com/function61/gokit/net/http/httputils"
 	"github.com/function61/gokit/os/osutil"
@@ -29,8 +28,13 @@ type Application struct {
 	inbound       *hapitypes.InboundFabric
 	booleans      *booleanStorage
 	constMetrics  *constmetrics.Collector
+	metrics       *hubMetrics
 	logl          *logex.Leveled
 	policyEngine  *policyEngine
+	quietHours    *quietHours
+
+	deviceGroupById map[string]*hapitypes.DeviceGroup
+	areaById        map[string]*hapitypes.Area
 }
 
 func NewApplication(logger *log.Logger) *Application {
@@ -44,10 +48,15 @@ func NewApplication(logger *log.Logger) *Application {
 		inbound:       hapitypes.NewInboundFabric(logex.Levels(logger)),
 		booleans:      NewBooleanStorage("anybodyHome", "environmentHasLight"),
 		constMetrics:  constmetrics.NewCollector(),
+		metrics:       newHubMetrics(),
 		logl:          logex.Levels(logger),
+
+		deviceGroupById: map[string]*hapitypes.DeviceGroup{},
+		areaById:        map[string]*hapitypes.Area{},
 	}
 
 	prometheus.MustRegister(app.constMetrics)
+	app.metrics.register()
 
 	_, _ = app.booleans.Set("anybodyHome", true)
 	app.updateEnvironmentLightStatus(false)
@@ -73,9 +82,19 @@ func (a *Application) task(ctx context.Context) error {
 			// TODO: generate a tick inbound event, and thus we'd be able to use
 			//       handleIncomingEvent() for this?
 			a.applyPowerDiffs()
+
+			a.updateAreaOccupancy(time.Now())
+
+			a.observePowerStates(time.Now())
 		case <-everyMinute.C:
 			a.updateEnvironmentLightStatus(true)
 
+			for _, event := range a.quietHours.TakeDeliverable(time.Now()) {
+				a.logl.Info.Printf("quiet hours ended - delivering suppressed %s", event.InboundEventType())
+
+				a.handleIncomingEvent(event)
+			}
+
 			if err := a.saveStateSnapshot(); err != nil {
 				a.logl.Error.Printf("failed saving state: %v", err)
 			}
@@ -115,6 +134,55 @@ func (a *Application) applyPowerDiffs() {
 
 		a.powerManager.CommitDiff(diff)
 	}
+
+	a.updateDeviceGroupPowerStates()
+}
+
+// group is on if any of its members is on. this doesn't send any messages - if the user
+// toggles the group, toggle is computed from this state.
+func (a *Application) updateDeviceGroupPowerStates() {
+	for _, group := range a.deviceGroupById {
+		on := a.anyGroupMemberOn(group)
+
+		if a.powerManager.GetActual(group.Id) != on {
+			if on {
+				a.powerManager.SetBypassingDiffs(group.Id, hapitypes.PowerKindOn)
+			} else {
+				a.powerManager.SetBypassingDiffs(group.Id, hapitypes.PowerKindOff)
+			}
+
+			a.deviceById[group.Id].ProbablyTurnedOn = on
+		}
+	}
+}
+
+func (a *Application) anyGroupMemberOn(group *hapitypes.DeviceGroup) bool {
+	for _, memberId := range group.DeviceIds {
+		if nestedGroup, isGroup := a.deviceGroupById[memberId]; isGroup {
+			if a.anyGroupMemberOn(nestedGroup) { // cycles were rejected on config load
+				return true
+			}
+		} else if a.powerManager.GetActual(memberId) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// publishes "area:<id>:occupied" | "area:<id>:vacant" when area's occupancy changes
+func (a *Application) updateAreaOccupancy(now time.Time) {
+	for _, area := range a.areaById {
+		if !area.UpdateOccupied(now) {
+			continue
+		}
+
+		if area.Occupied {
+			a.publish(fmt.Sprintf("area:%s:occupied", area.Conf.Id))
+		} else {
+			a.publish(fmt.Sprintf("area:%s:vacant", area.Conf.Id))
+		}
+	}
 }
 
 func (a *Application) updateEnvironmentLightStatus(broadcastChanges bool) {
@@ -139,13 +207,15 @@ func (a *Application) saveStateSnapshot() error {
 		statefile.Devices[device.Conf.DeviceId] = *snap
 	}
 
-	return jsonfile.Write(statefilePath, &statefile)
+	return hapitypes.WriteStatefile(statefilePath, statefile)
 }
 
 func (a *Application) handleIncomingEvent(inboundEvent hapitypes.InboundEvent) {
 	// TODO: maybe record this in the inbound event, so we can get more accurate time
 	now := time.Now()
 
+	a.metrics.inboundEvents.WithLabelValues(inboundEvent.InboundEventType()).Inc()
+
 	switch e := inboundEvent.(type) {
 	case *hapitypes.PersonPresenceChangeEvent:
 		a.logl.Info.Printf(
@@ -158,7 +228,7 @@ func (a *Application) handleIncomingEvent(inboundEvent hapitypes.InboundEvent) {
 		// for explicit (= non-computed. computed are like events and policies) sets we
 		// want to force a diff so the power is acted on if the power state is different
 		// than what home automation thinks it currently should be
-		if e.Explicit || isDeviceGroup(device) {
+		if e.Explicit || device.IsGroup() {
 			device.LastExplicitPowerEvent = &now
 
 			a.powerManager.SetExplicit(device.Conf.DeviceId, e.Kind)
@@ -191,6 +261,9 @@ func (a *Application) handleIncomingEvent(inboundEvent hapitypes.InboundEvent) {
 
 		a.powerManager.SetBypassingDiffs(device.Conf.DeviceId, hapitypes.PowerKindOn)
 
+		brightness := e.Brightness
+		device.LastBrightness = &brightness
+
 		adapter.Send(hapitypes.NewBrightnessMsg(
 			device.Conf.AdaptersDeviceId,
 			e.Brightness,
@@ -213,6 +286,11 @@ func (a *Application) handleIncomingEvent(inboundEvent hapitypes.InboundEvent) {
 			device.Conf.AdaptersDeviceId,
 			e.Position))
 	case *hapitypes.SpeakEvent:
+		if a.quietHours.Suppress(e, e.Priority, now) {
+			a.logl.Info.Println("quiet hours - queueing speak for later")
+			return
+		}
+
 		device := a.deviceById[e.Device]
 		adapter := a.adapterById[device.Conf.AdapterId]
 
@@ -222,11 +300,6 @@ func (a *Application) handleIncomingEvent(inboundEvent hapitypes.InboundEvent) {
 			return
 		}
 
-		if somebodyMightBeSleeping() {
-			a.logl.Info.Println("suppressing speak due to somebodyMightBeSleeping")
-			return
-		}
-
 		adapter.Send(hapitypes.NewPlaySoundEvent(
 			device.Conf.AdaptersDeviceId,
 			url))
@@ -243,10 +316,15 @@ func (a *Application) handleIncomingEvent(inboundEvent hapitypes.InboundEvent) {
 
 		adapter.Send(hapitypes.NewBlinkEvent(device.Conf.AdaptersDeviceId))
 	case *hapitypes.NotificationEvent:
+		if a.quietHours.Suppress(e, e.Priority, now) {
+			a.logl.Info.Println("quiet hours - queueing notification for later")
+			return
+		}
+
 		device := a.deviceById[e.Device]
 		adapter := a.adapterById[device.Conf.AdapterId]
 
-		adapter.Send(hapitypes.NewNotificationEvent(device.Conf.AdaptersDeviceId, e.Message))
+		adapter.Send(hapitypes.NewNotificationEvent(device.Conf.AdaptersDeviceId, e.Message, e.Priority))
 	case *hapitypes.InfraredEvent:
 		device := a.deviceById[e.Device]
 		adapter := a.adapterById[device.Conf.AdapterId]
@@ -258,8 +336,17 @@ func (a *Application) handleIncomingEvent(inboundEvent hapitypes.InboundEvent) {
 		dev := a.updateLastOnline(e.Device)
 		if e.Movement {
 			dev.LastMotion = &now
+
+			a.constMetrics.Add(dev.MotionCountMetric, 1, now)
+		}
+		a.constMetrics.Observe(dev.MotionMetric, boolToFloat(e.Movement), now)
+		if e.Illuminance != nil { // not all motion sensors have a lux sensor
+			a.constMetrics.Observe(dev.IlluminanceMetric, float64(*e.Illuminance), now)
 		}
+
 		a.publish(fmt.Sprintf("motion:%s:%v", e.Device, e.Movement))
+
+		a.updateAreaOccupancy(now)
 	case *hapitypes.ContactEvent:
 		dev := a.updateLastOnline(e.Device)
 		contactChanged := false
@@ -267,7 +354,10 @@ func (a *Application) handleIncomingEvent(inboundEvent hapitypes.InboundEvent) {
 			contactChanged = dev.LastContact.Contact != e.Contact
 		}
 		dev.LastContact = e
+		a.constMetrics.Observe(dev.ContactMetric, boolToFloat(e.Contact), now)
 		if contactChanged {
+			a.constMetrics.Add(dev.ContactCountMetric, 1, now)
+
 			a.publish(fmt.Sprintf("contact:%s:%v", e.Device, e.Contact))
 		}
 	case *hapitypes.VibrationEvent:
@@ -277,7 +367,8 @@ func (a *Application) handleIncomingEvent(inboundEvent hapitypes.InboundEvent) {
 		a.updateLastOnline(e.Device)
 		a.publish(fmt.Sprintf("pushbutton:%s:%s", e.Device, e.Specifier))
 	case *hapitypes.WaterLeakEvent:
-		a.updateLastOnline(e.Device)
+		dev := a.updateLastOnline(e.Device)
+		a.constMetrics.Observe(dev.WaterLeakMetric, boolToFloat(e.WaterDetected), now)
 		a.publish(fmt.Sprintf("waterleak:%s:%v", e.Device, e.WaterDetected))
 	case *hapitypes.LinkQualityEvent:
 		a.updateLastOnline(e.Device)
@@ -370,10 +461,14 @@ func (a *Application) publish(event string) {
 		}
 	}
 
+	a.metrics.subscriptionMatches.WithLabelValues(event).Inc()
+
 	// run async, so sleep actions don't disturb handling of actions before/after sleeping
 	go func() {
 		for _, action := range subscription.Actions {
 			if err := a.runAction(action); err != nil {
+				a.metrics.actionFailures.WithLabelValues(action.Verb).Inc()
+
 				a.logl.Error.Printf("failure running action: %v", err)
 			}
 		}
@@ -384,6 +479,10 @@ func (a *Application) runAction(action hapitypes.ActionConfig) error {
 	// state changes by these actions go via the inbound channel, i.e. act like they came from adapters
 	// just like all other state changes
 
+	if action.Area != "" {
+		return a.runAreaAction(action)
+	}
+
 	switch action.Verb {
 	case "sleep":
 		time.Sleep(time.Duration(action.DurationSeconds) * time.Second)
@@ -396,7 +495,12 @@ func (a *Application) runAction(action hapitypes.ActionConfig) error {
 	case "blink":
 		a.inbound.Receive(hapitypes.NewBlinkEvent(action.Device))
 	case "speak":
-		a.inbound.Receive(hapitypes.NewSpeakEvent(action.Device, action.SpeakPhrase))
+		priority, err := hapitypes.ParsePriority(action.Priority)
+		if err != nil {
+			return err
+		}
+
+		a.inbound.Receive(hapitypes.NewSpeakEvent(action.Device, action.SpeakPhrase, priority))
 	case "setBooleanTrue":
 		fallthrough
 	case "setBooleanFalse":
@@ -422,9 +526,15 @@ func (a *Application) runAction(action hapitypes.ActionConfig) error {
 			action.Device,
 			action.PlaybackAction))
 	case "notify":
+		priority, err := hapitypes.ParsePriority(action.Priority)
+		if err != nil {
+			return err
+		}
+
 		a.inbound.Receive(hapitypes.NewNotificationEvent(
 			action.Device,
-			action.NotifyMessage))
+			action.NotifyMessage,
+			priority))
 	case "cover_up":
 		a.inbound.Receive(hapitypes.NewCoverPositionEvent(
 			action.Device,
@@ -440,6 +550,79 @@ func (a *Application) runAction(action hapitypes.ActionConfig) error {
 	return nil
 }
 
+// runs the action separately for each device in the area that has the capability the verb needs.
+// devices in a device group that's in the area get it via the group only
+func (a *Application) runAreaAction(action hapitypes.ActionConfig) error {
+	area, found := a.areaById[action.Area]
+	if !found {
+		return fmt.Errorf("area not found: %s", action.Area)
+	}
+
+	verbSupported, found := areaVerbs[action.Verb]
+	if !found {
+		return fmt.Errorf("verb %s cannot target an area", action.Verb)
+	}
+
+	targets := []*hapitypes.Device{}
+	for _, device := range area.Devices {
+		if !verbSupported(device.DeviceType.Capabilities) {
+			continue
+		}
+
+		if action.DeviceClass != "" && device.Class() != hapitypes.DeviceClassById[action.DeviceClass] {
+			continue
+		}
+
+		targets = append(targets, device)
+	}
+
+	// a device that is also a member of a targeted group would get the command twice
+	reachedViaGroup := map[string]bool{}
+	for _, device := range targets {
+		if group, isGroup := a.deviceGroupById[device.Conf.DeviceId]; isGroup {
+			a.collectGroupMemberIds(group, reachedViaGroup)
+		}
+	}
+
+	for _, device := range targets {
+		if reachedViaGroup[device.Conf.DeviceId] {
+			continue
+		}
+
+		deviceAction := action
+		deviceAction.Area = ""
+		deviceAction.Device = device.Conf.DeviceId
+
+		if err := a.runAction(deviceAction); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// also members of nested groups (and the nested groups themselves)
+func (a *Application) collectGroupMemberIds(group *hapitypes.DeviceGroup, memberIds map[string]bool) {
+	for _, memberId := range group.DeviceIds {
+		memberIds[memberId] = true
+
+		if nestedGroup, isGroup := a.deviceGroupById[memberId]; isGroup {
+			a.collectGroupMemberIds(nestedGroup, memberIds) // cycles were rejected on config load
+		}
+	}
+}
+
+// verbs that can target all devices in an area, and which capability a device needs to be targeted
+var areaVerbs = map[string]func(caps hapitypes.Capabilities) bool{
+	"powerOn":     func(caps hapitypes.Capabilities) bool { return caps.Power },
+	"powerOff":    func(caps hapitypes.Capabilities) bool { return caps.Power },
+	"powerToggle": func(caps hapitypes.Capabilities) bool { return caps.Power },
+	"blink":       func(caps hapitypes.Capabilities) bool { return caps.Power },
+	"playback":    func(caps hapitypes.Capabilities) bool { return caps.Playback },
+	"cover_up":    func(caps hapitypes.Capabilities) bool { return caps.CoverPosition },
+	"cover_down":  func(caps hapitypes.Capabilities) bool { return caps.CoverPosition },
+}
+
 func (a *Application) textToSpeech(message string) (string, error) {
 	return "", errors.New("not implemented")
 }
@@ -459,21 +642,32 @@ func configureAppAndStartAdapters(
 			DevicegroupDevices: devGroup.Devices,
 		}
 
-		firstDeviceOfGroup := findDeviceConfig(devGroup.Devices[0], conf)
-		if firstDeviceOfGroup == nil {
-			return fmt.Errorf("device group device not found: %s", devGroup.Devices[0])
-		}
-
 		deviceConf := hapitypes.DeviceConfig{
-			DeviceId:    devGroup.DeviceId,
-			AdapterId:   adapterConf.Id,
-			Name:        devGroup.Name,
-			Description: deviceGroupDescription,
-			Type:        firstDeviceOfGroup.Type, // TODO: compute lowest common denominator type?
+			DeviceId:       devGroup.DeviceId,
+			AdapterId:      adapterConf.Id,
+			Name:           devGroup.Name,
+			Description:    "Device group",
+			Type:           hapitypes.DeviceTypeDeviceGroup, // actual type computed from members
+			DeviceClassId:  devGroup.DeviceClassId,
+			VoiceAssistant: devGroup.VoiceAssistant,
+			Area:           devGroup.Area,
 		}
 
 		conf.Adapters = append(conf.Adapters, adapterConf)
 		conf.Devices = append(conf.Devices, deviceConf)
+
+		app.deviceGroupById[devGroup.DeviceId] = hapitypes.NewDeviceGroup(
+			devGroup.DeviceId,
+			devGroup.Name,
+			devGroup.Devices)
+	}
+
+	for _, areaConf := range conf.Areas {
+		if _, exists := app.areaById[areaConf.Id]; exists {
+			return fmt.Errorf("duplicate area id %s", areaConf.Id)
+		}
+
+		app.areaById[areaConf.Id] = hapitypes.NewArea(areaConf)
 	}
 
 	definedAdapterIds := map[string]bool{} // for validating devices' adapter connections
@@ -483,9 +677,12 @@ func configureAppAndStartAdapters(
 
 	statefile := hapitypes.NewStatefile()
 	if exists, err := osutil.Exists(statefilePath); exists {
-		if err := jsonfile.ReadDisallowUnknownFields(statefilePath, &statefile); err != nil {
+		existing, err := hapitypes.ReadStatefile(statefilePath)
+		if err != nil {
 			return err
 		}
+
+		statefile = *existing
 	} else if err != nil {
 		return err
 	}
@@ -510,43 +707,27 @@ func configureAppAndStartAdapters(
 			}
 		}
 
-		device, err := hapitypes.NewDevice(deviceConf, snapshot)
+		deviceType, err := conf.ResolveDeviceType(deviceConf)
+		if err != nil {
+			return err
+		}
+
+		device, err := hapitypes.NewDevice(deviceConf, *deviceType, snapshot)
 		if err != nil {
 			return err
 		}
 
 		app.powerManager.Register(deviceConf.DeviceId, snapshot.ProbablyTurnedOn)
 
-		device.LinkQualityMetric = app.constMetrics.Register(
-			"ha_link_quality",
-			"Link quality [%]",
-			"sensor",
-			device.Conf.DeviceId)
-
-		if device.DeviceType.BatteryType != "" {
-			device.BatteryPctMetric = app.constMetrics.Register(
-				"ha_battery_pct",
-				"Battery [%]",
-				"sensor",
-				device.Conf.DeviceId)
-		}
-
-		if device.DeviceType.Capabilities.ReportsTemperature {
-			device.TemperatureMetric = app.constMetrics.Register(
-				"ha_temperature",
-				"Temperature in Celsius",
-				"sensor",
-				device.Conf.DeviceId)
-			device.HumidityMetric = app.constMetrics.Register(
-				"ha_humidity",
-				"Relative humidity [%]",
-				"sensor",
-				device.Conf.DeviceId)
-			device.PressureMetric = app.constMetrics.Register(
-				"ha_pressure",
-				"Air pressure, in [TODO]",
-				"sensor",
-				device.Conf.DeviceId)
+		registerDeviceMetrics(device, app.constMetrics)
+
+		if deviceConf.Area != "" {
+			area, found := app.areaById[deviceConf.Area]
+			if !found {
+				return fmt.Errorf("device %s area '%s' not found", deviceConf.DeviceId, deviceConf.Area)
+			}
+
+			area.Devices = append(area.Devices, device)
 		}
 
 		app.deviceById[deviceConf.DeviceId] = device
@@ -560,9 +741,21 @@ func configureAppAndStartAdapters(
 				subscription.Event)
 		}
 
+		for _, action := range subscription.Actions {
+			if err := validateAction(action, app); err != nil {
+				return fmt.Errorf("subscription %s: %w", subscription.Event, err)
+			}
+		}
+
 		app.subscriptions[subscription.Event] = &subscription
 	}
 
+	quietHours, err := newQuietHours(conf.QuietHours, app.booleans)
+	if err != nil {
+		return err
+	}
+	app.quietHours = quietHours
+
 	// we've to do this after device initialization because some adapters startup may need to access
 	// device state (via PowerManager)
 	for _, adapterConf := range conf.Adapters {
@@ -582,6 +775,8 @@ func configureAppAndStartAdapters(
 		})
 
 		app.adapterById[adapter.Conf.Id] = adapter
+
+		app.metrics.registerOutboundQueueDepth(adapter)
 	}
 
 	app.policyEngine = newPolicyEngine(
@@ -593,6 +788,31 @@ func configureAppAndStartAdapters(
 	return nil
 }
 
+// catches config errors on startup that we'd otherwise only see when the action runs
+func validateAction(action hapitypes.ActionConfig, app *Application) error {
+	if _, err := hapitypes.ParsePriority(action.Priority); err != nil {
+		return err
+	}
+
+	if action.Area != "" {
+		if _, found := app.areaById[action.Area]; !found {
+			return fmt.Errorf("area not found: %s", action.Area)
+		}
+
+		if _, found := areaVerbs[action.Verb]; !found {
+			return fmt.Errorf("verb %s cannot target an area", action.Verb)
+		}
+	}
+
+	if action.DeviceClass != "" {
+		if _, found := hapitypes.DeviceClassById[action.DeviceClass]; !found {
+			return fmt.Errorf("device_class not found: %s", action.DeviceClass)
+		}
+	}
+
+	return nil
+}
+
 func runServer(ctx context.Context, logger *log.Logger) error {
 	logl := logex.Levels(logger)
 
@@ -622,11 +842,3 @@ func runServer(ctx context.Context, logger *log.Logger) error {
 
 	return tasks.Wait()
 }
-
-// needed for ugly isDeviceGroup()
-const deviceGroupDescription = "Device group"
-
-// FIXME: ugly
-func isDeviceGroup(device *hapitypes.Device) bool {
-	return device.Conf.Description == deviceGroupDescription
-}
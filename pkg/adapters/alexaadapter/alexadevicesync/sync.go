@@ -22,6 +22,7 @@ type AlexaConnectorDevice struct {
 	Description     string   `json:"description"`
 	DisplayCategory string   `json:"display_category"`
 	CapabilityCodes []string `json:"capability_codes"`
+	Area            string   `json:"area,omitempty"` // area's name, e.g. "Kitchen"
 }
 
 type AlexaConnectorSpec struct {
@@ -54,7 +55,7 @@ func createAlexaConnectorSpec(sqsAdapter hapitypes.AdapterConfig, conf *hapitype
 			continue
 		}
 
-		deviceType, err := hapitypes.ResolveDeviceType(device.Type)
+		deviceType, err := conf.ResolveDeviceType(device)
 		if err != nil {
 			return nil, err
 		}
@@ -92,12 +93,23 @@ func createAlexaConnectorSpec(sqsAdapter hapitypes.AdapterConfig, conf *hapitype
 			return nil, fmt.Errorf("device '%s': description and name cannot be empty", device.DeviceId)
 		}
 
+		area := ""
+		if device.Area != "" {
+			areaConf := conf.FindAreaConfig(device.Area)
+			if areaConf == nil {
+				return nil, fmt.Errorf("device '%s': area not found: %s", device.DeviceId, device.Area)
+			}
+
+			area = areaConf.Name
+		}
+
 		devices = append(devices, AlexaConnectorDevice{
 			Id:              device.DeviceId,
 			FriendlyName:    device.Name,
 			Description:     description,
 			DisplayCategory: deviceClass.AlexaCategory,
 			CapabilityCodes: alexaCapabilities,
+			Area:            area,
 		})
 	}
 
@@ -32,8 +32,9 @@ func Start(ctx context.Context, adapter *hapitypes.Adapter) error {
 	entityById := map[string]*homeassistant.Entity{}
 
 	allEntities := []*homeassistant.Entity{}
-	for _, dev := range adapter.GetConfigFileDeprecated().Devices {
-		typ, err := hapitypes.ResolveDeviceType(dev.Type)
+	confFile := adapter.GetConfigFileDeprecated()
+	for _, dev := range confFile.Devices {
+		typ, err := confFile.ResolveDeviceType(dev)
 		if err != nil {
 			return err
 		}
@@ -42,7 +43,18 @@ func Start(ctx context.Context, adapter *hapitypes.Adapter) error {
 			continue
 		}
 
-		switchEntity := homeassistant.NewSwitch(dev.AdaptersDeviceId, dev.Name)
+		opts := homeassistant.DiscoveryOptions{}
+		if dev.Area != "" {
+			if area := confFile.FindAreaConfig(dev.Area); area != nil {
+				opts.Device = &homeassistant.DiscoveryOptionsDevice{
+					Name:          dev.Name,
+					Identifiers:   []string{dev.DeviceId},
+					AreaSuggested: area.Name,
+				}
+			}
+		}
+
+		switchEntity := homeassistant.NewSwitchEntity(dev.AdaptersDeviceId, dev.Name, opts)
 		entityById[switchEntity.Id] = switchEntity
 		allEntities = append(allEntities, switchEntity)
 	}
@@ -52,9 +52,9 @@ type SJCGQ11LM struct {
 
 // {"illuminance":60,"linkquality":68,"occupancy":true}
 type RTCGQ11LM struct {
-	Occupancy   bool `json:"occupancy"`
-	Illuminance uint `json:"illuminance"`
-	LinkQuality uint `json:"linkquality"`
+	Occupancy   bool  `json:"occupancy"`
+	Illuminance *uint `json:"illuminance"`
+	LinkQuality uint  `json:"linkquality"`
 }
 
 // {"temperature":24.04,"linkquality":89,"humidity":25.91,"pressure":963,"battery":100,"voltage":3135}
@@ -63,6 +63,12 @@ BatteryStatusEvent {"Device":"dummyId","BatteryPct":100,"Voltage":3115}`,
 			input: `{"illuminance":60,"linkquality":68,"occupancy":true}`,
 			kind:  deviceKindRTCGQ11LM,
 			output: `MotionEvent {"Device":"dummyId","Movement":true,"Illuminance":60}
+LinkQualityEvent {"Device":"dummyId","LinkQuality":68}`,
+		},
+		{
+			input: `{"linkquality":68,"occupancy":false}`,
+			kind:  deviceKindRTCGQ11LM,
+			output: `MotionEvent {"Device":"dummyId","Movement":false,"Illuminance":null}
 LinkQualityEvent {"Device":"dummyId","LinkQuality":68}`,
 		},
 		{
@@ -1,6 +1,7 @@
 package constmetrics
 
 import (
+	"sort"
 	"sync"
 	"time"
 
@@ -10,8 +11,10 @@ import (
 type Ref struct {
 	idx          int
 	desc         *prometheus.Desc
-	labelValue   string
-	latestMetric prometheus.Metric // is nil until first Observe() call
+	valueType    prometheus.ValueType
+	labelValues  []string
+	value        float64           // needed for counters, as Add() is relative to previous value
+	latestMetric prometheus.Metric // is nil until first Observe() / Add() call
 }
 
 type Collector struct {
@@ -25,16 +28,44 @@ func NewCollector() *Collector {
 	}
 }
 
-func (c *Collector) Register(name string, help string, labelKey string, labelValue string) *Ref {
+// gauge, i.e. value can go up and down
+func (c *Collector) Register(name string, help string, labels prometheus.Labels) *Ref {
+	return c.register(name, help, labels, prometheus.GaugeValue)
+}
+
+// value can only increase (use Add())
+func (c *Collector) RegisterCounter(name string, help string, labels prometheus.Labels) *Ref {
+	return c.register(name, help, labels, prometheus.CounterValue)
+}
+
+func (c *Collector) register(
+	name string,
+	help string,
+	labels prometheus.Labels,
+	valueType prometheus.ValueType,
+) *Ref {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// labels must be in the same order for each metric with same name, so sort them
+	labelKeys := []string{}
+	for key := range labels {
+		labelKeys = append(labelKeys, key)
+	}
+	sort.Strings(labelKeys)
+
+	labelValues := []string{}
+	for _, key := range labelKeys {
+		labelValues = append(labelValues, labels[key])
+	}
+
 	idx := len(c.refs)
 
 	c.refs = append(c.refs, &Ref{
-		idx:        idx,
-		desc:       prometheus.NewDesc(name, help, []string{labelKey}, nil),
-		labelValue: labelValue,
+		idx:         idx,
+		desc:        prometheus.NewDesc(name, help, labelKeys, nil),
+		valueType:   valueType,
+		labelValues: labelValues,
 	})
 
 	return c.refs[idx]
@@ -44,11 +75,24 @@ func (c *Collector) Observe(ref *Ref, value float64, ts time.Time) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.set(ref, value, ts)
+}
+
+// for counters
+func (c *Collector) Add(ref *Ref, delta float64, ts time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(ref, ref.value+delta, ts)
+}
+
+func (c *Collector) set(ref *Ref, value float64, ts time.Time) {
+	ref.value = value
 	ref.latestMetric = prometheus.NewMetricWithTimestamp(ts, prometheus.MustNewConstMetric(
 		ref.desc,
-		prometheus.GaugeValue,
+		ref.valueType,
 		value,
-		ref.labelValue))
+		ref.labelValues...))
 }
 
 // contract of prometheus.Collector
@@ -0,0 +1,68 @@
+package ezstack
+
+import (
+	"context"
+	"errors"
+
+	"github.com/function61/hautomo/pkg/ezstack/coordinator"
+)
+
+// reads network state from the radio. don't call while Run() is running (the radio can have only
+// one user)
+func (s *Stack) Backup(ctx context.Context, source string) (*coordinator.Backup, error) {
+	var backup *coordinator.Backup
+
+	return backup, s.withCoordinator(ctx, false, func() error {
+		var err error
+		backup, err = s.coordinator.Backup(source)
+		return err
+	})
+}
+
+// restores network state from *backup* to the radio. our configuration must already have network
+// configuration from the backup (it's flashed to the radio first). don't call while Run() is running.
+func (s *Stack) Restore(ctx context.Context, backup *coordinator.Backup) error {
+	backupConf, err := backup.NetworkConfiguration()
+	if err != nil {
+		return err
+	}
+
+	if !s.configuration.NetworkConfiguration.Equal(*backupConf) {
+		return errors.New("Restore: configuration doesn't have backup's network configuration")
+	}
+
+	return s.withCoordinator(ctx, true, func() error {
+		return s.coordinator.Restore(backup)
+	})
+}
+
+// runs *fn* once the coordinator has started, then disconnects from the radio
+func (s *Stack) withCoordinator(ctx context.Context, settingsFlash bool, fn func() error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	connectionStopped := make(chan error, 1)
+	go func() {
+		connectionStopped <- s.runRadioConnection(ctx, settingsFlash, nil)
+	}()
+
+	select {
+	case <-s.coordinator.OnStarted():
+	case err := <-connectionStopped:
+		if err == nil { // = ctx canceled
+			err = ctx.Err()
+		}
+
+		return err
+	}
+
+	fnErr := fn()
+
+	cancel()
+
+	if err := <-connectionStopped; err != nil && fnErr == nil {
+		return err
+	}
+
+	return fnErr
+}
@@ -0,0 +1,33 @@
+package ezstack
+
+import (
+	"context"
+
+	"github.com/function61/hautomo/pkg/ezstack/coordinator"
+)
+
+// measures interference on each channel. don't call while Run() is running (the radio can have
+// only one user)
+func (s *Stack) EnergyScan(ctx context.Context) ([]coordinator.ChannelEnergy, error) {
+	var energies []coordinator.ChannelEnergy
+
+	return energies, s.withCoordinator(ctx, false, func() error {
+		var err error
+		energies, err = s.coordinator.EnergyScan()
+		return err
+	})
+}
+
+// moves the network (incl. joined devices) to *channel*. the caller must update its stored
+// configuration to the new channel. don't call while Run() is running.
+func (s *Stack) ChangeChannel(ctx context.Context, channel uint8) error {
+	if err := s.withCoordinator(ctx, false, func() error {
+		return s.coordinator.ChangeChannel(channel)
+	}); err != nil {
+		return err
+	}
+
+	s.configuration.Channel = channel
+
+	return nil
+}
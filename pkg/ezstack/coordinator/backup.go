@@ -0,0 +1,542 @@
+package coordinator
+
+// network backup & restore, so moving to a new radio (or re-flashing its firmware) doesn't require
+// re-pairing all devices.
+//
+// backups are in the "Open Coordinator Backup" format that zigpy and Zigbee2MQTT also use, so a
+// network can be moved between them: https://github.com/zigpy/open-coordinator-backup
+//
+// the portable part of the format doesn't carry everything the radio knows (e.g. its device tables
+// are in firmware-specific layouts), so we also save those NVRAM items as-is. they're restored
+// only onto a radio with the same layout. Z-Stack 3.x.0 keeps its tables in extended NVRAM, which
+// is saved separately.
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/function61/hautomo/pkg/ezstack/binstruct"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+	"github.com/function61/hautomo/pkg/ezstack/znp"
+)
+
+const (
+	BackupFormat  = "zigpy/open-coordinator-backup"
+	BackupVersion = 1
+
+	// frame counter is saved to NVRAM only every so often, so the radio has sent more frames than
+	// the backup says. devices drop frames whose counter they've already seen.
+	frameCounterRestoreMargin = 2500
+
+	nibLengthPacked        = 110 // 8-bit radios (CC2530/CC2531) store structs without padding
+	nibNwkUpdateIdOffset   = 109 // valid for nibLengthPacked
+	addrMgrEntryLength     = 11  // valid for nibLengthPacked
+	addrMgrUserAssociation = 0x01
+
+	maxTableItems = 255 // safety limit when probing for NVRAM table items
+
+	genericExtPanId = zigbee.ExtendedPANID(0xffffffffffffffff) // security material not specific to one network
+)
+
+type Backup struct {
+	Metadata        BackupMetadata   `json:"metadata"`
+	CoordinatorIEEE string           `json:"coordinator_ieee"` // hex without "0x" prefix
+	PanId           string           `json:"pan_id"`           // hex
+	ExtendedPanId   string           `json:"extended_pan_id"`  // hex
+	NwkUpdateId     uint8            `json:"nwk_update_id"`
+	SecurityLevel   uint8            `json:"security_level"`
+	Channel         uint8            `json:"channel"`
+	ChannelMask     []uint8          `json:"channel_mask"`
+	NetworkKey      BackupNetworkKey `json:"network_key"`
+	Devices         []BackupDevice   `json:"devices"`
+}
+
+type BackupMetadata struct {
+	Format   string          `json:"format"`
+	Version  int             `json:"version"`
+	Source   string          `json:"source"`
+	Internal *BackupInternal `json:"internal,omitempty"`
+}
+
+// format leaves this for the tool that made the backup
+type BackupInternal struct {
+	Date                time.Time         `json:"date"`
+	ZStackNVRAM         map[string]string `json:"zstack_nvram,omitempty"`          // item ID (hex) => value (hex)
+	ZStackNVRAMExtended map[string]string `json:"zstack_nvram_extended,omitempty"` // "<item ID>:<sub-item ID>" (hex) => value (hex)
+}
+
+type BackupNetworkKey struct {
+	Key            string `json:"key"` // hex
+	SequenceNumber uint8  `json:"sequence_number"`
+	FrameCounter   uint32 `json:"frame_counter"`
+}
+
+type BackupDevice struct {
+	NwkAddress  string `json:"nwk_address"`  // hex without "0x" prefix
+	IEEEAddress string `json:"ieee_address"` // hex without "0x" prefix
+	IsChild     bool   `json:"is_child"`     // joined directly to the coordinator
+}
+
+func (b *Backup) Valid() error {
+	if b.Metadata.Format != BackupFormat || b.Metadata.Version != BackupVersion {
+		return fmt.Errorf("unsupported backup format: %s v%d", b.Metadata.Format, b.Metadata.Version)
+	}
+
+	_, err := b.NetworkConfiguration()
+	return err
+}
+
+// the part that goes to our configuration file
+func (b *Backup) NetworkConfiguration() (*NetworkConfiguration, error) {
+	panId, err := strconv.ParseUint(b.PanId, 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("pan_id: %w", err)
+	}
+
+	extPanId, err := strconv.ParseUint(b.ExtendedPanId, 16, 64)
+	if err != nil {
+		return nil, fmt.Errorf("extended_pan_id: %w", err)
+	}
+
+	networkKey, err := hex.DecodeString(b.NetworkKey.Key)
+	if err != nil || len(networkKey) != len(zigbee.NetworkKey{}) {
+		return nil, fmt.Errorf("network_key: invalid: %s", b.NetworkKey.Key)
+	}
+
+	if _, err := strconv.ParseUint(b.CoordinatorIEEE, 16, 64); err != nil {
+		return nil, fmt.Errorf("coordinator_ieee: %w", err)
+	}
+
+	return &NetworkConfiguration{
+		IEEEAddress: zigbee.IEEEAddress("0x" + strings.ToLower(b.CoordinatorIEEE)),
+		PanId:       zigbee.PANID(panId),
+		ExtPanId:    zigbee.ExtendedPANID(extPanId),
+		NetworkKey:  networkKey,
+		Channel:     b.Channel,
+	}, nil
+}
+
+// reads network state from the radio. call only after startup (see OnStarted())
+func (c *Coordinator) Backup(source string) (*Backup, error) {
+	np := c.processor() // shorthand
+
+	networkConf := c.NetworkConf()
+
+	activeKey := znp.ZCDNVNwkActiveKeyInfo{}
+	if err := np.NVRAMRead(&activeKey); err != nil {
+		return nil, err
+	}
+
+	nvram, err := readNVRAMForBackup(np)
+	if err != nil {
+		return nil, err
+	}
+
+	nvramExtended := map[extendedNVRAMKey][]byte{}
+	if c.ZStackVersion().HasExtendedNVRAM() {
+		nvramExtended, err = readExtendedNVRAMForBackup(np)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	frameCounter, err := frameCounterFromSecMaterial(
+		append(
+			secMaterialEntries(nvram),
+			secMaterialEntriesExtended(nvramExtended)...),
+		networkConf.ExtPanId)
+	if err != nil {
+		return nil, err
+	}
+
+	nib := nvram[znp.NVRAMItemNib]
+
+	backup := &Backup{
+		Metadata: BackupMetadata{
+			Format:  BackupFormat,
+			Version: BackupVersion,
+			Source:  source,
+			Internal: &BackupInternal{
+				Date:                time.Now().UTC(),
+				ZStackNVRAM:         map[string]string{},
+				ZStackNVRAMExtended: map[string]string{},
+			},
+		},
+		CoordinatorIEEE: strings.TrimPrefix(networkConf.IEEEAddress.HexPrefixedString(), "0x"),
+		PanId:           fmt.Sprintf("%04x", uint16(networkConf.PanId)),
+		ExtendedPanId:   fmt.Sprintf("%016x", uint64(networkConf.ExtPanId)),
+		NwkUpdateId: func() uint8 {
+			if len(nib) == nibLengthPacked {
+				return nib[nibNwkUpdateIdOffset]
+			}
+
+			return 0 // don't know the layout
+		}(),
+		SecurityLevel: 5, // the only one Zigbee 3.0 & HA 1.2 use
+		Channel:       networkConf.Channel,
+		ChannelMask:   []uint8{networkConf.Channel},
+		NetworkKey: BackupNetworkKey{
+			Key:            hex.EncodeToString(activeKey.Key[:]),
+			SequenceNumber: activeKey.KeySeqNum,
+			FrameCounter:   frameCounter,
+		},
+		Devices: []BackupDevice{},
+	}
+
+	for id, value := range nvram {
+		backup.Metadata.Internal.ZStackNVRAM[fmt.Sprintf("%04x", uint16(id))] = hex.EncodeToString(value)
+	}
+
+	for key, value := range nvramExtended {
+		backup.Metadata.Internal.ZStackNVRAMExtended[key.String()] = hex.EncodeToString(value)
+	}
+
+	if len(nib) == nibLengthPacked {
+		backup.Devices = devicesFromAddrMgr(nvram[znp.NVRAMItemAddrMgr])
+	} else {
+		log.Error.Printf("Backup: unknown NVRAM layout (NIB length %d). device list left empty", len(nib))
+	}
+
+	return backup, nil
+}
+
+// writes network state from *backup* to the radio, and resets the radio so it takes effect.
+// call only after startup with configuration from backup.NetworkConfiguration().
+func (c *Coordinator) Restore(backup *Backup) error {
+	np := c.processor() // shorthand
+
+	networkConf := c.NetworkConf()
+
+	backupConf, err := backup.NetworkConfiguration()
+	if err != nil {
+		return err
+	}
+
+	if !networkConf.Equal(*backupConf) {
+		return errors.New("Restore: radio is not in the network of the backup")
+	}
+
+	nib, err := np.NVRAMReadRaw(znp.NVRAMItemNib)
+	if err != nil {
+		return err
+	}
+
+	backupNVRAM, backupNVRAMExtended, err := backup.nvram()
+	if err != nil {
+		return err
+	}
+
+	extendedNVRAM := c.ZStackVersion().HasExtendedNVRAM()
+
+	// device tables etc. are only usable by a radio with the same struct layout
+	if backupNib := backupNVRAM[znp.NVRAMItemNib]; len(backupNib) == len(nib) {
+		for id, value := range backupNVRAM {
+			if err := np.NVRAMWriteRaw(id, value); err != nil {
+				return err
+			}
+		}
+
+		if extendedNVRAM {
+			for key, value := range backupNVRAMExtended {
+				if err := np.ExtendedNVRAMWriteRaw(key.id, key.subId, value); err != nil {
+					return err
+				}
+			}
+		}
+	} else {
+		log.Error.Printf(
+			"Restore: backup has no NVRAM items for this radio. restoring only network parameters (%d devices may need to rejoin)",
+			len(backup.Devices))
+
+		if len(nib) == nibLengthPacked {
+			nib[nibNwkUpdateIdOffset] = backup.NwkUpdateId
+
+			if err := np.NVRAMWriteRaw(znp.NVRAMItemNib, nib); err != nil {
+				return err
+			}
+		}
+	}
+
+	frameCounter := backup.NetworkKey.FrameCounter + frameCounterRestoreMargin
+
+	key := zigbee.NetworkKey{}
+	copy(key[:], backupConf.NetworkKey)
+
+	if err := np.NVRAMWrite(&znp.ZCDNVNwkActiveKeyInfo{
+		KeySeqNum: backup.NetworkKey.SequenceNumber,
+		Key:       key,
+	}); err != nil {
+		return err
+	}
+
+	if err := np.NVRAMWrite(&znp.ZCDNVNwkKey{
+		KeySeqNum:    backup.NetworkKey.SequenceNumber,
+		Key:          key,
+		FrameCounter: frameCounter,
+	}); err != nil {
+		return err
+	}
+
+	if err := writeFrameCounter(np, extendedNVRAM, backupConf.ExtPanId, frameCounter); err != nil {
+		return err
+	}
+
+	// radio reads these at boot
+	return c.Reset()
+}
+
+func (b *Backup) nvram() (map[znp.NVRAMItemId][]byte, map[extendedNVRAMKey][]byte, error) {
+	nvram := map[znp.NVRAMItemId][]byte{}
+	nvramExtended := map[extendedNVRAMKey][]byte{}
+
+	if b.Metadata.Internal == nil { // not made by us
+		return nvram, nvramExtended, nil
+	}
+
+	for idHex, valueHex := range b.Metadata.Internal.ZStackNVRAM {
+		id, err := strconv.ParseUint(idHex, 16, 16)
+		if err != nil {
+			return nil, nil, fmt.Errorf("zstack_nvram: %w", err)
+		}
+
+		value, err := hex.DecodeString(valueHex)
+		if err != nil {
+			return nil, nil, fmt.Errorf("zstack_nvram: %s: %w", idHex, err)
+		}
+
+		nvram[znp.NVRAMItemId(id)] = value
+	}
+
+	for keyHex, valueHex := range b.Metadata.Internal.ZStackNVRAMExtended {
+		key, err := parseExtendedNVRAMKey(keyHex)
+		if err != nil {
+			return nil, nil, fmt.Errorf("zstack_nvram_extended: %w", err)
+		}
+
+		value, err := hex.DecodeString(valueHex)
+		if err != nil {
+			return nil, nil, fmt.Errorf("zstack_nvram_extended: %s: %w", keyHex, err)
+		}
+
+		nvramExtended[*key] = value
+	}
+
+	return nvram, nvramExtended, nil
+}
+
+func readNVRAMForBackup(np *znp.Znp) (map[znp.NVRAMItemId][]byte, error) {
+	nvram := map[znp.NVRAMItemId][]byte{}
+
+	for _, id := range []znp.NVRAMItemId{
+		znp.NVRAMItemNib,
+		znp.NVRAMItemDeviceList,
+		znp.NVRAMItemAddrMgr,
+		znp.NVRAMItemNwkAlternKeyInfo,
+	} {
+		value, err := np.NVRAMReadRaw(id)
+		switch {
+		case errors.Is(err, znp.ErrNVRAMItemNotFound): // not all firmwares have all items
+			continue
+		case err != nil:
+			return nil, err
+		}
+
+		nvram[id] = value
+	}
+
+	// tables have one item per entry. they end at the first missing item
+	for _, tableStart := range []znp.NVRAMItemId{
+		znp.NVRAMItemNwkSecMaterialTable,
+		znp.NVRAMItemTclkTable,
+		znp.NVRAMItemApsLinkKeyDataTable,
+	} {
+		for id := tableStart; id < tableStart+maxTableItems; id++ {
+			value, err := np.NVRAMReadRaw(id)
+			if errors.Is(err, znp.ErrNVRAMItemNotFound) {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+
+			nvram[id] = value
+		}
+	}
+
+	return nvram, nil
+}
+
+func readExtendedNVRAMForBackup(np *znp.Znp) (map[extendedNVRAMKey][]byte, error) {
+	nvram := map[extendedNVRAMKey][]byte{}
+
+	for _, id := range []znp.ExtendedNVRAMItemId{
+		znp.ExtendedNVRAMItemAddrMgr,
+		znp.ExtendedNVRAMItemBindingTable,
+		znp.ExtendedNVRAMItemDeviceList,
+		znp.ExtendedNVRAMItemTclkTable,
+		znp.ExtendedNVRAMItemTclkIcTable,
+		znp.ExtendedNVRAMItemApsKeyDataTable,
+		znp.ExtendedNVRAMItemNwkSecMaterialTable,
+	} {
+		for subId := uint16(0); subId < maxTableItems; subId++ {
+			value, err := np.ExtendedNVRAMReadRaw(id, subId)
+			if errors.Is(err, znp.ErrNVRAMItemNotFound) {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+
+			nvram[extendedNVRAMKey{id, subId}] = value
+		}
+	}
+
+	return nvram, nil
+}
+
+func secMaterialEntries(nvram map[znp.NVRAMItemId][]byte) [][]byte {
+	entries := [][]byte{}
+
+	for id := znp.NVRAMItemNwkSecMaterialTable; id < znp.NVRAMItemNwkSecMaterialTable+maxTableItems; id++ {
+		value, found := nvram[id]
+		if !found {
+			break
+		}
+
+		entries = append(entries, value)
+	}
+
+	return entries
+}
+
+func secMaterialEntriesExtended(nvram map[extendedNVRAMKey][]byte) [][]byte {
+	entries := [][]byte{}
+
+	for subId := uint16(0); subId < maxTableItems; subId++ {
+		value, found := nvram[extendedNVRAMKey{znp.ExtendedNVRAMItemNwkSecMaterialTable, subId}]
+		if !found {
+			break
+		}
+
+		entries = append(entries, value)
+	}
+
+	return entries
+}
+
+// largest of our network's entry and the generic entry
+func frameCounterFromSecMaterial(entries [][]byte, extPanId zigbee.ExtendedPANID) (uint32, error) {
+	frameCounter := uint32(0)
+
+	for _, value := range entries {
+		entry := znp.NwkSecMaterialDescriptor{}
+		if err := binstruct.Decode(value, &entry); err != nil {
+			return 0, fmt.Errorf("NwkSecMaterialDescriptor: %w", err)
+		}
+
+		if (entry.ExtendedPANID == extPanId || entry.ExtendedPANID == genericExtPanId) && entry.FrameCounter > frameCounter {
+			frameCounter = entry.FrameCounter
+		}
+	}
+
+	return frameCounter, nil
+}
+
+// updates our network's entry in security material table, or takes the first entry if we have none
+func writeFrameCounter(np *znp.Znp, extendedNVRAM bool, extPanId zigbee.ExtendedPANID, frameCounter uint32) error {
+	readEntry := func(idx uint16) ([]byte, error) {
+		if extendedNVRAM {
+			return np.ExtendedNVRAMReadRaw(znp.ExtendedNVRAMItemNwkSecMaterialTable, idx)
+		} else {
+			return np.NVRAMReadRaw(znp.NVRAMItemNwkSecMaterialTable + znp.NVRAMItemId(idx))
+		}
+	}
+
+	target := uint16(0)
+
+	for idx := uint16(0); idx < maxTableItems; idx++ {
+		value, err := readEntry(idx)
+		if errors.Is(err, znp.ErrNVRAMItemNotFound) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		entry := znp.NwkSecMaterialDescriptor{}
+		if err := binstruct.Decode(value, &entry); err != nil {
+			return fmt.Errorf("NwkSecMaterialDescriptor: %w", err)
+		}
+
+		if entry.ExtendedPANID == extPanId {
+			target = idx
+			break
+		}
+	}
+
+	entry := binstruct.Encode(&znp.NwkSecMaterialDescriptor{
+		FrameCounter:  frameCounter,
+		ExtendedPANID: extPanId,
+	})
+
+	if extendedNVRAM {
+		return np.ExtendedNVRAMWriteRaw(znp.ExtendedNVRAMItemNwkSecMaterialTable, target, entry)
+	} else {
+		return np.NVRAMWriteRaw(znp.NVRAMItemNwkSecMaterialTable+znp.NVRAMItemId(target), entry)
+	}
+}
+
+func devicesFromAddrMgr(addrMgr []byte) []BackupDevice {
+	devices := []BackupDevice{}
+
+	for offset := 0; offset+addrMgrEntryLength <= len(addrMgr); offset += addrMgrEntryLength {
+		entry := addrMgr[offset : offset+addrMgrEntryLength]
+
+		user := entry[0]
+		nwkAddress := binary.LittleEndian.Uint16(entry[1:3])
+		ieeeAddress := binary.LittleEndian.Uint64(entry[3:11])
+
+		if user == 0 || nwkAddress == 0xfffe { // unused entry
+			continue
+		}
+
+		devices = append(devices, BackupDevice{
+			NwkAddress:  fmt.Sprintf("%04x", nwkAddress),
+			IEEEAddress: fmt.Sprintf("%016x", ieeeAddress),
+			IsChild:     user&addrMgrUserAssociation != 0,
+		})
+	}
+
+	return devices
+}
+
+// identifies an item in extended NVRAM
+type extendedNVRAMKey struct {
+	id    znp.ExtendedNVRAMItemId
+	subId uint16
+}
+
+func (e extendedNVRAMKey) String() string {
+	return fmt.Sprintf("%04x:%04x", uint16(e.id), e.subId)
+}
+
+func parseExtendedNVRAMKey(serialized string) (*extendedNVRAMKey, error) {
+	parts := strings.Split(serialized, ":")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid key: %s", serialized)
+	}
+
+	id, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %s: %w", serialized, err)
+	}
+
+	subId, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %s: %w", serialized, err)
+	}
+
+	return &extendedNVRAMKey{znp.ExtendedNVRAMItemId(id), uint16(subId)}, nil
+}
@@ -0,0 +1,143 @@
+package coordinator
+
+// finding a quiet channel (energy scan) and moving the network there without re-pairing devices
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+	"github.com/function61/hautomo/pkg/ezstack/znp"
+)
+
+const (
+	energyScanDuration        = 3 // per channel: (2^n + 1) * 15.36 ms
+	energyScanTimeout         = 30 * time.Second
+	channelChangeScanDuration = 0xfe // Mgmt_NWK_Update_req magic value for "change channel"
+	// devices switch after the broadcast has had time to propagate (nwkNetworkBroadcastDeliveryTime)
+	channelChangeTimeout      = 30 * time.Second
+	channelChangePollInterval = 1 * time.Second
+)
+
+// radio energy on a channel, as measured by the coordinator
+type ChannelEnergy struct {
+	Channel uint8
+	Energy  uint8 // 0-255. higher = more interference (other Zigbee networks, WiFi etc.)
+}
+
+// measures energy on all channels. (Mgmt_NWK_Update_req from coordinator to itself)
+func (c *Coordinator) EnergyScan() ([]ChannelEnergy, error) {
+	req := func() error {
+		status, err := c.processor().ZdoMgmtNwkUpdateReq(
+			zigbee.CoordinatorNwkAddr,
+			znp.AddrModeAddr16Bit,
+			znp.ChannelsFromMask(znp.AllChannelsMask),
+			energyScanDuration,
+			1, // scan count
+			zigbee.CoordinatorNwkAddr)
+		if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
+			return fmt.Errorf("unable to request energy scan: %w", err)
+		}
+		return nil
+	}
+
+	response, err := c.syncRequestResponse(req, ZdoMgmtNwkUpdateNotifyType, energyScanTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	notify := response.(*znp.ZdoMgmtNwkUpdateNotify)
+	if err := notify.Status.Error(); err != nil {
+		return nil, fmt.Errorf("Mgmt_NWK_Update_notify: %w", err)
+	}
+
+	energies := []ChannelEnergy{}
+
+	// energy values are for the channels in *ScannedChannels*, in order
+	for channel := uint8(0); channel < 32 && len(energies) < len(notify.EnergyValues); channel++ {
+		if notify.ScannedChannels&(1<<channel) != 0 {
+			energies = append(energies, ChannelEnergy{
+				Channel: channel,
+				Energy:  notify.EnergyValues[len(energies)],
+			})
+		}
+	}
+
+	return energies, nil
+}
+
+// moves the whole network to *channel* with a network-wide Mgmt_NWK_Update_req. routers (and
+// other devices that keep their receiver on) switch with us. sleepy end devices find the network
+// again when they can't reach their parent (= they rejoin).
+func (c *Coordinator) ChangeChannel(channel uint8) error {
+	channels, err := channelsFromConfig(channel)
+	if err != nil {
+		return err
+	}
+
+	np := c.processor() // shorthand
+
+	// the coordinator is a recipient of the broadcast as well
+	status, err := np.ZdoMgmtNwkUpdateReq(
+		zigbee.BroadcastRxOnWhenIdle,
+		znp.AddrModeAddrBroadcast,
+		channels,
+		channelChangeScanDuration,
+		0, // scan count: not used
+		zigbee.CoordinatorNwkAddr)
+	if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
+		return fmt.Errorf("ChangeChannel: %w", err)
+	}
+
+	if err := awaitChannel(np, channel); err != nil {
+		return fmt.Errorf("ChangeChannel: %w", err)
+	}
+
+	// the network is now on the new channel, but the channel the radio is configured for (which we
+	// compare our configuration against at startup) is still the old one
+	if c.ZStackVersion().IsZStack3() {
+		if err := np.NVRAMWrite(chanListItem(channel)); err != nil {
+			return fmt.Errorf("ChangeChannel: %w", err)
+		}
+
+		if _, err := np.AppCnfBdbSetChannel(1, channels); err != nil {
+			return fmt.Errorf("ChangeChannel: AppCnfBdbSetChannel: %w", err)
+		}
+	} else {
+		if _, err := np.UtilSetChannels(channels); err != nil {
+			return fmt.Errorf("ChangeChannel: UtilSetChannels: %w", err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config.Channel = channel // so re-running startup (e.g. after reconnect) agrees with the radio
+
+	networkConf := *c.networkConf
+	networkConf.Channel = channel
+	c.networkConf = &networkConf
+
+	return nil
+}
+
+func awaitChannel(np *znp.Znp, channel uint8) error {
+	deadline := time.Now().Add(channelChangeTimeout)
+
+	for {
+		info, err := np.ZdoExtNwkInfo()
+		if err != nil {
+			return fmt.Errorf("ZdoExtNwkInfo: %w", err)
+		}
+
+		if info.Channel == channel {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting to switch to channel %d (still on %d)", channel, info.Channel)
+		}
+
+		time.Sleep(channelChangePollInterval)
+	}
+}
@@ -13,7 +13,7 @@ import (
 
 func (c *Coordinator) Reset() error {
 	if _, err := c.syncRequestResponseRetryable(func() error {
-		c.networkProcessor.SysResetReq(1) // we don't know about TX errors for async requests
+		c.processor().SysResetReq(1) // we don't know about TX errors for async requests
 
 		return nil
 	}, SysResetIndType, 15*time.Second, 5); err != nil {
@@ -25,7 +25,7 @@ func (c *Coordinator) Reset() error {
 
 func (c *Coordinator) ActiveEndpoints(nwkAddress string) (*znp.ZdoActiveEpRsp, error) {
 	req := func() error {
-		status, err := c.networkProcessor.ZdoActiveEpReq(nwkAddress, nwkAddress)
+		status, err := c.processor().ZdoActiveEpReq(nwkAddress, nwkAddress)
 		if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
 			return fmt.Errorf("unable to request active endpoints: %w", err)
 		}
@@ -41,7 +41,7 @@ func (c *Coordinator) ActiveEndpoints(nwkAddress string) (*znp.ZdoActiveEpRsp, e
 
 func (c *Coordinator) NodeDescription(nwkAddress string) (*znp.ZdoNodeDescRsp, error) {
 	req := func() error {
-		status, err := c.networkProcessor.ZdoNodeDescReq(nwkAddress, nwkAddress)
+		status, err := c.processor().ZdoNodeDescReq(nwkAddress, nwkAddress)
 		if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
 			return fmt.Errorf("unable to request node description: %w", err)
 		}
@@ -57,7 +57,7 @@ func (c *Coordinator) NodeDescription(nwkAddress string) (*znp.ZdoNodeDescRsp, e
 
 func (c *Coordinator) SimpleDescription(nwkAddress string, endpoint zigbee.EndpointId) (*znp.ZdoSimpleDescRsp, error) {
 	req := func() error {
-		status, err := c.networkProcessor.ZdoSimpleDescReq(nwkAddress, nwkAddress, endpoint)
+		status, err := c.processor().ZdoSimpleDescReq(nwkAddress, nwkAddress, endpoint)
 		if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
 			return fmt.Errorf("unable to request simple description: %w", err)
 		}
@@ -71,22 +71,64 @@ func (c *Coordinator) SimpleDescription(nwkAddress string, endpoint zigbee.Endpo
 	return nil, err
 }
 
+// current network address of a device, from the radio's address manager (which is updated when
+// the device (re)joins). error if the radio doesn't know the device
+func (c *Coordinator) NetworkAddress(ieeeAddress zigbee.IEEEAddress) (string, error) {
+	rsp, err := c.processor().UtilAddrMgrExtAddrLookup(ieeeAddress.HexPrefixedString())
+	if err != nil {
+		return "", fmt.Errorf("UtilAddrMgrExtAddrLookup: %w", err)
+	}
+
+	if rsp.NwkAddr == zigbee.UnknownNwkAddr {
+		return "", fmt.Errorf("network address of %s not known", ieeeAddress)
+	}
+
+	return rsp.NwkAddr, nil
+}
+
+// asks device to leave the network with Mgmt_Leave_req. *rejoin* asks it to rejoin right after.
+func (c *Coordinator) Leave(nwkAddress string, ieeeAddress zigbee.IEEEAddress, rejoin bool) error {
+	req := func() error {
+		status, err := c.processor().ZdoMgmtLeaveReq(
+			nwkAddress,
+			ieeeAddress.HexPrefixedString(),
+			&znp.RemoveChildrenRejoin{
+				Rejoin:         boolToUint8(rejoin),
+				RemoveChildren: 0,
+			})
+		if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
+			return fmt.Errorf("unable to request leave: %w", err)
+		}
+		return nil
+	}
+
+	response, err := c.syncRequestResponseRetryable(req, ZdoMgmtLeaveRspType, defaultTimeout, 3)
+	if err != nil {
+		return err
+	}
+
+	return response.(*znp.ZdoMgmtLeaveRsp).Status.Error()
+}
+
+// *dstAddr* is the network address of the device whose binding table is modified (= the source device).
+// *dstAddress* is IEEE address for AddrModeAddr64Bit and group address for AddrModeAddrGroup
 func (c *Coordinator) Bind(
 	dstAddr string,
 	srcAddress zigbee.IEEEAddress,
 	srcEndpoint zigbee.EndpointId,
 	clusterId cluster.ClusterId,
-	dstAddress zigbee.IEEEAddress,
+	dstAddrMode znp.AddrMode,
+	dstAddress string,
 	dstEndpoint zigbee.EndpointId,
 ) (*znp.ZdoBindRsp, error) {
 	req := func() error {
-		status, err := c.networkProcessor.ZdoBindReq(
+		status, err := c.processor().ZdoBindReq(
 			dstAddr,
 			srcAddress.HexPrefixedString(),
 			srcEndpoint,
 			uint16(clusterId),
-			znp.AddrModeAddr64Bit,
-			dstAddress.HexPrefixedString(),
+			dstAddrMode,
+			dstAddress,
 			dstEndpoint)
 		if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
 			return fmt.Errorf("unable to bind: %w", err)
@@ -102,22 +144,25 @@ func (c *Coordinator) Bind(
 	return response.(*znp.ZdoBindRsp), nil
 }
 
+// *dstAddr* is the network address of the device whose binding table is modified (= the source device).
+// *dstAddress* is IEEE address for AddrModeAddr64Bit and group address for AddrModeAddrGroup
 func (c *Coordinator) Unbind(
 	dstAddr string,
 	srcAddress zigbee.IEEEAddress,
 	srcEndpoint zigbee.EndpointId,
 	clusterId cluster.ClusterId,
-	dstAddress zigbee.IEEEAddress,
+	dstAddrMode znp.AddrMode,
+	dstAddress string,
 	dstEndpoint zigbee.EndpointId,
 ) (*znp.ZdoUnbindRsp, error) {
 	req := func() error {
-		status, err := c.networkProcessor.ZdoUnbindReq(
+		status, err := c.processor().ZdoUnbindReq(
 			dstAddr,
 			srcAddress.HexPrefixedString(),
 			srcEndpoint,
 			uint16(clusterId),
-			znp.AddrModeAddr64Bit,
-			dstAddress.HexPrefixedString(),
+			dstAddrMode,
+			dstAddress,
 			dstEndpoint)
 		if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
 			return fmt.Errorf("unable to unbind: %w", err)
@@ -132,9 +177,145 @@ func (c *Coordinator) Unbind(
 	return nil, err
 }
 
+// reads the binding table of device at *nwkAddress*
+func (c *Coordinator) Bindings(nwkAddress string) ([]*znp.Binding, error) {
+	bindings := []*znp.Binding{}
+
+	// binding table is returned in pages
+	for {
+		startIndex := uint8(len(bindings))
+
+		req := func() error {
+			status, err := c.processor().ZdoMgmtBindReq(nwkAddress, startIndex)
+			if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
+				return fmt.Errorf("unable to request binding table: %w", err)
+			}
+			return nil
+		}
+
+		response, err := c.syncRequestResponseRetryable(req, ZdoMgmtBindRspType, defaultTimeout, 3)
+		if err != nil {
+			return nil, err
+		}
+
+		page := response.(*znp.ZdoMgmtBindRsp)
+		if err := page.Status.Error(); err != nil {
+			return nil, fmt.Errorf("Mgmt_Bind_rsp: %w", err)
+		}
+
+		bindings = append(bindings, page.BindTable...)
+
+		if len(page.BindTable) == 0 || len(bindings) >= int(page.BindTableEntries) {
+			return bindings, nil
+		}
+	}
+}
+
+const (
+	PermitJoinMaxDuration = 254 * time.Second // 255 would mean "forever", which we don't want to allow
+)
+
+// allows new devices to join via *router* (network address) for *duration*. empty *router* means
+// all routers and the coordinator. zero *duration* disables joining.
+func (c *Coordinator) PermitJoin(duration time.Duration, router string) error {
+	if duration > PermitJoinMaxDuration {
+		return fmt.Errorf("PermitJoin: duration over max %s", PermitJoinMaxDuration)
+	}
+
+	if duration < 0 { // would wrap around in the uint8 seconds
+		return fmt.Errorf("PermitJoin: negative duration %s", duration)
+	}
+
+	addrMode, dstAddr := func() (znp.AddrMode, string) {
+		if router == "" {
+			return znp.AddrModeAddrBroadcast, zigbee.BroadcastAllRouters
+		}
+		return znp.AddrModeAddr16Bit, router
+	}()
+
+	status, err := c.processor().ZdoMgmtPermitJoinReq(
+		addrMode,
+		dstAddr,
+		uint8(duration/time.Second),
+		0) // trust center significance: ignored
+	if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
+		return fmt.Errorf("PermitJoin: %w", err)
+	}
+
+	return nil
+}
+
+// queries neighbor table of a router (or coordinator) with Mgmt_Lqi_req
+func (c *Coordinator) NeighborTable(nwkAddress string) ([]*znp.NeighborLqi, error) {
+	neighbors := []*znp.NeighborLqi{}
+
+	// neighbor table is returned in pages
+	for {
+		startIndex := uint8(len(neighbors))
+
+		req := func() error {
+			status, err := c.processor().ZdoMgmtLqiReq(nwkAddress, startIndex)
+			if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
+				return fmt.Errorf("unable to request neighbor table: %w", err)
+			}
+			return nil
+		}
+
+		response, err := c.syncRequestResponseRetryable(req, ZdoMgmtLqiRspType, defaultTimeout, 3)
+		if err != nil {
+			return nil, err
+		}
+
+		page := response.(*znp.ZdoMgmtLqiRsp)
+		if err := page.Status.Error(); err != nil {
+			return nil, fmt.Errorf("Mgmt_Lqi_rsp: %w", err)
+		}
+
+		neighbors = append(neighbors, page.NeighborLqiList...)
+
+		if len(page.NeighborLqiList) == 0 || len(neighbors) >= int(page.NeighborTableEntries) {
+			return neighbors, nil
+		}
+	}
+}
+
+// queries routing table of a router (or coordinator) with Mgmt_Rtg_req
+func (c *Coordinator) RoutingTable(nwkAddress string) ([]*znp.Route, error) {
+	routes := []*znp.Route{}
+
+	// routing table is returned in pages
+	for {
+		startIndex := uint8(len(routes))
+
+		req := func() error {
+			status, err := c.processor().ZdoMgmtRtgReq(nwkAddress, startIndex)
+			if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
+				return fmt.Errorf("unable to request routing table: %w", err)
+			}
+			return nil
+		}
+
+		response, err := c.syncRequestResponseRetryable(req, ZdoMgmtRtgRspType, defaultTimeout, 3)
+		if err != nil {
+			return nil, err
+		}
+
+		page := response.(*znp.ZdoMgmtRtgRsp)
+		if err := page.Status.Error(); err != nil {
+			return nil, fmt.Errorf("Mgmt_Rtg_rsp: %w", err)
+		}
+
+		routes = append(routes, page.RoutingTable...)
+
+		if len(page.RoutingTable) == 0 || len(routes) >= int(page.RoutingTableEntries) {
+			return routes, nil
+		}
+	}
+}
+
 func (c *Coordinator) DataRequest(dstAddr string, dstEndpoint zigbee.EndpointId, srcEndpoint zigbee.EndpointId, clusterId uint16, options *znp.AfDataRequestOptions, radius uint8, data []uint8) (*znp.AfIncomingMessage, error) {
 	req := func(networkAddress string, transactionId uint8) error {
-		status, err := c.networkProcessor.AfDataRequest(networkAddress, dstEndpoint, srcEndpoint, clusterId, transactionId, options, radius, data)
+		status, err := c.processor().AfDataRequest(networkAddress, dstEndpoint, srcEndpoint, clusterId, transactionId, options, radius, data)
 		if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
 			return fmt.Errorf("DataRequest: %w", err)
 		}
@@ -144,6 +325,44 @@ func (c *Coordinator) DataRequest(dstAddr string, dstEndpoint zigbee.EndpointId,
 	return c.syncDataRequestResponseRetryable(req, dstAddr, nextTransactionId(), defaultTimeout, 3)
 }
 
+// like DataRequest, but for when the payload itself is a response (or otherwise doesn't elicit
+// a response), so we only wait for the frame to be sent.
+func (c *Coordinator) DataRequestNoResponse(dstAddr string, dstEndpoint zigbee.EndpointId, srcEndpoint zigbee.EndpointId, clusterId uint16, options *znp.AfDataRequestOptions, radius uint8, data []uint8) error {
+	req := func(transactionId uint8) error {
+		status, err := c.processor().AfDataRequest(dstAddr, dstEndpoint, srcEndpoint, clusterId, transactionId, options, radius, data)
+		if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
+			return fmt.Errorf("DataRequestNoResponse: %w", err)
+		}
+		return nil
+	}
+
+	return c.syncDataConfirm(req, nextTransactionId(), defaultTimeout)
+}
+
+// sends to all members of a group with one radio frame. there are no responses from the members,
+// so we only know that the frame was sent.
+func (c *Coordinator) GroupDataRequest(group zigbee.GroupId, srcEndpoint zigbee.EndpointId, clusterId uint16, options *znp.AfDataRequestOptions, radius uint8, data []uint8) error {
+	req := func(transactionId uint8) error {
+		status, err := c.processor().AfDataRequestExt(
+			znp.AddrModeAddrGroup,
+			group.HexPrefixedString(),
+			0xff, // ignored for groupcast
+			0,    // intra-PAN
+			srcEndpoint,
+			clusterId,
+			transactionId,
+			options,
+			radius,
+			data)
+		if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
+			return fmt.Errorf("GroupDataRequest: %w", err)
+		}
+		return nil
+	}
+
+	return c.syncDataConfirm(req, nextTransactionId(), defaultTimeout)
+}
+
 type errFn func() error
 
 func firstError(err1 error, err2 func() error) error {
@@ -153,3 +372,11 @@ func firstError(err1 error, err2 func() error) error {
 
 	return err2()
 }
+
+func boolToUint8(b bool) uint8 {
+	if b {
+		return 1
+	} else {
+		return 0
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"math/bits"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
@@ -25,20 +26,25 @@ var nextTransactionId = frame.MakeDefaultTransactionIdProvider()
 
 const defaultTimeout = 10 * time.Second
 
+var errResponseTimeout = errors.New("timeout. didn't receive response")
+
 type MessageChannels struct {
 	onError           chan error
 	onDeviceAnnounce  chan *znp.ZdoEndDeviceAnnceInd
 	onDeviceLeave     chan *znp.ZdoLeaveInd
 	onDeviceTc        chan *znp.ZdoTcDevInd
 	onIncomingMessage chan *znp.AfIncomingMessage
+	onStarted         chan *NetworkConfiguration
 }
 
 type Coordinator struct {
 	config           *Configuration
-	networkProcessor *znp.Znp
+	networkProcessor *znp.Znp // replaced on each Run(), i.e. after reconnecting to the radio
 	messageChannels  *MessageChannels
 	networkConf      *NetworkConfiguration
+	zstackVersion    znp.ZStackVersion
 	allIncomingMsgs  *topic.Topic // is buffered (capacity ~100)
+	mu               sync.Mutex   // for networkProcessor, networkConf & zstackVersion
 }
 
 func (c *Coordinator) OnIncomingMessage() chan *znp.AfIncomingMessage {
@@ -57,14 +63,38 @@ func (c *Coordinator) OnDeviceAnnounce() chan *znp.ZdoEndDeviceAnnceInd {
 	return c.messageChannels.onDeviceAnnounce
 }
 
+// signalled when startup is done and we're in our network. not guaranteed to be delivered if
+// nobody is listening
+func (c *Coordinator) OnStarted() chan *NetworkConfiguration {
+	return c.messageChannels.onStarted
+}
+
 func (c *Coordinator) OnError() chan error {
 	return c.messageChannels.onError
 }
 
 func (c *Coordinator) NetworkConf() *NetworkConfiguration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	return c.networkConf
 }
 
+// firmware of the radio. known after startup
+func (c *Coordinator) ZStackVersion() znp.ZStackVersion {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.zstackVersion
+}
+
+func (c *Coordinator) processor() *znp.Znp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.networkProcessor
+}
+
 func New(config *Configuration) *Coordinator {
 	messageChannels := &MessageChannels{
 		onError:           make(chan error, 100),
@@ -72,6 +102,7 @@ func New(config *Configuration) *Coordinator {
 		onDeviceLeave:     make(chan *znp.ZdoLeaveInd, 100),
 		onDeviceTc:        make(chan *znp.ZdoTcDevInd, 100),
 		onIncomingMessage: make(chan *znp.AfIncomingMessage, 100),
+		onStarted:         make(chan *NetworkConfiguration, 1),
 	}
 	return &Coordinator{
 		config:          config,
@@ -80,8 +111,13 @@ func New(config *Configuration) *Coordinator {
 	}
 }
 
-func (c *Coordinator) Run(ctx context.Context, joinEnable bool, networkProcessor *znp.Znp, settingsFlash bool) error {
+// can be called again with a new *networkProcessor* after the previous one stopped (e.g. the link
+// to a network-attached radio dropped). startup is re-run in full, so we're again in sync with
+// the radio. *permitJoin* zero disables joining.
+func (c *Coordinator) Run(ctx context.Context, permitJoin time.Duration, networkProcessor *znp.Znp, settingsFlash bool) error {
+	c.mu.Lock()
 	c.networkProcessor = networkProcessor
+	c.mu.Unlock()
 
 	tasks := taskrunner.New(ctx, logex.Discard)
 
@@ -93,9 +129,9 @@ func (c *Coordinator) Run(ctx context.Context, joinEnable bool, networkProcessor
 			select {
 			case <-ctx.Done():
 				return nil
-			case err := <-c.networkProcessor.Errors():
+			case err := <-networkProcessor.Errors():
 				c.messageChannels.onError <- err
-			case incoming := <-c.networkProcessor.AsyncInbound():
+			case incoming := <-networkProcessor.AsyncInbound():
 				// syncRequestResponse(), syncDataRequestResponse() use this
 				c.allIncomingMsgs.Broadcast <- incoming
 
@@ -123,9 +159,19 @@ func (c *Coordinator) Run(ctx context.Context, joinEnable bool, networkProcessor
 				case *znp.ZdoSrcRtgInd:
 					// NO-OP: at least IKEA bulbs seem to emit these when they are sent commands to.
 					//        it contains a relay list.
+				case *znp.ZdoConcentratorInd:
+					// NO-OP: Z-Stack 3.x tells us about routers doing many-to-one routing
+				case *znp.AppCnfBdbCommissioningNotification:
+					// NO-OP: Z-Stack 3.x network formation progress (intercepted by broadcast I guess)
 				case *znp.ZdoNodeDescRsp, *znp.ZdoActiveEpRsp, *znp.ZdoSimpleDescRsp:
 					// these are related to when we add a new device
 					log.Debug.Print("got probably interview-related messages")
+				case *znp.ZdoBindRsp, *znp.ZdoUnbindRsp, *znp.ZdoMgmtBindRsp:
+					// NO-OP: responses to binding management (intercepted by broadcast I guess)
+				case *znp.ZdoMgmtLqiRsp, *znp.ZdoMgmtRtgRsp:
+					// NO-OP: responses to topology scan (intercepted by broadcast I guess)
+				case *znp.ZdoMgmtLeaveRsp:
+					// NO-OP: response to us asking device to leave (intercepted by broadcast I guess)
 				default:
 					// TODO
 					log.Error.Printf("unexpected message type: %s", spew.Sdump(incoming))
@@ -134,12 +180,19 @@ func (c *Coordinator) Run(ctx context.Context, joinEnable bool, networkProcessor
 		}
 	})
 
-	firmwareVer, err := c.networkProcessor.SysVersion()
+	firmwareVer, err := networkProcessor.SysVersion()
 	if err != nil {
 		return fmt.Errorf("SysVersion: %w", err)
 	}
 
-	log.Info.Printf("starting, firmware v%d.%d.%d (transport v%d)",
+	zstackVersion := firmwareVer.ZStackVersion()
+
+	c.mu.Lock()
+	c.zstackVersion = zstackVersion
+	c.mu.Unlock()
+
+	log.Info.Printf("starting, %s firmware v%d.%d.%d (transport v%d)",
+		zstackVersion,
 		firmwareVer.MajorRel,
 		firmwareVer.MinorRel,
 		firmwareVer.MaintRel,
@@ -151,19 +204,27 @@ func (c *Coordinator) Run(ctx context.Context, joinEnable bool, networkProcessor
 		return fmt.Errorf("configureAndReset: %w", err)
 	}
 
+	c.mu.Lock()
 	c.networkConf = networkConf
+	c.mu.Unlock()
 
 	// "enable all subsystems"
-	if _, err := c.networkProcessor.UtilCallbackSubCmd(znp.SubsystemIdAllSubsystems, znp.ActionEnable); err != nil {
+	if _, err := networkProcessor.UtilCallbackSubCmd(znp.SubsystemIdAllSubsystems, znp.ActionEnable); err != nil {
 		return fmt.Errorf("UtilCallbackSubCmd: %w", err)
 	}
 
 	// "start zigbee"
-	if _, err := c.networkProcessor.SapiZbStartRequest(); err != nil {
-		return fmt.Errorf("SapiZbStartRequest: %w", err)
+	if zstackVersion.IsZStack3() {
+		if err := startNetworkZStack3(c); err != nil {
+			return err
+		}
+	} else {
+		if _, err := networkProcessor.SapiZbStartRequest(); err != nil {
+			return fmt.Errorf("SapiZbStartRequest: %w", err)
+		}
 	}
 
-	deviceInfo, err := c.networkProcessor.UtilGetDeviceInfo()
+	deviceInfo, err := networkProcessor.UtilGetDeviceInfo()
 	if err != nil {
 		return fmt.Errorf("UtilGetDeviceInfo: %w", err)
 	}
@@ -183,7 +244,7 @@ func (c *Coordinator) Run(ctx context.Context, joinEnable bool, networkProcessor
 		return errors.New("mismatching coordinator IEEEAddr in DeviceInfo vs radio config")
 	}
 
-	if err := setLed(c.config.Led, c.networkProcessor); err != nil {
+	if err := setLed(c.config.Led, networkProcessor); err != nil {
 		return fmt.Errorf("setLed: %w", err)
 	}
 
@@ -199,21 +260,27 @@ func (c *Coordinator) Run(ctx context.Context, joinEnable bool, networkProcessor
 	} {
 		endpoint := zigbee.EndpointId(idx + 1) // 1,2,3,...
 
-		if _, err := c.networkProcessor.AfRegister(endpoint, uint16(profileId), 0x0005, 0x1, znp.LatencyNoLatency, []uint16{}, []uint16{}); err != nil {
+		if _, err := networkProcessor.AfRegister(endpoint, uint16(profileId), 0x0005, 0x1, znp.LatencyNoLatency, []uint16{}, []uint16{}); err != nil {
 			return fmt.Errorf("AfRegister(%d, %d): %w", endpoint, profileId, err)
 		}
 	}
 
-	if joinEnable {
-		log.Info.Println("WARN: permitting joining")
+	if permitJoin > 0 {
+		log.Info.Printf("WARN: permitting joining for %s", permitJoin)
 	}
 
-	if err := setPermitJoiningStatus(joinEnable, c); err != nil {
-		return fmt.Errorf("setPermitJoiningStatus: %w", err)
+	// explicit disable when zero, because coordinator might remember joining from before restart
+	if err := c.PermitJoin(permitJoin, ""); err != nil {
+		return fmt.Errorf("PermitJoin: %w", err)
 	}
 
 	log.Info.Println("running")
 
+	select {
+	case c.messageChannels.onStarted <- networkConf:
+	default:
+	}
+
 	/* poll for when joining gets disabled, but I didn't find how to get current joining status
 	tasks.Start("nwinfodbg",func(ctx context.Context)error{
 		ticker:=time.NewTicker(5*time.Second)
@@ -237,6 +304,23 @@ func (c *Coordinator) syncRequestResponse(
 	sendRequest func() error,
 	expectedType reflect.Type,
 	timeout time.Duration,
+) (interface{}, error) {
+	msg, err := c.syncRequestAwait(sendRequest, func(msg interface{}) (bool, error) {
+		return reflect.TypeOf(msg) == expectedType, nil
+	}, timeout)
+	if errors.Is(err, errResponseTimeout) {
+		return nil, fmt.Errorf("%w of type: %s", err, expectedType)
+	}
+
+	return msg, err
+}
+
+// like syncRequestResponse(), but *accept* decides which message is the response. it can also fail
+// the request, e.g. for a message that reports an error.
+func (c *Coordinator) syncRequestAwait(
+	sendRequest func() error,
+	accept func(msg interface{}) (bool, error),
+	timeout time.Duration,
 ) (interface{}, error) {
 	allIncomingMsgs := make(chan interface{}, 100) // 100 b/c if channel becomes full while we race to consume, we won't get messages
 	c.allIncomingMsgs.Register(allIncomingMsgs)
@@ -245,25 +329,28 @@ func (c *Coordinator) syncRequestResponse(
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	// calling this should yield a message (of *expectedType*) in allIncomingMsgs
+	// calling this should yield an accepted message in allIncomingMsgs
 	if err := sendRequest(); err != nil {
 		return nil, err
 	}
 
 	// try to listen for the timeout duration all incoming messages ("broadcast") whether one comes
-	// in matching expectedType
-	return func() (interface{}, error) {
-		for {
-			select {
-			case msg := <-allIncomingMsgs:
-				if reflect.TypeOf(msg) == expectedType {
-					return msg, nil
-				}
-			case <-ctx.Done():
-				return nil, fmt.Errorf("timeout. didn't receive response of type: %s", expectedType)
+	// in that's accepted
+	for {
+		select {
+		case msg := <-allIncomingMsgs:
+			accepted, err := accept(msg)
+			if err != nil {
+				return nil, err
 			}
+
+			if accepted {
+				return msg, nil
+			}
+		case <-ctx.Done():
+			return nil, errResponseTimeout
 		}
-	}()
+	}
 }
 
 func (c *Coordinator) syncRequestResponseRetryable(call func() error, expectedType reflect.Type, timeout time.Duration, retries int) (interface{}, error) {
@@ -309,28 +396,10 @@ func (c *Coordinator) syncDataRequestResponse(
 		return nil, fmt.Errorf("unable to send data request: %s", err)
 	}
 
-	ctx1, cancel1 := context.WithTimeout(context.Background(), timeout)
-	defer cancel1()
-
 	// 1) AfDataConfirm. this could be a response directly generated by the ZNP to say
 	//    e.g. StatusNwkNoRoute if the target device is not currently in the network
 
-	if err := func() error {
-		for {
-			select {
-			case msg := <-allIncomingMsgs:
-				if dataConfirm, ok := msg.(*znp.AfDataConfirm); ok && dataConfirm.TransID == transactionId {
-					if dataConfirm.Status == znp.StatusSuccess {
-						return nil
-					} else {
-						return fmt.Errorf("data confirm: %s", dataConfirm.Status)
-					}
-				}
-			case <-ctx1.Done():
-				return fmt.Errorf("timeout. didn't receive confirmation for transaction: %d", transactionId)
-			}
-		}
-	}(); err != nil {
+	if err := awaitDataConfirm(allIncomingMsgs, transactionId, timeout); err != nil {
 		return nil, err
 	}
 
@@ -360,6 +429,43 @@ func (c *Coordinator) syncDataRequestResponse(
 	}()
 }
 
+// for groupcasts there is no response from devices - only AfDataConfirm from the ZNP
+func (c *Coordinator) syncDataConfirm(
+	sendRequest func(uint8) error,
+	transactionId uint8,
+	timeout time.Duration,
+) error {
+	allIncomingMsgs := make(chan interface{}, 100) // 100 b/c if channel becomes full while we race to consume, we won't get messages
+	c.allIncomingMsgs.Register(allIncomingMsgs)
+	defer c.allIncomingMsgs.Unregister(allIncomingMsgs)
+
+	if err := sendRequest(transactionId); err != nil {
+		return fmt.Errorf("unable to send data request: %s", err)
+	}
+
+	return awaitDataConfirm(allIncomingMsgs, transactionId, timeout)
+}
+
+func awaitDataConfirm(allIncomingMsgs <-chan interface{}, transactionId uint8, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for {
+		select {
+		case msg := <-allIncomingMsgs:
+			if dataConfirm, ok := msg.(*znp.AfDataConfirm); ok && dataConfirm.TransID == transactionId {
+				if dataConfirm.Status == znp.StatusSuccess {
+					return nil
+				} else {
+					return fmt.Errorf("data confirm: %s", dataConfirm.Status)
+				}
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("timeout. didn't receive confirmation for transaction: %d", transactionId)
+		}
+	}
+}
+
 func readNetworkConfigFromNVRAM(np *znp.Znp) (*NetworkConfiguration, error) {
 	savedNetworkParams, err := (&znp.UtilGetNvInfoRequest{}).Send(np)
 	if err != nil {
@@ -413,40 +519,44 @@ func readNetworkConfigFromNVRAM(np *znp.Znp) (*NetworkConfiguration, error) {
 }
 
 func configureAndReset(coordinator *Coordinator, settingsFlash bool) (*NetworkConfiguration, error) {
-	maybeWrapErr := func(prefix string, err error) error {
-		if err != nil {
-			return fmt.Errorf("%s%w", prefix, err)
-		} else {
-			return nil
-		}
-	}
-
 	if err := coordinator.Reset(); err != nil {
-		return nil, maybeWrapErr("Reset: ", err)
+		return nil, fmt.Errorf("Reset: %w", err)
 	}
 
-	np := coordinator.networkProcessor // shorthand
+	np := coordinator.processor() // shorthand
 
 	// TODO: does the ZNP expect local or UTC time?
 	now := time.Now()
 
 	if _, err := np.SysSetTime(0, uint8(now.Hour()), uint8(now.Minute()), uint8(now.Second()),
 		uint8(now.Month()), uint8(now.Day()), uint16(now.Year())); err != nil {
-		return nil, maybeWrapErr("SysSetTime: ", err)
+		return nil, fmt.Errorf("SysSetTime: %w", err)
 	}
 
-	radioConf, err := readNetworkConfigFromNVRAM(np)
+	zstack3 := coordinator.ZStackVersion().IsZStack3()
+
+	radioConf, err := func() (*NetworkConfiguration, error) {
+		if zstack3 {
+			return readNetworkConfigFromNVRAMZStack3(np)
+		} else {
+			return readNetworkConfigFromNVRAM(np)
+		}
+	}()
 	if err != nil {
 		return nil, fmt.Errorf("readNetworkConfigFromNVRAM: %w", err)
 	}
 
 	// check if in-device persisted configuration matches what we have. it would not be
 	// wise to flash config each time we start, because it wears out the EEPROM
-	if coordinator.config.Equal(*radioConf) {
+	if radioConf != nil && coordinator.config.Equal(*radioConf) {
 		return radioConf, nil // happy path
 	}
 
 	if !settingsFlash { // dangerous unless explicitly given permission
+		if radioConf == nil {
+			return nil, errors.New("radio is not in a network. add flag to allow flashing!")
+		}
+
 		radioConfJson, _ := json.MarshalIndent(radioConf, "", "  ")
 
 		return nil, fmt.Errorf("mismatching config. add flag to allow flashing!\nradio config = %s", radioConfJson)
@@ -454,6 +564,31 @@ func configureAndReset(coordinator *Coordinator, settingsFlash bool) (*NetworkCo
 
 	log.Error.Println("mismatching config - flashing")
 
+	if zstack3 {
+		err = flashNetworkConfigZStack3(coordinator)
+	} else {
+		err = flashNetworkConfig(coordinator)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// radio now has our configuration
+	return &coordinator.config.NetworkConfiguration, nil
+}
+
+// Z-Stack 1.2
+func flashNetworkConfig(coordinator *Coordinator) error {
+	maybeWrapErr := func(prefix string, err error) error {
+		if err != nil {
+			return fmt.Errorf("%s%w", prefix, err)
+		} else {
+			return nil
+		}
+	}
+
+	np := coordinator.processor() // shorthand
+
 	for _, step := range []func() error{
 		func() error {
 			_, err := np.UtilSetPreCfgKey(coordinator.config.NetworkKey)
@@ -496,47 +631,12 @@ func configureAndReset(coordinator *Coordinator, settingsFlash bool) (*NetworkCo
 			return maybeWrapErr("SysSetExtAddr: ", err)
 		},
 		func() error {
-			// TODO: replace this with bit field
-			// TODO: apparently we could list multiple here. is it related to sniffing?
-			channels := &znp.Channels{}
-			switch coordinator.config.Channel {
-			case 11:
-				channels.Channel11 = 1
-			case 12:
-				channels.Channel12 = 1
-			case 13:
-				channels.Channel13 = 1
-			case 14:
-				channels.Channel14 = 1
-			case 15:
-				channels.Channel15 = 1
-			case 16:
-				channels.Channel16 = 1
-			case 17:
-				channels.Channel17 = 1
-			case 18:
-				channels.Channel18 = 1
-			case 19:
-				channels.Channel19 = 1
-			case 20:
-				channels.Channel20 = 1
-			case 21:
-				channels.Channel21 = 1
-			case 22:
-				channels.Channel22 = 1
-			case 23:
-				channels.Channel23 = 1
-			case 24:
-				channels.Channel24 = 1
-			case 25:
-				channels.Channel25 = 1
-			case 26:
-				channels.Channel26 = 1
-			default:
-				return fmt.Errorf("unsupported channel: %d", coordinator.config.Channel)
+			channels, err := channelsFromConfig(coordinator.config.Channel)
+			if err != nil {
+				return err
 			}
 
-			_, err := np.UtilSetChannels(channels)
+			_, err = np.UtilSetChannels(channels)
 
 			return maybeWrapErr("UtilSetChannels: ", err)
 		},
@@ -545,38 +645,55 @@ func configureAndReset(coordinator *Coordinator, settingsFlash bool) (*NetworkCo
 		},
 	} {
 		if err := step(); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
-	return radioConf, nil
+	return nil
 }
 
-func setPermitJoiningStatus(permitJoin bool, coordinator *Coordinator) error {
-	// the naming implies that we can enable joining for temporarily, which we do want.
-	// TODO: take advantage of the fact.
-	timeout := func() uint8 {
-		if permitJoin {
-			// return 0xFF // forever?
-			go func() {
-				<-time.After(120 * time.Second)
-				log.Info.Println("join period passed")
-			}()
-
-			return 120 // minutes? seconds?
-		} else {
-			return 0x00
-		}
-	}()
-
-	if _, err := coordinator.networkProcessor.SapiZbPermitJoiningRequest(
-		zigbee.CoordinatorNwkAddr,
-		timeout,
-	); err != nil {
-		return fmt.Errorf("SapiZbPermitJoiningRequest: %w", err)
+// TODO: replace this with bit field
+// TODO: apparently we could list multiple here. is it related to sniffing?
+func channelsFromConfig(channel uint8) (*znp.Channels, error) {
+	channels := &znp.Channels{}
+	switch channel {
+	case 11:
+		channels.Channel11 = 1
+	case 12:
+		channels.Channel12 = 1
+	case 13:
+		channels.Channel13 = 1
+	case 14:
+		channels.Channel14 = 1
+	case 15:
+		channels.Channel15 = 1
+	case 16:
+		channels.Channel16 = 1
+	case 17:
+		channels.Channel17 = 1
+	case 18:
+		channels.Channel18 = 1
+	case 19:
+		channels.Channel19 = 1
+	case 20:
+		channels.Channel20 = 1
+	case 21:
+		channels.Channel21 = 1
+	case 22:
+		channels.Channel22 = 1
+	case 23:
+		channels.Channel23 = 1
+	case 24:
+		channels.Channel24 = 1
+	case 25:
+		channels.Channel25 = 1
+	case 26:
+		channels.Channel26 = 1
+	default:
+		return nil, fmt.Errorf("unsupported channel: %d", channel)
 	}
 
-	return nil
+	return channels, nil
 }
 
 func setLed(enabled bool, networkProcessor *znp.Znp) error {
@@ -0,0 +1,81 @@
+package coordinator
+
+// we're the trust center: we hand out the network key, and decide which link keys joining devices
+// may use
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+	"github.com/function61/hautomo/pkg/ezstack/znp"
+)
+
+// distributes *key* to all devices (APS Transport-Key), waits *switchDelay* for it to reach them and
+// then has the whole network switch to it (APS Switch-Key). sleepy devices that miss both need to
+// rejoin (they get the current key from us when they do).
+func (c *Coordinator) RotateNetworkKey(key zigbee.NetworkKey, switchDelay time.Duration) error {
+	np := c.processor() // shorthand
+
+	active := znp.ZCDNVNwkActiveKeyInfo{}
+	if err := np.NVRAMRead(&active); err != nil {
+		return fmt.Errorf("RotateNetworkKey: %w", err)
+	}
+
+	if active.Key == key {
+		return errors.New("RotateNetworkKey: key already in use")
+	}
+
+	keySeqNum := active.KeySeqNum + 1 // devices only switch to a key with a different sequence number
+
+	status, err := np.ZdoExtUpdateNwkKey(zigbee.BroadcastAll, keySeqNum, key)
+	if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
+		return fmt.Errorf("RotateNetworkKey: ZdoExtUpdateNwkKey: %w", err)
+	}
+
+	time.Sleep(switchDelay)
+
+	status, err = np.ZdoExtSwitchNwkKey(zigbee.BroadcastAll, keySeqNum)
+	if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
+		return fmt.Errorf("RotateNetworkKey: ZdoExtSwitchNwkKey: %w", err)
+	}
+
+	// the radio keeps the active key itself, but the pre-configured key is what we compare our
+	// configuration against at startup
+	if err := np.NVRAMWrite(&znp.ZCDNVPreCfgKey{NetworkKey: key}); err != nil {
+		return fmt.Errorf("RotateNetworkKey: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config.NetworkKey = key[:] // so re-running startup (e.g. after reconnect) agrees with the radio
+
+	networkConf := *c.networkConf
+	networkConf.NetworkKey = key[:]
+	c.networkConf = &networkConf
+
+	return nil
+}
+
+// lets *device* join with a link key derived from its install code (instead of the well-known
+// default link key). the radio stores it, so this can be done well before the device joins.
+func (c *Coordinator) AddInstallCode(device zigbee.IEEEAddress, code zigbee.InstallCode) error {
+	if !c.ZStackVersion().IsZStack3() {
+		return fmt.Errorf("AddInstallCode: install codes need Z-Stack 3 (have %s)", c.ZStackVersion())
+	}
+
+	// we derive the key ourselves, because the radio accepts only 16-byte codes as-is
+	key := code.LinkKey()
+
+	status, err := c.processor().AppCnfBdbAddInstallCode(
+		znp.InstallCodeFormatKeyDerivedFromInstallCode,
+		device.HexPrefixedString(),
+		key[:])
+	if err := firstError(err, func() error { return status.Status.Error() }); err != nil {
+		return fmt.Errorf("AddInstallCode: %w", err)
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"reflect"
+	"strings"
 
 	. "github.com/function61/hautomo/pkg/builtin"
 	"github.com/function61/hautomo/pkg/ezstack/zigbee"
@@ -68,9 +69,20 @@ func (c *Configuration) Valid() error {
 	)
 }
 
+const tcpPortPrefix = "tcp://"
+
 type Serial struct {
-	Port     string
-	BaudRate *int `json:"BaudRate,omitempty"` // if nil, optimal default is used
+	Port     string // "/dev/ttyACM0" or "tcp://192.168.1.10:6638" for network-attached radios (ser2net etc.)
+	BaudRate *int   `json:"BaudRate,omitempty"` // if nil, optimal default is used. not used for TCP
+}
+
+// "host:port" if radio is reachable over TCP instead of a local serial port
+func (s Serial) TcpAddress() (string, bool) {
+	if !strings.HasPrefix(s.Port, tcpPortPrefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(s.Port, tcpPortPrefix), true
 }
 
 func (s Serial) BaudRateOrDefault() int {
@@ -88,3 +100,8 @@ var ZdoSimpleDescRspType = reflect.TypeOf(&znp.ZdoSimpleDescRsp{})
 var ZdoNodeDescRspType = reflect.TypeOf(&znp.ZdoNodeDescRsp{})
 var ZdoBindRspType = reflect.TypeOf(&znp.ZdoBindRsp{})
 var ZdoUnbindRspType = reflect.TypeOf(&znp.ZdoUnbindRsp{})
+var ZdoMgmtBindRspType = reflect.TypeOf(&znp.ZdoMgmtBindRsp{})
+var ZdoMgmtLeaveRspType = reflect.TypeOf(&znp.ZdoMgmtLeaveRsp{})
+var ZdoMgmtLqiRspType = reflect.TypeOf(&znp.ZdoMgmtLqiRsp{})
+var ZdoMgmtRtgRspType = reflect.TypeOf(&znp.ZdoMgmtRtgRsp{})
+var ZdoMgmtNwkUpdateNotifyType = reflect.TypeOf(&znp.ZdoMgmtNwkUpdateNotify{})
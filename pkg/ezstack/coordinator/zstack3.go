@@ -0,0 +1,177 @@
+package coordinator
+
+// Z-Stack 3.x specifics. compared to Z-Stack 1.2, there's no UtilGetNvInfo (we read the NVRAM items
+// ourselves), no SAPI (network is formed with BDB commissioning and started with ZdoStartupFromApp)
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"time"
+
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+	"github.com/function61/hautomo/pkg/ezstack/znp"
+)
+
+// forming includes an energy scan of the channel, so this takes a few seconds
+const networkStartTimeout = 60 * time.Second
+
+// returns nil if the radio is not in a network (e.g. factory-fresh)
+func readNetworkConfigFromNVRAMZStack3(np *znp.Znp) (*NetworkConfiguration, error) {
+	onANetwork := znp.ZCDNVBDBNodeIsOnANetwork{}
+	if err := np.NVRAMRead(&onANetwork); err != nil {
+		return nil, err
+	}
+
+	if onANetwork.OnANetwork == 0 {
+		return nil, nil
+	}
+
+	deviceInfo, err := np.UtilGetDeviceInfo()
+	if err != nil {
+		return nil, fmt.Errorf("UtilGetDeviceInfo: %w", err)
+	}
+
+	panId := znp.ZCDNVPANID{}
+	extPanId := znp.ZCDNVExtPANID{}
+	chanList := znp.ZCDNVChanList{}
+	preCfgKey := znp.ZCDNVPreCfgKey{}
+
+	for _, item := range []znp.NVRAMItem{&panId, &extPanId, &chanList, &preCfgKey} {
+		if err := np.NVRAMRead(item); err != nil {
+			return nil, err
+		}
+	}
+
+	// unlike UtilGetNvInfo's, this is not byte-reversed
+	channelMask := binary.LittleEndian.Uint32(chanList.Channels[:])
+
+	// same reasoning as in readNetworkConfigFromNVRAM()
+	scanChannelsCount := bits.OnesCount32(channelMask)
+	if scanChannelsCount != 1 {
+		return nil, fmt.Errorf("scanChannelsCount: %d", scanChannelsCount)
+	}
+
+	return &NetworkConfiguration{
+		IEEEAddress: zigbee.IEEEAddress(deviceInfo.IEEEAddr),
+		PanId:       panId.PANID,
+		ExtPanId:    extPanId.ExtendedPANID,
+		Channel:     uint8(bits.TrailingZeros32(channelMask)),
+		NetworkKey:  preCfgKey.NetworkKey[:],
+	}, nil
+}
+
+// writes our configuration and has the radio form a new network with it
+func flashNetworkConfigZStack3(coordinator *Coordinator) error {
+	np := coordinator.processor() // shorthand
+
+	key, err := coordinator.config.GetNetworkKey()
+	if err != nil {
+		return err
+	}
+
+	channels, err := channelsFromConfig(coordinator.config.Channel)
+	if err != nil {
+		return err
+	}
+
+	// leave the current network (if any), so formation starts from a clean slate
+	if err := np.NVRAMWrite(&znp.ZCDNVStartUpOption{StartOption: znp.StartOptionClearState}); err != nil {
+		return err
+	}
+
+	if err := coordinator.Reset(); err != nil {
+		return fmt.Errorf("Reset: %w", err)
+	}
+
+	for _, item := range []znp.NVRAMItem{
+		&znp.ZCDNVLogicalType{LogicalType: zigbee.LogicalTypeCoordinator},
+		// not pre-configured = we distribute the network key to joining devices
+		&znp.ZCDNVPreCfgKeysEnable{Enabled: 0},
+		&znp.ZCDNVPreCfgKey{NetworkKey: key},
+		&znp.ZCDNVZDODirectCB{Enabled: 1},
+		chanListItem(coordinator.config.Channel),
+		&znp.ZCDNVPANID{PANID: coordinator.config.PanId},
+		&znp.ZCDNVExtPANID{ExtendedPANID: coordinator.config.ExtPanId},
+	} {
+		if err := np.NVRAMWrite(item); err != nil {
+			return err
+		}
+	}
+
+	if _, err := np.SysSetExtAddr(coordinator.config.IEEEAddress); err != nil {
+		return fmt.Errorf("SysSetExtAddr: %w", err)
+	}
+
+	// BDB tries primary channels first, then secondary. we don't want any other than ours
+	if _, err := np.AppCnfBdbSetChannel(1, channels); err != nil {
+		return fmt.Errorf("AppCnfBdbSetChannel: %w", err)
+	}
+
+	if _, err := np.AppCnfBdbSetChannel(0, &znp.Channels{}); err != nil {
+		return fmt.Errorf("AppCnfBdbSetChannel: %w", err)
+	}
+
+	if _, err := coordinator.syncRequestAwait(func() error {
+		resp, err := np.AppCnfBdbStartCommissioning(znp.CommissioningModeNetworkFormation)
+		return firstError(err, func() error { return resp.Status.Error() })
+	}, func(msg interface{}) (bool, error) {
+		notification, ok := msg.(*znp.AppCnfBdbCommissioningNotification)
+		if !ok {
+			return false, nil
+		}
+
+		switch notification.CommissioningStatus {
+		case znp.CommissioningStatusSuccess:
+			return true, nil
+		case znp.CommissioningStatusInProgress:
+			return false, nil
+		default:
+			return false, fmt.Errorf("network formation: status %d", notification.CommissioningStatus)
+		}
+	}, networkStartTimeout); err != nil {
+		return fmt.Errorf("AppCnfBdbStartCommissioning: %w", err)
+	}
+
+	return nil
+}
+
+func chanListItem(channel uint8) *znp.ZCDNVChanList {
+	item := &znp.ZCDNVChanList{}
+	binary.LittleEndian.PutUint32(item.Channels[:], 1<<channel)
+	return item
+}
+
+// Z-Stack 1.2 equivalent is SapiZbStartRequest()
+func startNetworkZStack3(coordinator *Coordinator) error {
+	np := coordinator.processor() // shorthand
+
+	deviceInfo, err := np.UtilGetDeviceInfo()
+	if err != nil {
+		return fmt.Errorf("UtilGetDeviceInfo: %w", err)
+	}
+
+	if deviceInfo.DeviceState == znp.DeviceStateStartedAsZigBeeCoordinator { // e.g. just formed the network
+		return nil
+	}
+
+	if _, err := coordinator.syncRequestAwait(func() error {
+		resp, err := np.ZdoStartupFromApp(100)
+		if err != nil {
+			return err
+		}
+
+		if resp.Status == znp.StartupFromAppStatusLeaveAndNotStarted {
+			return fmt.Errorf("radio did not start: %s", resp.Status)
+		}
+
+		return nil
+	}, func(msg interface{}) (bool, error) {
+		stateChange, ok := msg.(*znp.ZdoStateChangeInd)
+		return ok && stateChange.State == znp.DeviceStateStartedAsZigBeeCoordinator, nil
+	}, networkStartTimeout); err != nil {
+		return fmt.Errorf("ZdoStartupFromApp: %w", err)
+	}
+
+	return nil
+}
@@ -3,25 +3,169 @@ package ezstack
 // Registration is somewhat the most complex operation of this package, so it deserves its own file
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
 	"github.com/function61/hautomo/pkg/ezstack/zigbee"
 	"github.com/function61/hautomo/pkg/ezstack/znp"
 )
 
-// queries "device metadata" such as description, endpoints, supported clusters
-func (s *Stack) interrogateDevice(announcedDevice *znp.ZdoEndDeviceAnnceInd) (*Device, error) {
-	ieeeAddress := zigbee.IEEEAddress(announcedDevice.IEEEAddr)
-	nwkAddress := announcedDevice.NwkAddr
+// interview is a sequence of queries to the device. sleepy devices can fall asleep in the middle
+// of it, so we remember how far we got and can resume from the step that failed.
+type interview struct {
+	ieeeAddress zigbee.IEEEAddress
+	nwkAddress  string
+	mainPowered bool
 
+	// results of completed steps. nil = not completed yet
+	basic           *basicDetails
+	nodeDescription *znp.ZdoNodeDescRsp
+	activeEndpoints []zigbee.EndpointId
+	endpoints       []*Endpoint // grows one endpoint at a time
+}
+
+type basicDetails struct {
+	manufacturer string
+	model        Model
+	powerSource  PowerSource
+}
+
+// interview that failed part-way
+type FailedInterview struct {
+	IEEEAddress zigbee.IEEEAddress `json:"ieee_address"`
+	Step        string             `json:"step"` // step that failed
+	Error       string             `json:"error"`
+}
+
+// interviews are stored until they succeed, so they can be resumed
+type interviews struct {
+	failed  map[zigbee.IEEEAddress]*interview
+	errors  map[zigbee.IEEEAddress]error
+	mu      sync.Mutex // protects the maps
+	running sync.Mutex // serializes interviews, since responses are matched only by their type
+}
+
+func newInterview(announcedDevice *znp.ZdoEndDeviceAnnceInd) *interview {
+	return &interview{
+		ieeeAddress: zigbee.IEEEAddress(announcedDevice.IEEEAddr),
+		nwkAddress:  announcedDevice.NwkAddr,
+		mainPowered: announcedDevice.Capabilities.MainPowered > 0,
+	}
+}
+
+// interview for refreshing metadata of a device we already know (e.g. after firmware update)
+func newReinterview(device *Device) *interview {
+	return &interview{
+		ieeeAddress: device.IEEEAddress,
+		nwkAddress:  device.NetworkAddress,
+		mainPowered: device.MainPowered,
+	}
+}
+
+// next step that would run
+func (i *interview) step() string {
+	switch {
+	case i.basic == nil:
+		return "basic"
+	case i.nodeDescription == nil:
+		return "node_description"
+	case i.activeEndpoints == nil:
+		return "active_endpoints"
+	case len(i.endpoints) < len(i.activeEndpoints):
+		return fmt.Sprintf("endpoint_%d", i.activeEndpoints[len(i.endpoints)])
+	default:
+		return "done"
+	}
+}
+
+// queries "device metadata" such as description, endpoints, supported clusters. steps already
+// completed in *iv* are skipped
+func (s *Stack) interrogateDevice(iv *interview) (*Device, error) {
+	if iv.basic == nil {
+		basic, err := s.queryBasicDetails(iv.nwkAddress)
+		if err != nil {
+			return nil, fmt.Errorf("querying basic metadata: %w", err)
+		}
+		iv.basic = basic
+	}
+
+	if iv.nodeDescription == nil {
+		logl.Debug.Printf("Querying node description: [%s]", iv.ieeeAddress)
+
+		nodeDescription, err := s.coordinator.NodeDescription(iv.nwkAddress)
+		if err != nil {
+			return nil, fmt.Errorf("querying node description: %w", err)
+		}
+		iv.nodeDescription = nodeDescription
+	}
+
+	if iv.activeEndpoints == nil {
+		logl.Debug.Printf("Querying active endpoints: [%s]", iv.ieeeAddress)
+
+		activeEndpoints, err := s.coordinator.ActiveEndpoints(iv.nwkAddress)
+		if err != nil {
+			return nil, fmt.Errorf("querying active endpoints: %w", err)
+		}
+		iv.activeEndpoints = activeEndpoints.ActiveEPList
+	}
+
+	for _, endpointNo := range iv.activeEndpoints[len(iv.endpoints):] {
+		logl.Debug.Printf("Request endpoint description: [%s], ep: [%d]", iv.ieeeAddress, endpointNo)
+
+		endpointDescr, err := s.coordinator.SimpleDescription(iv.nwkAddress, endpointNo)
+		if err != nil {
+			return nil, fmt.Errorf("query endpoint %d description: %w", endpointNo, err)
+		}
+
+		endpoint := &Endpoint{
+			Id:             endpointDescr.Endpoint,
+			ProfileId:      endpointDescr.ProfileID,
+			DeviceId:       endpointDescr.DeviceID,
+			DeviceVersion:  endpointDescr.DeviceVersion,
+			InClusterList:  castClusterIds(endpointDescr.InClusterList),
+			OutClusterList: castClusterIds(endpointDescr.OutClusterList),
+		}
+
+		measurementScaling, err := s.queryMeasurementScaling(iv.nwkAddress, endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("query endpoint %d measurement scaling: %w", endpointNo, err)
+		}
+		endpoint.MeasurementScaling = measurementScaling
+
+		// best-effort: some devices have the cluster but don't play along (e.g. Aqara water leak
+		// sensor). they work without enrollment, just report via their own attributes
+		iasZone, err := s.enrollIasZone(iv.nwkAddress, endpoint)
+		if err != nil {
+			logl.Error.Printf("endpoint %d IAS zone enrollment (continuing without): %v", endpointNo, err)
+		}
+		endpoint.IasZone = iasZone // nil if enrollment failed
+
+		iv.endpoints = append(iv.endpoints, endpoint)
+	}
+
+	return &Device{
+		IEEEAddress:    iv.ieeeAddress,
+		NetworkAddress: iv.nwkAddress,
+		MainPowered:    iv.mainPowered,
+		Manufacturer:   iv.basic.manufacturer,
+		Model:          iv.basic.model,
+		PowerSource:    iv.basic.powerSource,
+		LogicalType:    iv.nodeDescription.LogicalType,
+		ManufacturerId: iv.nodeDescription.ManufacturerCode,
+		Endpoints:      iv.endpoints,
+	}, nil
+}
+
+func (s *Stack) queryBasicDetails(nwkAddress string) (*basicDetails, error) {
 	deviceDetails, err := s.ReadAttributes(nwkAddress, cluster.IdGenBasic, []cluster.AttributeId{
 		cluster.AttrBasicManufacturerName,
 		cluster.AttrBasicModelId,
 		cluster.AttrBasicPowerSource,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("querying basic metadata: %w", err)
+		return nil, err
 	}
 
 	findAttr := func(id cluster.AttributeId) *cluster.Attribute { // ugh
@@ -53,48 +197,141 @@ func (s *Stack) interrogateDevice(announcedDevice *znp.ZdoEndDeviceAnnceInd) (*D
 		return PowerSource(0) // this is what unset branch did in previous implementation anyway
 	}()
 
-	logl.Debug.Printf("Querying node description: [%s]", ieeeAddress)
+	return &basicDetails{
+		manufacturer: manufacturer,
+		model:        Model(deviceModel),
+		powerSource:  powerSource,
+	}, nil
+}
+
+// runs interview, remembering it if it fails so it can be resumed
+func (s *Stack) runInterview(iv *interview) (*Device, error) {
+	s.interviews.running.Lock()
+	device, err := s.interrogateDevice(iv)
+	s.interviews.running.Unlock()
+
+	s.interviews.mu.Lock()
+	defer s.interviews.mu.Unlock()
 
-	nodeDescription, err := s.coordinator.NodeDescription(nwkAddress)
 	if err != nil {
-		return nil, fmt.Errorf("querying node description: %w", err)
+		s.interviews.failed[iv.ieeeAddress] = iv
+		s.interviews.errors[iv.ieeeAddress] = err
+		return nil, err
+	}
+
+	delete(s.interviews.failed, iv.ieeeAddress)
+	delete(s.interviews.errors, iv.ieeeAddress)
+
+	return device, nil
+}
+
+func (s *Stack) FailedInterviews() []FailedInterview {
+	s.interviews.mu.Lock()
+	defer s.interviews.mu.Unlock()
+
+	failed := []FailedInterview{}
+	for address, iv := range s.interviews.failed {
+		failed = append(failed, FailedInterview{
+			IEEEAddress: address,
+			Step:        iv.step(),
+			Error:       s.interviews.errors[address].Error(),
+		})
+	}
+
+	return failed
+}
+
+// continues a failed interview of a new device from the step that failed. on success the
+// device gets registered like it would've on first try.
+// (sleepy devices need to be woken up, e.g. by pressing a button, right before calling this)
+func (s *Stack) ResumeInterview(address zigbee.IEEEAddress) (*Device, error) {
+	s.interviews.mu.Lock()
+	iv, found := s.interviews.failed[address]
+	s.interviews.mu.Unlock()
+	if !found {
+		return nil, fmt.Errorf("ResumeInterview: no failed interview for %s", address)
 	}
 
-	logl.Debug.Printf("Querying active endpoints: [%s]", ieeeAddress)
+	// device might have rejoined (with a new network address) since the interview failed
+	iv.nwkAddress = s.currentNetworkAddress(address, iv.nwkAddress)
 
-	activeEndpoints, err := s.coordinator.ActiveEndpoints(nwkAddress)
+	device, err := s.runInterview(iv)
 	if err != nil {
-		return nil, fmt.Errorf("querying active endpoints: %w", err)
+		return nil, fmt.Errorf("ResumeInterview: step %s: %w", iv.step(), err)
 	}
 
-	endpoints := []*Endpoint{}
-	for _, endpointNo := range activeEndpoints.ActiveEPList {
-		logl.Debug.Printf("Request endpoint description: [%s], ep: [%d]", ieeeAddress, endpointNo)
+	if _, found := s.db.GetDevice(address); found { // was a re-interview
+		return device, s.updateInterviewedDevice(device)
+	}
 
-		endpointDescr, err := s.coordinator.SimpleDescription(nwkAddress, endpointNo)
-		if err != nil {
-			return nil, fmt.Errorf("query endpoint %d description: %w", endpointNo, err)
-		}
+	return device, s.registerInterviewedDevice(device)
+}
 
-		endpoints = append(endpoints, &Endpoint{
-			Id:             endpointDescr.Endpoint,
-			ProfileId:      endpointDescr.ProfileID,
-			DeviceId:       endpointDescr.DeviceID,
-			DeviceVersion:  endpointDescr.DeviceVersion,
-			InClusterList:  castClusterIds(endpointDescr.InClusterList),
-			OutClusterList: castClusterIds(endpointDescr.OutClusterList),
-		})
+// re-runs interview on a known device to refresh its endpoints, clusters and model (e.g. after
+// a firmware update)
+func (s *Stack) ReinterviewDevice(address zigbee.IEEEAddress) (*Device, error) {
+	existing, found := s.db.GetDevice(address)
+	if !found {
+		return nil, fmt.Errorf("ReinterviewDevice: not found: %s", address)
 	}
 
-	return &Device{
-		IEEEAddress:    ieeeAddress,
-		NetworkAddress: nwkAddress,
-		MainPowered:    announcedDevice.Capabilities.MainPowered > 0,
-		Manufacturer:   manufacturer,
-		Model:          Model(deviceModel),
-		PowerSource:    powerSource,
-		LogicalType:    nodeDescription.LogicalType,
-		ManufacturerId: nodeDescription.ManufacturerCode,
-		Endpoints:      endpoints,
-	}, nil
+	refreshed, err := s.runInterview(newReinterview(existing))
+	if err != nil {
+		return nil, fmt.Errorf("ReinterviewDevice: %w", err)
+	}
+
+	return refreshed, s.updateInterviewedDevice(refreshed)
+}
+
+// stores re-interviewed device and configures reporting for clusters it might've gained
+func (s *Stack) updateInterviewedDevice(refreshed *Device) error {
+	if err := s.db.UpdateDevice(refreshed); err != nil {
+		return fmt.Errorf("UpdateDevice: %w", err)
+	}
+
+	// not fatal: device works without reporting, we just won't get pushed state updates
+	if err := s.configureReporting(refreshed); err != nil {
+		logl.Error.Printf("configureReporting [%s]: %v", refreshed.IEEEAddress, err)
+	}
+
+	select {
+	case s.channels.onDeviceReinterviewed <- refreshed:
+		return nil
+	default:
+		return errors.New("onDeviceReinterviewed channel has no capacity. Maybe channel has no subscribers")
+	}
+}
+
+// database is updated when a device we know rejoins. for devices we don't know yet, we ask the
+// radio. *fallback* is used if neither knows
+func (s *Stack) currentNetworkAddress(address zigbee.IEEEAddress, fallback string) string {
+	if device, found := s.db.GetDevice(address); found {
+		return device.NetworkAddress
+	}
+
+	nwkAddress, err := s.coordinator.NetworkAddress(address)
+	if err != nil {
+		logl.Debug.Printf("currentNetworkAddress: %v", err)
+		return fallback
+	}
+
+	return nwkAddress
+}
+
+// removes device from the network and the database. *deleteOnly* skips asking the device to
+// leave (useful when the device is dead or already reset)
+func (s *Stack) RemoveDevice(address zigbee.IEEEAddress, deleteOnly bool) error {
+	device, found := s.db.GetDevice(address)
+	if !found {
+		return fmt.Errorf("RemoveDevice: not found: %s", address)
+	}
+
+	if !deleteOnly {
+		if err := s.coordinator.Leave(device.NetworkAddress, device.IEEEAddress, false); err != nil {
+			return fmt.Errorf("RemoveDevice: %w (to only delete from database, use delete-only option)", err)
+		}
+	}
+
+	// device might also announce leaving (ZdoLeaveInd), but by then it's not found anymore
+	return s.unregisterDevice(address)
 }
@@ -0,0 +1,263 @@
+package ezhub
+
+// refreshes device state by reading its attributes: on request ("<prefix>/<device>/get", HTTP API)
+// and by polling devices that don't report their state changes on their own
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/function61/gokit/log/logex"
+	"github.com/function61/hautomo/pkg/ezstack"
+	"github.com/function61/hautomo/pkg/ezstack/ezhub/deviceadapters"
+	"github.com/function61/hautomo/pkg/ezstack/ezhub/homeassistantmqtt"
+	"github.com/function61/hautomo/pkg/ezstack/ezhub/hubtypes"
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+)
+
+// cluster => attributes to read from it
+type attributesToRead map[cluster.ClusterId][]cluster.AttributeId
+
+// reads *requested* attributes (see resolveAttributesToRead()) from device's endpoint and publishes
+// the state changes like they were reported by the device. returns the read values by attribute name.
+func refreshDevice(
+	stack *ezstack.Stack,
+	wdev *hubtypes.Device,
+	endpointId zigbee.EndpointId,
+	requested []string,
+	mqttPublish chan<- homeassistantmqtt.Message,
+	mqttPrefix string,
+) (map[string]interface{}, error) {
+	toRead, err := resolveAttributesToRead(wdev.ZigbeeDevice, endpointId, requested)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(toRead) == 0 {
+		return nil, fmt.Errorf("%s endpoint %d: nothing to read", wdev.ZigbeeDevice.IEEEAddress, endpointId)
+	}
+
+	return readAttributesAndNotifyMQTT(stack, wdev, endpointId, toRead, mqttPublish, mqttPrefix)
+}
+
+func readAttributesAndNotifyMQTT(
+	stack *ezstack.Stack,
+	wdev *hubtypes.Device,
+	endpointId zigbee.EndpointId,
+	toRead attributesToRead,
+	mqttPublish chan<- homeassistantmqtt.Message,
+	mqttPrefix string,
+) (map[string]interface{}, error) {
+	endpoint := ezstack.DeviceAndEndpoint{
+		NetworkAddress: wdev.ZigbeeDevice.NetworkAddress,
+		EndpointId:     endpointId,
+	}
+
+	reports := map[cluster.ClusterId][]*cluster.AttributeReport{}
+	values := map[string]interface{}{}
+
+	for clusterId, attributeIds := range toRead {
+		resp, err := stack.ReadEndpointAttributes(endpoint, clusterId, attributeIds)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", clusterName(clusterId), err)
+		}
+
+		for _, status := range resp.ReadAttributeStatuses {
+			if status.Status != cluster.ZclStatusSuccess { // e.g. device doesn't have this attribute
+				continue
+			}
+
+			// a read attribute is just like a reported one
+			reports[clusterId] = append(reports[clusterId], &cluster.AttributeReport{
+				AttributeID: status.AttributeID,
+				Attribute:   status.Attribute,
+			})
+
+			values[attributeName(clusterId, cluster.AttributeId(status.AttributeID))] = status.Attribute.Value
+		}
+	}
+
+	adapter := deviceadapters.For(wdev.ZigbeeDevice)
+
+	if err := updateAttributesAndNotifyMQTT(wdev, mqttPublish, mqttPrefix, endpointId, func(actx *hubtypes.AttrsCtx) error {
+		for clusterId, clusterReports := range reports {
+			for _, report := range clusterReports {
+				if err := deviceadapters.AttributeReportToAttributes(report, clusterId, wdev.ZigbeeDevice, adapter, actx); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// *requested* items are either a cluster ("genOnOff" = the attributes we'd configure reporting for)
+// or a single attribute ("genOnOff.onOff"). nothing requested = all clusters we'd configure reporting
+// for. clusters the endpoint doesn't implement are left out.
+func resolveAttributesToRead(
+	dev *ezstack.Device,
+	endpointId zigbee.EndpointId,
+	requested []string,
+) (attributesToRead, error) {
+	endpoint := findEndpoint(dev, endpointId)
+	if endpoint == nil {
+		return nil, fmt.Errorf("%s does not have endpoint %d", dev.IEEEAddress, endpointId)
+	}
+
+	reporting := ezstack.DefaultReportingConfiguration.Merge(
+		deviceadapters.For(dev).ReportingConfiguration())
+
+	reportedAttributes := func(clusterId cluster.ClusterId) []cluster.AttributeId {
+		attributeIds := []cluster.AttributeId{}
+		for _, attribute := range reporting[clusterId] {
+			attributeIds = append(attributeIds, attribute.AttributeId)
+		}
+		return attributeIds
+	}
+
+	toRead := attributesToRead{}
+
+	add := func(clusterId cluster.ClusterId, attributeIds ...cluster.AttributeId) {
+		if clusterIdIn(clusterId, endpoint.InClusterList) && len(attributeIds) > 0 {
+			toRead[clusterId] = append(toRead[clusterId], attributeIds...)
+		}
+	}
+
+	if len(requested) == 0 {
+		for _, clusterId := range endpoint.InClusterList {
+			add(clusterId, reportedAttributes(clusterId)...)
+		}
+
+		return toRead, nil
+	}
+
+	for _, item := range requested {
+		clusterId, attributeId, err := parseAttributeSelector(item)
+		if err != nil {
+			return nil, err
+		}
+
+		if attributeId != nil {
+			add(clusterId, *attributeId)
+		} else {
+			add(clusterId, reportedAttributes(clusterId)...)
+		}
+	}
+
+	return toRead, nil
+}
+
+// "genOnOff" => IdGenOnOff, nil
+// "genOnOff.onOff" => IdGenOnOff, 0x0000
+func parseAttributeSelector(selector string) (cluster.ClusterId, *cluster.AttributeId, error) {
+	clusterPart, attributePart, hasAttribute := func() (string, string, bool) {
+		parts := strings.SplitN(selector, ".", 2)
+		if len(parts) == 2 {
+			return parts[0], parts[1], true
+		}
+		return parts[0], "", false
+	}()
+
+	clusterId, definition := cluster.FindDefinitionByName(clusterPart)
+	if definition == nil {
+		return 0, nil, fmt.Errorf("unknown cluster: %s", clusterPart)
+	}
+
+	if !hasAttribute {
+		return clusterId, nil, nil
+	}
+
+	attributeId, attribute := definition.AttributeByName(attributePart)
+	if attribute == nil {
+		return 0, nil, fmt.Errorf("unknown attribute: %s", selector)
+	}
+
+	return clusterId, &attributeId, nil
+}
+
+// 6, 0 => "genOnOff.onOff"
+func attributeName(clusterId cluster.ClusterId, attributeId cluster.AttributeId) string {
+	definition := cluster.FindDefinition(clusterId)
+	if definition == nil {
+		return fmt.Sprintf("%d.%d", clusterId, attributeId)
+	}
+
+	if attribute := definition.Attribute(attributeId); attribute != nil {
+		return definition.Name() + "." + attribute.Name
+	}
+
+	return fmt.Sprintf("%s.%d", definition.Name(), attributeId)
+}
+
+func createPollTask(
+	poll PollConfig,
+	interval time.Duration,
+	stack *ezstack.Stack,
+	nodeDatabase *nodeDb,
+	mqttPublish chan<- homeassistantmqtt.Message,
+	mqttPrefix string,
+	logl *logex.Leveled,
+) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		pollTimer := time.NewTicker(interval)
+		defer pollTimer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-pollTimer.C:
+				// in parallel, so an offline device (whose reads time out) doesn't delay the others.
+				// the round is waited for, so a slow round skips ticks instead of piling up
+				wg := &sync.WaitGroup{}
+
+				for _, wdev := range nodeDatabase.GetWrappedDevices() {
+					if !poll.matches(wdev.ZigbeeDevice) {
+						continue
+					}
+
+					wg.Add(1)
+					go func(wdev *hubtypes.Device) {
+						defer wg.Done()
+
+						pollDevice(poll, wdev, stack, mqttPublish, mqttPrefix, logl)
+					}(wdev)
+				}
+
+				wg.Wait()
+			}
+		}
+	}
+}
+
+func pollDevice(
+	poll PollConfig,
+	wdev *hubtypes.Device,
+	stack *ezstack.Stack,
+	mqttPublish chan<- homeassistantmqtt.Message,
+	mqttPrefix string,
+	logl *logex.Leveled,
+) {
+	for _, endpoint := range wdev.ZigbeeDevice.Endpoints {
+		toRead, err := resolveAttributesToRead(wdev.ZigbeeDevice, endpoint.Id, poll.Attributes)
+		if err != nil || len(toRead) == 0 { // config was validated, so only "nothing to read" here
+			continue
+		}
+
+		// not worth a crash. the device might be offline
+		if _, err := readAttributesAndNotifyMQTT(stack, wdev, endpoint.Id, toRead, mqttPublish, mqttPrefix); err != nil {
+			logl.Error.Printf("poll %s endpoint %d: %v", wdev.ZigbeeDevice.IEEEAddress, endpoint.Id, err)
+
+			return // rest of the endpoints would most likely time out as well
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package ezhub
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/function61/gokit/log/logex"
+	"github.com/function61/gokit/testing/assert"
+	"github.com/function61/hautomo/pkg/ezstack"
+	"github.com/function61/hautomo/pkg/ezstack/coordinator"
+	"github.com/function61/hautomo/pkg/ezstack/ezhub/deviceadapters"
+	"github.com/function61/hautomo/pkg/ezstack/ezhub/homeassistantmqtt"
+	"github.com/function61/hautomo/pkg/ezstack/ezhub/hubtypes"
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+	"github.com/function61/hautomo/pkg/ezstack/znpsim"
+)
+
+func TestParseAttributeSelector(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{"genOnOff", "6 <nil>"},
+		{"genOnOff.onOff", "6 0"},
+		{"msTemperatureMeasurement.tolerance", "1026 3"},
+		{"genNonExistent", "unknown cluster: genNonExistent"},
+		{"genOnOff.nonExistent", "unknown attribute: genOnOff.nonExistent"},
+	} {
+		tc := tc
+		t.Run(tc.input, func(t *testing.T) {
+			assert.EqualString(t, func() string {
+				clusterId, attributeId, err := parseAttributeSelector(tc.input)
+				if err != nil {
+					return err.Error()
+				}
+
+				if attributeId == nil {
+					return fmt.Sprintf("%d <nil>", clusterId)
+				}
+
+				return fmt.Sprintf("%d %d", clusterId, *attributeId)
+			}(), tc.expected)
+		})
+	}
+}
+
+func TestResolveAttributesToRead(t *testing.T) {
+	light := &ezstack.Device{
+		IEEEAddress: "0x000d6ffffe1b2c3d",
+		Endpoints: []*ezstack.Endpoint{
+			{
+				Id:            1,
+				InClusterList: []cluster.ClusterId{cluster.IdGenBasic, cluster.IdGenOnOff, cluster.IdGenLevelCtrl},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name      string
+		requested []string
+		expected  string
+	}{
+		{"nothing requested = reported attributes of all clusters", nil, "map[6:[0] 8:[0]]"},
+		{"cluster", []string{"genOnOff"}, "map[6:[0]]"},
+		{"single attribute", []string{"genOnOff.startUpOnOff"}, "map[6:[16387]]"},
+		{"cluster the endpoint doesn't implement is left out", []string{"genOnOff", "msTemperatureMeasurement"}, "map[6:[0]]"},
+		{"unknown cluster", []string{"genNonExistent"}, "unknown cluster: genNonExistent"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			assert.EqualString(t, func() string {
+				toRead, err := resolveAttributesToRead(light, 1, tc.requested)
+				if err != nil {
+					return err.Error()
+				}
+
+				return fmt.Sprintf("%v", toRead)
+			}(), tc.expected)
+		})
+	}
+
+	_, err := resolveAttributesToRead(light, 2, nil)
+	assert.EqualString(t, err.Error(), "0x000d6ffffe1b2c3d does not have endpoint 2")
+}
+
+// polls apply their results concurrently with the message loop. run with -race
+func TestPollWhileReportsArrive(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "ezhub-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(workDir)
+
+	// node database is persisted to working directory
+	prevWorkDir, err := os.Getwd()
+	assert.Ok(t, err)
+	assert.Ok(t, os.Chdir(workDir))
+	defer func() { _ = os.Chdir(prevWorkDir) }()
+
+	network := coordinator.NetworkConfiguration{
+		IEEEAddress: "0x00124b0012345678",
+		PanId:       0x1a62,
+		ExtPanId:    0xdddddddddddddddd,
+		NetworkKey:  []byte{1, 3, 5, 7, 9, 11, 13, 15, 0, 2, 4, 6, 8, 10, 12, 13},
+		Channel:     15,
+	}
+
+	sim := znpsim.New(network)
+
+	sensor := znpsim.TemperatureSensor("0x00158d0000000001", "0x1001")
+
+	sim.AddDevice(sensor)
+
+	nodeDatabase := &nodeDb{Devices: []*hubtypes.Device{}, Groups: []*hubtypes.Group{}}
+
+	stack := ezstack.New(coordinator.Configuration{
+		NetworkConfiguration: network,
+		Serial:               &coordinator.Serial{Port: "sim"},
+	}, nodeDatabase, nil)
+	stack.UseTransport(sim.Port())
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	simStopped := make(chan error, 1)
+	go func() {
+		simStopped <- sim.Run(ctx)
+	}()
+
+	stackStopped := make(chan error, 1)
+	go func() {
+		stackStopped <- stack.Run(ctx, true, "", false)
+	}()
+
+	defer func() {
+		cancel()
+
+		assert.Ok(t, <-simStopped)
+		<-stackStopped
+	}()
+
+	select {
+	case <-stack.Channels().OnDeviceRegistered():
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for sensor to register")
+	}
+
+	wdev := nodeDatabase.GetWrappedDevice(sensor.IEEEAddress)
+
+	mqttPublish := make(chan homeassistantmqtt.Message, 100)
+
+	go func() {
+		for range mqttPublish {
+		}
+	}()
+	defer close(mqttPublish)
+
+	reportsApplied := make(chan int, 1)
+
+	// like the message loop does
+	go func() {
+		applied := 0
+		defer func() { reportsApplied <- applied }()
+
+		for applied < 20 {
+			select {
+			case msg := <-stack.Channels().OnDeviceIncomingMessage():
+				// e.g. interview's reporting configuration responses are just logged by the message loop
+				if err := updateAttributesAndNotifyMQTT(wdev, mqttPublish, "zigbee2mqtt", msg.IncomingMessage.SrcEndpoint, func(actx *hubtypes.AttrsCtx) error {
+					return deviceadapters.ZclIncomingMessageToAttributes(msg.IncomingMessage, actx, wdev.ZigbeeDevice)
+				}); err == nil {
+					applied++
+				}
+			case <-time.After(10 * time.Second):
+				return
+			}
+		}
+	}()
+
+	poll := PollConfig{Attributes: []string{"msTemperatureMeasurement"}}
+
+	pollErrors := &bytes.Buffer{}
+
+	for i := 0; i < 20; i++ {
+		assert.Ok(t, znpsim.SetTemperature(sensor, 2100+int64(i)))
+
+		pollDevice(poll, wdev, stack, mqttPublish, "zigbee2mqtt", logex.Levels(log.New(pollErrors, "", 0)))
+	}
+
+	assert.EqualInt(t, <-reportsApplied, 20)
+	assert.EqualString(t, pollErrors.String(), "")
+}
@@ -0,0 +1,82 @@
+package ezhub
+
+// "$ hautomo ezhub backup" & "$ hautomo ezhub restore" move the network to another radio (or over a
+// firmware re-flash) without re-pairing devices. see coordinator.Backup for the format.
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/function61/gokit/encoding/jsonfile"
+	"github.com/function61/hautomo/pkg/ezstack"
+	"github.com/function61/hautomo/pkg/ezstack/coordinator"
+)
+
+// writes backup of radio's network state to *backupFilename*
+func Backup(ctx context.Context, backupFilename string) error {
+	conf, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	nodeDatabase, err := loadNodeDatabaseOrInitIfNotFound()
+	if err != nil {
+		return err
+	}
+
+	backup, err := ezstack.New(conf.Coordinator, nodeDatabase, nil).Backup(ctx, "hautomo-ezhub")
+	if err != nil {
+		return err
+	}
+
+	if err := jsonfile.Write(backupFilename, backup); err != nil {
+		return err
+	}
+
+	fmt.Printf("Backed up network with %d device(s) to %s\n", len(backup.Devices), backupFilename)
+
+	return nil
+}
+
+// restores network state from *backupFilename* to the radio (usually a new one), and updates
+// our configuration to match the backup's network
+func Restore(ctx context.Context, backupFilename string) error {
+	backup := &coordinator.Backup{}
+	if err := jsonfile.ReadAllowUnknownFields(backupFilename, backup); err != nil { // other tools may have extra fields
+		return err
+	}
+
+	if err := backup.Valid(); err != nil {
+		return err
+	}
+
+	networkConf, err := backup.NetworkConfiguration()
+	if err != nil {
+		return err
+	}
+
+	conf, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	conf.Coordinator.NetworkConfiguration = *networkConf
+
+	nodeDatabase, err := loadNodeDatabaseOrInitIfNotFound()
+	if err != nil {
+		return err
+	}
+
+	if err := ezstack.New(conf.Coordinator, nodeDatabase, nil).Restore(ctx, backup); err != nil {
+		return err
+	}
+
+	// radio now has the backup's network, so we must also use it from now on
+	if err := jsonfile.Write(configFilename, conf); err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored network from %s. Updated %s to match it\n", backupFilename, configFilename)
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package ezhub
+
+// device-to-device binding, so e.g. an IKEA remote can control a bulb directly even if the hub is down
+
+import (
+	"fmt"
+
+	"github.com/function61/hautomo/pkg/ezstack"
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+)
+
+// clusters that make sense to bind when user didn't specify any. these are the ones remotes &
+// switches send commands in.
+var defaultBindClusters = []cluster.ClusterId{
+	cluster.IdGenOnOff,
+	cluster.IdGenLevelCtrl,
+	cluster.IdGenScenes,
+	cluster.IdLightingColorCtrl,
+	cluster.IdClosuresWindowCovering,
+}
+
+type bindRequest struct {
+	From         zigbee.IEEEAddress `json:"from"`
+	FromEndpoint zigbee.EndpointId  `json:"from_endpoint,omitempty"` // default: DefaultSingleEndpointId
+	To           zigbee.IEEEAddress `json:"to,omitempty"`
+	ToEndpoint   zigbee.EndpointId  `json:"to_endpoint,omitempty"` // default: DefaultSingleEndpointId
+	ToGroup      *zigbee.GroupId    `json:"to_group,omitempty"`    // use instead of To for group binding
+	Clusters     []string           `json:"clusters,omitempty"`    // cluster names. default: common control clusters of source
+}
+
+type bindResult struct {
+	From     zigbee.IEEEAddress `json:"from"`
+	Target   ezstack.BindTarget `json:"target"`
+	Clusters []string           `json:"clusters"`         // successfully (un)bound
+	Failed   map[string]string  `json:"failed,omitempty"` // cluster => error
+}
+
+// binds (or with *unbind* unbinds) all requested clusters. partial failures are reported in result.
+func (b bindRequest) execute(stack *ezstack.Stack, nodeDatabase *nodeDb, unbind bool) (*bindResult, error) {
+	source, found := nodeDatabase.GetDevice(b.From)
+	if !found {
+		return nil, fmt.Errorf("source device not found: %s", b.From)
+	}
+
+	sourceEndpoint := endpointOrDefault(b.FromEndpoint)
+
+	target, err := b.target(nodeDatabase)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterIds, err := resolveBindClusters(source, sourceEndpoint, b.Clusters)
+	if err != nil {
+		return nil, err
+	}
+
+	op := stack.Bind
+	if unbind {
+		op = stack.Unbind
+	}
+
+	result := &bindResult{
+		From:     b.From,
+		Target:   target,
+		Clusters: []string{},
+		Failed:   map[string]string{},
+	}
+
+	for _, clusterId := range clusterIds {
+		name := clusterName(clusterId)
+
+		if err := op(b.From, sourceEndpoint, clusterId, target); err != nil {
+			result.Failed[name] = err.Error()
+			continue
+		}
+
+		result.Clusters = append(result.Clusters, name)
+	}
+
+	if len(result.Clusters) == 0 {
+		return nil, fmt.Errorf("all clusters failed: %v", result.Failed)
+	}
+
+	return result, nil
+}
+
+func (b bindRequest) target(nodeDatabase *nodeDb) (ezstack.BindTarget, error) {
+	switch {
+	case b.ToGroup != nil && b.To != "":
+		return ezstack.BindTarget{}, fmt.Errorf("specify either target device or group, not both")
+	case b.ToGroup != nil:
+		return ezstack.BindTargetGroup(*b.ToGroup), nil
+	case b.To != "":
+		if _, found := nodeDatabase.GetDevice(b.To); !found {
+			return ezstack.BindTarget{}, fmt.Errorf("target device not found: %s", b.To)
+		}
+
+		return ezstack.BindTargetDevice(b.To, endpointOrDefault(b.ToEndpoint)), nil
+	default:
+		return ezstack.BindTarget{}, fmt.Errorf("target device or group required")
+	}
+}
+
+// explicitly requested clusters are used as-is. otherwise defaultBindClusters that the source
+// endpoint outputs
+func resolveBindClusters(source *ezstack.Device, endpointId zigbee.EndpointId, requested []string) ([]cluster.ClusterId, error) {
+	if len(requested) > 0 {
+		clusterIds := []cluster.ClusterId{}
+		for _, name := range requested {
+			clusterId, definition := cluster.FindDefinitionByName(name)
+			if definition == nil {
+				return nil, fmt.Errorf("unknown cluster: %s", name)
+			}
+
+			clusterIds = append(clusterIds, clusterId)
+		}
+
+		return clusterIds, nil
+	}
+
+	endpoint := findEndpoint(source, endpointId)
+	if endpoint == nil {
+		return nil, fmt.Errorf("%s does not have endpoint %d", source.IEEEAddress, endpointId)
+	}
+
+	clusterIds := []cluster.ClusterId{}
+	for _, clusterId := range defaultBindClusters {
+		if clusterIdIn(clusterId, endpoint.OutClusterList) {
+			clusterIds = append(clusterIds, clusterId)
+		}
+	}
+
+	if len(clusterIds) == 0 {
+		return nil, fmt.Errorf("%s endpoint %d does not output any bindable clusters", source.IEEEAddress, endpointId)
+	}
+
+	return clusterIds, nil
+}
+
+func findEndpoint(device *ezstack.Device, endpointId zigbee.EndpointId) *ezstack.Endpoint {
+	for _, endpoint := range device.Endpoints {
+		if endpoint.Id == endpointId {
+			return endpoint
+		}
+	}
+
+	return nil
+}
+
+func endpointOrDefault(endpointId zigbee.EndpointId) zigbee.EndpointId {
+	if endpointId == 0 {
+		return ezstack.DefaultSingleEndpointId
+	}
+
+	return endpointId
+}
+
+func clusterIdIn(clusterId cluster.ClusterId, clusterIds []cluster.ClusterId) bool {
+	for _, candidate := range clusterIds {
+		if candidate == clusterId {
+			return true
+		}
+	}
+
+	return false
+}
+
+func clusterName(clusterId cluster.ClusterId) string {
+	if definition := cluster.FindDefinition(clusterId); definition != nil {
+		return definition.Name()
+	} else {
+		return fmt.Sprintf("unknown cluster: %d", clusterId)
+	}
+}
@@ -0,0 +1,147 @@
+package ezhub
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/function61/gokit/encoding/jsonfile"
+	"github.com/function61/hautomo/pkg/ezstack"
+	"github.com/function61/hautomo/pkg/ezstack/ezhub/homeassistantmqtt"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+)
+
+// requests (in zigbee2mqtt style) to ezhub itself instead of to a specific device.
+// returned data is sent back as the response.
+func processMQTTBridgeRequest(
+	req homeassistantmqtt.BridgeRequest,
+	stack *ezstack.Stack,
+	nodeDatabase *nodeDb,
+) (interface{}, error) {
+	switch req.Name {
+	case "device/bind", "device/unbind":
+		bindReq := bindRequest{}
+		if err := unmarshalBridgeRequest(req, &bindReq); err != nil {
+			return nil, err
+		}
+
+		return bindReq.execute(stack, nodeDatabase, req.Name == "device/unbind")
+	case "device/bindings":
+		deviceReq := struct {
+			Id zigbee.IEEEAddress `json:"id"`
+		}{}
+		if err := unmarshalBridgeRequest(req, &deviceReq); err != nil {
+			return nil, err
+		}
+
+		return stack.Bindings(deviceReq.Id)
+	case "group/add":
+		groupReq := struct {
+			Id           zigbee.GroupId `json:"id"`
+			FriendlyName string         `json:"friendly_name"`
+		}{}
+		if err := unmarshalBridgeRequest(req, &groupReq); err != nil {
+			return nil, err
+		}
+
+		return createGroup(groupReq.Id, groupReq.FriendlyName, nodeDatabase)
+	case "group/remove":
+		groupReq := struct {
+			Id zigbee.GroupId `json:"id"`
+		}{}
+		if err := unmarshalBridgeRequest(req, &groupReq); err != nil {
+			return nil, err
+		}
+
+		return groupReq, deleteGroup(groupReq.Id, stack, nodeDatabase)
+	case "group/members/add":
+		memberReq := groupMemberRequest{}
+		if err := unmarshalBridgeRequest(req, &memberReq); err != nil {
+			return nil, err
+		}
+
+		return addGroupMember(memberReq, stack, nodeDatabase)
+	case "group/members/remove":
+		memberReq := groupMemberRequest{}
+		if err := unmarshalBridgeRequest(req, &memberReq); err != nil {
+			return nil, err
+		}
+
+		return memberReq, removeGroupMember(memberReq, stack, nodeDatabase)
+	case "device/remove":
+		removeReq := removeDeviceRequest{}
+		if err := unmarshalBridgeRequest(req, &removeReq); err != nil {
+			return nil, err
+		}
+
+		return removeReq, stack.RemoveDevice(removeReq.Id, removeReq.Force)
+	case "device/interview":
+		deviceReq := struct {
+			Id zigbee.IEEEAddress `json:"id"`
+		}{}
+		if err := unmarshalBridgeRequest(req, &deviceReq); err != nil {
+			return nil, err
+		}
+
+		return interviewDevice(deviceReq.Id, stack)
+	case "device/interviews/failed":
+		return stack.FailedInterviews(), nil
+	case "device/ota_update/check":
+		deviceReq := struct {
+			Id zigbee.IEEEAddress `json:"id"`
+		}{}
+		if err := unmarshalBridgeRequest(req, &deviceReq); err != nil {
+			return nil, err
+		}
+
+		return deviceReq, notifyOtaImage(deviceReq.Id, stack, nodeDatabase)
+	case "device/ota_update/list":
+		return stack.OtaProgress(), nil
+	case "permit_join":
+		permitJoinReq := permitJoinRequest{}
+		if err := unmarshalBridgeRequest(req, &permitJoinReq); err != nil {
+			return nil, err
+		}
+
+		return permitJoinReq.execute(stack, nodeDatabase)
+	case "networkmap":
+		mapReq := struct {
+			Type string `json:"type"` // "raw" | "graphviz"
+		}{}
+		if err := unmarshalBridgeRequest(req, &mapReq); err != nil {
+			return nil, err
+		}
+
+		topology, err := scanTopology(stack, nodeDatabase)
+		if err != nil {
+			return nil, err
+		}
+
+		switch mapReq.Type {
+		case "raw":
+			return topology, nil
+		case "graphviz":
+			return topologyToDot(topology, nodeDatabase), nil
+		default:
+			return nil, fmt.Errorf("networkmap: unsupported type: %s", mapReq.Type)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported bridge request: %s", req.Name)
+	}
+}
+
+func unmarshalBridgeRequest(req homeassistantmqtt.BridgeRequest, to interface{}) error {
+	if err := jsonfile.UnmarshalDisallowUnknownFields(bytes.NewReader(req.Payload), to); err != nil {
+		return fmt.Errorf("%s: %w", req.Name, err)
+	}
+
+	return nil
+}
+
+func notifyOtaImage(id zigbee.IEEEAddress, stack *ezstack.Stack, nodeDatabase *nodeDb) error {
+	device, found := nodeDatabase.GetDevice(id)
+	if !found {
+		return fmt.Errorf("device not found: %s", id)
+	}
+
+	return stack.NotifyOtaImage(device)
+}
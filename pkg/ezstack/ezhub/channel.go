@@ -0,0 +1,83 @@
+package ezhub
+
+// "$ hautomo ezhub energy-scan" helps choosing a quiet channel, and "$ hautomo ezhub channel-change"
+// moves the network there without re-pairing devices
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/function61/gokit/encoding/jsonfile"
+	"github.com/function61/hautomo/pkg/ezstack"
+)
+
+// prints interference on each channel
+func EnergyScan(ctx context.Context) error {
+	conf, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	nodeDatabase, err := loadNodeDatabaseOrInitIfNotFound()
+	if err != nil {
+		return err
+	}
+
+	energies, err := ezstack.New(conf.Coordinator, nodeDatabase, nil).EnergyScan(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("channel  energy (higher = more interference)")
+
+	for _, energy := range energies {
+		current := ""
+		if energy.Channel == conf.Coordinator.Channel {
+			current = " <- current"
+		}
+
+		fmt.Printf(
+			"%7d  %3d %s%s\n",
+			energy.Channel,
+			energy.Energy,
+			strings.Repeat("#", int(energy.Energy)/8), // max 255 => 31 chars
+			current)
+	}
+
+	return nil
+}
+
+// moves the network to *channel*, and updates our configuration to match
+func ChangeChannel(ctx context.Context, channel uint8) error {
+	conf, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	if channel == conf.Coordinator.Channel {
+		return fmt.Errorf("network is already on channel %d", channel)
+	}
+
+	nodeDatabase, err := loadNodeDatabaseOrInitIfNotFound()
+	if err != nil {
+		return err
+	}
+
+	previousChannel := conf.Coordinator.Channel
+
+	if err := ezstack.New(conf.Coordinator, nodeDatabase, nil).ChangeChannel(ctx, channel); err != nil {
+		return err
+	}
+
+	conf.Coordinator.Channel = channel
+
+	// radio is now on the new channel, so we must also use it from now on
+	if err := jsonfile.Write(configFilename, conf); err != nil {
+		return err
+	}
+
+	fmt.Printf("Moved network from channel %d to %d. Updated %s to match it\n", previousChannel, channel, configFilename)
+
+	return nil
+}
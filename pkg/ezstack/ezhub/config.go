@@ -3,6 +3,7 @@ package ezhub
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -12,20 +13,116 @@ import (
 	"github.com/function61/gokit/encoding/jsonfile"
 	. "github.com/function61/hautomo/pkg/builtin"
 	"github.com/function61/hautomo/pkg/changedetector"
+	"github.com/function61/hautomo/pkg/ezstack"
 	"github.com/function61/hautomo/pkg/ezstack/coordinator"
 	"github.com/function61/hautomo/pkg/ezstack/zigbee"
 )
 
+const configFilename = "ezhub-config.json"
+
 type Config struct {
 	Coordinator coordinator.Configuration
 	HttpAddr    string      `json:"HttpAddr,omitempty"`
 	MQTT        *MQTTConfig `json:"MQTT,omitempty"`
+	OtaDir      string      `json:"OtaDir,omitempty"` // directory of OTA firmware images to offer to devices
+
+	TopologyScanInterval string `json:"TopologyScanInterval,omitempty"` // e.g. "24h". empty = only scan on request
+
+	Polling []PollConfig `json:"Polling,omitempty"` // for devices that don't report their state changes
+}
+
+func readConfig() (*Config, error) {
+	conf := &Config{}
+	if err := jsonfile.ReadDisallowUnknownFields(configFilename, conf); err != nil {
+		return nil, err
+	}
+
+	if err := conf.Valid(); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
 }
 
 func (c Config) Valid() error {
 	return FirstError(
 		c.Coordinator.Valid(),
-		c.MQTT.Valid())
+		c.MQTT.Valid(),
+		func() error {
+			_, err := c.TopologyScanIntervalDuration()
+			return err
+		}(),
+		func() error {
+			for idx, poll := range c.Polling {
+				if err := poll.Valid(); err != nil {
+					return fmt.Errorf("Polling[%d]: %w", idx, err)
+				}
+			}
+			return nil
+		}())
+}
+
+// zero if topology scanning is not scheduled
+func (c Config) TopologyScanIntervalDuration() (time.Duration, error) {
+	if c.TopologyScanInterval == "" {
+		return 0, nil
+	}
+
+	interval, err := time.ParseDuration(c.TopologyScanInterval)
+	if err != nil {
+		return 0, fmt.Errorf("TopologyScanInterval: %w", err)
+	}
+
+	if interval <= 0 { // time.NewTicker() would panic
+		return 0, fmt.Errorf("TopologyScanInterval: must be positive; got %s", c.TopologyScanInterval)
+	}
+
+	return interval, nil
+}
+
+// reads matching devices' attributes every *Interval*
+type PollConfig struct {
+	Model      ezstack.Model `json:"Model,omitempty"`      // e.g. "TRADFRI bulb E27 W opal 1000lm". empty = all devices
+	Attributes []string      `json:"Attributes,omitempty"` // e.g. "genOnOff" or "genOnOff.onOff". empty = the ones we configure reporting for
+	Interval   string        // e.g. "5m"
+}
+
+func (p PollConfig) Valid() error {
+	if p.Model == "" && len(p.Attributes) == 0 { // would also keep waking up battery-powered devices
+		return errors.New("specify Model and/or Attributes")
+	}
+
+	for _, selector := range p.Attributes {
+		if _, _, err := parseAttributeSelector(selector); err != nil {
+			return err
+		}
+	}
+
+	_, err := p.IntervalDuration()
+	return err
+}
+
+// battery-powered devices sleep most of the time (and polling would drain them), so they're
+// polled only if the rule names their model
+func (p PollConfig) matches(dev *ezstack.Device) bool {
+	if p.Model != "" {
+		return dev.Model == p.Model
+	}
+
+	return dev.MainPowered
+}
+
+func (p PollConfig) IntervalDuration() (time.Duration, error) {
+	interval, err := time.ParseDuration(p.Interval)
+	if err != nil {
+		return 0, fmt.Errorf("Interval: %w", err)
+	}
+
+	if interval <= 0 {
+		return 0, fmt.Errorf("Interval: must be positive; got %s", p.Interval)
+	}
+
+	return interval, nil
 }
 
 type MQTTConfig struct {
@@ -79,8 +176,8 @@ func GenerateConfiguration(output io.Writer) error {
 		return err
 	}
 
-	networkKey := make([]byte, len(zigbee.NetworkKey{}))
-	if _, err := rand.Read(networkKey); err != nil {
+	networkKey, err := randomNetworkKey()
+	if err != nil {
 		return err
 	}
 
@@ -102,7 +199,7 @@ func GenerateConfiguration(output io.Writer) error {
 				PanId:       zigbee.PANID(panId.Int64()),
 				ExtPanId:    zigbee.ExtendedPANID(extPanId.Uint64()),
 				IEEEAddress: zigbee.IEEEAddress(fmt.Sprintf("0x%x", coordinatorIEEEAddress)),
-				NetworkKey:  networkKey,
+				NetworkKey:  networkKey[:],
 				Channel:     15,
 			},
 			Serial: &coordinator.Serial{
@@ -117,3 +214,9 @@ func GenerateConfiguration(output io.Writer) error {
 
 	return jsonfile.Marshal(output, conf)
 }
+
+func randomNetworkKey() (zigbee.NetworkKey, error) {
+	key := zigbee.NetworkKey{}
+	_, err := rand.Read(key[:])
+	return key, err
+}
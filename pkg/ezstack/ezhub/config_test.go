@@ -0,0 +1,75 @@
+package ezhub
+
+import (
+	"testing"
+
+	"github.com/function61/gokit/testing/assert"
+	"github.com/function61/hautomo/pkg/ezstack"
+)
+
+func TestPollConfigValid(t *testing.T) {
+	errStr := func(err error) string {
+		if err != nil {
+			return err.Error()
+		}
+
+		return "<nil>"
+	}
+
+	for _, tc := range []struct {
+		name     string
+		input    PollConfig
+		expected string
+	}{
+		{"model", PollConfig{Model: "TRADFRI bulb E27 W opal 1000lm", Interval: "5m"}, "<nil>"},
+		{"attributes", PollConfig{Attributes: []string{"genOnOff", "genLevelCtrl.currentLevel"}, Interval: "1m"}, "<nil>"},
+		{"neither model nor attributes", PollConfig{Interval: "1m"}, "specify Model and/or Attributes"},
+		{"unknown attribute", PollConfig{Attributes: []string{"genOnOff.foo"}, Interval: "1m"}, "unknown attribute: genOnOff.foo"},
+		{"no interval", PollConfig{Attributes: []string{"genOnOff"}}, `Interval: time: invalid duration ""`},
+		{"negative interval", PollConfig{Attributes: []string{"genOnOff"}, Interval: "-1m"}, "Interval: must be positive; got -1m"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			assert.EqualString(t, errStr(tc.input.Valid()), tc.expected)
+		})
+	}
+}
+
+func TestTopologyScanIntervalDuration(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{"", "0s"}, // not scheduled
+		{"24h", "24h0m0s"},
+		{"0s", "TopologyScanInterval: must be positive; got 0s"},
+		{"-1h", "TopologyScanInterval: must be positive; got -1h"},
+	} {
+		tc := tc
+		t.Run(tc.input, func(t *testing.T) {
+			assert.EqualString(t, func() string {
+				interval, err := Config{TopologyScanInterval: tc.input}.TopologyScanIntervalDuration()
+				if err != nil {
+					return err.Error()
+				}
+
+				return interval.String()
+			}(), tc.expected)
+		})
+	}
+}
+
+func TestPollConfigMatches(t *testing.T) {
+	bulb := &ezstack.Device{Model: "TRADFRI bulb E27 W opal 1000lm", MainPowered: true}
+	sensor := &ezstack.Device{Model: "lumi.weather", MainPowered: false}
+
+	allMainsPowered := PollConfig{Attributes: []string{"genOnOff"}}
+
+	assert.Assert(t, allMainsPowered.matches(bulb))
+	assert.Assert(t, !allMainsPowered.matches(sensor))
+
+	sensors := PollConfig{Model: "lumi.weather"}
+
+	assert.Assert(t, !sensors.matches(bulb))
+	assert.Assert(t, sensors.matches(sensor))
+}
@@ -2,6 +2,7 @@ package deviceadapters
 
 import (
 	"github.com/function61/hautomo/pkg/ezstack"
+	"github.com/function61/hautomo/pkg/ezstack/ezhub/hubtypes"
 )
 
 // we have to list devices here only for devices that we've to have custom parsers for
@@ -15,4 +16,7 @@ const (
 	modelAqaraVibrationSensor    ezstack.Model = "lumi.vibration.aq1"
 	modelAqaraDoorSensor         ezstack.Model = "lumi.sensor_magnet.aq2"
 	modelIkeaRollerBlind         ezstack.Model = "FYRTUR block-out roller blind"
+	modelEurotronicSpirit        ezstack.Model = "SPZB0001"                    // https://www.zigbee2mqtt.io/devices/SPZB0001.html
+	modelDanfossAlly             ezstack.Model = "eTRV0100"                    // https://www.zigbee2mqtt.io/devices/014G2461.html
+	modelHeimanGasSensor         ezstack.Model = hubtypes.ModelHeimanGasSensor // shared with autodiscovery
 )
@@ -70,7 +70,16 @@ func afIncomingMessageToAttributes(t *testing.T, dev *ezstack.Device, payloadHex
 
 	assert.EqualString(t, incomingMessage.SrcAddr, dev.NetworkAddress)
 
-	actx := &hubtypes.AttrsCtx{hubtypes.NewAttrBuilder(staticTimestamp), hubtypes.NewAttributes(), incomingMessage.SrcEndpoint}
+	var scaling *ezstack.MeasurementScaling
+	var iasZone *ezstack.IasZone
+	for _, endpoint := range dev.Endpoints {
+		if endpoint.Id == incomingMessage.SrcEndpoint {
+			scaling = endpoint.MeasurementScaling
+			iasZone = endpoint.IasZone
+		}
+	}
+
+	actx := &hubtypes.AttrsCtx{hubtypes.NewAttrBuilder(staticTimestamp), hubtypes.NewAttributes(), incomingMessage.SrcEndpoint, scaling, iasZone}
 
 	assert.Ok(t, ZclIncomingMessageToAttributes(incomingMessage, actx, dev))
 
@@ -0,0 +1,46 @@
+package deviceadapters
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/function61/hautomo/pkg/ezstack/ezhub/hubtypes"
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+)
+
+// Danfoss' manufacturer-specific hvacThermostat attributes
+const (
+	danfossAttrWindowOpenInternal = 0x4000 // enum8
+	danfossAttrMountedModeActive  = 0x4012 // bool. true = not mounted on a radiator yet
+)
+
+var danfossWindowStates = map[uint64]string{
+	0: "quarantine", // window detection is paused
+	1: "closed",
+	2: "hold",
+	3: "open",
+	4: "external_open", // told via window open external attribute
+}
+
+func init() {
+	defineAdapter(modelDanfossAlly,
+		attributeParser(unknownAttribute("hvacThermostat", danfossAttrWindowOpenInternal), danfossWindowOpenInternal),
+		attributeParser(unknownAttribute("hvacThermostat", danfossAttrMountedModeActive), danfossMountedModeActive))
+}
+
+func danfossWindowOpenInternal(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
+	state, found := danfossWindowStates[attr.Value.(uint64)]
+	if !found {
+		return fmt.Errorf("unknown window state: %d", attr.Value.(uint64))
+	}
+
+	actx.Attrs.CustomString["window_state"] = actx.String(state)
+
+	return nil
+}
+
+func danfossMountedModeActive(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
+	actx.Attrs.CustomString["mounted_mode_active"] = actx.String(strconv.FormatBool(attr.Value.(bool)))
+
+	return nil
+}
@@ -0,0 +1,15 @@
+package deviceadapters
+
+import (
+	"testing"
+
+	"github.com/function61/gokit/testing/assert"
+)
+
+func TestDanfossAlly(t *testing.T) {
+	// manufacturer-specific: windowOpenInternal=open, mountedModeActive=true
+	_, attrs := afIncomingMessageToAttributes(t, radiatorValve(modelDanfossAlly), "00000102933d0101007b000d9a8a00000d1c4612010a0040300312401001ade21c")
+
+	assert.EqualString(t, attrs.CustomString["window_state"].Value, "open")
+	assert.EqualString(t, attrs.CustomString["mounted_mode_active"].Value, "true")
+}
@@ -0,0 +1,32 @@
+package deviceadapters
+
+import (
+	"strconv"
+
+	"github.com/function61/hautomo/pkg/ezstack/ezhub/hubtypes"
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+)
+
+// Eurotronic's manufacturer-specific hvacThermostat attributes
+const (
+	eurotronicAttrCurrentHeatingSetpoint = 0x4003 // int16 [0.01 °C]
+	eurotronicAttrHostFlags              = 0x4008 // bitmap24
+)
+
+func init() {
+	defineAdapter(modelEurotronicSpirit,
+		// setpoint changes made with the wheel are only reported here. standard occupiedHeatingSetpoint
+		// catches up later
+		attributeParser(unknownAttribute("hvacThermostat", eurotronicAttrCurrentHeatingSetpoint), hvacThermostatOccupiedHeatingSetpoint),
+		attributeParser(unknownAttribute("hvacThermostat", eurotronicAttrHostFlags), eurotronicHostFlags))
+}
+
+func eurotronicHostFlags(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
+	flags := attr.Value.(uint64)
+
+	actx.Attrs.CustomString["boost"] = actx.String(strconv.FormatBool(flags&(1<<2) != 0))
+	actx.Attrs.CustomString["window_open"] = actx.String(strconv.FormatBool(flags&(1<<4) != 0))
+	actx.Attrs.CustomString["child_protection"] = actx.String(strconv.FormatBool(flags&(1<<7) != 0))
+
+	return nil
+}
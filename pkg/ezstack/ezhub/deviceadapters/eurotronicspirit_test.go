@@ -0,0 +1,17 @@
+package deviceadapters
+
+import (
+	"testing"
+
+	"github.com/function61/gokit/testing/assert"
+)
+
+func TestEurotronicSpirit(t *testing.T) {
+	// manufacturer-specific: currentHeatingSetpoint=2250, hostFlags=boost|window open
+	_, attrs := afIncomingMessageToAttributes(t, radiatorValve(modelEurotronicSpirit), "00000102933d0101007b000d9a8a0000101c3710010a034029ca0808401a140000ade21c")
+
+	assert.Assert(t, attrs.HeatingSetpoint.Value == 22.5)
+	assert.EqualString(t, attrs.CustomString["boost"].Value, "true")
+	assert.EqualString(t, attrs.CustomString["window_open"].Value, "true")
+	assert.EqualString(t, attrs.CustomString["child_protection"].Value, "false")
+}
@@ -90,7 +90,7 @@ func AttributeReportToAttributes(
 	// "genBasic.unknown(65281)" | "genBasic.modelId"
 	keyDisplay := func() string {
 		if attrDef == nil {
-			return fmt.Sprintf("%s.unknown(%d)", clDefinition.Name(), report.AttributeID)
+			return unknownAttribute(clDefinition.Name(), rxAttributeId)
 		} else {
 			return fmt.Sprintf("%s.%s", clDefinition.Name(), attrDef.Name)
 		}
@@ -110,3 +110,9 @@ func AttributeReportToAttributes(
 		return nil
 	}
 }
+
+// attributes missing from cluster definitions (like manufacturer-specific ones) are matched by number.
+// "hvacThermostat", 0x4003 => "hvacThermostat.unknown(16387)"
+func unknownAttribute(clusterName string, attributeId cluster.AttributeId) string {
+	return fmt.Sprintf("%s.unknown(%d)", clusterName, attributeId)
+}
@@ -1,6 +1,8 @@
 package deviceadapters
 
 import (
+	"fmt"
+
 	"github.com/function61/hautomo/pkg/ezstack"
 	"github.com/function61/hautomo/pkg/ezstack/ezhub/hubtypes"
 	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
@@ -19,9 +21,71 @@ var adapterByModel = map[ezstack.Model]Adapter{
 		attributeParser("msIlluminanceMeasurement.measuredValue", msIlluminanceMeasurementMeasuredValue),
 		attributeParser("genBasic.modelId", noopParser), // we already got this key in our Zigbee device metadata, so don't record it
 		attributeParser("closuresWindowCovering.currentPositionLiftPercentage", closuresWindowCoveringCurrentPositionLiftPercentage),
+		attributeParser("closuresWindowCovering.currentPositionTiltPercentage", closuresWindowCoveringCurrentPositionTiltPercentage),
+		attributeParser("closuresDoorLock.lockState", closuresDoorLockLockState),
+		attributeParser("hvacFanCtrl.fanMode", hvacFanCtrlFanMode),
+		attributeParser("haElectricalMeasurement.activePower", haElectricalMeasurementActivePower),
+		attributeParser("haElectricalMeasurement.rmsVoltage", haElectricalMeasurementRmsVoltage),
+		attributeParser("haElectricalMeasurement.rmsCurrent", haElectricalMeasurementRmsCurrent),
+		attributeParser("seMetering.currentSummDelivered", seMeteringCurrentSummDelivered),
+		attributeParser("seMetering.instantaneousDemand", seMeteringInstantaneousDemand),
+		attributeParser("hvacThermostat.localTemp", hvacThermostatLocalTemp),
+		attributeParser("hvacThermostat.occupiedHeatingSetpoint", hvacThermostatOccupiedHeatingSetpoint),
+		attributeParser("hvacThermostat.systemMode", hvacThermostatSystemMode),
+		attributeParser("hvacThermostat.runningState", hvacThermostatRunningState),
+		attributeParser("hvacThermostat.pIHeatingDemand", hvacThermostatPIHeatingDemand),
+		withCommandHandler(genericCommandHandler),
 	),
 }
 
+func genericCommandHandler(command interface{}, actx *hubtypes.AttrsCtx) error {
+	switch cmd := command.(type) {
+	case *cluster.ZoneStatusChangeNotificationCommand:
+		return ssIasZoneStatusChangeNotification(cmd, actx)
+	default:
+		return errUnhandledCommand
+	}
+}
+
+// meaning of the alarm depends on the zone type we read when enrolling the device
+func ssIasZoneStatusChangeNotification(cmd *cluster.ZoneStatusChangeNotificationCommand, actx *hubtypes.AttrsCtx) error {
+	if actx.IasZone == nil {
+		return fmt.Errorf("zone status from endpoint %d with unknown zone type (enrollment pending)", actx.Endpoint)
+	}
+
+	alarm := cmd.ZoneStatus.Alarm1()
+
+	switch actx.IasZone.Type {
+	case cluster.IasZoneTypeMotionSensor:
+		actx.Attrs.Presence = actx.Bool(alarm)
+	case cluster.IasZoneTypeContactSwitch:
+		actx.Attrs.Contact = actx.Bool(!alarm) // alarm = opened
+	case cluster.IasZoneTypeFireSensor:
+		actx.Attrs.Smoke = actx.Bool(alarm)
+	case cluster.IasZoneTypeWaterSensor:
+		actx.Attrs.WaterDetected = actx.Bool(alarm)
+	case cluster.IasZoneTypeCarbonMonoxideSensor:
+		actx.Attrs.CarbonMonoxide = actx.Bool(alarm)
+	case cluster.IasZoneTypeVibrationMovementSensor:
+		if alarm {
+			actx.Attrs.Vibration = actx.Event()
+		}
+	case cluster.IasZoneTypeStandardWarningDevice:
+		// sirens only use the tamper and battery bits
+	default:
+		return fmt.Errorf("unsupported IAS zone type: %s", actx.IasZone.Type)
+	}
+
+	ssIasZoneStatusTamperAndBattery(cmd.ZoneStatus, actx)
+
+	return nil
+}
+
+func ssIasZoneStatusTamperAndBattery(zoneStatus cluster.ZoneStatus, actx *hubtypes.AttrsCtx) {
+	actx.Attrs.Tamper = actx.Bool(zoneStatus.Tamper())
+	actx.Attrs.BatteryLow = actx.Bool(zoneStatus.BatteryLow())
+}
+
 func genOnOffOnOff(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
 	actx.Attrs.On = actx.Bool(attr.Value.(bool))
 
@@ -68,6 +132,131 @@ func closuresWindowCoveringCurrentPositionLiftPercentage(attr *cluster.Attribute
 	return nil
 }
 
+func closuresWindowCoveringCurrentPositionTiltPercentage(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
+	actx.Attrs.ShadeTilt = actx.Int(int64(attr.Value.(uint64)))
+
+	return nil
+}
+
+// 0xff = "undefined", i.e. the lock doesn't know its state (yet)
+func closuresDoorLockLockState(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
+	lockState := cluster.DoorLockState(attr.Value.(uint64))
+	if lockState == cluster.DoorLockStateUndefined {
+		return nil
+	}
+
+	actx.Attrs.LockState = actx.String(lockState.String())
+	actx.Attrs.Locked = actx.Bool(lockState == cluster.DoorLockStateLocked)
+
+	return nil
+}
+
+func hvacFanCtrlFanMode(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
+	actx.Attrs.FanMode = actx.String(cluster.FanMode(attr.Value.(uint64)).String())
+
+	return nil
+}
+
+func haElectricalMeasurementActivePower(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
+	if scaling, known := measurementScaling(actx); known {
+		actx.Attrs.Power = actx.Float(scaling.AcPower.Apply(float64(attr.Value.(int64))))
+	}
+
+	return nil
+}
+
+func haElectricalMeasurementRmsVoltage(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
+	if scaling, known := measurementScaling(actx); known {
+		actx.Attrs.Voltage = actx.Float(scaling.AcVoltage.Apply(float64(attr.Value.(uint64))))
+	}
+
+	return nil
+}
+
+func haElectricalMeasurementRmsCurrent(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
+	if scaling, known := measurementScaling(actx); known {
+		actx.Attrs.Current = actx.Float(scaling.AcCurrent.Apply(float64(attr.Value.(uint64))))
+	}
+
+	return nil
+}
+
+// FIXME: assuming unitOfMeasure is kWh (it is for plugs, but not for e.g. gas meters)
+func seMeteringCurrentSummDelivered(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
+	if scaling, known := measurementScaling(actx); known {
+		actx.Attrs.Energy = actx.Float(scaling.Metering.Apply(float64(attr.Value.(uint64))))
+	}
+
+	return nil
+}
+
+// some plugs report power only via metering cluster
+func seMeteringInstantaneousDemand(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
+	if scaling, known := measurementScaling(actx); known {
+		kw := scaling.Metering.Apply(float64(attr.Value.(int64)))
+
+		actx.Attrs.Power = actx.Float(kw * 1000)
+	}
+
+	return nil
+}
+
+// 0x8000 = "invalid", i.e. the thermostat doesn't have a reading (yet)
+func hvacThermostatLocalTemp(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
+	if centidegrees := attr.Value.(int64); centidegrees != -0x8000 {
+		actx.Attrs.LocalTemperature = actx.Float(float64(centidegrees) / 100)
+	}
+
+	return nil
+}
+
+func hvacThermostatOccupiedHeatingSetpoint(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
+	actx.Attrs.HeatingSetpoint = actx.Float(float64(attr.Value.(int64)) / 100)
+
+	return nil
+}
+
+func hvacThermostatSystemMode(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
+	actx.Attrs.SystemMode = actx.String(cluster.ThermostatSystemMode(attr.Value.(uint64)).String())
+
+	return nil
+}
+
+func hvacThermostatRunningState(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
+	// bits: 0 = heat, 1 = cool, 2 = fan, 3 = heat 2nd stage, 4 = cool 2nd stage, ...
+	runningState := attr.Value.(uint64)
+
+	actx.Attrs.RunningState = actx.String(func() string {
+		switch {
+		case runningState&0b01001 != 0:
+			return "heat"
+		case runningState&0b10010 != 0:
+			return "cool"
+		default:
+			return "idle"
+		}
+	}())
+
+	return nil
+}
+
+func hvacThermostatPIHeatingDemand(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
+	actx.Attrs.HeatingDemand = actx.Int(int64(attr.Value.(uint64)))
+
+	return nil
+}
+
+// endpoint's scaling factors. not known for devices interviewed before we read them at interview
+// (ezstack reads them when the device reports). meanwhile values can't be converted to units, and
+// are better dropped than published wrong
+func measurementScaling(actx *hubtypes.AttrsCtx) (ezstack.MeasurementScaling, bool) {
+	if actx.Scaling == nil {
+		return ezstack.MeasurementScaling{}, false
+	}
+
+	return *actx.Scaling, true
+}
+
 func ignoreForNowTODOTakeIntoAccount(attr *cluster.Attribute, actx *hubtypes.AttrsCtx) error {
 	return nil
 }
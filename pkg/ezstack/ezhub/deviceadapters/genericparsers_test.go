@@ -40,3 +40,185 @@ func ikeaRollerBlind() *ezstack.Device {
 		},
 	}
 }
+
+func TestSmartPlugMetering(t *testing.T) {
+	// activePower=1234, rmsVoltage=230, rmsCurrent=500
+	_, attrs := afIncomingMessageToAttributes(t, smartPlug(), "0000040b933d0101007b000d9a8a00001218010a0b0529d204050521e600080521f401ade21c")
+
+	assert.Assert(t, attrs.Power.Value == 123.4)
+	assert.Assert(t, attrs.Voltage.Value == 230)
+	assert.Assert(t, attrs.Current.Value == 0.5)
+
+	// currentSummDelivered=150
+	_, attrs = afIncomingMessageToAttributes(t, smartPlug(), "00000207933d0101007b000d9a8a00000c18020a000025960000000000ade21c")
+
+	assert.Assert(t, attrs.Energy.Value == 1.5)
+
+	// scaling not known yet (device interviewed before we read it) => raw values are not published
+	plugWithoutScaling := smartPlug()
+	plugWithoutScaling.Endpoints[0].MeasurementScaling = nil
+
+	_, attrs = afIncomingMessageToAttributes(t, plugWithoutScaling, "0000040b933d0101007b000d9a8a00001218010a0b0529d204050521e600080521f401ade21c")
+
+	assert.Assert(t, attrs.Power == nil)
+	assert.Assert(t, attrs.Voltage == nil)
+	assert.Assert(t, attrs.Current == nil)
+}
+
+func smartPlug() *ezstack.Device {
+	return &ezstack.Device{
+		Manufacturer:   "hautomo",
+		Model:          "hautomo.sim.plug",
+		LogicalType:    1,
+		MainPowered:    true,
+		PowerSource:    ezstack.MainsSinglePhase,
+		NetworkAddress: "0x3d93",
+		IEEEAddress:    "0x00158d0000000003",
+		Endpoints: []*ezstack.Endpoint{
+			{
+				Id:             1,
+				ProfileId:      260,
+				DeviceId:       81,
+				DeviceVersion:  1,
+				InClusterList:  []cluster.ClusterId{0, 6, 1794, 2820},
+				OutClusterList: []cluster.ClusterId{},
+				MeasurementScaling: &ezstack.MeasurementScaling{
+					AcVoltage: &ezstack.Scaling{Multiplier: 1, Divisor: 1},
+					AcCurrent: &ezstack.Scaling{Multiplier: 1, Divisor: 1000},
+					AcPower:   &ezstack.Scaling{Multiplier: 1, Divisor: 10},
+					Metering:  &ezstack.Scaling{Multiplier: 1, Divisor: 100},
+				},
+			},
+		},
+	}
+}
+
+func TestThermostat(t *testing.T) {
+	// localTemp=2150, occupiedHeatingSetpoint=2100, systemMode=heat, runningState=heat, pIHeatingDemand=45
+	_, attrs := afIncomingMessageToAttributes(t, radiatorValve(modelEurotronicSpirit), "00000102933d0101007b000d9a8a00001a18010a000029660812002934081c00300429001901000800202dade21c")
+
+	assert.Assert(t, attrs.LocalTemperature.Value == 21.5)
+	assert.Assert(t, attrs.HeatingSetpoint.Value == 21)
+	assert.EqualString(t, attrs.SystemMode.Value, "heat")
+	assert.EqualString(t, attrs.RunningState.Value, "heat")
+	assert.Assert(t, attrs.HeatingDemand.Value == 45)
+
+	// localTemp=0x8000 (no reading)
+	_, attrs = afIncomingMessageToAttributes(t, radiatorValve(modelEurotronicSpirit), "00000102933d0101007b000d9a8a00000818010a0000290080ade21c")
+
+	assert.Assert(t, attrs.LocalTemperature == nil)
+}
+
+func radiatorValve(model ezstack.Model) *ezstack.Device {
+	return &ezstack.Device{
+		Model:          model,
+		LogicalType:    2,
+		MainPowered:    false,
+		PowerSource:    ezstack.Battery,
+		NetworkAddress: "0x3d93",
+		IEEEAddress:    "0x00158d0000000004",
+		Endpoints: []*ezstack.Endpoint{
+			{
+				Id:             1,
+				ProfileId:      260,
+				DeviceId:       769,
+				DeviceVersion:  1,
+				InClusterList:  []cluster.ClusterId{0, 1, 3, 10, 513, 516},
+				OutClusterList: []cluster.ClusterId{25},
+			},
+		},
+	}
+}
+
+func TestIasZone(t *testing.T) {
+	// zoneStatus=alarm1|tamper
+	_, attrs := afIncomingMessageToAttributes(t, alarmSensor("hautomo.sim.smoke", cluster.IasZoneTypeFireSensor), "0000000535a801010083001a8a0200000919530005000017000035a81d")
+
+	assert.Assert(t, attrs.Smoke.Value)
+	assert.Assert(t, attrs.Tamper.Value)
+	assert.Assert(t, !attrs.BatteryLow.Value)
+
+	// alarm of a contact switch means it's open
+	_, attrs = afIncomingMessageToAttributes(t, alarmSensor("hautomo.sim.contact", cluster.IasZoneTypeContactSwitch), "0000000535a801010083001a8a0200000919530001000017000035a81d")
+
+	assert.Assert(t, !attrs.Contact.Value)
+	assert.Assert(t, attrs.Smoke == nil)
+}
+
+func alarmSensor(model ezstack.Model, zoneType cluster.IasZoneType) *ezstack.Device {
+	return &ezstack.Device{
+		Model:          model,
+		LogicalType:    2,
+		MainPowered:    false,
+		PowerSource:    ezstack.Battery,
+		NetworkAddress: "0xa835",
+		IEEEAddress:    "0x00158d0000000005",
+		Endpoints: []*ezstack.Endpoint{
+			{
+				Id:             1,
+				ProfileId:      260,
+				DeviceId:       1026,
+				DeviceVersion:  1,
+				InClusterList:  []cluster.ClusterId{0, 1, 3, 1280},
+				OutClusterList: []cluster.ClusterId{25},
+				IasZone:        &ezstack.IasZone{Type: zoneType},
+			},
+		},
+	}
+}
+
+func TestDoorLock(t *testing.T) {
+	// lockState=locked
+	_, attrs := afIncomingMessageToAttributes(t, genericDevice("hautomo.sim.lock", 10, 257), "00000101933d0101007b000d9a8a00000718010a00003001ade21c")
+
+	assert.Assert(t, attrs.Locked.Value)
+	assert.EqualString(t, attrs.LockState.Value, "locked")
+
+	// lockState=not_fully_locked (jammed)
+	_, attrs = afIncomingMessageToAttributes(t, genericDevice("hautomo.sim.lock", 10, 257), "00000101933d0101007b000d9a8a00000718010a00003000ade21c")
+
+	assert.Assert(t, !attrs.Locked.Value)
+	assert.EqualString(t, attrs.LockState.Value, "not_fully_locked")
+
+	// lockState=undefined
+	_, attrs = afIncomingMessageToAttributes(t, genericDevice("hautomo.sim.lock", 10, 257), "00000101933d0101007b000d9a8a00000718010a000030ffade21c")
+
+	assert.Assert(t, attrs.Locked == nil)
+}
+
+func TestFanControl(t *testing.T) {
+	// fanMode=high
+	_, attrs := afIncomingMessageToAttributes(t, genericDevice("hautomo.sim.fan", 770, 514), "00000202933d0101007b000d9a8a00000718010a00003003ade21c")
+
+	assert.EqualString(t, attrs.FanMode.Value, "high")
+}
+
+func TestWindowCoveringTilt(t *testing.T) {
+	// currentPositionTiltPercentage=25
+	_, attrs := afIncomingMessageToAttributes(t, genericDevice("hautomo.sim.blind", 514, 258), "00000201933d0101007b000d9a8a00000718010a09002019ade21c")
+
+	assert.Assert(t, attrs.ShadeTilt.Value == 25)
+	assert.Assert(t, attrs.ShadePosition == nil)
+}
+
+// mains-powered single-endpoint device implementing *clusterId*
+func genericDevice(model ezstack.Model, deviceId uint16, clusterId cluster.ClusterId) *ezstack.Device {
+	return &ezstack.Device{
+		Model:          model,
+		LogicalType:    1,
+		MainPowered:    true,
+		PowerSource:    ezstack.MainsSinglePhase,
+		NetworkAddress: "0x3d93",
+		IEEEAddress:    "0x00158d0000000006",
+		Endpoints: []*ezstack.Endpoint{
+			{
+				Id:             1,
+				ProfileId:      260,
+				DeviceId:       deviceId,
+				DeviceVersion:  1,
+				InClusterList:  []cluster.ClusterId{0, 3, clusterId},
+				OutClusterList: []cluster.ClusterId{},
+			},
+		},
+	}
+}
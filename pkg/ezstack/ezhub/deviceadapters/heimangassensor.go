@@ -0,0 +1,22 @@
+package deviceadapters
+
+import (
+	"github.com/function61/hautomo/pkg/ezstack/ezhub/hubtypes"
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+)
+
+func init() {
+	defineAdapter(modelHeimanGasSensor,
+		withCommandHandler(func(command interface{}, actx *hubtypes.AttrsCtx) error {
+			switch cmd := command.(type) {
+			case *cluster.ZoneStatusChangeNotificationCommand:
+				// there's no zone type for gas sensors, so we can't rely on it
+				actx.Attrs.Gas = actx.Bool(cmd.ZoneStatus.Alarm1())
+				ssIasZoneStatusTamperAndBattery(cmd.ZoneStatus, actx)
+
+				return nil
+			default:
+				return errUnhandledCommand
+			}
+		}))
+}
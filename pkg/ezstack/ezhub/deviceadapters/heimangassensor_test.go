@@ -0,0 +1,14 @@
+package deviceadapters
+
+import (
+	"testing"
+
+	"github.com/function61/gokit/testing/assert"
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+)
+
+func TestHeimanGasSensor(t *testing.T) {
+	// zone type doesn't matter, alarm means gas
+	assert.EqualString(t, afIncomingMessageToMqttMsg(t, alarmSensor(modelHeimanGasSensor, cluster.IasZoneTypeStandardCIE), "0000000535a801010083001a8a0200000919530001000017000035a81d"), `
+{"gas":true,"tamper":false,"battery_low":false}`)
+}
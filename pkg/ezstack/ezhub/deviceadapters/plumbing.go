@@ -38,6 +38,9 @@ type Adapter interface {
 
 	// returns nil if not battery powered
 	BatteryType() *hubtypes.BatteryType
+
+	// overrides for ezstack.DefaultReportingConfiguration. nil if no overrides
+	ReportingConfiguration() ezstack.ReportingConfiguration
 }
 
 // swallows an attribute we know we don't need (so it is not logged as surprising unsupported attribute)
@@ -79,6 +82,13 @@ func withCommandHandler(handler commandHandlerFn) optFn {
 	}
 }
 
+// per-cluster overrides to reporting configuration. empty attribute list disables a cluster
+func withReporting(reporting ezstack.ReportingConfiguration) optFn {
+	return func(adapter *simpleAdapter) {
+		adapter.reporting = reporting
+	}
+}
+
 type commandHandlerFn func(command interface{}, actx *hubtypes.AttrsCtx) error
 
 // covers the most common use case where we pass attribute to a function for parsing
@@ -86,6 +96,7 @@ type simpleAdapter struct {
 	matchers       []attributeMatcher
 	batteryType    *hubtypes.BatteryType
 	commandHandler commandHandlerFn
+	reporting      ezstack.ReportingConfiguration
 }
 
 func newAdapter(opts ...optFn) Adapter {
@@ -121,6 +132,10 @@ func (h *simpleAdapter) BatteryType() *hubtypes.BatteryType {
 	return h.batteryType
 }
 
+func (h *simpleAdapter) ReportingConfiguration() ezstack.ReportingConfiguration {
+	return h.reporting
+}
+
 // uses primary device (model-specific parsers) to find a parser for an attribute.
 // if no such thing is found, returns parser from secondary device.
 //
@@ -156,3 +171,7 @@ func (m *mergedDevice) HandleCommand(command interface{}, actx *hubtypes.AttrsCt
 func (m *mergedDevice) BatteryType() *hubtypes.BatteryType {
 	return m.primary.BatteryType()
 }
+
+func (m *mergedDevice) ReportingConfiguration() ezstack.ReportingConfiguration {
+	return m.primary.ReportingConfiguration()
+}
@@ -1,8 +1,20 @@
 package deviceadapters
 
+import (
+	"github.com/function61/hautomo/pkg/ezstack"
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+)
+
 func init() {
 	defineAdapter(modelAqaraTemperatureSensor,
 		aqaraVoltageEtc,
 		withBatteryType(BatteryCR2032),
+		// reports on its own schedule and doesn't honor reporting configuration
+		withReporting(ezstack.ReportingConfiguration{
+			cluster.IdMsTemperatureMeasurement: {},
+			cluster.IdMsRelativeHumidity:       {},
+			cluster.IdMsPressureMeasurement:    {},
+			cluster.IdGenPowerCfg:              {},
+		}),
 	)
 }
@@ -0,0 +1,25 @@
+package ezhub
+
+// device lifecycle operations: removing and (re-)interviewing
+
+import (
+	"github.com/function61/hautomo/pkg/ezstack"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+)
+
+type removeDeviceRequest struct {
+	Id    zigbee.IEEEAddress `json:"id"`
+	Force bool               `json:"force,omitempty"` // only delete from database, don't ask the device to leave
+}
+
+// interviews a device whose earlier interview failed (resuming from the failed step), or
+// re-interviews a known device to refresh its metadata
+func interviewDevice(id zigbee.IEEEAddress, stack *ezstack.Stack) (*ezstack.Device, error) {
+	for _, failed := range stack.FailedInterviews() {
+		if failed.IEEEAddress == id {
+			return stack.ResumeInterview(id)
+		}
+	}
+
+	return stack.ReinterviewDevice(id)
+}
@@ -6,11 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"reflect"
 	"time"
 
-	"github.com/function61/gokit/encoding/jsonfile"
 	"github.com/function61/gokit/log/logex"
 	"github.com/function61/gokit/net/http/httputils"
 	"github.com/function61/gokit/sync/taskrunner"
@@ -18,6 +18,7 @@ import (
 	"github.com/function61/hautomo/pkg/ezstack/ezhub/deviceadapters"
 	"github.com/function61/hautomo/pkg/ezstack/ezhub/homeassistantmqtt"
 	"github.com/function61/hautomo/pkg/ezstack/ezhub/hubtypes"
+	"github.com/function61/hautomo/pkg/ezstack/ota"
 	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
 	"github.com/function61/hautomo/pkg/ezstack/zigbee"
 	"github.com/function61/hautomo/pkg/homeassistant"
@@ -29,19 +30,18 @@ func Run(
 	joinEnable bool,
 	packetCaptureFile string,
 	settingsFlash bool,
+	serialSim bool,
 	rootLogger *log.Logger,
 ) error {
 	logl := logex.Levels(rootLogger)
 
 	mqttPublish := make(chan homeassistantmqtt.Message, 100)
 	mqttInbound := make(chan homeassistantmqtt.InboundMessage, 100)
+	mqttReadRequests := make(chan homeassistantmqtt.ReadRequest, 10)
+	mqttBridgeRequests := make(chan homeassistantmqtt.BridgeRequest, 10)
 
-	conf := Config{}
-	if err := jsonfile.ReadDisallowUnknownFields("ezhub-config.json", &conf); err != nil {
-		return err
-	}
-
-	if err := conf.Valid(); err != nil {
+	conf, err := readConfig()
+	if err != nil {
 		return err
 	}
 
@@ -54,10 +54,29 @@ func Run(
 		logl.Error.Printf("homeAssistantAutoDiscovery: %w", err)
 	}
 
-	stack := ezstack.New(conf.Coordinator, nodeDatabase)
+	stack := ezstack.New(conf.Coordinator, nodeDatabase, func(model ezstack.Model) ezstack.ReportingConfiguration {
+		return deviceadapters.AdapterForModel(model).ReportingConfiguration()
+	})
+
+	if conf.OtaDir != "" {
+		otaImages, err := ota.LoadDirectory(conf.OtaDir)
+		if err != nil {
+			return err
+		}
+
+		logl.Info.Printf("OTA: serving %d image(s) from %s", len(otaImages.Images()), conf.OtaDir)
+
+		stack.EnableOta(otaImages)
+	}
 
 	tasks := taskrunner.New(ctx, rootLogger)
 
+	if serialSim {
+		logl.Info.Println("WARN: using simulated Zigbee radio")
+
+		startSerialSim(stack, *conf, nodeDatabase, tasks)
+	}
+
 	tasks.Start("mqtt-connection-loop", func(ctx context.Context) error {
 		for {
 			err := homeassistantmqtt.ConnectAndServe(
@@ -65,7 +84,9 @@ func Run(
 				conf.MQTT.Addr,
 				conf.MQTT.Prefix,
 				mqttPublish,
-				mqttInbound)
+				mqttInbound,
+				mqttReadRequests,
+				mqttBridgeRequests)
 
 			select {
 			case <-ctx.Done():
@@ -84,7 +105,7 @@ func Run(
 	if conf.HttpAddr != "" {
 		srv := &http.Server{
 			Addr:    conf.HttpAddr,
-			Handler: createHttpApi(stack, nodeDatabase),
+			Handler: createHttpApi(stack, nodeDatabase, mqttPublish, conf.MQTT.Prefix),
 		}
 
 		tasks.Start("http "+srv.Addr, func(ctx context.Context) error {
@@ -104,14 +125,42 @@ func Run(
 				logl.Debug.Printf("MQTT inbound %s: %s", msg.DeviceId, msgJson)
 
 				go func() {
+					if msg.Group != nil {
+						if err := processMQTTInboundGroupMessage(msg, stack, nodeDatabase, mqttPublish, conf.MQTT.Prefix); err != nil {
+							logl.Error.Printf("processMQTTInboundGroupMessage: %v", err.Error())
+						}
+
+						return
+					}
+
 					if err := processMQTTInboundMessage(msg, stack, nodeDatabase, mqttPublish, conf.MQTT.Prefix); err != nil {
 						logl.Error.Printf("processMQTTInboundMessage: %v", err.Error())
 					}
 				}()
+			case req := <-mqttReadRequests: // refresh device's state from the Zigbee network
+				logl.Debug.Printf("MQTT read request %s: %v", req.DeviceId, req.Attributes)
+
+				go func() {
+					if err := processMQTTReadRequest(req, stack, nodeDatabase, mqttPublish, conf.MQTT.Prefix); err != nil {
+						logl.Error.Printf("processMQTTReadRequest: %v", err)
+					}
+				}()
+			case req := <-mqttBridgeRequests: // requests to ezhub itself
+				logl.Debug.Printf("MQTT bridge request %s: %s", req.Name, req.Payload)
+
+				go func() {
+					data, err := processMQTTBridgeRequest(req, stack, nodeDatabase)
+					if err != nil {
+						logl.Error.Printf("processMQTTBridgeRequest %s: %v", req.Name, err)
+					}
+
+					mqttPublish <- homeassistantmqtt.BridgeResponse(conf.MQTT.Prefix, req.Name, data, err)
+				}()
 			case deviceIncomingMessage := <-chans.OnDeviceIncomingMessage(): // messages FROM Zigbee network
 				wdev := nodeDatabase.GetWrappedDevice(deviceIncomingMessage.Device.IEEEAddress)
-				if wdev == nil {
-					return fmt.Errorf("incoming message from unknown device: %s", deviceIncomingMessage.Device.IEEEAddress)
+				if wdev == nil { // e.g. a late frame from a device that just left. not worth a crash
+					logl.Error.Printf("incoming message from unknown device: %s", deviceIncomingMessage.Device.IEEEAddress)
+					continue
 				}
 
 				// after this wrapper has returned, we will have informed subscribers over MQTT
@@ -131,18 +180,58 @@ func Run(
 				); err != nil {
 					logl.Error.Printf("OnDeviceIncomingMessage: %v", err)
 				}
-			case _ = <-chans.OnDeviceRegistered():
-				logl.Info.Println("device registered")
-			case _ = <-chans.OnDeviceBecameAvailable(): // TODO: diff between registered & available?
-				logl.Info.Println("device available")
-			case _ = <-chans.OnDeviceUnregistered():
-				logl.Info.Println("device unregistered")
+			case progress := <-chans.OnOtaProgress():
+				logl.Info.Printf("OTA %s: %s %d %%", progress.Device, progress.State, progress.Percent())
+
+				mqttPublish <- homeassistantmqtt.OtaProgressMessage(conf.MQTT.Prefix, progress)
+			case dev := <-chans.OnDeviceRegistered():
+				logl.Info.Printf("device registered: %s", dev.IEEEAddress)
+
+				mqttPublish <- homeassistantmqtt.BridgeEvent(conf.MQTT.Prefix, "device_joined", deviceEventData(dev, nodeDatabase))
+			case dev := <-chans.OnDeviceReinterviewed():
+				logl.Info.Printf("device re-interviewed: %s", dev.IEEEAddress)
+
+				mqttPublish <- homeassistantmqtt.BridgeEvent(conf.MQTT.Prefix, "device_interview", deviceEventData(dev, nodeDatabase))
+
+				// device might have gained entities. connects to MQTT, so don't block message handling
+				go func() {
+					if err := homeAssistantAutoDiscovery(conf.MQTT.Addr, conf.MQTT.Prefix, nodeDatabase, rootLogger); err != nil {
+						logl.Error.Printf("homeAssistantAutoDiscovery after re-interview: %v", err)
+					}
+				}()
+			case dev := <-chans.OnDeviceBecameAvailable(): // TODO: diff between registered & available?
+				logl.Info.Printf("device available: %s", dev.IEEEAddress)
+
+				mqttPublish <- homeassistantmqtt.BridgeEvent(conf.MQTT.Prefix, "device_announce", deviceEventData(dev, nodeDatabase))
+			case dev := <-chans.OnDeviceUnregistered():
+				logl.Info.Printf("device unregistered: %s", dev.IEEEAddress)
+
+				mqttPublish <- homeassistantmqtt.BridgeEvent(conf.MQTT.Prefix, "device_leave", deviceEventData(dev, nodeDatabase))
 			}
 		}
 	})
 
 	tasks.Start("state-snapshot", createStateSnapshotTask(nodeDatabase))
 
+	tasks.Start("permit-join-publisher", createPermitJoinPublisherTask(stack, mqttPublish, conf.MQTT.Prefix))
+
+	if topologyScanInterval, _ := conf.TopologyScanIntervalDuration(); topologyScanInterval != 0 {
+		tasks.Start("topology-scan", createTopologyScanTask(topologyScanInterval, stack, nodeDatabase, logl))
+	}
+
+	for idx, poll := range conf.Polling {
+		interval, _ := poll.IntervalDuration() // validated
+
+		tasks.Start(fmt.Sprintf("poll[%d] every %s", idx, interval), createPollTask(
+			poll,
+			interval,
+			stack,
+			nodeDatabase,
+			mqttPublish,
+			conf.MQTT.Prefix,
+			logl))
+	}
+
 	return tasks.Wait()
 }
 
@@ -159,123 +248,320 @@ func processMQTTInboundMessage(
 		return fmt.Errorf("device not found: %s", inboundMsg.DeviceId)
 	}
 
+	endpointId := dev.DefaultEndpointId()
+	if inboundMsg.Endpoint != nil {
+		endpointId = *inboundMsg.Endpoint
+	}
+
+	if !dev.HasEndpoint(endpointId) {
+		return fmt.Errorf("device %s has no endpoint %d", inboundMsg.DeviceId, endpointId)
+	}
+
 	endpoint := ezstack.DeviceAndEndpoint{
 		NetworkAddress: dev.ZigbeeDevice.NetworkAddress,
-		EndpointId:     ezstack.DefaultSingleEndpointId, // incoming commands only modify the default endpoint
+		EndpointId:     endpointId,
 	}
 
 	// we must echo the changes made back to the MQTT network (Home Assistant expects that in
 	// "non-optimistic" mode)
-	if err := updateAttributesAndNotifyMQTT(dev, mqttPublish, mqttPrefix, endpoint.EndpointId, func(actx *hubtypes.AttrsCtx) error {
-		// when tweaking color temp, incoming MQTT message will happily ask us to:
-		//
-		//   {"state":"ON","color_temp":313}
-		//
-		// even if the state is already on. we can't therefore take state=ON at face value
-		// to send "turn on" to Zigbee network (if we want to avoid unnecessary traffic). so
-		// we'll do desired state vs. actual state diffs to determine which messages we'll
-		// actually send.
-		desiredState := &hubtypes.AttrsCtx{ // TODO: new somewhere else
-			Attrs:       hubtypes.NewAttributes(),
-			AttrBuilder: actx.AttrBuilder,
-		}
+	return updateAttributesAndNotifyMQTT(dev, mqttPublish, mqttPrefix, endpoint.EndpointId, func(actx *hubtypes.AttrsCtx) error {
+		return applyInboundMessage(inboundMsg, actx, func(command cluster.LocalCommand) error {
+			return zigbee.LocalCommand(endpoint, command)
+		}, func(clusterId cluster.ClusterId, records ...*cluster.WriteAttributeRecord) error {
+			return zigbee.WriteEndpointAttributes(endpoint, clusterId, records...)
+		})
+	})
+}
 
-		currentlyOn := func() bool { // toggle msg needs this
-			if actx.Attrs.On != nil {
-				return actx.Attrs.On.Value
-			} else {
-				return false // assume off if we don't know (or entity is not "on-able")
-			}
-		}()
+// some external system wants fresh state of a Zigbee device
+func processMQTTReadRequest(
+	req homeassistantmqtt.ReadRequest,
+	stack *ezstack.Stack,
+	nodeDatabase *nodeDb,
+	mqttPublish chan<- homeassistantmqtt.Message,
+	mqttPrefix string,
+) error {
+	dev := nodeDatabase.GetWrappedDevice(req.DeviceId)
+	if dev == nil {
+		return fmt.Errorf("device not found: %s", req.DeviceId)
+	}
+
+	endpointId := dev.DefaultEndpointId()
+	if req.Endpoint != nil {
+		endpointId = *req.Endpoint
+	}
+
+	if !dev.HasEndpoint(endpointId) {
+		return fmt.Errorf("device %s has no endpoint %d", req.DeviceId, endpointId)
+	}
+
+	_, err := refreshDevice(stack, dev, endpointId, req.Attributes, mqttPublish, mqttPrefix)
+	return err
+}
+
+// some external system wants to control a Zigbee group. commands are groupcast.
+func processMQTTInboundGroupMessage(
+	inboundMsg homeassistantmqtt.InboundMessage,
+	zigbee *ezstack.Stack,
+	nodeDatabase *nodeDb,
+	mqttPublish chan<- homeassistantmqtt.Message,
+	mqttPrefix string,
+) error {
+	groupId := *inboundMsg.Group
+
+	now := time.Now().UTC()
 
-		// *desiredState* will now contain {"On":true,"ColorTemperature":313}
-		if err := homeassistantmqtt.MessageToAttributes(inboundMsg, desiredState, currentlyOn); err != nil {
+	// group's state is modified in place, so it must happen under the database lock (which also
+	// persists the state). groupcasts are fire-and-forget, so sending them doesn't hold the lock for long
+	changedAttributesMsg := ""
+	if err := nodeDatabase.UpdateGroup(groupId, func(group *hubtypes.Group) error {
+		actx := &hubtypes.AttrsCtx{hubtypes.NewAttrBuilder(now), group.State, 0, nil, nil}
+
+		if err := applyInboundMessage(inboundMsg, actx, func(command cluster.LocalCommand) error {
+			return zigbee.GroupCommand(groupId, command)
+		}, func(_ cluster.ClusterId, _ ...*cluster.WriteAttributeRecord) error {
+			return errors.New("writing attributes is not supported for groups")
+		}); err != nil {
 			return err
 		}
 
-		// assigns desired state attrs to current state only if the values are different
-		// (along with last change timestamp as *now*, so changed() helper can detect it)
-		desiredState.Attrs.CopyDifferentAttrsTo(actx.Attrs)
+		var err error
+		changedAttributesMsg, err = homeassistantmqtt.MessageFromChangedAttributes(group.State, nil, nil, now)
+		return err
+	}); err != nil {
+		return err
+	}
 
-		now := actx.Reported
-		attrs := actx.Attrs // shorthand
+	select {
+	case mqttPublish <- homeassistantmqtt.Message{
+		Topic:   fmt.Sprintf("%s/group/%d", mqttPrefix, groupId),
+		Content: changedAttributesMsg,
+	}:
+		return nil
+	default:
+		return errors.New("mqttPublish full")
+	}
+}
 
-		changed := func(attr hubtypes.Attribute) bool { // helper
-			if isNilInterface(attr) {
-				return false
-			}
+// translates inbound message to desired attribute changes and *send*s commands (or *write*s
+// attributes, for things that have no command) for the ones that differ from *actx*'s current attributes
+func applyInboundMessage(
+	inboundMsg homeassistantmqtt.InboundMessage,
+	actx *hubtypes.AttrsCtx,
+	send func(command cluster.LocalCommand) error,
+	write func(clusterId cluster.ClusterId, records ...*cluster.WriteAttributeRecord) error,
+) error {
+	// when tweaking color temp, incoming MQTT message will happily ask us to:
+	//
+	//   {"state":"ON","color_temp":313}
+	//
+	// even if the state is already on. we can't therefore take state=ON at face value
+	// to send "turn on" to Zigbee network (if we want to avoid unnecessary traffic). so
+	// we'll do desired state vs. actual state diffs to determine which messages we'll
+	// actually send.
+	desiredState := &hubtypes.AttrsCtx{ // TODO: new somewhere else
+		Attrs:       hubtypes.NewAttributes(),
+		AttrBuilder: actx.AttrBuilder,
+	}
 
-			return attr.LastChange().Equal(now)
+	currentlyOn := func() bool { // toggle msg needs this
+		if actx.Attrs.On != nil {
+			return actx.Attrs.On.Value
+		} else {
+			return false // assume off if we don't know (or entity is not "on-able")
 		}
+	}()
 
-		if changed(attrs.On) {
-			if attrs.On.Value {
-				if err := zigbee.LocalCommand(endpoint, &cluster.GenOnOffOnCommand{}); err != nil {
-					return err
-				}
-			} else {
-				if err := zigbee.LocalCommand(endpoint, &cluster.GenOnOffOffCommand{}); err != nil {
-					return err
-				}
-			}
+	// *desiredState* will now contain {"On":true,"ColorTemperature":313}
+	if err := homeassistantmqtt.MessageToAttributes(inboundMsg, desiredState, currentlyOn); err != nil {
+		return err
+	}
+
+	// assigns desired state attrs to current state only if the values are different
+	// (along with last change timestamp as *now*, so changed() helper can detect it)
+	desiredState.Attrs.CopyDifferentAttrsTo(actx.Attrs)
+
+	now := actx.Reported
+	attrs := actx.Attrs // shorthand
+
+	changed := func(attr hubtypes.Attribute) bool { // helper
+		if isNilInterface(attr) {
+			return false
 		}
 
-		if changed(attrs.Brightness) {
-			if err := zigbee.LocalCommand(endpoint, &cluster.MoveToLevelCommand{
-				Level:          uint8(attrs.Brightness.Value),
-				TransitionTime: cluster.TransitionTimeFrom(1 * time.Second),
-			}); err != nil {
+		return attr.LastChange().Equal(now)
+	}
+
+	if changed(attrs.On) {
+		if attrs.On.Value {
+			if err := send(&cluster.GenOnOffOnCommand{}); err != nil {
+				return err
+			}
+		} else {
+			if err := send(&cluster.GenOnOffOffCommand{}); err != nil {
 				return err
 			}
 		}
+	}
+
+	if changed(attrs.Brightness) {
+		if err := send(&cluster.MoveToLevelCommand{
+			Level:          uint8(attrs.Brightness.Value),
+			TransitionTime: cluster.TransitionTimeFrom(1 * time.Second),
+		}); err != nil {
+			return err
+		}
+	}
 
-		if changed(attrs.Color) {
-			// 3rd return is luminance, which a color technically doesn't have
-			X, Y, _ := attrs.Color.Converter().Xyz()
+	if changed(attrs.Color) {
+		// 3rd return is luminance, which a color technically doesn't have
+		X, Y, _ := attrs.Color.Converter().Xyz()
 
-			if err := zigbee.LocalCommand(endpoint, &cluster.LightingColorCtrlMoveToColor{
-				X:              uint16(X * 65279),
-				Y:              uint16(Y * 65279),
-				TransitionTime: cluster.TransitionTimeFrom(1 * time.Second),
-			}); err != nil {
+		if err := send(&cluster.LightingColorCtrlMoveToColor{
+			X:              uint16(X * 65279),
+			Y:              uint16(Y * 65279),
+			TransitionTime: cluster.TransitionTimeFrom(1 * time.Second),
+		}); err != nil {
+			return err
+		}
+	}
+
+	if changed(attrs.ColorTemperature) {
+		if err := send(&cluster.LightingColorCtrlMoveToColorTemperature{
+			uint16(attrs.ColorTemperature.Value),
+			cluster.TransitionTimeFrom(1 * time.Second),
+		}); err != nil {
+			return err
+		}
+	}
+
+	if changed(attrs.ShadePosition) {
+		if err := send(&cluster.ClosuresWindowCoveringGoToLiftPercentage{
+			uint8(attrs.ShadePosition.Value),
+		}); err != nil {
+			return err
+		}
+	}
+
+	if changed(attrs.ShadeTilt) {
+		if err := send(&cluster.ClosuresWindowCoveringGoToTiltPercentage{
+			uint8(attrs.ShadeTilt.Value),
+		}); err != nil {
+			return err
+		}
+	}
+
+	if changed(attrs.ShadeStop) {
+		if err := send(&cluster.ClosuresWindowCoveringStop{}); err != nil {
+			return err
+		}
+	}
+
+	if changed(attrs.HeatingSetpoint) {
+		if err := write(cluster.IdHvacThermostat, &cluster.WriteAttributeRecord{
+			AttributeID: uint16(cluster.AttrHvacThermostatOccupiedHeatingSetpoint),
+			Attribute: &cluster.Attribute{
+				DataType: cluster.ZclDataTypeInt16,
+				Value:    int64(math.Round(attrs.HeatingSetpoint.Value * 100)), // [0.01 °C]
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	if changed(attrs.SystemMode) {
+		systemMode, err := cluster.ThermostatSystemModeFromString(attrs.SystemMode.Value)
+		if err != nil {
+			return err
+		}
+
+		if err := write(cluster.IdHvacThermostat, &cluster.WriteAttributeRecord{
+			AttributeID: uint16(cluster.AttrHvacThermostatSystemMode),
+			Attribute: &cluster.Attribute{
+				DataType: cluster.ZclDataTypeEnum8,
+				Value:    uint64(systemMode),
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	if changed(attrs.Locked) {
+		if attrs.Locked.Value {
+			if err := send(&cluster.ClosuresDoorLockLockDoor{}); err != nil {
+				return err
+			}
+		} else {
+			if err := send(&cluster.ClosuresDoorLockUnlockDoor{}); err != nil {
 				return err
 			}
 		}
+	}
 
-		if changed(attrs.ColorTemperature) {
-			if err := zigbee.LocalCommand(endpoint, &cluster.LightingColorCtrlMoveToColorTemperature{
-				uint16(attrs.ColorTemperature.Value),
-				cluster.TransitionTimeFrom(1 * time.Second),
+	// not an attribute: PIN codes are secrets, so we don't store them (the lock doesn't report them anyway)
+	if pinCode := inboundMsg.Message.PinCode; pinCode != nil {
+		if pinCode.PinCode != nil {
+			if err := send(&cluster.ClosuresDoorLockSetPinCode{
+				UserId:     pinCode.User,
+				UserStatus: cluster.DoorLockUserStatusOccupiedEnabled,
+				UserType:   cluster.DoorLockUserTypeUnrestricted,
+				PinCode:    *pinCode.PinCode,
 			}); err != nil {
 				return err
 			}
-		}
-
-		if changed(attrs.ShadePosition) {
-			if err := zigbee.LocalCommand(endpoint, &cluster.ClosuresWindowCoveringGoToLiftPercentage{
-				uint8(attrs.ShadePosition.Value),
+		} else {
+			if err := send(&cluster.ClosuresDoorLockClearPinCode{
+				UserId: pinCode.User,
 			}); err != nil {
 				return err
 			}
 		}
+	}
 
-		if changed(attrs.ShadeStop) {
-			if err := zigbee.LocalCommand(endpoint, &cluster.ClosuresWindowCoveringStop{}); err != nil {
-				return err
-			}
+	if changed(attrs.FanMode) {
+		fanMode, err := cluster.FanModeFromString(attrs.FanMode.Value)
+		if err != nil {
+			return err
 		}
 
-		if changed(attrs.AlertSelect) {
-			if err := zigbee.LocalCommand(endpoint, &cluster.GenIdentifyTriggerEffectCommand{
-				Effect: cluster.EffectIdBlink,
-			}); err != nil {
-				return err
-			}
+		if err := write(cluster.IdHvacFanCtrl, &cluster.WriteAttributeRecord{
+			AttributeID: uint16(cluster.AttrHvacFanCtrlFanMode),
+			Attribute: &cluster.Attribute{
+				DataType: cluster.ZclDataTypeEnum8,
+				Value:    uint64(fanMode),
+			},
+		}); err != nil {
+			return err
 		}
+	}
 
-		return nil
-	}); err != nil {
-		return err
+	if changed(attrs.Warning) {
+		if err := send(cluster.NewSsIasWdStartWarning(
+			attrs.Warning.Mode,
+			attrs.Warning.Level,
+			attrs.Warning.Strobe,
+			attrs.Warning.Duration,
+		)); err != nil {
+			return err
+		}
+	}
+
+	if changed(attrs.Squawk) {
+		if err := send(cluster.NewSsIasWdSquawk(
+			attrs.Squawk.Mode,
+			attrs.Squawk.Level,
+			attrs.Squawk.Strobe,
+		)); err != nil {
+			return err
+		}
+	}
+
+	if changed(attrs.AlertSelect) {
+		if err := send(&cluster.GenIdentifyTriggerEffectCommand{
+			Effect: cluster.EffectIdBlink,
+		}); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -289,6 +575,8 @@ func updateAttributesAndNotifyMQTT(
 	endpoint zigbee.EndpointId,
 	updater func(actx *hubtypes.AttrsCtx) error,
 ) error {
+	defer wdev.LockState()()
+
 	now := time.Now().UTC()
 
 	attrs, found := wdev.State.EndpointAttrs[endpoint]
@@ -296,7 +584,14 @@ func updateAttributesAndNotifyMQTT(
 		return fmt.Errorf("received message from non-declared endpoint: %d", endpoint)
 	}
 
-	actx := &hubtypes.AttrsCtx{hubtypes.NewAttrBuilder(now), attrs, endpoint}
+	var scaling *ezstack.MeasurementScaling
+	var iasZone *ezstack.IasZone
+	if ep := findEndpoint(wdev.ZigbeeDevice, endpoint); ep != nil {
+		scaling = ep.MeasurementScaling
+		iasZone = ep.IasZone
+	}
+
+	actx := &hubtypes.AttrsCtx{hubtypes.NewAttrBuilder(now), attrs, endpoint, scaling, iasZone}
 
 	// this is expected to modify device's attributes
 	if err := updater(actx); err != nil {
@@ -319,17 +614,30 @@ func updateAttributesAndNotifyMQTT(
 		return err
 	}
 
-	msg := homeassistantmqtt.Message{
-		Topic:   mqttPrefix + "/" + wdev.ZigbeeDevice.IEEEAddress.HexPrefixedString(),
-		Content: changedAttributesMsg,
+	msgs := []homeassistantmqtt.Message{
+		{
+			Topic:   mqttPrefix + "/" + wdev.ZigbeeDevice.IEEEAddress.HexPrefixedString(),
+			Content: changedAttributesMsg,
+		},
 	}
 
-	select {
-	case mqttPublish <- msg:
-		return nil
-	default:
-		return errors.New("mqttPublish full")
+	// the device's topic mixes all endpoints' changes, so each endpoint also needs its own
+	if wdev.HasMultipleEndpoints() {
+		msgs = append(msgs, homeassistantmqtt.Message{
+			Topic:   homeassistantmqtt.EndpointTopic(mqttPrefix, wdev.ZigbeeDevice.IEEEAddress, endpoint),
+			Content: changedAttributesMsg,
+		})
 	}
+
+	for _, msg := range msgs {
+		select {
+		case mqttPublish <- msg:
+		default:
+			return errors.New("mqttPublish full")
+		}
+	}
+
+	return nil
 }
 
 // https://www.home-assistant.io/docs/mqtt/discovery/
@@ -346,6 +654,19 @@ func homeAssistantAutoDiscovery(
 			entities = append(entities, homeassistantmqtt.AutodiscoveryEntities(dev, mqttPrefix)...)
 		}
 
+		for _, group := range nodeDatabase.Groups {
+			members := []*hubtypes.Device{}
+			for _, member := range group.Members {
+				for _, dev := range nodeDatabase.Devices { // can't use GetWrappedDevice() since we're holding the lock
+					if dev.ZigbeeDevice.IEEEAddress == member.Device {
+						members = append(members, dev)
+					}
+				}
+			}
+
+			entities = append(entities, homeassistantmqtt.AutodiscoveryGroupEntities(group, members, mqttPrefix)...)
+		}
+
 		return nil
 	}); err != nil {
 		return err
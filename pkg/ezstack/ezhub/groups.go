@@ -0,0 +1,116 @@
+package ezhub
+
+// Zigbee group management. the devices store their group memberships, but we also keep them in the
+// node database so we know what groups exist and who are in them (for e.g. Home Assistant).
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/function61/hautomo/pkg/ezstack"
+	"github.com/function61/hautomo/pkg/ezstack/ezhub/hubtypes"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+)
+
+type groupMemberRequest struct {
+	Group    zigbee.GroupId     `json:"group"`
+	Device   zigbee.IEEEAddress `json:"device"`
+	Endpoint zigbee.EndpointId  `json:"endpoint,omitempty"` // default: DefaultSingleEndpointId
+}
+
+func (g groupMemberRequest) member() hubtypes.GroupMember {
+	return hubtypes.GroupMember{
+		Device:   g.Device,
+		Endpoint: endpointOrDefault(g.Endpoint),
+	}
+}
+
+func createGroup(id zigbee.GroupId, friendlyName string, nodeDatabase *nodeDb) (*hubtypes.Group, error) {
+	if friendlyName == "" {
+		friendlyName = fmt.Sprintf("Group %d", id)
+	}
+
+	group := hubtypes.NewGroup(id, friendlyName)
+
+	return group, nodeDatabase.InsertGroup(group)
+}
+
+// removes all members from the group (on the device side) and then the group from database. the
+// group is removed from database even if some members couldn't be reached (they're reported in the error)
+func deleteGroup(id zigbee.GroupId, stack *ezstack.Stack, nodeDatabase *nodeDb) error {
+	group := nodeDatabase.GetGroup(id)
+	if group == nil {
+		return fmt.Errorf("group not found: %d", id)
+	}
+
+	members := []hubtypes.GroupMember{}
+	_ = nodeDatabase.withLock(func() error { // copy b/c removal mutates
+		members = append(members, group.Members...)
+		return nil
+	})
+
+	failures := []string{}
+	for _, member := range members {
+		if err := removeGroupMember(groupMemberRequest{id, member.Device, member.Endpoint}, stack, nodeDatabase); err != nil {
+			failures = append(failures, fmt.Sprintf("%s/%d: %v", member.Device, member.Endpoint, err))
+		}
+	}
+
+	if err := nodeDatabase.RemoveGroup(id); err != nil {
+		return err
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf(
+			"group %d deleted, but these members may still be in it (remove them manually when reachable): %s",
+			id,
+			strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+func addGroupMember(req groupMemberRequest, stack *ezstack.Stack, nodeDatabase *nodeDb) (*hubtypes.Group, error) {
+	member := req.member()
+
+	dev, found := nodeDatabase.GetDevice(member.Device)
+	if !found {
+		return nil, fmt.Errorf("device not found: %s", member.Device)
+	}
+
+	if nodeDatabase.GetGroup(req.Group) == nil { // check before bothering the device
+		return nil, fmt.Errorf("group not found: %d", req.Group)
+	}
+
+	if err := stack.AddToGroup(member.DeviceAndEndpoint(dev), req.Group); err != nil {
+		return nil, fmt.Errorf("AddToGroup: %w", err)
+	}
+
+	if err := nodeDatabase.UpdateGroup(req.Group, func(group *hubtypes.Group) error {
+		if !group.HasMember(member) {
+			group.Members = append(group.Members, member)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return nodeDatabase.GetGroup(req.Group), nil
+}
+
+func removeGroupMember(req groupMemberRequest, stack *ezstack.Stack, nodeDatabase *nodeDb) error {
+	member := req.member()
+
+	// if device is no longer in the network, we still want to be able to clean up the database
+	if dev, found := nodeDatabase.GetDevice(member.Device); found {
+		if err := stack.RemoveFromGroup(member.DeviceAndEndpoint(dev), req.Group); err != nil {
+			return fmt.Errorf("RemoveFromGroup: %w", err)
+		}
+	}
+
+	return nodeDatabase.UpdateGroup(req.Group, func(group *hubtypes.Group) error {
+		group.RemoveMember(member)
+		return nil
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/function61/hautomo/pkg/ezstack"
 	"github.com/function61/hautomo/pkg/ezstack/ezhub/hubtypes"
 	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
 	"github.com/function61/hautomo/pkg/homeassistant"
 )
 
@@ -39,7 +40,6 @@ func AutodiscoveryEntities(dev *hubtypes.Device, mqttPrefix string) []*homeassis
 	}
 
 	stateTopic := fmt.Sprintf("%s/%s", mqttPrefix, id)
-	commandTopic := fmt.Sprintf("%s/%s/set", mqttPrefix, id)
 
 	// the "primary entities" don't have name suffix
 	// (i.e. contact sensor is just "<friendly name>" and not "<friendly name> - contact")
@@ -61,95 +61,347 @@ func AutodiscoveryEntities(dev *hubtypes.Device, mqttPrefix string) []*homeassis
 			Device: devSpec,
 		}))
 
+	// entity for each endpoint that has *clusterId*
+	endpointEntities := func(clusterId cluster.ClusterId, entity func(ep endpointEntity)) {
+		for _, endpoint := range dev.EndpointsImplementing(clusterId) {
+			entity(newEndpointEntity(dev, endpoint, mqttPrefix))
+		}
+	}
+
 	// FIXME: is it safe assumption that all level-controllable things are lights?
-	if dev.ImplementsCluster(cluster.IdGenLevelCtrl) {
+	endpointEntities(cluster.IdGenLevelCtrl, func(ep endpointEntity) {
 		addEntity(homeassistant.NewLightEntity(
-			id+"_light",
-			dev.FriendlyName,
+			id+"_light"+ep.idSuffix,
+			dev.FriendlyName+ep.nameSuffix,
 			homeassistant.DiscoveryOptions{
-				UniqueId: uniqueId("light"),
+				UniqueId: uniqueId("light" + ep.idSuffix),
 
-				StateTopic:   stateTopic,
-				CommandTopic: commandTopic,
+				StateTopic:   ep.stateTopic,
+				CommandTopic: ep.commandTopic,
 
 				Schema: "json",
 
-				Brightness: dev.ImplementsCluster(cluster.IdGenLevelCtrl),
-				ColorTemp:  dev.ImplementsCluster(cluster.IdLightingColorCtrl),
-				XY:         dev.ImplementsCluster(cluster.IdLightingColorCtrl),
+				Brightness: true,
+				ColorTemp:  ep.implements(cluster.IdLightingColorCtrl),
+				XY:         ep.implements(cluster.IdLightingColorCtrl),
 
 				Device: devSpec,
 			}))
-	}
+	})
+
+	// plugs, relays, wall switches. the ones with level control are lights (above)
+	endpointEntities(cluster.IdGenOnOff, func(ep endpointEntity) {
+		if ep.implements(cluster.IdGenLevelCtrl) {
+			return
+		}
+
+		addEntity(homeassistant.NewSwitchEntity(
+			id+"_switch"+ep.idSuffix,
+			dev.FriendlyName+ep.nameSuffix,
+			homeassistant.DiscoveryOptions{
+				UniqueId: uniqueId("switch" + ep.idSuffix),
 
-	if dev.ImplementsCluster(cluster.IdMsTemperatureMeasurement) {
+				StateTopic:   ep.stateTopic,
+				CommandTopic: ep.commandTopic,
+
+				ValueTemplate: "{{ value_json.state }}",
+				PayloadOn:     "ON",
+				PayloadOff:    "OFF",
+
+				Device: devSpec,
+			}))
+	})
+
+	endpointEntities(cluster.IdMsTemperatureMeasurement, func(ep endpointEntity) {
 		addEntity(homeassistant.NewSensorEntity(
-			id+"_temp",
-			dev.FriendlyName+" - temp",
+			id+"_temp"+ep.idSuffix,
+			dev.FriendlyName+" - temp"+ep.nameSuffix,
 			homeassistant.DiscoveryOptions{
 				DeviceClass: homeassistant.DeviceClassTemperature,
-				UniqueId:    uniqueId("temperature"),
+				UniqueId:    uniqueId("temperature" + ep.idSuffix),
 
-				StateTopic: stateTopic,
+				StateTopic: ep.stateTopic,
 
 				ValueTemplate:     "{{ value_json.temperature }}",
 				UnitOfMeasurement: "°C",
 
 				Device: devSpec,
 			}))
-	}
+	})
 
-	if dev.ImplementsCluster(cluster.IdMsRelativeHumidity) {
+	endpointEntities(cluster.IdMsRelativeHumidity, func(ep endpointEntity) {
 		addEntity(homeassistant.NewSensorEntity(
-			id+"_humid",
-			dev.FriendlyName+" - humidity",
+			id+"_humid"+ep.idSuffix,
+			dev.FriendlyName+" - humidity"+ep.nameSuffix,
 			homeassistant.DiscoveryOptions{
 				DeviceClass: homeassistant.DeviceClassHumidity,
-				UniqueId:    uniqueId("humidity"),
+				UniqueId:    uniqueId("humidity" + ep.idSuffix),
 
-				StateTopic: stateTopic,
+				StateTopic: ep.stateTopic,
 
 				ValueTemplate:     "{{ value_json.humidity }}",
 				UnitOfMeasurement: "%",
 
 				Device: devSpec,
 			}))
-	}
+	})
 
-	if dev.ImplementsCluster(cluster.IdMsPressureMeasurement) {
+	endpointEntities(cluster.IdMsPressureMeasurement, func(ep endpointEntity) {
 		addEntity(homeassistant.NewSensorEntity(
-			id+"_pressure",
-			dev.FriendlyName+" - pressure",
+			id+"_pressure"+ep.idSuffix,
+			dev.FriendlyName+" - pressure"+ep.nameSuffix,
 			homeassistant.DiscoveryOptions{
 				DeviceClass: homeassistant.DeviceClassPressure,
-				UniqueId:    uniqueId("pressure"),
+				UniqueId:    uniqueId("pressure" + ep.idSuffix),
 
-				StateTopic: stateTopic,
+				StateTopic: ep.stateTopic,
 
 				ValueTemplate:     "{{ value_json.pressure }}",
 				UnitOfMeasurement: "hPa",
 
+				Device: devSpec,
+			}))
+	})
+
+	// smart plugs etc. with state classes, so Home Assistant keeps statistics (and the energy dashboard can use them)
+	meteringSensor := func(ep endpointEntity, key string, deviceClass string, unit string, stateClass string) {
+		addEntity(homeassistant.NewSensorEntity(
+			id+"_"+key+ep.idSuffix,
+			dev.FriendlyName+" - "+key+ep.nameSuffix,
+			homeassistant.DiscoveryOptions{
+				DeviceClass: deviceClass,
+				UniqueId:    uniqueId(key + ep.idSuffix),
+
+				StateTopic: ep.stateTopic,
+
+				ValueTemplate:     "{{ value_json." + key + " }}",
+				UnitOfMeasurement: unit,
+				StateClass:        stateClass,
+
 				Device: devSpec,
 			}))
 	}
 
-	if dev.ImplementsCluster(cluster.IdClosuresWindowCovering) {
+	endpointEntities(cluster.IdHaElectricalMeasurement, func(ep endpointEntity) {
+		meteringSensor(ep, "power", homeassistant.DeviceClassPower, "W", homeassistant.StateClassMeasurement)
+		meteringSensor(ep, "voltage", homeassistant.DeviceClassVoltage, "V", homeassistant.StateClassMeasurement)
+		meteringSensor(ep, "current", homeassistant.DeviceClassCurrent, "A", homeassistant.StateClassMeasurement)
+	})
+
+	endpointEntities(cluster.IdSeMetering, func(ep endpointEntity) {
+		meteringSensor(ep, "energy", homeassistant.DeviceClassEnergy, "kWh", homeassistant.StateClassTotalIncreasing)
+
+		if !ep.implements(cluster.IdHaElectricalMeasurement) { // then power comes from metering cluster
+			meteringSensor(ep, "power", homeassistant.DeviceClassPower, "W", homeassistant.StateClassMeasurement)
+		}
+	})
+
+	endpointEntities(cluster.IdClosuresWindowCovering, func(ep endpointEntity) {
+		opts := homeassistant.DiscoveryOptions{
+			DeviceClass: homeassistant.DeviceClassShade,
+			UniqueId:    uniqueId("shade" + ep.idSuffix),
+
+			StateTopic:   ep.stateTopic,
+			CommandTopic: ep.commandTopic,
+
+			Optimistic: true,
+
+			Device: devSpec,
+		}
+
+		// most coverings only lift, so we offer tilt only once the covering has reported it
+		if ep.attrs != nil && ep.attrs.ShadeTilt != nil {
+			tiltOpen, tiltClosed := 0, 100 // Zigbee's 100 % is closed, Home Assistant's is open
+
+			opts.TiltCommandTopic = ep.commandTopic
+			opts.TiltCommandTemplate = `{"tilt": {{ tilt_position }}}`
+			opts.TiltStatusTopic = ep.stateTopic
+			opts.TiltStatusTemplate = "{{ value_json.tilt }}"
+			opts.TiltMin = &tiltClosed
+			opts.TiltMax = &tiltOpen
+			opts.TiltOpenedValue = &tiltOpen
+			opts.TiltClosedValue = &tiltClosed
+		}
+
 		addEntity(homeassistant.NewCoverEntity(
-			id+"_shade",
-			dev.FriendlyName,
+			id+"_shade"+ep.idSuffix,
+			dev.FriendlyName+ep.nameSuffix,
+			opts))
+	})
+
+	endpointEntities(cluster.IdClosuresDoorLock, func(ep endpointEntity) {
+		addEntity(homeassistant.NewLockEntity(
+			id+"_lock"+ep.idSuffix,
+			dev.FriendlyName+ep.nameSuffix,
+			homeassistant.DiscoveryOptions{
+				UniqueId: uniqueId("lock" + ep.idSuffix),
+
+				StateTopic:   ep.stateTopic,
+				CommandTopic: ep.commandTopic,
+
+				ValueTemplate: "{{ value_json.state }}",
+				PayloadLock:   "LOCK",
+				PayloadUnlock: "UNLOCK",
+				StateLocked:   "LOCK",
+				StateUnlocked: "UNLOCK",
+
+				Device: devSpec,
+			}))
+	})
+
+	// fan modes low/medium/high are speeds 1-3 (= percentages), auto & smart are presets
+	endpointEntities(cluster.IdHvacFanCtrl, func(ep endpointEntity) {
+		addEntity(homeassistant.NewFanEntity(
+			id+"_fan"+ep.idSuffix,
+			dev.FriendlyName+ep.nameSuffix,
+			homeassistant.DiscoveryOptions{
+				UniqueId: uniqueId("fan" + ep.idSuffix),
+
+				StateTopic:         ep.stateTopic,
+				StateValueTemplate: "{{ value_json.fan_state }}",
+				CommandTopic:       ep.commandTopic,
+				CommandTemplate:    `{"fan_state": "{{ value }}"}`,
+
+				PercentageStateTopic:      ep.stateTopic,
+				PercentageValueTemplate:   "{{ {'low': 1, 'medium': 2, 'high': 3, 'on': 3}.get(value_json.fan_mode, 0) }}",
+				PercentageCommandTopic:    ep.commandTopic,
+				PercentageCommandTemplate: `{"fan_mode": "{{ ['off', 'low', 'medium', 'high'][value] }}"}`,
+				SpeedRangeMin:             1,
+				SpeedRangeMax:             3,
+
+				PresetModeStateTopic:      ep.stateTopic,
+				PresetModeValueTemplate:   "{{ value_json.fan_mode if value_json.fan_mode in ['auto', 'smart'] else 'None' }}",
+				PresetModeCommandTopic:    ep.commandTopic,
+				PresetModeCommandTemplate: `{"fan_mode": "{{ value }}"}`,
+				PresetModes:               []string{"auto", "smart"},
+
+				Device: devSpec,
+			}))
+	})
+
+	// thermostats & radiator valves. climate has no common state topic, so each value points to it
+	endpointEntities(cluster.IdHvacThermostat, func(ep endpointEntity) {
+		addEntity(homeassistant.NewClimateEntity(
+			id+"_climate"+ep.idSuffix,
+			dev.FriendlyName+ep.nameSuffix,
 			homeassistant.DiscoveryOptions{
-				DeviceClass: homeassistant.DeviceClassShade,
-				UniqueId:    uniqueId("shade"),
+				UniqueId: uniqueId("climate" + ep.idSuffix),
+
+				CurrentTemperatureTopic:    ep.stateTopic,
+				CurrentTemperatureTemplate: "{{ value_json.local_temperature }}",
+
+				TemperatureStateTopic:      ep.stateTopic,
+				TemperatureStateTemplate:   "{{ value_json.occupied_heating_setpoint }}",
+				TemperatureCommandTopic:    ep.commandTopic,
+				TemperatureCommandTemplate: `{"occupied_heating_setpoint": {{ value }}}`,
 
-				StateTopic:   stateTopic,
-				CommandTopic: commandTopic,
+				ModeStateTopic:      ep.stateTopic,
+				ModeStateTemplate:   "{{ value_json.system_mode }}",
+				ModeCommandTopic:    ep.commandTopic,
+				ModeCommandTemplate: `{"system_mode": "{{ value }}"}`,
+				Modes:               []string{"off", "auto", "heat"}, // we don't know which modes the device supports. these are the common ones for heating
 
-				Optimistic: true,
+				ActionTopic:    ep.stateTopic,
+				ActionTemplate: "{{ {'heat': 'heating', 'cool': 'cooling'}.get(value_json.running_state, 'idle') }}",
+
+				MinTemp:  5,
+				MaxTemp:  30,
+				TempStep: 0.5,
+
+				Device: devSpec,
+			}))
+	})
+
+	alarmSensor := func(ep endpointEntity, key string, name string, deviceClass string) {
+		addEntity(homeassistant.NewBinarySensorEntity(
+			id+"_"+key+ep.idSuffix,
+			name+ep.nameSuffix,
+			homeassistant.DiscoveryOptions{
+				DeviceClass: deviceClass,
+				UniqueId:    uniqueId(key + ep.idSuffix),
+
+				StateTopic: ep.stateTopic,
+
+				ValueTemplate: "{{ value_json." + key + " }}",
+				PayloadOn:     true,
+				PayloadOff:    false,
 
 				Device: devSpec,
 			}))
 	}
 
+	// alarm sensors. what the alarm means depends on the zone type we got when enrolling the device
+	endpointEntities(cluster.IdSsIasZone, func(ep endpointEntity) {
+		if dev.ZigbeeDevice.Model == hubtypes.ModelHeimanGasSensor { // there's no zone type for gas sensors
+			alarmSensor(ep, "gas", dev.FriendlyName, homeassistant.DeviceClassGas)
+		} else if ep.iasZone != nil {
+			switch ep.iasZone.Type {
+			case cluster.IasZoneTypeFireSensor:
+				alarmSensor(ep, "smoke", dev.FriendlyName, homeassistant.DeviceClassSmoke)
+			case cluster.IasZoneTypeCarbonMonoxideSensor:
+				alarmSensor(ep, "carbon_monoxide", dev.FriendlyName, homeassistant.DeviceClassCarbonMonoxide)
+			case cluster.IasZoneTypeWaterSensor:
+				alarmSensor(ep, "water_leak", dev.FriendlyName, homeassistant.DeviceClassMoisture)
+			case cluster.IasZoneTypeMotionSensor:
+				alarmSensor(ep, "occupancy", dev.FriendlyName, homeassistant.DeviceClassMotion)
+			case cluster.IasZoneTypeContactSwitch:
+				addEntity(homeassistant.NewBinarySensorEntity(
+					id+"_contact"+ep.idSuffix,
+					dev.FriendlyName+ep.nameSuffix,
+					homeassistant.DiscoveryOptions{
+						DeviceClass: homeassistant.DeviceClassDoor,
+						UniqueId:    uniqueId("contact" + ep.idSuffix),
+
+						StateTopic: ep.stateTopic,
+
+						ValueTemplate: "{{ value_json.contact }}",
+						PayloadOn:     false, // contact = closed, but for Home Assistant "on" means open
+						PayloadOff:    true,
+
+						Device: devSpec,
+					}))
+			case cluster.IasZoneTypeVibrationMovementSensor:
+				// vibration is an event, not a state
+				addEntity(homeassistant.NewSensorEntity(
+					id+"_action"+ep.idSuffix,
+					dev.FriendlyName+ep.nameSuffix,
+					homeassistant.DiscoveryOptions{
+						UniqueId: uniqueId("action" + ep.idSuffix),
+
+						StateTopic: ep.stateTopic,
+
+						ValueTemplate: "{{ value_json.action }}",
+
+						Icon: "mdi:vibrate",
+
+						Device: devSpec,
+					}))
+			}
+		}
+
+		alarmSensor(ep, "tamper", dev.FriendlyName+" - tamper", homeassistant.DeviceClassTamper)
+		alarmSensor(ep, "battery_low", dev.FriendlyName+" - battery low", homeassistant.DeviceClassBattery)
+	})
+
+	// sirens. Home Assistant's tones are our warning modes
+	endpointEntities(cluster.IdSsIasWd, func(ep endpointEntity) {
+		addEntity(homeassistant.NewSirenEntity(
+			id+"_siren"+ep.idSuffix,
+			dev.FriendlyName+ep.nameSuffix,
+			homeassistant.DiscoveryOptions{
+				UniqueId: uniqueId("siren" + ep.idSuffix),
+
+				CommandTopic:    ep.commandTopic,
+				CommandTemplate: `{"warning": {"mode": "{{ (tone | default('emergency')) if value == 'ON' else 'stop' }}"{% if duration is defined %}, "duration": {{ duration }}{% endif %}}}`,
+				AvailableTones:  []string{"burglar", "fire", "emergency", "police_panic", "fire_panic", "emergency_panic"},
+				SupportDuration: true,
+
+				Optimistic: true, // siren doesn't report its state
+
+				Device: devSpec,
+			}))
+	})
+
 	// FIXME: being battery powered does not necessarily mean we get the voltage reported to us
 	if dev.ZigbeeDevice.PowerSource == ezstack.Battery {
 		addEntity(homeassistant.NewSensorEntity(
@@ -238,5 +490,138 @@ func AutodiscoveryEntities(dev *hubtypes.Device, mqttPrefix string) []*homeassis
 			}))
 	}
 
+	if dev.ZigbeeDevice.OtaClientEndpoint() != nil {
+		otaStateTopic := fmt.Sprintf("%s/%s/ota", mqttPrefix, id)
+
+		addEntity(homeassistant.NewSensorEntity(
+			id+"_update_state",
+			dev.FriendlyName+" - firmware update",
+			homeassistant.DiscoveryOptions{
+				UniqueId: uniqueId("update_state"),
+
+				StateTopic: otaStateTopic,
+
+				ValueTemplate: "{{ value_json.state }}",
+
+				Icon: "mdi:update",
+
+				Device: devSpec,
+			}))
+
+		addEntity(homeassistant.NewSensorEntity(
+			id+"_update_progress",
+			dev.FriendlyName+" - firmware update progress",
+			homeassistant.DiscoveryOptions{
+				UniqueId: uniqueId("update_progress"),
+
+				StateTopic: otaStateTopic,
+
+				ValueTemplate:     "{{ value_json.progress }}",
+				UnitOfMeasurement: "%",
+
+				Icon: "mdi:progress-download",
+
+				Device: devSpec,
+			}))
+	}
+
 	return entities
 }
+
+// entity for one endpoint of a device. single-endpoint devices use the device's topics. for
+// multi-endpoint devices (multi-gang switches, power strips etc.) each endpoint has its own topics,
+// and the entities of non-default endpoints get a suffix to tell them apart
+type endpointEntity struct {
+	idSuffix     string // "" or e.g. "_2"
+	nameSuffix   string // "" or e.g. " 2"
+	stateTopic   string
+	commandTopic string
+	implements   func(clusterId cluster.ClusterId) bool
+	iasZone      *ezstack.IasZone     // nil if endpoint isn't an alarm sensor
+	attrs        *hubtypes.Attributes // last known state. nil if we haven't heard from the endpoint
+}
+
+func newEndpointEntity(dev *hubtypes.Device, endpoint zigbee.EndpointId, mqttPrefix string) endpointEntity {
+	ep := endpointEntity{
+		stateTopic: fmt.Sprintf("%s/%s", mqttPrefix, dev.ZigbeeDevice.IEEEAddress.HexPrefixedString()),
+		implements: func(clusterId cluster.ClusterId) bool {
+			for _, candidate := range dev.EndpointsImplementing(clusterId) {
+				if candidate == endpoint {
+					return true
+				}
+			}
+
+			return false
+		},
+	}
+
+	for _, candidate := range dev.ZigbeeDevice.Endpoints {
+		if candidate.Id == endpoint {
+			ep.iasZone = candidate.IasZone
+		}
+	}
+
+	if dev.State != nil {
+		ep.attrs = dev.State.EndpointAttrs[endpoint]
+	}
+
+	if dev.HasMultipleEndpoints() {
+		ep.stateTopic = EndpointTopic(mqttPrefix, dev.ZigbeeDevice.IEEEAddress, endpoint)
+	}
+
+	ep.commandTopic = ep.stateTopic + "/set"
+
+	if endpoint != dev.DefaultEndpointId() { // default endpoint's entities keep the ids they had before multi-endpoint support
+		ep.idSuffix = fmt.Sprintf("_%d", endpoint)
+		ep.nameSuffix = fmt.Sprintf(" %d", endpoint)
+	}
+
+	return ep
+}
+
+// *members* are the group's member devices we know of
+func AutodiscoveryGroupEntities(group *hubtypes.Group, members []*hubtypes.Device, mqttPrefix string) []*homeassistant.Entity {
+	if group.Area == "" { // skip groups without area specified
+		return []*homeassistant.Entity{}
+	}
+
+	id := fmt.Sprintf("group_%d", group.Id)
+
+	// group supports a feature only if all members support it
+	allImplement := func(clusterId cluster.ClusterId) bool {
+		for _, member := range members {
+			if !member.ImplementsCluster(clusterId) {
+				return false
+			}
+		}
+
+		return len(members) > 0
+	}
+
+	return []*homeassistant.Entity{
+		homeassistant.NewLightEntity(
+			id+"_light",
+			group.FriendlyName,
+			homeassistant.DiscoveryOptions{
+				UniqueId: fmt.Sprintf("%s_light_hautomo", id),
+
+				StateTopic:   fmt.Sprintf("%s/group/%d", mqttPrefix, group.Id),
+				CommandTopic: fmt.Sprintf("%s/group/%d/set", mqttPrefix, group.Id),
+
+				Schema: "json",
+
+				Brightness: allImplement(cluster.IdGenLevelCtrl),
+				ColorTemp:  allImplement(cluster.IdLightingColorCtrl),
+				XY:         allImplement(cluster.IdLightingColorCtrl),
+
+				Device: &homeassistant.DiscoveryOptionsDevice{
+					Name:          group.FriendlyName,
+					Model:         "Zigbee group",
+					Identifiers:   []string{id},
+					AreaSuggested: group.Area,
+
+					SoftwareVersion: "Hautomo-EZhub",
+				},
+			}),
+	}
+}
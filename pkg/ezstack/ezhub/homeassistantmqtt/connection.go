@@ -3,11 +3,14 @@ package homeassistantmqtt
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/function61/gokit/encoding/jsonfile"
+	"github.com/function61/hautomo/pkg/ezstack"
 	"github.com/function61/hautomo/pkg/ezstack/zigbee"
 	"github.com/yosssi/gmq/mqtt"
 	"github.com/yosssi/gmq/mqtt/client"
@@ -20,15 +23,126 @@ type Message struct {
 
 type InboundMessage struct {
 	DeviceId zigbee.IEEEAddress
+	Endpoint *zigbee.EndpointId // nil = device's default endpoint
+	Group    *zigbee.GroupId    // non-nil if message is to a group (then DeviceId is empty)
 	Message  zigbee2mqttGenericJson
 }
 
+// asks to refresh device's state from "<prefix>/<device>/get" or "<prefix>/<device>/<endpoint>/get".
+// payload is empty or a JSON array of attributes, e.g. ["genOnOff", "genLevelCtrl.currentLevel"]
+type ReadRequest struct {
+	DeviceId   zigbee.IEEEAddress
+	Endpoint   *zigbee.EndpointId // nil = device's default endpoint
+	Attributes []string           // empty = the ones we configure reporting for
+}
+
+// state of a single endpoint of a multi-endpoint device, "<prefix>/<device>/<endpoint>".
+// commands for the endpoint go to "<prefix>/<device>/<endpoint>/set"
+func EndpointTopic(mqttPrefix string, device zigbee.IEEEAddress, endpoint zigbee.EndpointId) string {
+	return fmt.Sprintf("%s/%s/%d", mqttPrefix, device.HexPrefixedString(), endpoint)
+}
+
+// request to the bridge itself (not to a device), e.g. "device/bind" from topic "<prefix>/bridge/request/device/bind"
+// https://www.zigbee2mqtt.io/information/mqtt_topics_and_message_structure.html#zigbee2mqttbridgerequest
+type BridgeRequest struct {
+	Name    string
+	Payload []byte
+}
+
+// response for a BridgeRequest. *err* non-nil signals failure
+func BridgeResponse(mqttPrefix string, requestName string, data interface{}, err error) Message {
+	type response struct {
+		Data   interface{} `json:"data"`
+		Status string      `json:"status"`
+		Error  string      `json:"error,omitempty"`
+	}
+
+	resp := response{Data: data, Status: "ok"}
+	if err != nil {
+		resp = response{Data: struct{}{}, Status: "error", Error: err.Error()}
+	}
+
+	content, errMarshal := json.Marshal(resp)
+	if errMarshal != nil {
+		panic(errMarshal)
+	}
+
+	return Message{
+		Topic:   mqttPrefix + "/bridge/response/" + requestName,
+		Content: string(content),
+	}
+}
+
+// z2m-style event about the network, e.g. "device_joined", published to "<prefix>/bridge/event"
+func BridgeEvent(mqttPrefix string, eventType string, data interface{}) Message {
+	content, err := json.Marshal(struct {
+		Type string      `json:"type"`
+		Data interface{} `json:"data"`
+	}{eventType, data})
+	if err != nil {
+		panic(err)
+	}
+
+	return Message{
+		Topic:   mqttPrefix + "/bridge/event",
+		Content: string(content),
+	}
+}
+
+// published to "<prefix>/bridge/permit_join". *time* is remaining seconds
+func PermitJoinMessage(mqttPrefix string, status ezstack.PermitJoinStatus) Message {
+	content, err := json.Marshal(struct {
+		Value  bool                `json:"value"`
+		Time   int                 `json:"time,omitempty"`
+		Device *zigbee.IEEEAddress `json:"device,omitempty"`
+	}{status.Enabled, int(status.Remaining.Seconds()), status.Router})
+	if err != nil {
+		panic(err)
+	}
+
+	return Message{
+		Topic:   mqttPrefix + "/bridge/permit_join",
+		Content: string(content),
+	}
+}
+
+// OTA firmware update state of a device, published to "<prefix>/<device>/ota"
+func OtaProgressMessage(mqttPrefix string, progress ezstack.OtaProgress) Message {
+	type otaProgressJson struct {
+		State            ezstack.OtaState `json:"state"`
+		Progress         int              `json:"progress"`          // [%]
+		Remaining        int              `json:"remaining"`         // [s] estimated
+		InstalledVersion uint32           `json:"installed_version"` // ZCL file version
+		LatestVersion    uint32           `json:"latest_version"`
+		Error            string           `json:"error,omitempty"`
+	}
+
+	content, err := json.Marshal(otaProgressJson{
+		State:            progress.State,
+		Progress:         progress.Percent(),
+		Remaining:        int(progress.Remaining().Seconds()),
+		InstalledVersion: progress.CurrentFileVersion,
+		LatestVersion:    progress.NewFileVersion,
+		Error:            progress.Error,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return Message{
+		Topic:   fmt.Sprintf("%s/%s/ota", mqttPrefix, progress.Device.HexPrefixedString()),
+		Content: string(content),
+	}
+}
+
 func ConnectAndServe(
 	ctx context.Context,
 	addr string,
 	mqttPrefix string,
 	outbound <-chan Message,
 	inbound chan<- InboundMessage,
+	readRequests chan<- ReadRequest,
+	bridgeRequests chan<- BridgeRequest,
 ) error {
 	// to debug:
 	// $ docker run -d --name mosquitto -p 1883:1883 eclipse-mosquitto:1.6.12
@@ -72,28 +186,140 @@ func ConnectAndServe(
 					// "joonas/0xec1bbdfffe210132/set" => "0xec1bbdfffe210132"
 					address := strings.Split(string(topicName), "/")[1]
 
-					msg := zigbee2mqttGenericJson{}
-
-					// we can't get Home Assistant to both send us a payload of {"state": "ON"} and
-					// have it parse it too, so to allow HA to parse state from JSON we must tolerate it
-					// sending us non-JSON
-					switch string(message) {
-					case "OPEN", "CLOSE", "STOP":
-						hackShadeCommand := string(message)
-						msg.HackShadeCommand = &hackShadeCommand
-					case "ON", "OFF":
-						messageCopy := string(message) // need copy to get ptr
-						msg.State = &messageCopy
-					default:
-						if err := jsonfile.UnmarshalDisallowUnknownFields(bytes.NewReader(message), &msg); err != nil {
-							breakConnectionWithError(fmt.Errorf("not JSON: %s", string(message)))
-							return
-						}
+					msg, err := parseSetMessage(message)
+					if err != nil {
+						breakConnectionWithError(err)
+						return
+					}
+
+					inbound <- InboundMessage{
+						DeviceId: zigbee.IEEEAddress(address),
+						Message:  *msg,
+					}
+				},
+			},
+			{
+				TopicFilter: []byte(mqttPrefix + "/+/+/set"),
+				QoS:         mqtt.QoS0,
+				Handler: func(topicName, message []byte) {
+					// "joonas/0xec1bbdfffe210132/2/set" => "0xec1bbdfffe210132", "2"
+					topicParts := strings.Split(string(topicName), "/")
+					if topicParts[1] == "group" { // "joonas/group/3/set" is for the group handler
+						return
+					}
+
+					address, endpointStr := topicParts[1], topicParts[2]
+
+					endpointNum, err := strconv.ParseUint(endpointStr, 10, 8)
+					if err != nil {
+						breakConnectionWithError(fmt.Errorf("invalid endpoint: %s", endpointStr))
+						return
+					}
+					endpoint := zigbee.EndpointId(endpointNum)
+
+					msg, err := parseSetMessage(message)
+					if err != nil {
+						breakConnectionWithError(err)
+						return
 					}
 
 					inbound <- InboundMessage{
 						DeviceId: zigbee.IEEEAddress(address),
-						Message:  msg,
+						Endpoint: &endpoint,
+						Message:  *msg,
+					}
+				},
+			},
+			{
+				TopicFilter: []byte(mqttPrefix + "/+/get"),
+				QoS:         mqtt.QoS0,
+				Handler: func(topicName, message []byte) {
+					// "joonas/0xec1bbdfffe210132/get" => "0xec1bbdfffe210132"
+					address := strings.Split(string(topicName), "/")[1]
+
+					attributes, err := parseGetMessage(message)
+					if err != nil {
+						breakConnectionWithError(err)
+						return
+					}
+
+					readRequests <- ReadRequest{
+						DeviceId:   zigbee.IEEEAddress(address),
+						Attributes: attributes,
+					}
+				},
+			},
+			{
+				TopicFilter: []byte(mqttPrefix + "/+/+/get"),
+				QoS:         mqtt.QoS0,
+				Handler: func(topicName, message []byte) {
+					// "joonas/0xec1bbdfffe210132/2/get" => "0xec1bbdfffe210132", "2"
+					topicParts := strings.Split(string(topicName), "/")
+					if topicParts[1] == "group" { // groups don't have state of their own to read
+						return
+					}
+
+					address, endpointStr := topicParts[1], topicParts[2]
+
+					endpointNum, err := strconv.ParseUint(endpointStr, 10, 8)
+					if err != nil {
+						breakConnectionWithError(fmt.Errorf("invalid endpoint: %s", endpointStr))
+						return
+					}
+					endpoint := zigbee.EndpointId(endpointNum)
+
+					attributes, err := parseGetMessage(message)
+					if err != nil {
+						breakConnectionWithError(err)
+						return
+					}
+
+					readRequests <- ReadRequest{
+						DeviceId:   zigbee.IEEEAddress(address),
+						Endpoint:   &endpoint,
+						Attributes: attributes,
+					}
+				},
+			},
+			{
+				TopicFilter: []byte(mqttPrefix + "/group/+/set"),
+				QoS:         mqtt.QoS0,
+				Handler: func(topicName, message []byte) {
+					// "joonas/group/3/set" => "3"
+					groupIdStr := strings.Split(string(topicName), "/")[2]
+
+					groupIdNum, err := strconv.ParseUint(groupIdStr, 10, 16)
+					if err != nil {
+						breakConnectionWithError(fmt.Errorf("invalid group: %s", groupIdStr))
+						return
+					}
+					groupId := zigbee.GroupId(groupIdNum)
+
+					msg, err := parseSetMessage(message)
+					if err != nil {
+						breakConnectionWithError(err)
+						return
+					}
+
+					inbound <- InboundMessage{
+						Group:   &groupId,
+						Message: *msg,
+					}
+				},
+			},
+			{
+				TopicFilter: []byte(mqttPrefix + "/bridge/request/#"), // # means multi-level catch-all
+				QoS:         mqtt.QoS0,
+				Handler: func(topicName, message []byte) {
+					// "joonas/bridge/request/device/bind" => "device/bind"
+					name := strings.TrimPrefix(string(topicName), mqttPrefix+"/bridge/request/")
+
+					payload := make([]byte, len(message)) // don't know if client reuses the buffer
+					copy(payload, message)
+
+					bridgeRequests <- BridgeRequest{
+						Name:    name,
+						Payload: payload,
 					}
 				},
 			},
@@ -128,3 +354,39 @@ func ConnectAndServe(
 		return mqttClient.Disconnect()
 	}
 }
+
+func parseSetMessage(message []byte) (*zigbee2mqttGenericJson, error) {
+	msg := zigbee2mqttGenericJson{}
+
+	// we can't get Home Assistant to both send us a payload of {"state": "ON"} and
+	// have it parse it too, so to allow HA to parse state from JSON we must tolerate it
+	// sending us non-JSON
+	switch string(message) {
+	case "OPEN", "CLOSE", "STOP":
+		hackShadeCommand := string(message)
+		msg.HackShadeCommand = &hackShadeCommand
+	case "ON", "OFF", "LOCK", "UNLOCK":
+		messageCopy := string(message) // need copy to get ptr
+		msg.State = &messageCopy
+	default:
+		if err := jsonfile.UnmarshalDisallowUnknownFields(bytes.NewReader(message), &msg); err != nil {
+			return nil, fmt.Errorf("not JSON: %s", string(message))
+		}
+	}
+
+	return &msg, nil
+}
+
+// empty payload or JSON array of attributes to read
+func parseGetMessage(message []byte) ([]string, error) {
+	if len(bytes.TrimSpace(message)) == 0 {
+		return nil, nil
+	}
+
+	attributes := []string{}
+	if err := json.Unmarshal(message, &attributes); err != nil {
+		return nil, fmt.Errorf("not JSON array of attributes: %s", string(message))
+	}
+
+	return attributes, nil
+}
@@ -0,0 +1,34 @@
+package homeassistantmqtt
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/function61/gokit/testing/assert"
+)
+
+func TestParseGetMessage(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{``, "[]"},
+		{"  \n", "[]"},
+		{`[]`, "[]"},
+		{`["genOnOff","genLevelCtrl.currentLevel"]`, "[genOnOff genLevelCtrl.currentLevel]"},
+		{`{"state": "ON"}`, `not JSON array of attributes: {"state": "ON"}`},
+		{`genOnOff`, "not JSON array of attributes: genOnOff"},
+	} {
+		tc := tc
+		t.Run(tc.input, func(t *testing.T) {
+			assert.EqualString(t, func() string {
+				attributes, err := parseGetMessage([]byte(tc.input))
+				if err != nil {
+					return err.Error()
+				}
+
+				return fmt.Sprintf("%v", attributes)
+			}(), tc.expected)
+		})
+	}
+}
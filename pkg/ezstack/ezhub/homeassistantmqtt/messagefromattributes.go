@@ -9,6 +9,7 @@ import (
 
 	"github.com/function61/hautomo/pkg/evdevcodes"
 	"github.com/function61/hautomo/pkg/ezstack/ezhub/hubtypes"
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
 )
 
 const (
@@ -40,10 +41,37 @@ type zigbee2mqttGenericJson struct {
 	Alert *string `json:"alert,omitempty"` // usually "select"
 
 	LinkQuality *int64            `json:"linkquality,omitempty"` // [LQI]
-	Voltage     *int64            `json:"voltage,omitempty"`     // [mV]
+	Voltage     *float64          `json:"voltage,omitempty"`     // [mV] for batteries, [V] for mains (like zigbee2mqtt)
 	Battery     *int64            `json:"battery,omitempty"`     // [%]
 	Extra       map[string]string `json:"extra,omitempty"`
 
+	Power   *float64 `json:"power,omitempty"`   // [W]
+	Current *float64 `json:"current,omitempty"` // [A]
+	Energy  *float64 `json:"energy,omitempty"`  // [kWh]
+
+	LocalTemperature        *float64 `json:"local_temperature,omitempty"`         // [°C]
+	OccupiedHeatingSetpoint *float64 `json:"occupied_heating_setpoint,omitempty"` // [°C] settable
+	SystemMode              *string  `json:"system_mode,omitempty"`               // settable
+	RunningState            *string  `json:"running_state,omitempty"`             // "idle" | "heat" | "cool"
+	PIHeatingDemand         *int64   `json:"pi_heating_demand,omitempty"`         // [%]
+
+	Smoke          *bool `json:"smoke,omitempty"`
+	CarbonMonoxide *bool `json:"carbon_monoxide,omitempty"`
+	Gas            *bool `json:"gas,omitempty"`
+	Tamper         *bool `json:"tamper,omitempty"`
+	BatteryLow     *bool `json:"battery_low,omitempty"`
+
+	Warning *warning `json:"warning,omitempty"` // siren. only inbound
+	Squawk  *squawk  `json:"squawk,omitempty"`  // siren. only inbound
+
+	LockState *string  `json:"lock_state,omitempty"` // "locked" | "unlocked" | "not_fully_locked". lock/unlock is in *State*
+	PinCode   *pinCode `json:"pin_code,omitempty"`   // only inbound. a secret, so it is sent to the lock but not stored
+
+	FanMode  *string `json:"fan_mode,omitempty"`  // settable
+	FanState *string `json:"fan_state,omitempty"` // "ON" | "OFF". settable
+
+	Tilt *int `json:"tilt,omitempty"` // [0-100 %] window covering's tilt. settable
+
 	HackShadeCommand *string `json:"shade_command,omitempty"`  // not really in Home Assistant
 	CoverPosition    *int    `json:"cover_position,omitempty"` // not really in Home Assistant
 }
@@ -72,7 +100,11 @@ func MessageFromChangedAttributes(
 		return attr.LastChange().Equal(now)
 	}
 
-	voltage, battery := func() (*int64, *int64) {
+	voltage, battery := func() (*float64, *int64) {
+		if known(attrs.Voltage) { // mains-powered devices don't have batteries
+			return &attrs.Voltage.Value, nil
+		}
+
 		if known(attrs.BatteryVoltage) {
 			var batteryLevelPtr *int64
 			if batteryType != nil {
@@ -80,7 +112,7 @@ func MessageFromChangedAttributes(
 				batteryLevelPtr = &batteryLevel
 			}
 
-			voltage := int64(attrs.BatteryVoltage.Value * 1000) // [mV]
+			voltage := float64(int64(attrs.BatteryVoltage.Value * 1000)) // [mV]
 			return &voltage, batteryLevelPtr
 		} else {
 			return nil, nil
@@ -162,7 +194,7 @@ func MessageFromChangedAttributes(
 		AngleZ:  angleZ,
 		Extra:   extra,
 		LinkQuality: func() *int64 {
-			if dev.State.LinkQuality != nil { // can be nil mainly for testing
+			if dev != nil && dev.State.LinkQuality != nil { // can be nil for groups and mainly for testing
 				return &dev.State.LinkQuality.Value
 			} else {
 				return nil
@@ -170,13 +202,20 @@ func MessageFromChangedAttributes(
 		}(),
 		State: func() *string {
 			// Home Assistant requires us to always send this
-			if known(attrs.On) {
+			switch {
+			case known(attrs.On):
 				if attrs.On.Value {
 					return stringPtr("ON")
 				} else {
 					return stringPtr("OFF")
 				}
-			} else {
+			case known(attrs.Locked):
+				if attrs.Locked.Value {
+					return stringPtr("LOCK")
+				} else {
+					return stringPtr("UNLOCK")
+				}
+			default:
 				return nil
 			}
 		}(),
@@ -257,6 +296,130 @@ func MessageFromChangedAttributes(
 				return nil
 			}
 		}(),
+		Power: func() *float64 {
+			if known(attrs.Power) {
+				return &attrs.Power.Value
+			} else {
+				return nil
+			}
+		}(),
+		Current: func() *float64 {
+			if known(attrs.Current) {
+				return &attrs.Current.Value
+			} else {
+				return nil
+			}
+		}(),
+		Energy: func() *float64 {
+			if known(attrs.Energy) {
+				return &attrs.Energy.Value
+			} else {
+				return nil
+			}
+		}(),
+		LocalTemperature: func() *float64 {
+			if known(attrs.LocalTemperature) {
+				return &attrs.LocalTemperature.Value
+			} else {
+				return nil
+			}
+		}(),
+		OccupiedHeatingSetpoint: func() *float64 {
+			if known(attrs.HeatingSetpoint) {
+				return &attrs.HeatingSetpoint.Value
+			} else {
+				return nil
+			}
+		}(),
+		SystemMode: func() *string {
+			if known(attrs.SystemMode) {
+				return &attrs.SystemMode.Value
+			} else {
+				return nil
+			}
+		}(),
+		RunningState: func() *string {
+			if known(attrs.RunningState) {
+				return &attrs.RunningState.Value
+			} else {
+				return nil
+			}
+		}(),
+		PIHeatingDemand: func() *int64 {
+			if known(attrs.HeatingDemand) {
+				return &attrs.HeatingDemand.Value
+			} else {
+				return nil
+			}
+		}(),
+		Smoke: func() *bool {
+			if known(attrs.Smoke) {
+				return &attrs.Smoke.Value
+			} else {
+				return nil
+			}
+		}(),
+		CarbonMonoxide: func() *bool {
+			if known(attrs.CarbonMonoxide) {
+				return &attrs.CarbonMonoxide.Value
+			} else {
+				return nil
+			}
+		}(),
+		Gas: func() *bool {
+			if known(attrs.Gas) {
+				return &attrs.Gas.Value
+			} else {
+				return nil
+			}
+		}(),
+		Tamper: func() *bool {
+			if known(attrs.Tamper) {
+				return &attrs.Tamper.Value
+			} else {
+				return nil
+			}
+		}(),
+		BatteryLow: func() *bool {
+			if known(attrs.BatteryLow) {
+				return &attrs.BatteryLow.Value
+			} else {
+				return nil
+			}
+		}(),
+		LockState: func() *string {
+			if known(attrs.LockState) {
+				return &attrs.LockState.Value
+			} else {
+				return nil
+			}
+		}(),
+		FanMode: func() *string {
+			if known(attrs.FanMode) {
+				return &attrs.FanMode.Value
+			} else {
+				return nil
+			}
+		}(),
+		FanState: func() *string {
+			if known(attrs.FanMode) {
+				if attrs.FanMode.Value == cluster.FanModeOff.String() {
+					return stringPtr("OFF")
+				} else {
+					return stringPtr("ON")
+				}
+			} else {
+				return nil
+			}
+		}(),
+		Tilt: func() *int {
+			if known(attrs.ShadeTilt) {
+				num := int(attrs.ShadeTilt.Value)
+				return &num
+			} else {
+				return nil
+			}
+		}(),
 	})
 	if err != nil { // shouldn't happen
 		return "", err
@@ -291,8 +454,12 @@ func MessageToAttributes(inboundMsg InboundMessage, actx *hubtypes.AttrsCtx, cur
 			attrs.On = actx.Bool(true)
 		case "OFF":
 			attrs.On = actx.Bool(false)
+		case "LOCK":
+			attrs.Locked = actx.Bool(true)
+		case "UNLOCK":
+			attrs.Locked = actx.Bool(false)
 		default:
-			return fmt.Errorf("unknown on/off state: %s", *msg.State)
+			return fmt.Errorf("unknown state: %s", *msg.State)
 		}
 	}
 
@@ -325,6 +492,77 @@ func MessageToAttributes(inboundMsg InboundMessage, actx *hubtypes.AttrsCtx, cur
 		attrs.ShadePosition = actx.Int(int64(*msg.CoverPosition))
 	}
 
+	if msg.Tilt != nil {
+		attrs.ShadeTilt = actx.Int(int64(*msg.Tilt))
+	}
+
+	if msg.OccupiedHeatingSetpoint != nil {
+		attrs.HeatingSetpoint = actx.Float(*msg.OccupiedHeatingSetpoint)
+	}
+
+	if msg.SystemMode != nil {
+		attrs.SystemMode = actx.String(*msg.SystemMode)
+	}
+
+	if msg.FanState != nil {
+		switch *msg.FanState {
+		case "ON":
+			attrs.FanMode = actx.String(cluster.FanModeOn.String())
+		case "OFF":
+			attrs.FanMode = actx.String(cluster.FanModeOff.String())
+		default:
+			return fmt.Errorf("unknown fan state: %s", *msg.FanState)
+		}
+	}
+
+	if msg.FanMode != nil { // more specific than *FanState*, so wins if both given
+		attrs.FanMode = actx.String(*msg.FanMode)
+	}
+
+	if msg.Warning != nil {
+		mode, err := cluster.WarningModeFromString(msg.Warning.Mode)
+		if err != nil {
+			return err
+		}
+
+		level, err := sirenLevelOrDefault(msg.Warning.Level, cluster.SirenLevelMedium)
+		if err != nil {
+			return err
+		}
+
+		duration := 10 * time.Second
+		if msg.Warning.Duration != nil {
+			duration = time.Duration(*msg.Warning.Duration) * time.Second
+		}
+
+		attrs.Warning = &hubtypes.AttrWarning{
+			Mode:       mode,
+			Level:      level,
+			Strobe:     msg.Warning.Strobe == nil || *msg.Warning.Strobe,
+			Duration:   duration,
+			LastReport: actx.Reported,
+		}
+	}
+
+	if msg.Squawk != nil {
+		mode, err := cluster.SquawkModeFromString(msg.Squawk.State)
+		if err != nil {
+			return err
+		}
+
+		level, err := sirenLevelOrDefault(msg.Squawk.Level, cluster.SirenLevelVeryHigh)
+		if err != nil {
+			return err
+		}
+
+		attrs.Squawk = &hubtypes.AttrSquawk{
+			Mode:       mode,
+			Level:      level,
+			Strobe:     msg.Squawk.Strobe == nil || *msg.Squawk.Strobe,
+			LastReport: actx.Reported,
+		}
+	}
+
 	if msg.Alert != nil {
 		switch *msg.Alert {
 		case "select":
@@ -366,3 +604,30 @@ type color struct {
 	G uint8 // 255
 	B uint8 // 255
 }
+
+// defaults (for omitted fields) are the same as zigbee2mqtt's
+type warning struct {
+	Mode     string `json:"mode"`               // "stop" | "burglar" | "fire" | "emergency" | "police_panic" | "fire_panic" | "emergency_panic"
+	Level    string `json:"level,omitempty"`    // "low" | "medium" | "high" | "very_high". default "medium"
+	Strobe   *bool  `json:"strobe,omitempty"`   // default true
+	Duration *int   `json:"duration,omitempty"` // [s] default 10
+}
+
+type squawk struct {
+	State  string `json:"state"`            // "system_is_armed" | "system_is_disarmed"
+	Level  string `json:"level,omitempty"`  // "low" | "medium" | "high" | "very_high". default "very_high"
+	Strobe *bool  `json:"strobe,omitempty"` // default true
+}
+
+type pinCode struct {
+	User    uint16  `json:"user"`
+	PinCode *string `json:"pin_code"` // null clears user's PIN code
+}
+
+func sirenLevelOrDefault(name string, fallback cluster.SirenLevel) (cluster.SirenLevel, error) {
+	if name == "" {
+		return fallback, nil
+	}
+
+	return cluster.SirenLevelFromString(name)
+}
@@ -3,27 +3,52 @@ package ezhub
 import (
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/function61/gokit/net/http/httputils"
 	"github.com/function61/hautomo/pkg/ezstack"
+	"github.com/function61/hautomo/pkg/ezstack/ezhub/homeassistantmqtt"
+	"github.com/function61/hautomo/pkg/ezstack/ezhub/hubtypes"
 	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
 	"github.com/function61/hautomo/pkg/ezstack/zigbee"
 )
 
-func createHttpApi(stack *ezstack.Stack, nodeDatabase *nodeDb) http.Handler {
+func createHttpApi(
+	stack *ezstack.Stack,
+	nodeDatabase *nodeDb,
+	mqttPublish chan<- homeassistantmqtt.Message,
+	mqttPrefix string,
+) http.Handler {
 	routes := http.NewServeMux()
 
+	// ?addr=<device>&endpoint=<endpoint> (endpoint optional)
 	routes.HandleFunc("/api/power/toggle", func(w http.ResponseWriter, r *http.Request) {
-		device, found := nodeDatabase.GetDevice(zigbee.IEEEAddress(r.URL.Query().Get("addr")))
-		if !found {
+		device := nodeDatabase.GetWrappedDevice(zigbee.IEEEAddress(r.URL.Query().Get("addr")))
+		if device == nil {
 			httputils.Error(w, http.StatusNotFound)
 			return
 		}
 
+		endpointId, err := endpointFromQuery(r.URL.Query(), "endpoint")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if endpointId == 0 {
+			endpointId = device.DefaultEndpointId()
+		}
+
+		if !device.HasEndpoint(endpointId) {
+			http.Error(w, fmt.Sprintf("device has no endpoint %d", endpointId), http.StatusBadRequest)
+			return
+		}
+
 		endpoint := ezstack.DeviceAndEndpoint{
-			NetworkAddress: device.NetworkAddress,
-			EndpointId:     ezstack.DefaultSingleEndpointId,
+			NetworkAddress: device.ZigbeeDevice.NetworkAddress,
+			EndpointId:     endpointId,
 		}
 
 		if err := stack.LocalCommand(endpoint, &cluster.GenOnOffToggleCommand{}); err != nil {
@@ -44,20 +69,396 @@ func createHttpApi(stack *ezstack.Stack, nodeDatabase *nodeDb) http.Handler {
 
 		for _, endpoint := range device.Endpoints {
 			for _, clusterId := range endpoint.InClusterList {
-				clusterName := func() string {
-					if def := cluster.FindDefinition(clusterId); def != nil {
-						return def.Name()
-					} else {
-						return fmt.Sprintf("unknown cluster: %d", clusterId)
-					}
-				}()
-
-				line(fmt.Sprintf("%d -> %s", clusterId, clusterName))
+				line(fmt.Sprintf("%d -> %s", clusterId, clusterName(clusterId)))
 			}
 		}
 
 		fmt.Fprint(w, strings.Join(lines, "\n")+"\n")
 	})
 
+	// ?addr=<source>&endpoint=<source endpoint>&to=<target>&to_endpoint=<target endpoint>&clusters=genOnOff,genLevelCtrl
+	// or instead of "to" & "to_endpoint": &to_group=<group id>
+	bindHandler := func(unbind bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "expecting POST", http.StatusMethodNotAllowed)
+				return
+			}
+
+			req, err := bindRequestFromQuery(r.URL.Query())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			result, err := req.execute(stack, nodeDatabase, unbind)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			httputils.RespondJson(w, result)
+		}
+	}
+
+	routes.HandleFunc("/api/bind", bindHandler(false))
+	routes.HandleFunc("/api/unbind", bindHandler(true))
+
+	routes.HandleFunc("/api/bindings", func(w http.ResponseWriter, r *http.Request) {
+		bindings, err := stack.Bindings(zigbee.IEEEAddress(r.URL.Query().Get("addr")))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		httputils.RespondJson(w, bindings)
+	})
+
+	routes.HandleFunc("/api/groups", func(w http.ResponseWriter, r *http.Request) {
+		groups := []*hubtypes.Group{}
+		_ = nodeDatabase.withLock(func() error {
+			groups = append(groups, nodeDatabase.Groups...)
+			return nil
+		})
+
+		httputils.RespondJson(w, groups)
+	})
+
+	// ?id=<group id>&name=<friendly name>
+	routes.HandleFunc("/api/group/create", func(w http.ResponseWriter, r *http.Request) {
+		id, err := groupIdFromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		group, err := createGroup(id, r.URL.Query().Get("name"), nodeDatabase)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		httputils.RespondJson(w, group)
+	})
+
+	// ?id=<group id>
+	routes.HandleFunc("/api/group/delete", func(w http.ResponseWriter, r *http.Request) {
+		id, err := groupIdFromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := deleteGroup(id, stack, nodeDatabase); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+
+	// ?id=<group id>&addr=<device>&endpoint=<endpoint>
+	routes.HandleFunc("/api/group/add", func(w http.ResponseWriter, r *http.Request) {
+		req, err := groupMemberRequestFromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		group, err := addGroupMember(*req, stack, nodeDatabase)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		httputils.RespondJson(w, group)
+	})
+
+	// ?id=<group id>&addr=<device>&endpoint=<endpoint>
+	routes.HandleFunc("/api/group/remove", func(w http.ResponseWriter, r *http.Request) {
+		req, err := groupMemberRequestFromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := removeGroupMember(*req, stack, nodeDatabase); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+
+	// ?addr=<device>&force=true (force = only delete from database)
+	routes.HandleFunc("/api/device/remove", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "expecting POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		addr := zigbee.IEEEAddress(r.URL.Query().Get("addr"))
+
+		if err := stack.RemoveDevice(addr, r.URL.Query().Get("force") == "true"); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+
+	// ?addr=<device>. resumes a failed interview, or re-interviews a known device
+	routes.HandleFunc("/api/device/interview", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "expecting POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		device, err := interviewDevice(zigbee.IEEEAddress(r.URL.Query().Get("addr")), stack)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		httputils.RespondJson(w, device)
+	})
+
+	// ?addr=<device>&endpoint=<endpoint>&attributes=genOnOff,genLevelCtrl.currentLevel (endpoint and
+	// attributes optional). publishes the state changes over MQTT and responds with the read values
+	routes.HandleFunc("/api/device/read", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "expecting POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		device := nodeDatabase.GetWrappedDevice(zigbee.IEEEAddress(r.URL.Query().Get("addr")))
+		if device == nil {
+			httputils.Error(w, http.StatusNotFound)
+			return
+		}
+
+		endpointId, err := endpointFromQuery(r.URL.Query(), "endpoint")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if endpointId == 0 {
+			endpointId = device.DefaultEndpointId()
+		}
+
+		if !device.HasEndpoint(endpointId) {
+			http.Error(w, fmt.Sprintf("device has no endpoint %d", endpointId), http.StatusBadRequest)
+			return
+		}
+
+		attributes := []string{}
+		if attributesStr := r.URL.Query().Get("attributes"); attributesStr != "" {
+			attributes = strings.Split(attributesStr, ",")
+		}
+
+		values, err := refreshDevice(stack, device, endpointId, attributes, mqttPublish, mqttPrefix)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		httputils.RespondJson(w, values)
+	})
+
+	routes.HandleFunc("/api/device/interviews/failed", func(w http.ResponseWriter, r *http.Request) {
+		httputils.RespondJson(w, stack.FailedInterviews())
+	})
+
+	// GET: status. POST: ?value=true&time=<seconds>&device=<router>
+	routes.HandleFunc("/api/permit_join", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httputils.RespondJson(w, stack.PermitJoinStatus())
+			return
+		}
+
+		query := r.URL.Query()
+
+		req := permitJoinRequest{
+			Value:  query.Get("value") != "false",
+			Device: zigbee.IEEEAddress(query.Get("device")),
+		}
+
+		if timeStr := query.Get("time"); timeStr != "" {
+			seconds, err := strconv.Atoi(timeStr)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("time: %v", err), http.StatusBadRequest)
+				return
+			}
+
+			if seconds < 0 {
+				http.Error(w, fmt.Sprintf("time: negative: %d", seconds), http.StatusBadRequest)
+				return
+			}
+
+			req.Time = seconds
+		}
+
+		status, err := req.execute(stack, nodeDatabase)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		httputils.RespondJson(w, status)
+	})
+
+	// takes a while (the new key has to reach the devices before they're told to switch to it)
+	routes.HandleFunc("/api/network_key/rotate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "expecting POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := rotateNetworkKey(stack); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+
+	// ?addr=<device>&code=<install code incl. CRC, in hex>
+	routes.HandleFunc("/api/install_code", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "expecting POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		code, err := zigbee.ParseInstallCode(r.URL.Query().Get("code"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := stack.AddInstallCode(zigbee.IEEEAddress(r.URL.Query().Get("addr")), code); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+
+	// latest topology scan. ?format=dot for Graphviz
+	routes.HandleFunc("/api/topology", func(w http.ResponseWriter, r *http.Request) {
+		topology := nodeDatabase.GetTopology()
+		if topology == nil {
+			http.Error(w, "no topology scan yet. POST /api/topology/scan", http.StatusNotFound)
+			return
+		}
+
+		respondTopology(w, r, topology, nodeDatabase)
+	})
+
+	// scanning takes a while (roughly a second per router)
+	routes.HandleFunc("/api/topology/scan", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "expecting POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		topology, err := scanTopology(stack, nodeDatabase)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		respondTopology(w, r, topology, nodeDatabase)
+	})
+
+	routes.HandleFunc("/api/ota", func(w http.ResponseWriter, r *http.Request) {
+		httputils.RespondJson(w, stack.OtaProgress())
+	})
+
+	// ?addr=<device>
+	// prompts the device to check for an update now instead of waiting for its periodic query
+	routes.HandleFunc("/api/ota/notify", func(w http.ResponseWriter, r *http.Request) {
+		if err := notifyOtaImage(zigbee.IEEEAddress(r.URL.Query().Get("addr")), stack, nodeDatabase); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+
 	return routes
 }
+
+func respondTopology(w http.ResponseWriter, r *http.Request, topology *ezstack.Topology, nodeDatabase *nodeDb) {
+	if r.URL.Query().Get("format") == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		fmt.Fprint(w, topologyToDot(topology, nodeDatabase))
+		return
+	}
+
+	httputils.RespondJson(w, topology)
+}
+
+func groupIdFromQuery(query url.Values) (zigbee.GroupId, error) {
+	id, err := strconv.ParseUint(query.Get("id"), 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("id: %w", err)
+	}
+
+	return zigbee.GroupId(id), nil
+}
+
+func groupMemberRequestFromQuery(query url.Values) (*groupMemberRequest, error) {
+	id, err := groupIdFromQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := endpointFromQuery(query, "endpoint")
+	if err != nil {
+		return nil, err
+	}
+
+	return &groupMemberRequest{
+		Group:    id,
+		Device:   zigbee.IEEEAddress(query.Get("addr")),
+		Endpoint: endpoint,
+	}, nil
+}
+
+// zero if not given (=> default)
+func endpointFromQuery(query url.Values, key string) (zigbee.EndpointId, error) {
+	if query.Get(key) == "" {
+		return 0, nil
+	}
+
+	endpoint, err := strconv.ParseUint(query.Get(key), 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+
+	return zigbee.EndpointId(endpoint), nil
+}
+
+func bindRequestFromQuery(query url.Values) (*bindRequest, error) {
+	fromEndpoint, err := endpointFromQuery(query, "endpoint")
+	if err != nil {
+		return nil, err
+	}
+
+	toEndpoint, err := endpointFromQuery(query, "to_endpoint")
+	if err != nil {
+		return nil, err
+	}
+
+	req := &bindRequest{
+		From:         zigbee.IEEEAddress(query.Get("addr")),
+		FromEndpoint: fromEndpoint,
+		To:           zigbee.IEEEAddress(query.Get("to")),
+		ToEndpoint:   toEndpoint,
+	}
+
+	if toGroup := query.Get("to_group"); toGroup != "" {
+		group, err := strconv.ParseUint(toGroup, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+
+		groupId := zigbee.GroupId(group)
+		req.ToGroup = &groupId
+	}
+
+	if clusters := query.Get("clusters"); clusters != "" {
+		req.Clusters = strings.Split(clusters, ",")
+	}
+
+	return req, nil
+}
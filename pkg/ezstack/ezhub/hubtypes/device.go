@@ -1,6 +1,7 @@
 package hubtypes
 
 import (
+	"sync"
 	"time"
 
 	"github.com/function61/hautomo/pkg/evdevcodes"
@@ -10,12 +11,18 @@ import (
 	"github.com/lucasb-eyer/go-colorful"
 )
 
+// gas sensors don't have an IAS zone type of their own, so both the attribute parsers and Home
+// Assistant autodiscovery recognize them by model
+const ModelHeimanGasSensor ezstack.Model = "GASSensor-N" // https://www.zigbee2mqtt.io/devices/HS3CG.html
+
 // unique id is ZigbeeDevice.IEEEAddress
 type Device struct {
 	FriendlyName string          `json:"friendly_name"`
 	Area         string          `json:"area"`          // added to Home Assistant only if this is set
 	ZigbeeDevice *ezstack.Device `json:"zigbee_device"` // zigbee-level details
 	State        *DeviceState    `json:"state"`
+
+	stateMu sync.Mutex // state is updated from the message loop, but also by polls & on-demand reads
 }
 
 type DeviceState struct {
@@ -28,23 +35,101 @@ func (d *DeviceState) LastHeard() time.Time {
 	return d.LinkQuality.LastReport // Link quality is updated each time we hear from the device
 }
 
+// use as "defer wdev.LockState()()"
+func (d *Device) LockState() func() {
+	d.stateMu.Lock()
+	return d.stateMu.Unlock
+}
+
 // you can use this if device doesn't implement multiple endpoints
 func (d *Device) DefaultEndpoint() *Attributes {
-	// usually the "main" (only) Zigbee Endpoint ID is 1
-	return d.State.EndpointAttrs[ezstack.DefaultSingleEndpointId] // TODO: resolve dynamically?
+	return d.State.EndpointAttrs[d.DefaultEndpointId()]
+}
+
+// endpoint that gets commands that don't specify one. usually the "main" (only) Zigbee endpoint
+// ID is 1, but not always (e.g. Philips Hue lights use 11)
+func (d *Device) DefaultEndpointId() zigbee.EndpointId {
+	for _, endpoint := range d.ZigbeeDevice.Endpoints {
+		if endpoint.Id == ezstack.DefaultSingleEndpointId {
+			return endpoint.Id
+		}
+	}
+
+	for _, endpoint := range d.ZigbeeDevice.Endpoints {
+		if endpoint.Id != ezstack.GreenPowerEndpointId {
+			return endpoint.Id
+		}
+	}
+
+	return ezstack.DefaultSingleEndpointId
+}
+
+// multi-gang switches, power strips etc. have one endpoint per controllable thing, i.e. more
+// than one endpoint implements the same application cluster. just having many endpoints doesn't
+// count (e.g. IKEA bulbs have endpoints 1 and 242)
+func (d *Device) HasMultipleEndpoints() bool {
+	for _, clusterId := range applicationClusters {
+		implementing := 0
+		for _, endpoint := range d.EndpointsImplementing(clusterId) {
+			if endpoint != ezstack.GreenPowerEndpointId {
+				implementing++
+			}
+		}
+
+		if implementing > 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *Device) HasEndpoint(id zigbee.EndpointId) bool {
+	for _, endpoint := range d.ZigbeeDevice.Endpoints {
+		if endpoint.Id == id {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (d *Device) ImplementsCluster(cluster cluster.ClusterId) bool {
-	// FIXME: bad to assume each endpoint has similar cluster support
-	if len(d.ZigbeeDevice.Endpoints) > 0 {
-		for _, candidate := range d.ZigbeeDevice.Endpoints[0].InClusterList {
+	return len(d.EndpointsImplementing(cluster)) > 0
+}
+
+// endpoints that have *cluster* in their input clusters, in the order the device declared them
+func (d *Device) EndpointsImplementing(cluster cluster.ClusterId) []zigbee.EndpointId {
+	endpoints := []zigbee.EndpointId{}
+
+	for _, endpoint := range d.ZigbeeDevice.Endpoints {
+		for _, candidate := range endpoint.InClusterList {
 			if candidate == cluster {
-				return true
+				endpoints = append(endpoints, endpoint.Id)
+				break
 			}
 		}
 	}
 
-	return false
+	return endpoints
+}
+
+// clusters whose attributes are endpoint-specific state (and which get Home Assistant entities
+// per endpoint). as opposed to device-wide clusters like genBasic, genPowerCfg or genOta
+var applicationClusters = []cluster.ClusterId{
+	cluster.IdGenOnOff,
+	cluster.IdGenLevelCtrl,
+	cluster.IdMsTemperatureMeasurement,
+	cluster.IdMsRelativeHumidity,
+	cluster.IdMsPressureMeasurement,
+	cluster.IdHaElectricalMeasurement,
+	cluster.IdSeMetering,
+	cluster.IdClosuresWindowCovering,
+	cluster.IdClosuresDoorLock,
+	cluster.IdHvacFanCtrl,
+	cluster.IdHvacThermostat,
+	cluster.IdSsIasZone,
+	cluster.IdSsIasWd,
 }
 
 // encapsulates device endpoint's (a device can have many) attribute values like sensor values (temperature, humidity etc.)
@@ -70,7 +155,37 @@ type Attributes struct {
 	Illuminance      *AttrFloat       `json:"illuminance,omitempty"`  // lux?
 	Orientation      *AttrOrientation `json:"orientation,omitempty"`
 	ShadePosition    *AttrInt         `json:"shade_position,omitempty"` // 0-100. 100 % = covers whole window, i.e. closed
+	ShadeTilt        *AttrInt         `json:"shade_tilt,omitempty"`     // 0-100. 100 % = slats closed
 	ShadeStop        *AttrEvent       `json:"shade_stop,omitempty"`
+	Power            *AttrFloat       `json:"power,omitempty"`   // [W]
+	Voltage          *AttrFloat       `json:"voltage,omitempty"` // [V] mains voltage (see BatteryVoltage for batteries)
+	Current          *AttrFloat       `json:"current,omitempty"` // [A]
+	Energy           *AttrFloat       `json:"energy,omitempty"`  // [kWh] cumulative consumption
+
+	// thermostats & radiator valves
+	LocalTemperature *AttrFloat  `json:"local_temperature,omitempty"` // [°C] thermostat's own measurement
+	HeatingSetpoint  *AttrFloat  `json:"heating_setpoint,omitempty"`  // [°C]
+	SystemMode       *AttrString `json:"system_mode,omitempty"`       // "off" | "auto" | "heat" | ... (see cluster.ThermostatSystemMode)
+	RunningState     *AttrString `json:"running_state,omitempty"`     // "idle" | "heat" | "cool"
+	HeatingDemand    *AttrInt    `json:"heating_demand,omitempty"`    // [0-100 %] e.g. valve opening of a radiator valve
+
+	// alarm sensors (IAS zone devices)
+	Smoke          *AttrBool `json:"smoke,omitempty"`
+	CarbonMonoxide *AttrBool `json:"carbon_monoxide,omitempty"`
+	Gas            *AttrBool `json:"gas,omitempty"`
+	Tamper         *AttrBool `json:"tamper,omitempty"`      // casing opened or device removed from its mount
+	BatteryLow     *AttrBool `json:"battery_low,omitempty"` // for devices that don't report battery voltage
+
+	// sirens (IAS WD devices). these are commands, the device doesn't report them back
+	Warning *AttrWarning `json:"warning,omitempty"`
+	Squawk  *AttrSquawk  `json:"squawk,omitempty"`
+
+	// door locks
+	Locked    *AttrBool   `json:"locked,omitempty"`     // settable
+	LockState *AttrString `json:"lock_state,omitempty"` // "locked" | "unlocked" | "not_fully_locked"
+
+	// fans
+	FanMode *AttrString `json:"fan_mode,omitempty"` // "off" | "low" | "medium" | "high" | "on" | "auto" | "smart" (see cluster.FanMode)
 
 	PlaybackControl *AttrPlaybackControl `json:"playback_control,omitempty"`
 
@@ -98,6 +213,11 @@ func (a *Attributes) CopyDifferentAttrsTo(dest *Attributes) {
 	source.Color.CopyIfDifferent(&dest.Color)
 	source.ColorTemperature.CopyIfDifferent(&dest.ColorTemperature)
 	source.ShadePosition.CopyIfDifferent(&dest.ShadePosition)
+	source.HeatingSetpoint.CopyIfDifferent(&dest.HeatingSetpoint)
+	source.SystemMode.CopyIfDifferent(&dest.SystemMode)
+	source.Locked.CopyIfDifferent(&dest.Locked)
+	source.FanMode.CopyIfDifferent(&dest.FanMode)
+	source.ShadeTilt.CopyIfDifferent(&dest.ShadeTilt)
 
 	// TODO: put into event struct
 	eventCopyIfDifferent := func(source *AttrEvent, dest **AttrEvent) {
@@ -111,6 +231,14 @@ func (a *Attributes) CopyDifferentAttrsTo(dest *Attributes) {
 	eventCopyIfDifferent(source.ShadeStop, &dest.ShadeStop)
 	eventCopyIfDifferent(source.AlertSelect, &dest.AlertSelect)
 
+	// like events, a siren can be asked to do the same thing again
+	if source.Warning != nil {
+		dest.Warning = source.Warning
+	}
+	if source.Squawk != nil {
+		dest.Squawk = source.Squawk
+	}
+
 	// TODO: rest. the above mainly used for writable attrs (that can be controlled from Home Assistant etc).
 }
 
@@ -311,11 +439,36 @@ func (a *AttrPlaybackControl) LastChange() time.Time { return a.LastReport }
 
 var _ Attribute = (*AttrPlaybackControl)(nil)
 
+type AttrWarning struct {
+	Mode       cluster.WarningMode `json:"mode"` // WarningModeStop stops an ongoing warning
+	Level      cluster.SirenLevel  `json:"level"`
+	Strobe     bool                `json:"strobe"`
+	Duration   time.Duration       `json:"duration"`
+	LastReport time.Time           `json:"reported"`
+}
+
+func (a *AttrWarning) LastChange() time.Time { return a.LastReport }
+
+var _ Attribute = (*AttrWarning)(nil)
+
+type AttrSquawk struct {
+	Mode       cluster.SquawkMode `json:"mode"`
+	Level      cluster.SirenLevel `json:"level"`
+	Strobe     bool               `json:"strobe"`
+	LastReport time.Time          `json:"reported"`
+}
+
+func (a *AttrSquawk) LastChange() time.Time { return a.LastReport }
+
+var _ Attribute = (*AttrSquawk)(nil)
+
 // builder helper for wrapping attributes with shared timestamp
 type AttrsCtx struct {
 	AttrBuilder
 	Attrs    *Attributes
 	Endpoint zigbee.EndpointId
+	Scaling  *ezstack.MeasurementScaling // for metering values. nil if endpoint has none
+	IasZone  *ezstack.IasZone            // for alarm sensor notifications. nil if endpoint has none
 }
 
 type AttrBuilder struct {
@@ -0,0 +1,41 @@
+package hubtypes
+
+import (
+	"testing"
+
+	"github.com/function61/gokit/testing/assert"
+	"github.com/function61/hautomo/pkg/ezstack"
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+)
+
+func TestHasMultipleEndpoints(t *testing.T) {
+	greenPower := &ezstack.Endpoint{Id: 242, InClusterList: []cluster.ClusterId{}, OutClusterList: []cluster.ClusterId{cluster.IdGreenPower}}
+	light := func(id uint8) *ezstack.Endpoint {
+		return &ezstack.Endpoint{Id: zigbee.EndpointId(id), InClusterList: []cluster.ClusterId{cluster.IdGenBasic, cluster.IdGenOnOff, cluster.IdGenLevelCtrl}}
+	}
+
+	for _, tc := range []struct {
+		name      string
+		endpoints []*ezstack.Endpoint
+		expected  bool
+		defaultEp uint8
+	}{
+		{"IKEA bulb", []*ezstack.Endpoint{light(1), greenPower}, false, 1},
+		{"Hue bulb", []*ezstack.Endpoint{light(11), greenPower}, false, 11},
+		{"Green Power endpoint listed first", []*ezstack.Endpoint{greenPower, light(11)}, false, 11},
+		{"2-gang switch", []*ezstack.Endpoint{light(1), light(2)}, true, 1},
+		{"second endpoint with only device-wide clusters", []*ezstack.Endpoint{
+			light(1),
+			{Id: 2, InClusterList: []cluster.ClusterId{cluster.IdGenBasic, cluster.IdGenOta}},
+		}, false, 1},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			dev := &Device{ZigbeeDevice: &ezstack.Device{Endpoints: tc.endpoints}}
+
+			assert.Assert(t, dev.HasMultipleEndpoints() == tc.expected)
+			assert.Assert(t, dev.DefaultEndpointId() == zigbee.EndpointId(tc.defaultEp))
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package hubtypes
+
+import (
+	"github.com/function61/hautomo/pkg/ezstack"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+)
+
+// Zigbee group. commands to a group are groupcast, i.e. one radio frame reaches all members.
+// unique id is Id
+type Group struct {
+	Id           zigbee.GroupId `json:"id"`
+	FriendlyName string         `json:"friendly_name"`
+	Area         string         `json:"area"` // added to Home Assistant only if this is set
+	Members      []GroupMember  `json:"members"`
+	State        *Attributes    `json:"state"` // groupcasts aren't ACKed, so this is what we last commanded
+}
+
+type GroupMember struct {
+	Device   zigbee.IEEEAddress `json:"device"`
+	Endpoint zigbee.EndpointId  `json:"endpoint"`
+}
+
+func NewGroup(id zigbee.GroupId, friendlyName string) *Group {
+	return &Group{
+		Id:           id,
+		FriendlyName: friendlyName,
+		Members:      []GroupMember{},
+		State:        NewAttributes(),
+	}
+}
+
+func (g *Group) HasMember(member GroupMember) bool {
+	for _, candidate := range g.Members {
+		if candidate == member {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (g *Group) RemoveMember(member GroupMember) {
+	for idx, candidate := range g.Members {
+		if candidate == member {
+			g.Members = append(g.Members[:idx], g.Members[idx+1:]...)
+			return
+		}
+	}
+}
+
+// helper
+func (m GroupMember) DeviceAndEndpoint(dev *ezstack.Device) ezstack.DeviceAndEndpoint {
+	return ezstack.DeviceAndEndpoint{
+		NetworkAddress: dev.NetworkAddress,
+		EndpointId:     m.Endpoint,
+	}
+}
@@ -18,8 +18,10 @@ const (
 )
 
 type nodeDb struct {
-	Devices []*hubtypes.Device `json:"devices"`
-	mu      sync.Mutex
+	Devices  []*hubtypes.Device `json:"devices"`
+	Groups   []*hubtypes.Group  `json:"groups"`
+	Topology *ezstack.Topology  `json:"topology,omitempty"` // latest network scan
+	mu       sync.Mutex
 }
 
 func loadNodeDatabase() (*nodeDb, error) {
@@ -40,12 +42,14 @@ var _ ezstack.NodeDatabase = (*nodeDb)(nil)
 func (d *nodeDb) InsertDevice(device *ezstack.Device) error {
 	// TODO: locking is borked, but better than nothing
 
-	if _, found := d.GetDevice(device.IEEEAddress); found {
-		// likely re-joined network, so just update record in DB
+	if wdev := d.GetWrappedDevice(device.IEEEAddress); wdev != nil {
+		// likely re-joined network, so just update record in DB (re-interviews use UpdateDevice())
 		//
-		// NO-OP: just assuming that user modified record from GetDevice() which already shares
-		//        the pointer value
-		return nil
+		// just assuming that user modified record from GetDevice() which already shares
+		// the pointer value
+		defer lockAndUnlock(&d.mu)()
+
+		return saveNodeDatabase(d)
 	}
 
 	if _, found := d.GetDeviceByNetworkAddress(device.NetworkAddress); found {
@@ -73,6 +77,31 @@ func (d *nodeDb) InsertDevice(device *ezstack.Device) error {
 	return saveNodeDatabase(d)
 }
 
+// swaps in the re-interviewed device. readers that already got the old *ezstack.Device keep
+// seeing it unchanged, instead of seeing it change under them
+func (d *nodeDb) UpdateDevice(device *ezstack.Device) error {
+	defer lockAndUnlock(&d.mu)()
+
+	for _, wdev := range d.Devices {
+		if wdev.ZigbeeDevice.IEEEAddress == device.IEEEAddress {
+			defer wdev.LockState()()
+
+			wdev.ZigbeeDevice = device
+
+			// re-interview can reveal new endpoints, which need attributes
+			for _, endpointSpec := range device.Endpoints {
+				if _, has := wdev.State.EndpointAttrs[endpointSpec.Id]; !has {
+					wdev.State.EndpointAttrs[endpointSpec.Id] = hubtypes.NewAttributes()
+				}
+			}
+
+			return saveNodeDatabase(d)
+		}
+	}
+
+	return fmt.Errorf("not found by: %s", device.IEEEAddress)
+}
+
 func (d *nodeDb) GetDevice(ieeeAddress zigbee.IEEEAddress) (*ezstack.Device, bool) {
 	// lock implemented in subcall
 
@@ -95,6 +124,13 @@ func (d *nodeDb) GetWrappedDevice(ieeeAddress zigbee.IEEEAddress) *hubtypes.Devi
 	return nil
 }
 
+// snapshot of the device list. the devices themselves are shared
+func (d *nodeDb) GetWrappedDevices() []*hubtypes.Device {
+	defer lockAndUnlock(&d.mu)()
+
+	return append([]*hubtypes.Device{}, d.Devices...)
+}
+
 func (d *nodeDb) GetDeviceByNetworkAddress(nwkAddress string) (*ezstack.Device, bool) {
 	defer lockAndUnlock(&d.mu)()
 
@@ -113,13 +149,94 @@ func (d *nodeDb) RemoveDevice(ieeeAddress zigbee.IEEEAddress) error {
 	for idx, dev := range d.Devices {
 		if dev.ZigbeeDevice.IEEEAddress == ieeeAddress {
 			d.Devices = append(d.Devices[:idx], d.Devices[idx+1:]...)
-			return nil
+
+			// group memberships would otherwise point to a device that doesn't exist
+			for _, group := range d.Groups {
+				for _, member := range append([]hubtypes.GroupMember{}, group.Members...) {
+					if member.Device == ieeeAddress {
+						group.RemoveMember(member)
+					}
+				}
+			}
+
+			return saveNodeDatabase(d)
 		}
 	}
 
 	return fmt.Errorf("not found by: %s", ieeeAddress)
 }
 
+func (d *nodeDb) GetGroup(id zigbee.GroupId) *hubtypes.Group {
+	defer lockAndUnlock(&d.mu)()
+
+	return d.getGroupWithoutLock(id)
+}
+
+func (d *nodeDb) getGroupWithoutLock(id zigbee.GroupId) *hubtypes.Group {
+	for _, group := range d.Groups {
+		if group.Id == id {
+			return group
+		}
+	}
+
+	return nil
+}
+
+// *update* is called with the group, under lock. the database is saved afterwards
+func (d *nodeDb) UpdateGroup(id zigbee.GroupId, update func(group *hubtypes.Group) error) error {
+	defer lockAndUnlock(&d.mu)()
+
+	group := d.getGroupWithoutLock(id)
+	if group == nil {
+		return fmt.Errorf("group not found: %d", id)
+	}
+
+	if err := update(group); err != nil {
+		return err
+	}
+
+	return saveNodeDatabase(d)
+}
+
+func (d *nodeDb) InsertGroup(group *hubtypes.Group) error {
+	defer lockAndUnlock(&d.mu)()
+
+	if d.getGroupWithoutLock(group.Id) != nil {
+		return fmt.Errorf("group already exists: %d", group.Id)
+	}
+
+	d.Groups = append(d.Groups, group)
+
+	return saveNodeDatabase(d)
+}
+
+func (d *nodeDb) RemoveGroup(id zigbee.GroupId) error {
+	defer lockAndUnlock(&d.mu)()
+
+	for idx, group := range d.Groups {
+		if group.Id == id {
+			d.Groups = append(d.Groups[:idx], d.Groups[idx+1:]...)
+			return saveNodeDatabase(d)
+		}
+	}
+
+	return fmt.Errorf("group not found: %d", id)
+}
+
+func (d *nodeDb) GetTopology() *ezstack.Topology {
+	defer lockAndUnlock(&d.mu)()
+
+	return d.Topology
+}
+
+func (d *nodeDb) SetTopology(topology *ezstack.Topology) error {
+	defer lockAndUnlock(&d.mu)()
+
+	d.Topology = topology
+
+	return saveNodeDatabase(d)
+}
+
 func (d *nodeDb) withLock(do func() error) error {
 	defer lockAndUnlock(&d.mu)()
 
@@ -130,7 +247,7 @@ func loadNodeDatabaseOrInitIfNotFound() (*nodeDb, error) {
 	db, err := loadNodeDatabase()
 	if err != nil {
 		if os.IsNotExist(err) {
-			if err := saveNodeDatabase(&nodeDb{Devices: []*hubtypes.Device{}}); err != nil {
+			if err := saveNodeDatabase(&nodeDb{Devices: []*hubtypes.Device{}, Groups: []*hubtypes.Group{}}); err != nil {
 				return nil, err
 			}
 
@@ -0,0 +1,101 @@
+package ezhub
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/function61/hautomo/pkg/ezstack"
+	"github.com/function61/hautomo/pkg/ezstack/coordinator"
+	"github.com/function61/hautomo/pkg/ezstack/ezhub/homeassistantmqtt"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+)
+
+type permitJoinRequest struct {
+	Value  bool               `json:"value"`
+	Time   int                `json:"time,omitempty"`   // [s] default and max 254
+	Device zigbee.IEEEAddress `json:"device,omitempty"` // router to open. default: all
+}
+
+func (p permitJoinRequest) execute(stack *ezstack.Stack, nodeDatabase *nodeDb) (ezstack.PermitJoinStatus, error) {
+	if p.Time < 0 {
+		return ezstack.PermitJoinStatus{}, fmt.Errorf("time: negative: %d", p.Time)
+	}
+
+	if !p.Value {
+		if err := stack.PermitJoin(0, nil); err != nil {
+			return ezstack.PermitJoinStatus{}, err
+		}
+
+		return stack.PermitJoinStatus(), nil
+	}
+
+	duration := time.Duration(p.Time) * time.Second
+	if duration == 0 {
+		duration = coordinator.PermitJoinMaxDuration
+	}
+
+	var router *ezstack.Device
+	if p.Device != "" {
+		device, found := nodeDatabase.GetDevice(p.Device)
+		if !found {
+			return ezstack.PermitJoinStatus{}, fmt.Errorf("device not found: %s", p.Device)
+		}
+
+		if device.LogicalType != zigbee.LogicalTypeRouter {
+			return ezstack.PermitJoinStatus{}, fmt.Errorf("not a router: %s", p.Device)
+		}
+
+		router = device
+	}
+
+	if err := stack.PermitJoin(duration, router); err != nil {
+		return ezstack.PermitJoinStatus{}, err
+	}
+
+	return stack.PermitJoinStatus(), nil
+}
+
+// publishes remaining permit join time each second while joining is enabled, and once more
+// when it's disabled
+func createPermitJoinPublisherTask(
+	stack *ezstack.Stack,
+	mqttPublish chan<- homeassistantmqtt.Message,
+	mqttPrefix string,
+) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		tick := time.NewTicker(1 * time.Second)
+		defer tick.Stop()
+
+		previouslyEnabled := false
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-tick.C:
+				status := stack.PermitJoinStatus()
+
+				if !status.Enabled && !previouslyEnabled {
+					continue
+				}
+				previouslyEnabled = status.Enabled
+
+				mqttPublish <- homeassistantmqtt.PermitJoinMessage(mqttPrefix, status)
+			}
+		}
+	}
+}
+
+// payload for device-related bridge events
+func deviceEventData(dev *ezstack.Device, nodeDatabase *nodeDb) interface{} {
+	friendlyName := dev.IEEEAddress.HexPrefixedString()
+	if wdev := nodeDatabase.GetWrappedDevice(dev.IEEEAddress); wdev != nil {
+		friendlyName = wdev.FriendlyName
+	}
+
+	return struct {
+		FriendlyName string             `json:"friendly_name"`
+		IEEEAddress  zigbee.IEEEAddress `json:"ieee_address"`
+	}{friendlyName, dev.IEEEAddress}
+}
@@ -0,0 +1,12 @@
+package ezhub
+
+import (
+	"testing"
+
+	"github.com/function61/gokit/testing/assert"
+)
+
+func TestPermitJoinRequestNegativeTime(t *testing.T) {
+	_, err := permitJoinRequest{Value: true, Time: -5}.execute(nil, nil)
+	assert.EqualString(t, err.Error(), "time: negative: -5")
+}
@@ -0,0 +1,89 @@
+package ezhub
+
+// "$ hautomo ezhub network-key-rotate" and "$ hautomo ezhub install-code-add". also available from
+// the HTTP API while ezhub is running
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/function61/gokit/encoding/jsonfile"
+	"github.com/function61/hautomo/pkg/ezstack"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+)
+
+// switches the network to a new random key, and updates our configuration to match
+func RotateNetworkKey(ctx context.Context) error {
+	stack, err := stackForMaintenance()
+	if err != nil {
+		return err
+	}
+
+	if err := stack.Maintenance(ctx, func() error {
+		return rotateNetworkKey(stack)
+	}); err != nil {
+		return err
+	}
+
+	fmt.Printf("Rotated network key. Updated %s to match it\n", configFilename)
+
+	return nil
+}
+
+// registers *installCode* so *device* can join (it still needs joining to be enabled)
+func AddInstallCode(ctx context.Context, device zigbee.IEEEAddress, installCode string) error {
+	code, err := zigbee.ParseInstallCode(installCode)
+	if err != nil {
+		return err
+	}
+
+	stack, err := stackForMaintenance()
+	if err != nil {
+		return err
+	}
+
+	return stack.Maintenance(ctx, func() error {
+		return stack.AddInstallCode(device, code)
+	})
+}
+
+func rotateNetworkKey(stack *ezstack.Stack) error {
+	key, err := randomNetworkKey()
+	if err != nil {
+		return err
+	}
+
+	if err := stack.RotateNetworkKey(key); err != nil {
+		return err
+	}
+
+	// radio now uses the new key, so we must also use it from now on
+	if err := func() error {
+		conf, err := readConfig()
+		if err != nil {
+			return err
+		}
+
+		conf.Coordinator.NetworkKey = key[:]
+
+		return jsonfile.Write(configFilename, conf)
+	}(); err != nil { // don't lose the key
+		return fmt.Errorf("network key rotated to %x but failed updating %s: %w", key, configFilename, err)
+	}
+
+	return nil
+}
+
+func stackForMaintenance() (*ezstack.Stack, error) {
+	conf, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	nodeDatabase, err := loadNodeDatabaseOrInitIfNotFound()
+	if err != nil {
+		return nil, err
+	}
+
+	return ezstack.New(conf.Coordinator, nodeDatabase, nil), nil
+}
@@ -0,0 +1,58 @@
+package ezhub
+
+// "$ hautomo ezhub --serial-sim" runs against a simulated Zigbee radio with a few demo devices,
+// so you can try out ezhub (and the Home Assistant integration) without hardware
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/function61/gokit/sync/taskrunner"
+	"github.com/function61/hautomo/pkg/ezstack"
+	"github.com/function61/hautomo/pkg/ezstack/znpsim"
+)
+
+func startSerialSim(
+	stack *ezstack.Stack,
+	conf Config,
+	nodeDatabase *nodeDb,
+	tasks *taskrunner.Runner,
+) {
+	// radio is already set up for our network, so no --settings-flash is needed
+	sim := znpsim.New(conf.Coordinator.NetworkConfiguration)
+
+	light := znpsim.OnOffLight("0x000b57fffe5a0001", "0x5a01")
+	sensor := znpsim.TemperatureSensor("0x00158d00015a0002", "0x5a02")
+
+	for _, device := range []*znpsim.Device{light, sensor} {
+		// devices joined on previous run stay in the network. others join with --join-enable
+		_, device.Joined = nodeDatabase.GetDevice(device.IEEEAddress)
+
+		sim.AddDevice(device)
+	}
+
+	stack.UseTransport(sim.Port())
+
+	tasks.Start("serial-sim", sim.Run)
+
+	tasks.Start("serial-sim-temperature", func(ctx context.Context) error {
+		tick := time.NewTicker(30 * time.Second)
+		defer tick.Stop()
+
+		temperature := int64(2150) // [0.01 °C]
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-tick.C:
+				temperature += rand.Int63n(41) - 20 // drift +- 0.2 °C
+
+				if err := znpsim.SetTemperature(sensor, temperature); err != nil {
+					return err
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package ezhub
+
+// network topology (a.k.a. network map): which routers our devices are attached to, and how good
+// the links between them are
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/function61/gokit/log/logex"
+	"github.com/function61/hautomo/pkg/ezstack"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+)
+
+// scans the network and stores the result as the latest topology
+func scanTopology(stack *ezstack.Stack, nodeDatabase *nodeDb) (*ezstack.Topology, error) {
+	topology, err := stack.ScanTopology()
+	if err != nil {
+		return nil, err
+	}
+
+	return topology, nodeDatabase.SetTopology(topology)
+}
+
+func createTopologyScanTask(
+	interval time.Duration,
+	stack *ezstack.Stack,
+	nodeDatabase *nodeDb,
+	logl *logex.Leveled,
+) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		scanTimer := time.NewTicker(interval)
+		defer scanTimer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-scanTimer.C:
+				topology, err := scanTopology(stack, nodeDatabase)
+				if err != nil { // not worth a crash. maybe the coordinator is restarting
+					logl.Error.Printf("topology scan: %v", err)
+					continue
+				}
+
+				logl.Info.Printf(
+					"topology scan: %d node(s), %d link(s), %d failed router(s)",
+					len(topology.Nodes),
+					len(topology.Links),
+					len(topology.Failed))
+			}
+		}
+	}
+}
+
+// renders topology in Graphviz dot format. render with e.g. "$ dot -Tsvg topology.dot > topology.svg"
+func topologyToDot(topology *ezstack.Topology, nodeDatabase *nodeDb) string {
+	lines := []string{}
+	line := func(format string, args ...interface{}) { lines = append(lines, fmt.Sprintf(format, args...)) }
+
+	friendlyName := func(address zigbee.IEEEAddress) string {
+		if wdev := nodeDatabase.GetWrappedDevice(address); wdev != nil {
+			return wdev.FriendlyName
+		}
+		return address.HexPrefixedString()
+	}
+
+	line("digraph topology {")
+	line(`  label="Zigbee network %s";`, topology.Scanned.Format(time.RFC3339))
+	line("  node [fontsize=10];")
+	line("  edge [fontsize=8];")
+
+	for _, node := range topology.Nodes {
+		shape := func() string {
+			switch node.LogicalType {
+			case zigbee.LogicalTypeCoordinator:
+				return "box, style=bold"
+			case zigbee.LogicalTypeRouter:
+				return "box"
+			default:
+				return "ellipse"
+			}
+		}()
+
+		failed := ""
+		if _, scanFailed := topology.Failed[node.IEEEAddress]; scanFailed {
+			failed = ", color=red"
+		}
+
+		line(`  "%s" [label="%s\n%s", shape=%s%s];`,
+			node.IEEEAddress,
+			friendlyName(node.IEEEAddress),
+			node.NetworkAddress,
+			shape,
+			failed)
+	}
+
+	for _, link := range topology.Links {
+		style := "solid"
+		if link.Relationship != ezstack.NeighborRelationshipChild && link.Relationship != ezstack.NeighborRelationshipParent {
+			style = "dashed" // mesh link, not a parent-child association
+		}
+
+		line(`  "%s" -> "%s" [label="%d", style=%s];`, link.Source, link.Target, link.LQI, style)
+	}
+
+	line("}")
+
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -5,6 +5,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"sync"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/function61/gokit/log/logex"
@@ -16,12 +19,11 @@ import (
 	"github.com/function61/hautomo/pkg/ezstack/zcl/frame"
 	"github.com/function61/hautomo/pkg/ezstack/zigbee"
 	"github.com/function61/hautomo/pkg/ezstack/znp"
-	"github.com/function61/hautomo/pkg/ezstack/znp/unp"
-	"go.bug.st/serial"
 )
 
 const (
-	DefaultSingleEndpointId = 1 // for simple single-endpoint devices, its endpoint ID usually is 1
+	DefaultSingleEndpointId = 1   // for simple single-endpoint devices, its endpoint ID usually is 1
+	GreenPowerEndpointId    = 242 // Green Power proxy. many routers (IKEA, Hue bulbs..) have it, but it's not an application endpoint
 )
 
 // FIXME: these are all bad
@@ -33,15 +35,22 @@ var (
 
 type Channels struct {
 	onDeviceRegistered      chan *Device
+	onDeviceReinterviewed   chan *Device
 	onDeviceUnregistered    chan *Device
 	onDeviceBecameAvailable chan *Device
 	onDeviceIncomingMessage chan *DeviceIncomingMessage
+	onOtaProgress           chan OtaProgress
 }
 
 func (c *Channels) OnDeviceRegistered() chan *Device {
 	return c.onDeviceRegistered
 }
 
+// known device whose endpoints, clusters and model were refreshed (it might have new ones)
+func (c *Channels) OnDeviceReinterviewed() chan *Device {
+	return c.onDeviceReinterviewed
+}
+
 // TODO: document what's the difference between available and registered
 // seems to be signalled only when device's network address changes
 func (c *Channels) OnDeviceBecameAvailable() chan *Device {
@@ -52,6 +61,11 @@ func (c *Channels) OnDeviceUnregistered() chan *Device {
 	return c.onDeviceUnregistered
 }
 
+// OTA firmware update state changes and download progress
+func (c *Channels) OnOtaProgress() chan OtaProgress {
+	return c.onOtaProgress
+}
+
 // "application-level" message, i.e. sensor sending data
 // TODO: rename to reduce confusion between device registration (name sounds like device is incoming to the cluster..)
 func (c *Channels) OnDeviceIncomingMessage() chan *DeviceIncomingMessage {
@@ -63,77 +77,81 @@ type NodeDatabase interface {
 	GetDeviceByNetworkAddress(nwkAddress string) (*Device, bool)
 	GetDevice(address zigbee.IEEEAddress) (*Device, bool)
 	RemoveDevice(address zigbee.IEEEAddress) error
+	UpdateDevice(*Device) error // replaces (under the database's lock) the known device that has the same IEEE address
 }
 
 type Stack struct {
-	db                NodeDatabase
-	configuration     coordinator.Configuration
-	coordinator       *coordinator.Coordinator
-	registrationQueue chan *znp.ZdoEndDeviceAnnceInd
-	zcl               *zcl.Zcl
-	channels          *Channels
+	db                    NodeDatabase
+	configuration         coordinator.Configuration
+	coordinator           *coordinator.Coordinator
+	registrationQueue     chan *znp.ZdoEndDeviceAnnceInd
+	zcl                   *zcl.Zcl
+	channels              *Channels
+	reportingOverrides    ReportingOverrides
+	ota                   *otaServer // nil if OTA not enabled
+	topologyScanning      sync.Mutex // one scan at a time, since responses are matched only by their type
+	permitJoin            permitJoinState
+	interviews            interviews
+	scalingReads          sync.Map           // IEEE addresses whose measurement scaling is being read
+	lateEnrollments       sync.Map           // IEEE addresses being enrolled to IAS zone after their interview
+	transport             io.ReadWriteCloser // if set, used instead of opening the serial port
+	networkKeySwitchDelay time.Duration      // tests don't want to wait
 }
 
-func New(configuration coordinator.Configuration, db NodeDatabase) *Stack {
+// *reportingOverrides* can be nil
+func New(configuration coordinator.Configuration, db NodeDatabase, reportingOverrides ReportingOverrides) *Stack {
 	coordinator := coordinator.New(&configuration)
 
 	zcl := zcl.Library
 
 	return &Stack{
-		db:                db,
-		configuration:     configuration,
-		coordinator:       coordinator,
-		registrationQueue: make(chan *znp.ZdoEndDeviceAnnceInd),
-		zcl:               zcl,
+		db:                    db,
+		configuration:         configuration,
+		coordinator:           coordinator,
+		registrationQueue:     make(chan *znp.ZdoEndDeviceAnnceInd),
+		zcl:                   zcl,
+		reportingOverrides:    reportingOverrides,
+		networkKeySwitchDelay: networkKeySwitchDelay,
+		interviews: interviews{
+			failed: map[zigbee.IEEEAddress]*interview{},
+			errors: map[zigbee.IEEEAddress]error{},
+		},
 		channels: &Channels{
 			onDeviceRegistered:      make(chan *Device, 10),
+			onDeviceReinterviewed:   make(chan *Device, 10),
 			onDeviceBecameAvailable: make(chan *Device, 10),
 			onDeviceUnregistered:    make(chan *Device, 10),
 			onDeviceIncomingMessage: make(chan *DeviceIncomingMessage, 100),
+			onOtaProgress:           make(chan OtaProgress, 10),
 		},
 	}
 }
 
-// if *packetCaptureFile* non-empty, specifies a file to log inbound UNP frames
-func (s *Stack) Run(ctx context.Context, joinEnable bool, packetCaptureFilename string, settingsFlash bool) error {
-	logl.Debug.Printf(
-		"opening Zigbee radio %s at %d bauds/s",
-		s.configuration.Serial.Port,
-		s.configuration.Serial.BaudRateOrDefault())
-
-	port, err := openPort(
-		s.configuration.Serial.Port,
-		s.configuration.Serial.BaudRateOrDefault())
-	if err != nil {
-		return fmt.Errorf("openPort: %s: %w", s.configuration.Serial.Port, err)
-	}
-	defer port.Close()
-
-	// connect to ZNP using UNP protocol with serial port as a transport
-	networkProcessor := znp.New(unp.NewWith8BitsPayloadLength(port), logex.Prefix("znp", logger))
+// talk to the ZNP over *transport* instead of the configured serial port (e.g. a simulated radio).
+// call before Run()
+func (s *Stack) UseTransport(transport io.ReadWriteCloser) {
+	s.transport = transport
+}
 
+// if *packetCaptureFile* non-empty, specifies a pcap-ng file to capture ZNP frames to
+func (s *Stack) Run(ctx context.Context, joinEnable bool, packetCaptureFilename string, settingsFlash bool) error {
 	tasks := taskrunner.New(ctx, log)
 
+	var connected chan *znp.Znp // nil if nobody needs to know of (re)connections
 	if packetCaptureFilename != "" {
+		connected = make(chan *znp.Znp)
+
 		tasks.Start("packetcapture", func(ctx context.Context) error {
-			return runPacketCapture(ctx, packetCaptureFilename, networkProcessor)
+			return runPacketCapture(ctx, packetCaptureFilename, connected, s.configuration.NetworkConfiguration.PanId)
 		})
 	}
 
-	// multiple ways for us to need port closing, so this is mainly a hack
-	tasks.Start("portcloser", func(ctx context.Context) error {
-		<-ctx.Done()
-
-		// ZNP is most likely blocking on an UNP read
-		return port.Close() // double close intentional
-	})
-
-	tasks.Start("znp", func(ctx context.Context) error {
-		return networkProcessor.Run(ctx)
-	})
+	if joinEnable { // coordinator enables joining for max duration at startup
+		s.permitJoin.set(coordinator.PermitJoinMaxDuration, nil)
+	}
 
-	tasks.Start("coordinator", func(ctx context.Context) error {
-		return s.coordinator.Run(ctx, joinEnable, networkProcessor, settingsFlash)
+	tasks.Start("radio", func(ctx context.Context) error {
+		return s.runRadio(ctx, settingsFlash, connected)
 	})
 
 	// to have expensive operation in separate non-blocking thread, but still do multiple registrations
@@ -170,6 +188,11 @@ func (s *Stack) Run(ctx context.Context, joinEnable bool, packetCaptureFilename
 		case deviceLeave := <-s.coordinator.OnDeviceLeave():
 			ieeeAddress := zigbee.IEEEAddress(deviceLeave.ExtAddr)
 
+			if _, found := s.db.GetDevice(ieeeAddress); !found { // e.g. we asked it to leave and already removed it
+				logl.Debug.Printf("leave from unknown device: [%s]", ieeeAddress)
+				continue
+			}
+
 			logl.Info.Printf("Unregistering device: [%s]", ieeeAddress)
 
 			if err := s.unregisterDevice(ieeeAddress); err != nil {
@@ -192,8 +215,37 @@ func (s *Stack) Channels() *Channels {
 func (f *Stack) LocalCommand(dev DeviceAndEndpoint, command cluster.LocalCommand) error {
 	clusterId, commandId := command.CommandClusterAndId()
 
+	response, err := f.localCommand(dev, command)
+	if err != nil {
+		return err
+	}
+
+	// most commands are answered with a default response, but some (like door lock) have
+	// a command-specific response that carries the status
+	var status cluster.ZclStatus
+	switch zclCommand := response.(type) {
+	case *cluster.DefaultResponseCommand:
+		status = zclCommand.Status
+	case cluster.StatusResponse:
+		status = zclCommand.ResponseStatus()
+	default:
+		return fmt.Errorf("unexpected response to command [%d] on cluster [%d]: %T", commandId, clusterId, response)
+	}
+
+	if err := status.Error(); err != nil {
+		return fmt.Errorf("unable to run command [%d] on cluster [%d]. Status: %v", commandId, clusterId, err)
+	}
+
+	return nil
+}
+
+// sends *command* to all members of *group* with one radio frame. there's no response from
+// the members, so the best we know is that the frame was sent.
+func (f *Stack) GroupCommand(group zigbee.GroupId, command cluster.LocalCommand) error {
+	clusterId, commandId := command.CommandClusterAndId()
+
 	frm, err := frame.New().
-		DisableDefaultResponse(false).
+		DisableDefaultResponse(true).
 		FrameType(frame.FrameTypeLocal).
 		Direction(frame.DirectionClientServer).
 		CommandId(commandId).
@@ -203,6 +255,31 @@ func (f *Stack) LocalCommand(dev DeviceAndEndpoint, command cluster.LocalCommand
 		return err
 	}
 
+	return f.coordinator.GroupDataRequest(
+		group,
+		1,
+		uint16(clusterId),
+		&znp.AfDataRequestOptions{},
+		15,
+		binstruct.Encode(frm))
+}
+
+// returns the command the device responded with. usually *cluster.DefaultResponseCommand, but for
+// commands with a specific response (like AddGroup) it's that response
+func (f *Stack) localCommand(dev DeviceAndEndpoint, command cluster.LocalCommand) (interface{}, error) {
+	clusterId, commandId := command.CommandClusterAndId()
+
+	frm, err := frame.New().
+		DisableDefaultResponse(false).
+		FrameType(frame.FrameTypeLocal).
+		Direction(frame.DirectionClientServer).
+		CommandId(commandId).
+		Command(command).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
 	response, err := f.coordinator.DataRequest(
 		dev.NetworkAddress,
 		dev.EndpointId,
@@ -212,21 +289,16 @@ func (f *Stack) LocalCommand(dev DeviceAndEndpoint, command cluster.LocalCommand
 		15,
 		binstruct.Encode(frm))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	zclIncomingMessage, err := f.zcl.ToZclIncomingMessage(response)
 	if err != nil {
 		logl.Error.Printf("Unsupported data response message:\n%s\n", spew.Sdump(response))
-		return err
-	}
-
-	zclCommand := zclIncomingMessage.Data.Command.(*cluster.DefaultResponseCommand)
-	if err := zclCommand.Status.Error(); err != nil {
-		return fmt.Errorf("unable to run command [%d] on cluster [%d]. Status: %v", commandId, clusterId, err)
+		return nil, err
 	}
 
-	return nil
+	return zclIncomingMessage.Data.Command, nil
 }
 
 func (s *Stack) processIncomingMessage(incomingMessage *znp.AfIncomingMessage) error {
@@ -240,6 +312,16 @@ func (s *Stack) processIncomingMessage(incomingMessage *znp.AfIncomingMessage) e
 		return fmt.Errorf("Received message from unknown device: %s", incomingMessage.SrcAddr)
 	}
 
+	if s.otaProcessIncomingMessage(device, zclIncomingMessage) {
+		return nil
+	}
+
+	if s.iasZoneProcessIncomingMessage(device, zclIncomingMessage) {
+		return nil
+	}
+
+	s.measurementScalingProcessIncomingMessage(device, zclIncomingMessage)
+
 	select {
 	case s.channels.onDeviceIncomingMessage <- &DeviceIncomingMessage{
 		Device:          device,
@@ -275,15 +357,24 @@ func (s *Stack) registerDevice(announcedDevice *znp.ZdoEndDeviceAnnceInd) error
 		}
 	}
 
-	device, err := s.interrogateDevice(announcedDevice)
+	device, err := s.runInterview(newInterview(announcedDevice))
 	if err != nil {
 		return fmt.Errorf("interrogateDevice: %w", err)
 	}
 
+	return s.registerInterviewedDevice(device)
+}
+
+func (s *Stack) registerInterviewedDevice(device *Device) error {
 	if err := s.db.InsertDevice(device); err != nil {
 		return fmt.Errorf("InsertDevice: %w", err)
 	}
 
+	// not fatal: device works without reporting, we just won't get pushed state updates
+	if err := s.configureReporting(device); err != nil {
+		logl.Error.Printf("configureReporting [%s]: %v", device.IEEEAddress, err)
+	}
+
 	select {
 	case s.channels.onDeviceRegistered <- device:
 		logl.Info.Printf(
@@ -332,12 +423,3 @@ func castClusterIds(clusterIdsInt []uint16) []cluster.ClusterId {
 
 	return clusterIds
 }
-
-func openPort(portName string, baudRate int) (port serial.Port, err error) {
-	port, err = serial.Open(portName, &serial.Mode{BaudRate: baudRate})
-	if err != nil {
-		return nil, err
-	}
-
-	return port, port.SetRTS(true)
-}
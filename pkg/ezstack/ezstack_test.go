@@ -0,0 +1,871 @@
+package ezstack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/function61/gokit/testing/assert"
+	"github.com/function61/hautomo/pkg/ezstack/binstruct"
+	"github.com/function61/hautomo/pkg/ezstack/coordinator"
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+	"github.com/function61/hautomo/pkg/ezstack/znp"
+	"github.com/function61/hautomo/pkg/ezstack/znpsim"
+)
+
+var testNetwork = coordinator.NetworkConfiguration{
+	IEEEAddress: "0x00124b0012345678",
+	PanId:       0x1a62,
+	ExtPanId:    0xdddddddddddddddd,
+	NetworkKey:  []byte{1, 3, 5, 7, 9, 11, 13, 15, 0, 2, 4, 6, 8, 10, 12, 13},
+	Channel:     15,
+}
+
+// full stack (coordinator startup, join + interview, commands, reports) against a simulated radio
+func TestStackWithSimulatedRadio(t *testing.T) {
+	sim := znpsim.New(testNetwork)
+
+	light := znpsim.OnOffLight("0x000b57fffe000001", "0x1001")
+	sensor := znpsim.TemperatureSensor("0x00158d0000000002", "0x1002")
+
+	sim.AddDevice(light)
+	sim.AddDevice(sensor)
+
+	stack, stop := startStack(t, sim, false)
+	defer stop()
+
+	registered := map[zigbee.IEEEAddress]*Device{}
+	for len(registered) < 2 {
+		select {
+		case dev := <-stack.Channels().OnDeviceRegistered():
+			registered[dev.IEEEAddress] = dev
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timeout waiting for devices to register. got %d", len(registered))
+		}
+	}
+
+	lightDev := registered[light.IEEEAddress]
+	assert.EqualString(t, string(lightDev.Model), znpsim.ModelOnOffLight)
+	assert.EqualString(t, lightDev.Manufacturer, "hautomo")
+	assert.Assert(t, lightDev.LogicalType == zigbee.LogicalTypeRouter)
+	assert.EqualInt(t, len(lightDev.Endpoints), 1)
+	assert.EqualInt(t, len(lightDev.Endpoints[0].InClusterList), 2)
+	assert.Assert(t, lightDev.Endpoints[0].InClusterList[1] == cluster.IdGenOnOff)
+
+	assert.Assert(t, registered[sensor.IEEEAddress].PowerSource == Battery)
+
+	// bound for reporting during registration
+	bindings, err := stack.Bindings(light.IEEEAddress)
+	assert.Ok(t, err)
+	assert.EqualInt(t, len(bindings), 1)
+	assert.Assert(t, bindings[0].Target.Device == testNetwork.IEEEAddress)
+
+	readOnOff := func() bool {
+		t.Helper()
+
+		resp, err := stack.ReadAttributes(lightDev.NetworkAddress, cluster.IdGenOnOff, []cluster.AttributeId{0x0000})
+		assert.Ok(t, err)
+		assert.EqualInt(t, len(resp.ReadAttributeStatuses), 1)
+
+		return resp.ReadAttributeStatuses[0].Attribute.Value.(bool)
+	}
+
+	assert.Assert(t, !readOnOff())
+
+	assert.Ok(t, stack.LocalCommand(DeviceAndEndpoint{
+		NetworkAddress: lightDev.NetworkAddress,
+		EndpointId:     1,
+	}, &cluster.GenOnOffOnCommand{}))
+
+	assert.Assert(t, readOnOff())
+
+	// same, but addressed to the endpoint directly
+	resp, err := stack.ReadEndpointAttributes(DeviceAndEndpoint{
+		NetworkAddress: lightDev.NetworkAddress,
+		EndpointId:     1,
+	}, cluster.IdGenOnOff, []cluster.AttributeId{0x0000})
+	assert.Ok(t, err)
+	assert.Assert(t, resp.ReadAttributeStatuses[0].Attribute.Value == true)
+
+	// the light reported its new state by itself
+	report := awaitReport(t, stack, light.IEEEAddress)
+	assert.Assert(t, report.AttributeReports[0].Attribute.Value == true)
+
+	assert.Ok(t, znpsim.SetTemperature(sensor, 2345))
+
+	report = awaitReport(t, stack, sensor.IEEEAddress)
+	assert.Assert(t, report.AttributeReports[0].Attribute.Value == int64(2345))
+
+	// asking to leave
+	assert.Ok(t, stack.RemoveDevice(sensor.IEEEAddress, false))
+	_, found := stack.db.GetDevice(sensor.IEEEAddress)
+	assert.Assert(t, !found)
+}
+
+// radio has wrong settings => we're allowed to flash ours
+func TestSettingsFlashWithSimulatedRadio(t *testing.T) {
+	sim := znpsim.New(coordinator.NetworkConfiguration{
+		IEEEAddress: "0x00124b00ffffffff",
+		PanId:       0x1234,
+		ExtPanId:    0x1234,
+		NetworkKey:  make([]byte, 16),
+		Channel:     11,
+	})
+
+	// joining is permitted as the last step of startup, so this tells us when startup is done
+	sim.AddDevice(znpsim.OnOffLight("0x000b57fffe000001", "0x1001"))
+
+	stack, stop := startStack(t, sim, true)
+	defer stop()
+
+	select {
+	case <-stack.Channels().OnDeviceRegistered():
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for coordinator to start")
+	}
+
+	assert.Assert(t, sim.Network().Equal(testNetwork))
+	assert.Assert(t, stack.coordinator.NetworkConf().Equal(testNetwork))
+}
+
+// Z-Stack 3 forms the network with BDB commissioning, and starts differently
+func TestZStack3WithSimulatedRadio(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		radioNetwork  coordinator.NetworkConfiguration
+		settingsFlash bool
+	}{
+		{"factory-fresh", coordinator.NetworkConfiguration{}, true},
+		{"already in our network", testNetwork, false},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			sim := znpsim.NewWithFirmware(tc.radioNetwork, znpsim.FirmwareZStack3x0)
+
+			// joining is permitted as the last step of startup, so this tells us when startup is done
+			sim.AddDevice(znpsim.OnOffLight("0x000b57fffe000001", "0x1001"))
+
+			stack, stop := startStack(t, sim, tc.settingsFlash)
+			defer stop()
+
+			select {
+			case <-stack.Channels().OnDeviceRegistered():
+			case <-time.After(10 * time.Second):
+				t.Fatal("timeout waiting for coordinator to start")
+			}
+
+			assert.Assert(t, stack.coordinator.ZStackVersion() == znp.ZStackVersion3x0)
+			assert.Assert(t, sim.Network().Equal(testNetwork))
+
+			onANetwork := znp.ZCDNVBDBNodeIsOnANetwork{}
+			assert.Ok(t, binstruct.Decode(sim.NVRAM(onANetwork.ItemID()), &onANetwork))
+			assert.Assert(t, onANetwork.OnANetwork == 1)
+		})
+	}
+}
+
+// network-attached radio whose link drops: we reconnect, re-run startup and keep working
+func TestReconnectToTcpRadio(t *testing.T) {
+	sim := znpsim.New(testNetwork)
+
+	light := znpsim.OnOffLight("0x000b57fffe000001", "0x1001")
+	sim.AddDevice(light)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Ok(t, err)
+	defer listener.Close()
+
+	gateway := newTcpGateway(sim.Port())
+	go gateway.serve(listener)
+
+	stack := New(coordinator.Configuration{
+		NetworkConfiguration: testNetwork,
+		Serial:               &coordinator.Serial{Port: "tcp://" + listener.Addr().String()},
+	}, newMemoryNodeDatabase(), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	simStopped := make(chan error, 1)
+	go func() {
+		simStopped <- sim.Run(ctx)
+	}()
+
+	stackStopped := make(chan error, 1)
+	go func() {
+		stackStopped <- stack.Run(ctx, true, "", false)
+	}()
+
+	defer func() {
+		cancel()
+
+		assert.Ok(t, <-stackStopped)
+		assert.Ok(t, <-simStopped)
+	}()
+
+	var lightDev *Device
+	select {
+	case lightDev = <-stack.Channels().OnDeviceRegistered():
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for device to register")
+	}
+
+	gateway.dropClient()
+
+	// commands fail until we've reconnected
+	deadline := time.Now().Add(reconnectDelay + 10*time.Second)
+	for {
+		_, err := stack.ReadAttributes(lightDev.NetworkAddress, cluster.IdGenOnOff, []cluster.AttributeId{0x0000})
+		if err == nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("no reconnect: %v", err)
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	assert.EqualInt(t, gateway.connections(), 2)
+
+	// joining was restored for what's left of it
+	assert.Assert(t, stack.PermitJoinStatus().Enabled)
+}
+
+// back up a network from one radio and restore it onto a fresh one
+func TestBackupAndRestore(t *testing.T) {
+	nib := make([]byte, 110) // layout of CC2531
+	nib[109] = 3             // nwkUpdateId
+
+	addrMgr := []byte{
+		0x01, 0x01, 0x10, 0x01, 0x00, 0x00, 0xfe, 0xff, 0x57, 0x0b, 0x00, // child
+		0x02, 0x02, 0x10, 0x02, 0x00, 0x00, 0x00, 0x00, 0x8d, 0x15, 0x00, // not our child
+		0x00, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, // unused
+	}
+
+	linkKeys := bytes.Repeat([]byte{0xab}, 600) // needs reads & writes past 255 offset
+
+	oldRadio := znpsim.New(testNetwork)
+	oldRadio.SetNVRAM(znp.NVRAMItemNib, nib)
+	oldRadio.SetNVRAM(znp.NVRAMItemAddrMgr, addrMgr)
+	oldRadio.SetNVRAM(znp.NVRAMItemApsLinkKeyDataTable, linkKeys)
+	oldRadio.SetNVRAM(znp.NVRAMItemNwkSecMaterialTable, binstruct.Encode(&znp.NwkSecMaterialDescriptor{
+		FrameCounter:  1000,
+		ExtendedPANID: testNetwork.ExtPanId,
+	}))
+	oldRadio.SetNVRAM(znp.NVRAMItemNwkSecMaterialTable+1, binstruct.Encode(&znp.NwkSecMaterialDescriptor{
+		FrameCounter:  1200,
+		ExtendedPANID: 0xffffffffffffffff, // generic entry
+	}))
+
+	var backup *coordinator.Backup
+	withSimulatedRadio(t, oldRadio, testNetwork, func(stack *Stack) {
+		var err error
+		backup, err = stack.Backup(context.Background(), "test")
+		assert.Ok(t, err)
+	})
+
+	assert.EqualString(t, backup.CoordinatorIEEE, "00124b0012345678")
+	assert.EqualString(t, backup.PanId, "1a62")
+	assert.EqualString(t, backup.ExtendedPanId, "dddddddddddddddd")
+	assert.EqualString(t, backup.NetworkKey.Key, "01030507090b0d0f00020406080a0c0d")
+	assert.Assert(t, backup.NetworkKey.FrameCounter == 1200)
+	assert.Assert(t, backup.NwkUpdateId == 3)
+	assert.Assert(t, backup.Channel == 15)
+
+	devicesJson, err := json.Marshal(backup.Devices)
+	assert.Ok(t, err)
+	assert.EqualString(t, string(devicesJson), `[{"nwk_address":"1001","ieee_address":"000b57fffe000001","is_child":true},{"nwk_address":"1002","ieee_address":"00158d0000000002","is_child":false}]`)
+
+	// goes through JSON like it would from a file
+	backupJson, err := json.Marshal(backup)
+	assert.Ok(t, err)
+	backup = &coordinator.Backup{}
+	assert.Ok(t, json.Unmarshal(backupJson, backup))
+	assert.Ok(t, backup.Valid())
+
+	restoredConf, err := backup.NetworkConfiguration()
+	assert.Ok(t, err)
+	assert.Assert(t, restoredConf.Equal(testNetwork))
+
+	newRadio := znpsim.New(coordinator.NetworkConfiguration{}) // factory-fresh
+	newRadio.SetNVRAM(znp.NVRAMItemNib, make([]byte, 110))
+
+	withSimulatedRadio(t, newRadio, *restoredConf, func(stack *Stack) {
+		assert.Ok(t, stack.Restore(context.Background(), backup))
+	})
+
+	assert.Assert(t, newRadio.Network().Equal(testNetwork))
+	assert.Assert(t, bytes.Equal(newRadio.NVRAM(znp.NVRAMItemNib), nib))
+	assert.Assert(t, bytes.Equal(newRadio.NVRAM(znp.NVRAMItemAddrMgr), addrMgr))
+	assert.Assert(t, bytes.Equal(newRadio.NVRAM(znp.NVRAMItemApsLinkKeyDataTable), linkKeys))
+
+	// frame counter continues from where the old radio was, with margin
+	secMaterial := znp.NwkSecMaterialDescriptor{}
+	assert.Ok(t, binstruct.Decode(newRadio.NVRAM(znp.NVRAMItemNwkSecMaterialTable), &secMaterial))
+	assert.Assert(t, secMaterial.ExtendedPANID == testNetwork.ExtPanId)
+	assert.Assert(t, secMaterial.FrameCounter == 3700)
+}
+
+// runs *fn* against a radio that's not in use by Run()
+// Z-Stack 3.x.0 keeps tables in extended NVRAM
+func TestBackupAndRestoreZStack3(t *testing.T) {
+	nib := make([]byte, 116) // layout of CC26x2
+
+	addrMgrEntry := bytes.Repeat([]byte{0x11}, 16)
+
+	oldRadio := znpsim.NewWithFirmware(testNetwork, znpsim.FirmwareZStack3x0)
+	oldRadio.SetNVRAM(znp.NVRAMItemNib, nib)
+	oldRadio.SetExtendedNVRAM(znp.ExtendedNVRAMItemAddrMgr, 0, addrMgrEntry)
+	oldRadio.SetExtendedNVRAM(znp.ExtendedNVRAMItemNwkSecMaterialTable, 0, binstruct.Encode(&znp.NwkSecMaterialDescriptor{
+		FrameCounter:  5000,
+		ExtendedPANID: testNetwork.ExtPanId,
+	}))
+
+	var backup *coordinator.Backup
+	withSimulatedRadio(t, oldRadio, testNetwork, func(stack *Stack) {
+		var err error
+		backup, err = stack.Backup(context.Background(), "test")
+		assert.Ok(t, err)
+	})
+
+	assert.Assert(t, backup.NetworkKey.FrameCounter == 5000)
+	assert.EqualString(t, backup.Metadata.Internal.ZStackNVRAMExtended["0001:0000"], "11111111111111111111111111111111")
+
+	newRadio := znpsim.NewWithFirmware(coordinator.NetworkConfiguration{}, znpsim.FirmwareZStack3x0)
+	newRadio.SetNVRAM(znp.NVRAMItemNib, make([]byte, 116))
+
+	withSimulatedRadio(t, newRadio, testNetwork, func(stack *Stack) {
+		assert.Ok(t, stack.Restore(context.Background(), backup))
+	})
+
+	assert.Assert(t, newRadio.Network().Equal(testNetwork))
+	assert.Assert(t, bytes.Equal(newRadio.ExtendedNVRAM(znp.ExtendedNVRAMItemAddrMgr, 0), addrMgrEntry))
+
+	secMaterial := znp.NwkSecMaterialDescriptor{}
+	assert.Ok(t, binstruct.Decode(newRadio.ExtendedNVRAM(znp.ExtendedNVRAMItemNwkSecMaterialTable, 0), &secMaterial))
+	assert.Assert(t, secMaterial.ExtendedPANID == testNetwork.ExtPanId)
+	assert.Assert(t, secMaterial.FrameCounter == 7500)
+}
+
+func TestEnergyScan(t *testing.T) {
+	sim := znpsim.New(testNetwork)
+	sim.SetChannelEnergy(15, 180)
+
+	withSimulatedRadio(t, sim, testNetwork, func(stack *Stack) {
+		energies, err := stack.EnergyScan(context.Background())
+		assert.Ok(t, err)
+
+		assert.EqualInt(t, len(energies), 16)
+		assert.Assert(t, energies[0] == coordinator.ChannelEnergy{Channel: 11, Energy: 0})
+		assert.Assert(t, energies[4] == coordinator.ChannelEnergy{Channel: 15, Energy: 180})
+		assert.Assert(t, energies[15] == coordinator.ChannelEnergy{Channel: 26, Energy: 0})
+	})
+}
+
+func TestPermitJoinNegativeDuration(t *testing.T) {
+	stack, stop := startStack(t, znpsim.New(testNetwork), false)
+	defer stop()
+
+	// would wrap around to 251 seconds in the radio's uint8
+	assert.EqualString(t, stack.PermitJoin(-5*time.Second, nil).Error(), "PermitJoin: negative duration -5s")
+
+	assert.Assert(t, !stack.PermitJoinStatus().Enabled)
+}
+
+func TestChangeChannel(t *testing.T) {
+	for _, firmware := range []znp.SysVersionResponse{znpsim.FirmwareZStack12, znpsim.FirmwareZStack3x0} {
+		sim := znpsim.NewWithFirmware(testNetwork, firmware)
+
+		withSimulatedRadio(t, sim, testNetwork, func(stack *Stack) {
+			assert.Ok(t, stack.ChangeChannel(context.Background(), 26))
+
+			assert.Assert(t, stack.configuration.Channel == 26)
+		})
+
+		// radio is configured for the new channel, so next startup won't need flashing
+		assert.Assert(t, sim.Network().Channel == 26)
+	}
+}
+
+func TestRotateNetworkKey(t *testing.T) {
+	newKey := zigbee.NetworkKey{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+
+	for _, firmware := range []znp.SysVersionResponse{znpsim.FirmwareZStack12, znpsim.FirmwareZStack3x0} {
+		sim := znpsim.NewWithFirmware(testNetwork, firmware)
+
+		withSimulatedRadio(t, sim, testNetwork, func(stack *Stack) {
+			stack.networkKeySwitchDelay = 0
+
+			assert.Ok(t, stack.Maintenance(context.Background(), func() error {
+				return stack.RotateNetworkKey(newKey)
+			}))
+
+			assert.Assert(t, bytes.Equal(stack.configuration.NetworkKey, newKey[:]))
+		})
+
+		activeKey := znp.ZCDNVNwkActiveKeyInfo{}
+		assert.Ok(t, binstruct.Decode(sim.NVRAM(activeKey.ItemID()), &activeKey))
+		assert.Assert(t, activeKey.Key == newKey)
+		assert.EqualInt(t, int(activeKey.KeySeqNum), 1)
+
+		// radio is configured for the new key, so next startup won't need flashing
+		assert.Assert(t, bytes.Equal(sim.Network().NetworkKey, newKey[:]))
+	}
+}
+
+func TestAddInstallCode(t *testing.T) {
+	const device = zigbee.IEEEAddress("0x00158d0001234567")
+
+	// test vector from Zigbee spec
+	code, err := zigbee.ParseInstallCode("83FE D340 7A93 9723 A5C6 39B2 6916 D505 C3B5")
+	assert.Ok(t, err)
+
+	_, err = zigbee.ParseInstallCode("83FE D340 7A93 9723 A5C6 39B2 6916 D505 C3B6")
+	assert.EqualString(t, err.Error(), "ParseInstallCode: CRC mismatch (typo in the code?)")
+
+	sim := znpsim.NewWithFirmware(testNetwork, znpsim.FirmwareZStack3x0)
+
+	withSimulatedRadio(t, sim, testNetwork, func(stack *Stack) {
+		assert.Ok(t, stack.Maintenance(context.Background(), func() error {
+			return stack.AddInstallCode(device, code)
+		}))
+	})
+
+	linkKey, found := sim.LinkKey(device)
+	assert.Assert(t, found)
+	assert.EqualString(t, fmt.Sprintf("%x", linkKey), "66b6900981e1ee3ca4206b6b861c02bb")
+
+	// Z-Stack 1.2 doesn't support install codes
+	withSimulatedRadio(t, znpsim.New(testNetwork), testNetwork, func(stack *Stack) {
+		assert.EqualString(t, stack.Maintenance(context.Background(), func() error {
+			return stack.AddInstallCode(device, code)
+		}).Error(), "AddInstallCode: install codes need Z-Stack 3 (have Z-Stack 1.2)")
+	})
+}
+
+// metering values need scaling, which is read at interview
+func TestSmartPlugMeasurementScaling(t *testing.T) {
+	sim := znpsim.New(testNetwork)
+
+	plug := znpsim.SmartPlug("0x00158d0000000003", "0x1003")
+
+	sim.AddDevice(plug)
+
+	stack, stop := startStack(t, sim, false)
+	defer stop()
+
+	var plugDev *Device
+	select {
+	case plugDev = <-stack.Channels().OnDeviceRegistered():
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for plug to register")
+	}
+
+	scaling := plugDev.Endpoints[0].MeasurementScaling
+	assert.Assert(t, scaling != nil)
+	assert.Assert(t, scaling.AcPower.Apply(1234) == 123.4)
+	assert.Assert(t, scaling.AcCurrent.Apply(500) == 0.5)
+	assert.Assert(t, scaling.AcVoltage.Apply(230) == 230)
+	assert.Assert(t, scaling.Metering.Apply(150) == 1.5)
+
+	assert.Ok(t, znpsim.SetPowerReadings(plug, 1234, 150))
+
+	report := awaitReport(t, stack, plug.IEEEAddress)
+	assert.Assert(t, report.AttributeReports[0].Attribute.Value == int64(1234))
+
+	// devices interviewed before we read scaling at interview have none. it's read when they report
+	withoutScaling := *plugDev
+	withoutScaling.Endpoints = []*Endpoint{{
+		Id:            plugDev.Endpoints[0].Id,
+		InClusterList: plugDev.Endpoints[0].InClusterList,
+	}}
+	assert.Ok(t, stack.db.UpdateDevice(&withoutScaling))
+
+	assert.Ok(t, znpsim.SetPowerReadings(plug, 1234, 150))
+	awaitReport(t, stack, plug.IEEEAddress)
+
+	readScaling := func() *MeasurementScaling {
+		dev, _ := stack.db.GetDevice(plug.IEEEAddress)
+		return dev.Endpoints[0].MeasurementScaling
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); readScaling() == nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for measurement scaling to be read")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Assert(t, readScaling().AcPower.Apply(1234) == 123.4)
+	assert.Assert(t, withoutScaling.Endpoints[0].MeasurementScaling == nil) // replaced, not modified
+}
+
+func TestThermostatWriteAttributes(t *testing.T) {
+	sim := znpsim.New(testNetwork)
+
+	thermostat := znpsim.Thermostat("0x00158d0000000004", "0x1004")
+
+	sim.AddDevice(thermostat)
+
+	stack, stop := startStack(t, sim, false)
+	defer stop()
+
+	select {
+	case <-stack.Channels().OnDeviceRegistered():
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for thermostat to register")
+	}
+
+	endpoint := DeviceAndEndpoint{thermostat.NetworkAddress, 1}
+
+	assert.Ok(t, stack.WriteEndpointAttributes(endpoint, cluster.IdHvacThermostat, &cluster.WriteAttributeRecord{
+		AttributeID: uint16(cluster.AttrHvacThermostatOccupiedHeatingSetpoint),
+		Attribute:   &cluster.Attribute{DataType: cluster.ZclDataTypeInt16, Value: int64(2150)},
+	}))
+
+	assert.Assert(t, thermostat.Attribute(1, cluster.IdHvacThermostat, cluster.AttrHvacThermostatOccupiedHeatingSetpoint).Value == int64(2150))
+
+	// refused attribute (simulator doesn't know manufacturer-specific ones) is an error
+	assert.EqualString(t, stack.WriteEndpointAttributes(endpoint, cluster.IdHvacThermostat, &cluster.WriteAttributeRecord{
+		AttributeID: 0x4003,
+		Attribute:   &cluster.Attribute{DataType: cluster.ZclDataTypeInt16, Value: int64(2150)},
+	}).Error(), "WriteAttributes failed: attribute 16387: status 134")
+}
+
+func TestDoorLockCommands(t *testing.T) {
+	sim := znpsim.New(testNetwork)
+
+	lock := znpsim.DoorLock("0x00158d0000000006", "0x1006")
+
+	sim.AddDevice(lock)
+
+	stack, stop := startStack(t, sim, false)
+	defer stop()
+
+	select {
+	case <-stack.Channels().OnDeviceRegistered():
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for lock to register")
+	}
+
+	endpoint := DeviceAndEndpoint{lock.NetworkAddress, 1}
+
+	lockState := func() cluster.DoorLockState {
+		return cluster.DoorLockState(lock.Attribute(1, cluster.IdClosuresDoorLock, cluster.AttrClosuresDoorLockLockState).Value.(uint64))
+	}
+
+	// lock answers with a status response instead of a default response
+	assert.Ok(t, stack.LocalCommand(endpoint, &cluster.ClosuresDoorLockLockDoor{}))
+	assert.EqualString(t, lockState().String(), "locked")
+
+	assert.Ok(t, stack.LocalCommand(endpoint, &cluster.ClosuresDoorLockUnlockDoor{}))
+	assert.EqualString(t, lockState().String(), "unlocked")
+
+	assert.Ok(t, stack.LocalCommand(endpoint, &cluster.ClosuresDoorLockSetPinCode{
+		UserId:     3,
+		UserStatus: cluster.DoorLockUserStatusOccupiedEnabled,
+		UserType:   cluster.DoorLockUserTypeUnrestricted,
+		PinCode:    "1234",
+	}))
+
+	pin, found := znpsim.DoorLockPinCode(lock, 3)
+	assert.Assert(t, found)
+	assert.EqualString(t, pin, "1234")
+
+	// failure status in the status response is an error
+	assert.EqualString(t, stack.LocalCommand(endpoint, &cluster.ClosuresDoorLockSetPinCode{
+		UserId:     4,
+		UserStatus: cluster.DoorLockUserStatusOccupiedEnabled,
+		UserType:   cluster.DoorLockUserTypeUnrestricted,
+		PinCode:    "12",
+	}).Error(), "unable to run command [5] on cluster [257]. Status: ZCL error: 1")
+
+	_, found = znpsim.DoorLockPinCode(lock, 4)
+	assert.Assert(t, !found)
+
+	assert.Ok(t, stack.LocalCommand(endpoint, &cluster.ClosuresDoorLockClearPinCode{UserId: 3}))
+
+	_, found = znpsim.DoorLockPinCode(lock, 3)
+	assert.Assert(t, !found)
+}
+
+func TestIasZoneEnrollment(t *testing.T) {
+	sim := znpsim.New(testNetwork)
+
+	sensor := znpsim.SmokeSensor("0x00158d0000000005", "0x1005")
+
+	sim.AddDevice(sensor)
+
+	stack, stop := startStack(t, sim, false)
+	defer stop()
+
+	var sensorDev *Device
+	select {
+	case sensorDev = <-stack.Channels().OnDeviceRegistered():
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for smoke sensor to register")
+	}
+
+	zoneAttr := func(attributeId cluster.AttributeId) interface{} {
+		return sensor.Attribute(1, cluster.IdSsIasZone, attributeId).Value
+	}
+
+	assert.Assert(t, sensorDev.Endpoints[0].IasZone.Type == cluster.IasZoneTypeFireSensor)
+	assert.EqualString(t, zoneAttr(cluster.AttrSsIasZoneIasCieAddr).(string), testNetwork.IEEEAddress.HexPrefixedString())
+	assert.Assert(t, zoneAttr(cluster.AttrSsIasZoneZoneState) == uint64(1))
+	assert.Assert(t, zoneAttr(cluster.AttrSsIasZoneZoneId) == uint64(iasZoneId))
+
+	// device that lost its enrollment asks for it again
+	assert.Ok(t, sensor.SetAttribute(1, cluster.IdSsIasZone, cluster.AttrSsIasZoneZoneState, uint64(0)))
+	assert.Ok(t, sensor.SendServerCommand(1, &cluster.ZoneEnrollRequestCommand{ZoneType: cluster.IasZoneTypeFireSensor}))
+
+	for deadline := time.Now().Add(5 * time.Second); zoneAttr(cluster.AttrSsIasZoneZoneState) != uint64(1); {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for enroll response")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Ok(t, znpsim.SetSmoke(sensor, true))
+
+	for {
+		select {
+		case msg := <-stack.Channels().OnDeviceIncomingMessage():
+			// default response to our enroll response also comes through here
+			if notification, ok := msg.IncomingMessage.Data.Command.(*cluster.ZoneStatusChangeNotificationCommand); ok {
+				assert.Assert(t, notification.ZoneStatus.Alarm1())
+				assert.Assert(t, notification.ZoneID == iasZoneId)
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for zone status change notification")
+		}
+	}
+}
+
+func TestIasZoneEnrollmentAfterInterview(t *testing.T) {
+	sim := znpsim.New(testNetwork)
+
+	sensor := znpsim.SmokeSensor("0x00158d0000000005", "0x1005")
+
+	sim.AddDevice(sensor)
+
+	stack, stop := startStack(t, sim, false)
+	defer stop()
+
+	var sensorDev *Device
+	select {
+	case sensorDev = <-stack.Channels().OnDeviceRegistered():
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for smoke sensor to register")
+	}
+
+	// like it was interviewed before we enrolled devices
+	assert.Ok(t, stack.updateEndpoint(sensorDev, 1, func(endpoint *Endpoint) {
+		endpoint.IasZone = nil
+	}))
+
+	assert.Ok(t, znpsim.SetSmoke(sensor, true))
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if dev, _ := stack.db.GetDevice(sensor.IEEEAddress); dev.Endpoints[0].IasZone != nil {
+			assert.Assert(t, dev.Endpoints[0].IasZone.Type == cluster.IasZoneTypeFireSensor)
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for enrollment")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func withSimulatedRadio(t *testing.T, sim *znpsim.Simulator, network coordinator.NetworkConfiguration, fn func(*Stack)) {
+	stack := New(coordinator.Configuration{
+		NetworkConfiguration: network,
+		Serial:               &coordinator.Serial{Port: "sim"},
+	}, newMemoryNodeDatabase(), nil)
+	stack.UseTransport(sim.Port())
+
+	simStopped := make(chan error, 1)
+	go func() {
+		simStopped <- sim.Run(context.Background())
+	}()
+
+	fn(stack)
+
+	assert.Ok(t, <-simStopped) // stack hung up
+}
+
+func startStack(t *testing.T, sim *znpsim.Simulator, settingsFlash bool) (*Stack, func()) {
+	stack := New(coordinator.Configuration{
+		NetworkConfiguration: testNetwork,
+		Serial:               &coordinator.Serial{Port: "sim"},
+	}, newMemoryNodeDatabase(), nil)
+	stack.UseTransport(sim.Port())
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	simStopped := make(chan error, 1)
+	go func() {
+		simStopped <- sim.Run(ctx)
+	}()
+
+	stackStopped := make(chan error, 1)
+	go func() {
+		stackStopped <- stack.Run(ctx, true, "", settingsFlash)
+	}()
+
+	return stack, func() {
+		cancel()
+
+		assert.Ok(t, <-simStopped)
+		<-stackStopped
+	}
+}
+
+func awaitReport(t *testing.T, stack *Stack, from zigbee.IEEEAddress) *cluster.ReportAttributesCommand {
+	t.Helper()
+
+	for {
+		select {
+		case msg := <-stack.Channels().OnDeviceIncomingMessage():
+			if report, ok := msg.IncomingMessage.Data.Command.(*cluster.ReportAttributesCommand); ok && msg.Device.IEEEAddress == from {
+				return report
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for report from %s", from)
+		}
+	}
+}
+
+type memoryNodeDatabase struct {
+	devices map[zigbee.IEEEAddress]*Device
+	mu      sync.Mutex
+}
+
+func newMemoryNodeDatabase() *memoryNodeDatabase {
+	return &memoryNodeDatabase{devices: map[zigbee.IEEEAddress]*Device{}}
+}
+
+func (m *memoryNodeDatabase) InsertDevice(device *Device) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.devices[device.IEEEAddress] = device
+	return nil
+}
+
+func (m *memoryNodeDatabase) GetDeviceByNetworkAddress(nwkAddress string) (*Device, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, device := range m.devices {
+		if device.NetworkAddress == nwkAddress {
+			return device, true
+		}
+	}
+
+	return nil, false
+}
+
+func (m *memoryNodeDatabase) GetDevice(address zigbee.IEEEAddress) (*Device, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	device, found := m.devices[address]
+	return device, found
+}
+
+func (m *memoryNodeDatabase) UpdateDevice(device *Device) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.devices[device.IEEEAddress]; !found {
+		return fmt.Errorf("not found: %s", device.IEEEAddress)
+	}
+
+	m.devices[device.IEEEAddress] = device
+	return nil
+}
+
+func (m *memoryNodeDatabase) RemoveDevice(address zigbee.IEEEAddress) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.devices, address)
+	return nil
+}
+
+// like ser2net: exposes the radio to one TCP client at a time
+type tcpGateway struct {
+	radio   io.ReadWriter
+	client  net.Conn
+	clients int
+	mu      sync.Mutex
+}
+
+func newTcpGateway(radio io.ReadWriter) *tcpGateway {
+	gateway := &tcpGateway{radio: radio}
+
+	// radio -> current client. frames sent while no client is connected are lost, like with the real thing
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := radio.Read(buf)
+			if err != nil {
+				return
+			}
+
+			gateway.mu.Lock()
+			if gateway.client != nil {
+				_, _ = gateway.client.Write(buf[:n])
+			}
+			gateway.mu.Unlock()
+		}
+	}()
+
+	return gateway
+}
+
+func (g *tcpGateway) serve(listener net.Listener) {
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		g.mu.Lock()
+		g.client = client
+		g.clients++
+		g.mu.Unlock()
+
+		go func() { // client -> radio
+			_, _ = io.Copy(g.radio, client)
+		}()
+	}
+}
+
+func (g *tcpGateway) dropClient() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.client.Close()
+	g.client = nil
+}
+
+func (g *tcpGateway) connections() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.clients
+}
@@ -1,11 +1,52 @@
 package ezstack
 
 import (
+	"fmt"
+	"strconv"
+
 	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
 	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+	"github.com/function61/hautomo/pkg/ezstack/znp"
 )
 
-/* TODO: has source NwkAddr confused with destination NwkAddr
+// where the bound source sends its messages to. either a device's endpoint or a group.
+type BindTarget struct {
+	Device   zigbee.IEEEAddress `json:"device,omitempty"`
+	Endpoint zigbee.EndpointId  `json:"endpoint,omitempty"`
+	Group    *zigbee.GroupId    `json:"group,omitempty"` // non-nil => group binding (Device & Endpoint unused)
+}
+
+func BindTargetDevice(address zigbee.IEEEAddress, endpoint zigbee.EndpointId) BindTarget {
+	return BindTarget{Device: address, Endpoint: endpoint}
+}
+
+func BindTargetGroup(group zigbee.GroupId) BindTarget {
+	return BindTarget{Group: &group}
+}
+
+func (b BindTarget) String() string {
+	if b.Group != nil {
+		return fmt.Sprintf("group %d", *b.Group)
+	} else {
+		return fmt.Sprintf("%s/%d", b.Device, b.Endpoint)
+	}
+}
+
+func (b BindTarget) addrModeAndAddress() (znp.AddrMode, string) {
+	if b.Group != nil {
+		return znp.AddrModeAddrGroup, b.Group.HexPrefixedString()
+	} else {
+		return znp.AddrModeAddr64Bit, b.Device.HexPrefixedString()
+	}
+}
+
+// entry in device's binding table
+type Binding struct {
+	SourceEndpoint zigbee.EndpointId `json:"source_endpoint"`
+	ClusterId      cluster.ClusterId `json:"cluster"`
+	Target         BindTarget        `json:"target"`
+}
+
 // "Zigbee has support for binding which makes it possible that devices can directly control each
 // other without the intervention of Zigbee2MQTT or any home automation software."
 // https://www.zigbee2mqtt.io/information/binding.html
@@ -13,37 +54,54 @@ func (s *Stack) Bind(
 	sourceAddress zigbee.IEEEAddress,
 	sourceEndpoint zigbee.EndpointId,
 	clusterId cluster.ClusterId,
-	destinationIeeeAddress zigbee.IEEEAddress,
-	destinationEndpoint zigbee.EndpointId,
-) (*znp.ZdoBindRsp, error) {
+	target BindTarget,
+) error {
 	dev, found := s.db.GetDevice(sourceAddress)
 	if !found {
-		return nil, fmt.Errorf("device not found: %s", sourceAddress)
+		return fmt.Errorf("device not found: %s", sourceAddress)
 	}
 
-	return s.coordinator.Bind(
+	dstAddrMode, dstAddress := target.addrModeAndAddress()
+
+	// Bind_req is processed by the source device (it's the source's binding table that changes),
+	// so it has to be sent there
+	resp, err := s.coordinator.Bind(
 		dev.NetworkAddress,
 		sourceAddress,
 		sourceEndpoint,
 		clusterId,
-		destinationIeeeAddress,
-		destinationEndpoint)
+		dstAddrMode,
+		dstAddress,
+		target.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	return resp.Status.Error()
 }
-*/
 
-func (s *Stack) BindToCoordinator(
+// undoes the effect of Bind()
+func (s *Stack) Unbind(
 	sourceAddress zigbee.IEEEAddress,
 	sourceEndpoint zigbee.EndpointId,
 	clusterId cluster.ClusterId,
-	coordinatorEndpoint zigbee.EndpointId,
+	target BindTarget,
 ) error {
-	resp, err := s.coordinator.Bind(
-		zigbee.CoordinatorNwkAddr,
+	dev, found := s.db.GetDevice(sourceAddress)
+	if !found {
+		return fmt.Errorf("device not found: %s", sourceAddress)
+	}
+
+	dstAddrMode, dstAddress := target.addrModeAndAddress()
+
+	resp, err := s.coordinator.Unbind(
+		dev.NetworkAddress,
 		sourceAddress,
 		sourceEndpoint,
 		clusterId,
-		s.coordinator.NetworkConf().IEEEAddress,
-		coordinatorEndpoint)
+		dstAddrMode,
+		dstAddress,
+		target.Endpoint)
 	if err != nil {
 		return err
 	}
@@ -51,26 +109,61 @@ func (s *Stack) BindToCoordinator(
 	return resp.Status.Error()
 }
 
-/* TODO: has source NwkAddr confused with destination NwkAddr
-// undoes the effect of Bind()
-func (s *Stack) Unbind(
-	sourceAddress zigbee.IEEEAddress,
-	sourceEndpoint zigbee.EndpointId,
-	clusterId cluster.ClusterId,
-	destinationIeeeAddress zigbee.IEEEAddress,
-	destinationEndpoint zigbee.EndpointId,
-) (*znp.ZdoUnbindRsp, error) {
-	dev, found := s.db.GetDevice(sourceAddress)
+// reads device's binding table (via Mgmt_Bind_req). not all devices support this.
+func (s *Stack) Bindings(address zigbee.IEEEAddress) ([]Binding, error) {
+	dev, found := s.db.GetDevice(address)
 	if !found {
-		return nil, fmt.Errorf("device not found: %s", sourceAddress)
+		return nil, fmt.Errorf("device not found: %s", address)
 	}
 
-	return s.coordinator.Unbind(
-		dev.NetworkAddress,
+	entries, err := s.coordinator.Bindings(dev.NetworkAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	bindings := []Binding{}
+	for _, entry := range entries {
+		target, err := bindTargetFromAddr(entry.DstAddr)
+		if err != nil {
+			return nil, err
+		}
+
+		bindings = append(bindings, Binding{
+			SourceEndpoint: entry.SrcEndpoint,
+			ClusterId:      cluster.ClusterId(entry.ClusterID),
+			Target:         target,
+		})
+	}
+
+	return bindings, nil
+}
+
+// makes source device send reports for *clusterId* to the coordinator
+func (s *Stack) BindToCoordinator(
+	sourceAddress zigbee.IEEEAddress,
+	sourceEndpoint zigbee.EndpointId,
+	clusterId cluster.ClusterId,
+	coordinatorEndpoint zigbee.EndpointId,
+) error {
+	return s.Bind(
 		sourceAddress,
 		sourceEndpoint,
 		clusterId,
-		destinationIeeeAddress,
-		destinationEndpoint)
+		BindTargetDevice(s.coordinator.NetworkConf().IEEEAddress, coordinatorEndpoint))
+}
+
+func bindTargetFromAddr(addr *znp.Addr) (BindTarget, error) {
+	switch addr.AddrMode {
+	case znp.AddrModeAddr64Bit:
+		return BindTargetDevice(zigbee.IEEEAddress(addr.ExtendedAddr), addr.DstEndpoint), nil
+	case znp.AddrModeAddrGroup:
+		group, err := strconv.ParseUint(addr.ShortAddr[2:], 16, 16) // "0x0001" => 1
+		if err != nil {
+			return BindTarget{}, fmt.Errorf("bindTargetFromAddr: %w", err)
+		}
+
+		return BindTargetGroup(zigbee.GroupId(group)), nil
+	default:
+		return BindTarget{}, fmt.Errorf("bindTargetFromAddr: unsupported AddrMode: %d", addr.AddrMode)
+	}
 }
-*/
@@ -4,10 +4,14 @@ package ezstack
 // *WriteAttributes*.
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/davecgh/go-spew/spew"
 	"github.com/function61/hautomo/pkg/ezstack/binstruct"
 	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
 	"github.com/function61/hautomo/pkg/ezstack/zcl/frame"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
 	"github.com/function61/hautomo/pkg/ezstack/znp"
 )
 
@@ -17,7 +21,16 @@ func (s *Stack) ReadAttributes(
 	clusterId cluster.ClusterId,
 	attributeIds []cluster.AttributeId,
 ) (*cluster.ReadAttributesResponse, error) {
-	response, err := s.globalCommand(nwkAddress, clusterId, 0x00, &cluster.ReadAttributesCommand{castAttributeIds(attributeIds)})
+	return s.ReadEndpointAttributes(DeviceAndEndpoint{nwkAddress, allEndpoints}, clusterId, attributeIds)
+}
+
+// like ReadAttributes(), but for when the device has the cluster in multiple endpoints
+func (s *Stack) ReadEndpointAttributes(
+	endpoint DeviceAndEndpoint,
+	clusterId cluster.ClusterId,
+	attributeIds []cluster.AttributeId,
+) (*cluster.ReadAttributesResponse, error) {
+	response, err := s.endpointGlobalCommand(endpoint, clusterId, 0x00, &cluster.ReadAttributesCommand{castAttributeIds(attributeIds)})
 	if err != nil {
 		return nil, err
 	}
@@ -35,6 +48,20 @@ func (s *Stack) WriteAttributes(nwkAddress string, clusterId cluster.ClusterId,
 	return response.(*cluster.WriteAttributesResponse), nil
 }
 
+// writes to a specific endpoint. unlike WriteAttributes(), attributes the device refused are an error
+func (s *Stack) WriteEndpointAttributes(
+	endpoint DeviceAndEndpoint,
+	clusterId cluster.ClusterId,
+	writeAttributeRecords ...*cluster.WriteAttributeRecord,
+) error {
+	response, err := s.endpointGlobalCommand(endpoint, clusterId, 0x02, &cluster.WriteAttributesCommand{writeAttributeRecords})
+	if err != nil {
+		return err
+	}
+
+	return writeAttributesResponseError(response.(*cluster.WriteAttributesResponse))
+}
+
 // ZCL spec section 2.5.7
 func (s *Stack) ConfigureReporting(nwkAddress string, clusterId cluster.ClusterId, configs ...*cluster.AttributeReportingConfigurationRecord) error {
 	response, err := s.globalCommand(nwkAddress, clusterId, 0x06, &cluster.ConfigureReportingCommand{configs})
@@ -42,13 +69,53 @@ func (s *Stack) ConfigureReporting(nwkAddress string, clusterId cluster.ClusterI
 		return err
 	}
 
-	// TODO: validate status
-	_ = response.(*cluster.ConfigureReportingResponse)
+	return configureReportingResponseError(response.(*cluster.ConfigureReportingResponse))
+}
+
+// successful response has a single record with success status. otherwise records are the failed attributes
+func configureReportingResponseError(response *cluster.ConfigureReportingResponse) error {
+	failures := []string{}
+	for _, record := range response.AttributeStatusRecords {
+		if record.Status == cluster.ZclStatusSuccess {
+			continue
+		}
+
+		failures = append(failures, fmt.Sprintf("attribute %d: status %d", record.AttributeID, record.Status))
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("ConfigureReporting failed: %s", strings.Join(failures, ", "))
+	}
+
+	return nil
+}
+
+// successful response has a single record with success status. otherwise records are the failed attributes
+func writeAttributesResponseError(response *cluster.WriteAttributesResponse) error {
+	failures := []string{}
+	for _, status := range response.WriteAttributeStatuses {
+		if status.Status == cluster.ZclStatusSuccess {
+			continue
+		}
+
+		failures = append(failures, fmt.Sprintf("attribute %d: status %d", status.AttributeID, status.Status))
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("WriteAttributes failed: %s", strings.Join(failures, ", "))
+	}
 
 	return nil
 }
 
+// the device delivers it to the endpoint(s) that implement the cluster
+const allEndpoints = zigbee.EndpointId(0xff)
+
 func (s *Stack) globalCommand(nwkAddress string, clusterId cluster.ClusterId, commandId uint8, command interface{}) (interface{}, error) {
+	return s.endpointGlobalCommand(DeviceAndEndpoint{nwkAddress, allEndpoints}, clusterId, commandId, command)
+}
+
+func (s *Stack) endpointGlobalCommand(endpoint DeviceAndEndpoint, clusterId cluster.ClusterId, commandId uint8, command interface{}) (interface{}, error) {
 	options := &znp.AfDataRequestOptions{}
 	frm, err := frame.New().
 		DisableDefaultResponse(true).
@@ -62,7 +129,7 @@ func (s *Stack) globalCommand(nwkAddress string, clusterId cluster.ClusterId, co
 		return nil, err
 	}
 
-	response, err := s.coordinator.DataRequest(nwkAddress, 255, 1, uint16(clusterId), options, 15, binstruct.Encode(frm))
+	response, err := s.coordinator.DataRequest(endpoint.NetworkAddress, endpoint.EndpointId, 1, uint16(clusterId), options, 15, binstruct.Encode(frm))
 	if err == nil {
 		zclIncomingMessage, err := s.zcl.ToZclIncomingMessage(response)
 		if err == nil {
@@ -0,0 +1,81 @@
+package ezstack
+
+// Zigbee groups (genGroups cluster). group membership is stored in the devices themselves: we
+// ask a device's endpoint to join a group, after which it reacts to commands sent to that group.
+
+import (
+	"fmt"
+
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+)
+
+// ZCL spec section 3.6.2.3.2
+func (s *Stack) AddToGroup(dev DeviceAndEndpoint, group zigbee.GroupId) error {
+	response, err := s.localCommand(dev, &cluster.GenGroupsAddGroupCommand{
+		GroupId: uint16(group),
+	})
+	if err != nil {
+		return err
+	}
+
+	switch resp := response.(type) {
+	case *cluster.GenGroupsAddGroupResponse:
+		if resp.Status == cluster.ZclStatusDuplicateExists { // already a member => we're happy
+			return nil
+		}
+
+		return resp.Status.Error()
+	case *cluster.DefaultResponseCommand: // some devices respond to errors with this
+		return resp.Status.Error()
+	default:
+		return fmt.Errorf("AddToGroup: unexpected response: %T", response)
+	}
+}
+
+// ZCL spec section 3.6.2.3.5
+func (s *Stack) RemoveFromGroup(dev DeviceAndEndpoint, group zigbee.GroupId) error {
+	response, err := s.localCommand(dev, &cluster.GenGroupsRemoveGroupCommand{
+		GroupId: uint16(group),
+	})
+	if err != nil {
+		return err
+	}
+
+	switch resp := response.(type) {
+	case *cluster.GenGroupsRemoveGroupResponse:
+		if resp.Status == cluster.ZclStatusNotFound { // wasn't a member => we're happy
+			return nil
+		}
+
+		return resp.Status.Error()
+	case *cluster.DefaultResponseCommand:
+		return resp.Status.Error()
+	default:
+		return fmt.Errorf("RemoveFromGroup: unexpected response: %T", response)
+	}
+}
+
+// ZCL spec section 3.6.2.3.4
+func (s *Stack) GroupMembership(dev DeviceAndEndpoint) ([]zigbee.GroupId, error) {
+	response, err := s.localCommand(dev, &cluster.GenGroupsGetGroupMembershipCommand{
+		GroupList: []uint16{}, // = all groups
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp := response.(type) {
+	case *cluster.GenGroupsGetGroupMembershipResponse:
+		groups := []zigbee.GroupId{}
+		for _, group := range resp.GroupList {
+			groups = append(groups, zigbee.GroupId(group))
+		}
+
+		return groups, nil
+	case *cluster.DefaultResponseCommand:
+		return nil, fmt.Errorf("GroupMembership: %w", resp.Status.Error())
+	default:
+		return nil, fmt.Errorf("GroupMembership: unexpected response: %T", response)
+	}
+}
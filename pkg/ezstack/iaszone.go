@@ -0,0 +1,117 @@
+package ezstack
+
+// IAS (Intruder Alarm Systems) zone devices (smoke, water, contact etc. sensors) only send their alarms
+// to the CIE (= us) they're enrolled to. enrolling = we write our address to the device and answer with
+// a zone ID. devices either ask for it with an enroll request or just wait for the response.
+
+import (
+	"fmt"
+
+	"github.com/function61/hautomo/pkg/ezstack/zcl"
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+)
+
+// zone ID only identifies the device in its notifications. we know the sender anyway, so all devices
+// can share the same ID (zigbee2mqtt does the same)
+const iasZoneId = 23
+
+type IasZone struct {
+	Type cluster.IasZoneType // tells what the device's alarm means
+}
+
+// nil if endpoint doesn't have ssIasZone
+func (s *Stack) enrollIasZone(nwkAddress string, endpoint *Endpoint) (*IasZone, error) {
+	if !clusterIdIn(cluster.IdSsIasZone, endpoint.InClusterList) {
+		return nil, nil
+	}
+
+	dev := DeviceAndEndpoint{nwkAddress, endpoint.Id}
+
+	if err := s.WriteEndpointAttributes(dev, cluster.IdSsIasZone, &cluster.WriteAttributeRecord{
+		AttributeID: uint16(cluster.AttrSsIasZoneIasCieAddr),
+		Attribute: &cluster.Attribute{
+			DataType: cluster.ZclDataTypeIeeeAddr,
+			Value:    s.configuration.NetworkConfiguration.IEEEAddress.HexPrefixedString(),
+		},
+	}); err != nil {
+		return nil, fmt.Errorf("write CIE address: %w", err)
+	}
+
+	resp, err := s.ReadEndpointAttributes(dev, cluster.IdSsIasZone, []cluster.AttributeId{cluster.AttrSsIasZoneZoneType})
+	if err != nil {
+		return nil, fmt.Errorf("read zone type: %w", err)
+	}
+
+	if len(resp.ReadAttributeStatuses) != 1 || resp.ReadAttributeStatuses[0].Status != cluster.ZclStatusSuccess {
+		return nil, fmt.Errorf("read zone type: unexpected response %v", resp.ReadAttributeStatuses)
+	}
+
+	zoneType, ok := resp.ReadAttributeStatuses[0].Attribute.Value.(uint64)
+	if !ok {
+		return nil, fmt.Errorf("read zone type: unexpected value %v", resp.ReadAttributeStatuses[0].Attribute.Value)
+	}
+
+	// devices using the "auto-enroll-response" method don't ask for this
+	if err := s.LocalCommand(dev, iasZoneEnrollResponse()); err != nil {
+		return nil, fmt.Errorf("enroll response: %w", err)
+	}
+
+	return &IasZone{Type: cluster.IasZoneType(zoneType)}, nil
+}
+
+// enroll requests are answered here. they're not forwarded to the application
+func (s *Stack) iasZoneProcessIncomingMessage(device *Device, msg *zcl.ZclIncomingMessage) bool {
+	switch msg.Data.Command.(type) {
+	case *cluster.ZoneEnrollRequestCommand:
+		go func() { // don't block the main loop, responding requires a roundtrip to the device
+			if err := s.LocalCommand(DeviceAndEndpoint{device.NetworkAddress, msg.SrcEndpoint}, iasZoneEnrollResponse()); err != nil {
+				logl.Error.Printf("IAS zone enroll %s: %s", device.IEEEAddress, err.Error())
+			}
+		}()
+
+		return true
+	case *cluster.ZoneStatusChangeNotificationCommand:
+		s.enrollIasZoneIfNotEnrolled(device, msg.SrcEndpoint)
+	}
+
+	return false
+}
+
+// devices interviewed before we enrolled them (or whose enrollment failed at interview) don't have
+// a zone type, so their notifications can't be parsed. the first notification enrolls them in the
+// background, like measurement scaling is read. notifications meanwhile are dropped by the parsers.
+func (s *Stack) enrollIasZoneIfNotEnrolled(device *Device, endpointId zigbee.EndpointId) {
+	endpoint := findEndpoint(device, endpointId)
+	if endpoint == nil || endpoint.IasZone != nil {
+		return
+	}
+
+	if _, alreadyEnrolling := s.lateEnrollments.LoadOrStore(device.IEEEAddress, true); alreadyEnrolling {
+		return
+	}
+
+	go func() {
+		defer s.lateEnrollments.Delete(device.IEEEAddress)
+
+		if err := func() error {
+			iasZone, err := s.enrollIasZone(device.NetworkAddress, endpoint)
+			if err != nil {
+				return err
+			}
+
+			return s.updateEndpoint(device, endpoint.Id, func(updated *Endpoint) {
+				updated.IasZone = iasZone
+			})
+		}(); err != nil {
+			logl.Error.Printf("IAS zone enroll %s endpoint %d: %v", device.IEEEAddress, endpoint.Id, err)
+		}
+	}()
+}
+
+func iasZoneEnrollResponse() *cluster.ZoneEnrollResponseCommand {
+	return &cluster.ZoneEnrollResponseCommand{
+		EnrollResponseCode: cluster.IasZoneEnrollResponseCodeSuccess,
+		ZoneID:             iasZoneId,
+	}
+}
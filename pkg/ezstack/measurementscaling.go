@@ -0,0 +1,193 @@
+package ezstack
+
+// smart plugs etc. report raw values that are converted to units with device-specific multiplier
+// and divisor attributes. they don't change, so we read them once at interview (or, for devices
+// interviewed before we knew to, the first time they report a measurement).
+
+import (
+	"fmt"
+
+	"github.com/function61/hautomo/pkg/ezstack/zcl"
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+)
+
+// value in units = raw * Multiplier / Divisor. zero (device didn't tell) is taken as 1
+type Scaling struct {
+	Multiplier uint32
+	Divisor    uint32
+}
+
+// nil-safe: without scaling the raw value is returned as-is
+func (s *Scaling) Apply(raw float64) float64 {
+	if s == nil {
+		return raw
+	}
+
+	if s.Multiplier != 0 {
+		raw *= float64(s.Multiplier)
+	}
+
+	if s.Divisor != 0 {
+		raw /= float64(s.Divisor)
+	}
+
+	return raw
+}
+
+// nil fields = endpoint doesn't have the cluster
+type MeasurementScaling struct {
+	AcVoltage *Scaling `json:",omitempty"` // haElectricalMeasurement.rmsVoltage => [V]
+	AcCurrent *Scaling `json:",omitempty"` // haElectricalMeasurement.rmsCurrent => [A]
+	AcPower   *Scaling `json:",omitempty"` // haElectricalMeasurement.activePower => [W]
+	Metering  *Scaling `json:",omitempty"` // seMetering.currentSummDelivered => [kWh], seMetering.instantaneousDemand => [kW]
+}
+
+// nil if endpoint has neither haElectricalMeasurement nor seMetering
+func (s *Stack) queryMeasurementScaling(nwkAddress string, endpoint *Endpoint) (*MeasurementScaling, error) {
+	hasElectrical := clusterIdIn(cluster.IdHaElectricalMeasurement, endpoint.InClusterList)
+	hasMetering := clusterIdIn(cluster.IdSeMetering, endpoint.InClusterList)
+
+	if !hasElectrical && !hasMetering {
+		return nil, nil
+	}
+
+	// attribute => value. attributes the device doesn't have are left out
+	readUints := func(clusterId cluster.ClusterId, attributeIds ...cluster.AttributeId) (map[cluster.AttributeId]uint64, error) {
+		resp, err := s.ReadEndpointAttributes(DeviceAndEndpoint{nwkAddress, endpoint.Id}, clusterId, attributeIds)
+		if err != nil {
+			return nil, err
+		}
+
+		values := map[cluster.AttributeId]uint64{}
+		for _, status := range resp.ReadAttributeStatuses {
+			if status.Status != cluster.ZclStatusSuccess {
+				continue
+			}
+
+			if value, ok := status.Attribute.Value.(uint64); ok {
+				values[cluster.AttributeId(status.AttributeID)] = value
+			}
+		}
+
+		return values, nil
+	}
+
+	scaling := &MeasurementScaling{}
+
+	if hasElectrical {
+		values, err := readUints(cluster.IdHaElectricalMeasurement,
+			cluster.AttrHaElectricalMeasurementAcVoltageMultiplier,
+			cluster.AttrHaElectricalMeasurementAcVoltageDivisor,
+			cluster.AttrHaElectricalMeasurementAcCurrentMultiplier,
+			cluster.AttrHaElectricalMeasurementAcCurrentDivisor,
+			cluster.AttrHaElectricalMeasurementAcPowerMultiplier,
+			cluster.AttrHaElectricalMeasurementAcPowerDivisor)
+		if err != nil {
+			return nil, fmt.Errorf("haElectricalMeasurement: %w", err)
+		}
+
+		scaling.AcVoltage = &Scaling{
+			Multiplier: uint32(values[cluster.AttrHaElectricalMeasurementAcVoltageMultiplier]),
+			Divisor:    uint32(values[cluster.AttrHaElectricalMeasurementAcVoltageDivisor]),
+		}
+		scaling.AcCurrent = &Scaling{
+			Multiplier: uint32(values[cluster.AttrHaElectricalMeasurementAcCurrentMultiplier]),
+			Divisor:    uint32(values[cluster.AttrHaElectricalMeasurementAcCurrentDivisor]),
+		}
+		scaling.AcPower = &Scaling{
+			Multiplier: uint32(values[cluster.AttrHaElectricalMeasurementAcPowerMultiplier]),
+			Divisor:    uint32(values[cluster.AttrHaElectricalMeasurementAcPowerDivisor]),
+		}
+	}
+
+	if hasMetering {
+		values, err := readUints(cluster.IdSeMetering,
+			cluster.AttrSeMeteringMultiplier,
+			cluster.AttrSeMeteringDivisor)
+		if err != nil {
+			return nil, fmt.Errorf("seMetering: %w", err)
+		}
+
+		scaling.Metering = &Scaling{
+			Multiplier: uint32(values[cluster.AttrSeMeteringMultiplier]),
+			Divisor:    uint32(values[cluster.AttrSeMeteringDivisor]),
+		}
+	}
+
+	return scaling, nil
+}
+
+// devices interviewed before we read scaling at interview don't have it. reading requires a
+// roundtrip to the device, so it's done in the background. measurements reported meanwhile can't
+// be converted to units, so attribute parsers drop them.
+func (s *Stack) measurementScalingProcessIncomingMessage(device *Device, msg *zcl.ZclIncomingMessage) {
+	if msg.ClusterID != cluster.IdHaElectricalMeasurement && msg.ClusterID != cluster.IdSeMetering {
+		return
+	}
+
+	endpoint := findEndpoint(device, msg.SrcEndpoint)
+	if endpoint == nil || endpoint.MeasurementScaling != nil {
+		return
+	}
+
+	if _, alreadyReading := s.scalingReads.LoadOrStore(device.IEEEAddress, true); alreadyReading {
+		return
+	}
+
+	go func() {
+		defer s.scalingReads.Delete(device.IEEEAddress)
+
+		if err := s.updateMeasurementScaling(device, endpoint); err != nil {
+			logl.Error.Printf("measurement scaling %s endpoint %d: %v", device.IEEEAddress, endpoint.Id, err)
+		}
+	}()
+}
+
+func (s *Stack) updateMeasurementScaling(device *Device, endpoint *Endpoint) error {
+	scaling, err := s.queryMeasurementScaling(device.NetworkAddress, endpoint)
+	if err != nil {
+		return err
+	}
+
+	return s.updateEndpoint(device, endpoint.Id, func(updated *Endpoint) {
+		updated.MeasurementScaling = scaling
+	})
+}
+
+// don't modify the device others might be reading. replaces it with a copy instead
+func (s *Stack) updateEndpoint(device *Device, endpointId zigbee.EndpointId, update func(*Endpoint)) error {
+	updated := *device
+	updated.Endpoints = []*Endpoint{}
+	for _, candidate := range device.Endpoints {
+		if candidate.Id == endpointId {
+			copied := *candidate
+			update(&copied)
+			candidate = &copied
+		}
+
+		updated.Endpoints = append(updated.Endpoints, candidate)
+	}
+
+	return s.db.UpdateDevice(&updated)
+}
+
+func findEndpoint(device *Device, endpointId zigbee.EndpointId) *Endpoint {
+	for _, endpoint := range device.Endpoints {
+		if endpoint.Id == endpointId {
+			return endpoint
+		}
+	}
+
+	return nil
+}
+
+func clusterIdIn(clusterId cluster.ClusterId, clusterIds []cluster.ClusterId) bool {
+	for _, candidate := range clusterIds {
+		if candidate == clusterId {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,129 @@
+// Zigbee OTA upgrade image files (ZCL spec section 11.4)
+package ota
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	fileIdentifier   = 0x0beef11e
+	minHeaderLength  = 56
+	headerStringSize = 32
+)
+
+// header field control bits
+const (
+	fieldControlSecurityCredentialVersion = 1 << 0
+	fieldControlDeviceSpecific            = 1 << 1
+	fieldControlHardwareVersions          = 1 << 2
+)
+
+type Header struct {
+	HeaderVersion    uint16
+	HeaderLength     uint16
+	FieldControl     uint16
+	ManufacturerCode uint16
+	ImageType        uint16
+	FileVersion      uint32
+	StackVersion     uint16
+	HeaderString     string
+	TotalImageSize   uint32 // includes header
+
+	// optional fields
+	MinimumHardwareVersion *uint16
+	MaximumHardwareVersion *uint16
+}
+
+// image applies to a device with given hardware version. hardware version is optional in both ends.
+func (h Header) SupportsHardwareVersion(hardwareVersion *uint16) bool {
+	if hardwareVersion == nil || h.MinimumHardwareVersion == nil {
+		return true
+	}
+
+	return *hardwareVersion >= *h.MinimumHardwareVersion && *hardwareVersion <= *h.MaximumHardwareVersion
+}
+
+type Image struct {
+	Header Header
+	Data   []byte // the whole image (header + sub-elements). this is what is transferred to the device
+}
+
+// some vendors (e.g. IKEA) wrap the image in their own container, so we look for the OTA file
+// identifier instead of assuming it's at offset 0
+func ParseImage(file []byte) (*Image, error) {
+	identifier := make([]byte, 4)
+	binary.LittleEndian.PutUint32(identifier, fileIdentifier)
+
+	start := bytes.Index(file, identifier)
+	if start == -1 {
+		return nil, errors.New("ParseImage: OTA file identifier not found")
+	}
+
+	data := file[start:]
+
+	header, err := parseHeader(data)
+	if err != nil {
+		return nil, fmt.Errorf("ParseImage: %w", err)
+	}
+
+	if int(header.TotalImageSize) > len(data) {
+		return nil, fmt.Errorf(
+			"ParseImage: header says image size %d, but only have %d bytes",
+			header.TotalImageSize,
+			len(data))
+	}
+
+	return &Image{
+		Header: *header,
+		Data:   data[:header.TotalImageSize],
+	}, nil
+}
+
+func parseHeader(data []byte) (*Header, error) {
+	if len(data) < minHeaderLength {
+		return nil, fmt.Errorf("too short for header: %d", len(data))
+	}
+
+	le := binary.LittleEndian
+
+	header := &Header{
+		HeaderVersion:    le.Uint16(data[4:]),
+		HeaderLength:     le.Uint16(data[6:]),
+		FieldControl:     le.Uint16(data[8:]),
+		ManufacturerCode: le.Uint16(data[10:]),
+		ImageType:        le.Uint16(data[12:]),
+		FileVersion:      le.Uint32(data[14:]),
+		StackVersion:     le.Uint16(data[18:]),
+		HeaderString:     strings.TrimRight(string(data[20:20+headerStringSize]), "\x00"),
+		TotalImageSize:   le.Uint32(data[52:]),
+	}
+
+	if int(header.HeaderLength) > len(data) || header.HeaderLength < minHeaderLength {
+		return nil, fmt.Errorf("invalid header length: %d", header.HeaderLength)
+	}
+
+	// optional fields are in fixed order, and present only if their field control bit is set
+	offset := minHeaderLength
+	if header.FieldControl&fieldControlSecurityCredentialVersion != 0 {
+		offset += 1
+	}
+	if header.FieldControl&fieldControlDeviceSpecific != 0 {
+		offset += 8 // upgrade file destination (IEEE address)
+	}
+	if header.FieldControl&fieldControlHardwareVersions != 0 {
+		if offset+4 > int(header.HeaderLength) {
+			return nil, errors.New("hardware versions don't fit in header")
+		}
+
+		min := le.Uint16(data[offset:])
+		max := le.Uint16(data[offset+2:])
+		header.MinimumHardwareVersion = &min
+		header.MaximumHardwareVersion = &max
+	}
+
+	return header, nil
+}
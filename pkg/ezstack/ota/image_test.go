@@ -0,0 +1,61 @@
+package ota
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/function61/gokit/testing/assert"
+)
+
+func TestParseImage(t *testing.T) {
+	image, err := ParseImage(append([]byte("vendor prefix"), testImage(0x117c, 0x2101, 0x23086631, []byte{0xaa, 0xbb})...))
+	assert.Ok(t, err)
+
+	assert.Assert(t, image.Header.ManufacturerCode == 0x117c)
+	assert.Assert(t, image.Header.ImageType == 0x2101)
+	assert.Assert(t, image.Header.FileVersion == 0x23086631)
+	assert.EqualString(t, image.Header.HeaderString, "test image")
+	assert.EqualInt(t, len(image.Data), 58)
+	assert.Assert(t, image.Data[0] == 0x1e) // prefix got stripped
+
+	_, err = ParseImage([]byte("not an OTA image"))
+	assert.EqualString(t, err.Error(), "ParseImage: OTA file identifier not found")
+}
+
+func TestFindUpgrade(t *testing.T) {
+	store := NewStore(
+		mustParse(t, testImage(0x117c, 0x2101, 10, nil)),
+		mustParse(t, testImage(0x117c, 0x2101, 20, nil)),
+		mustParse(t, testImage(0x117c, 0x9999, 30, nil)))
+
+	assert.EqualInt(t, int(store.FindUpgrade(0x117c, 0x2101, 5, nil).Header.FileVersion), 20)
+	assert.Assert(t, store.FindUpgrade(0x117c, 0x2101, 20, nil) == nil)
+	assert.Assert(t, store.FindUpgrade(0x1234, 0x2101, 5, nil) == nil)
+}
+
+func mustParse(t *testing.T, file []byte) *Image {
+	t.Helper()
+
+	image, err := ParseImage(file)
+	assert.Ok(t, err)
+
+	return image
+}
+
+func testImage(manufacturerCode uint16, imageType uint16, fileVersion uint32, payload []byte) []byte {
+	header := make([]byte, minHeaderLength)
+
+	le := binary.LittleEndian
+	le.PutUint32(header[0:], fileIdentifier)
+	le.PutUint16(header[4:], 0x0100) // header version
+	le.PutUint16(header[6:], minHeaderLength)
+	le.PutUint16(header[8:], 0) // field control
+	le.PutUint16(header[10:], manufacturerCode)
+	le.PutUint16(header[12:], imageType)
+	le.PutUint32(header[14:], fileVersion)
+	le.PutUint16(header[18:], 2) // Zigbee PRO
+	copy(header[20:], "test image")
+	le.PutUint32(header[52:], uint32(minHeaderLength+len(payload)))
+
+	return append(header, payload...)
+}
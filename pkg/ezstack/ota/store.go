@@ -0,0 +1,72 @@
+package ota
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// collection of upgrade images we can serve to devices
+type Store struct {
+	images []*Image
+}
+
+// loads all OTA images from a directory. files that don't look like OTA images are skipped
+func LoadDirectory(dir string) (*Store, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("LoadDirectory: %w", err)
+	}
+
+	store := &Store{}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		file, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("LoadDirectory: %w", err)
+		}
+
+		image, err := ParseImage(file)
+		if err != nil {
+			continue // not an OTA image
+		}
+
+		store.images = append(store.images, image)
+	}
+
+	return store, nil
+}
+
+func NewStore(images ...*Image) *Store {
+	return &Store{images}
+}
+
+func (s *Store) Images() []*Image {
+	return s.images
+}
+
+// finds newest image that is newer than *currentVersion*. nil if there is no upgrade
+func (s *Store) FindUpgrade(manufacturerCode uint16, imageType uint16, currentVersion uint32, hardwareVersion *uint16) *Image {
+	var newest *Image
+	for _, image := range s.images {
+		header := image.Header // shorthand
+
+		if header.ManufacturerCode != manufacturerCode || header.ImageType != imageType {
+			continue
+		}
+
+		if header.FileVersion <= currentVersion || !header.SupportsHardwareVersion(hardwareVersion) {
+			continue
+		}
+
+		if newest == nil || header.FileVersion > newest.Header.FileVersion {
+			newest = image
+		}
+	}
+
+	return newest
+}
@@ -0,0 +1,442 @@
+package ezstack
+
+// OTA firmware updates. in the OTA cluster roles are reversed: the device is the client that asks
+// us (the server) whether there's a new image, and then pulls the image from us block by block.
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/function61/hautomo/pkg/ezstack/binstruct"
+	"github.com/function61/hautomo/pkg/ezstack/ota"
+	"github.com/function61/hautomo/pkg/ezstack/zcl"
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+	"github.com/function61/hautomo/pkg/ezstack/zcl/frame"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+	"github.com/function61/hautomo/pkg/ezstack/znp"
+)
+
+const (
+	otaMaxDataSize = 50 // [bytes] conservative so that the block fits in one frame even with APS encryption + source routing
+
+	// sleepy devices get the next block only after this. they usually ask for blocks as fast as
+	// they can, which can starve the network (and the device's battery)
+	otaSleepyMinimumBlockPeriod = 250 * time.Millisecond
+
+	// don't spam sleepy devices with ImageNotify each time we hear from them
+	otaNotifyInterval = 1 * time.Hour
+)
+
+type OtaState string
+
+const (
+	OtaStateAvailable OtaState = "available" // newer image exists, but device hasn't started the download
+	OtaStateUpdating  OtaState = "updating"
+	OtaStateDone      OtaState = "done"
+	OtaStateFailed    OtaState = "failed"
+)
+
+type OtaProgress struct {
+	Device             zigbee.IEEEAddress `json:"device"`
+	State              OtaState           `json:"state"`
+	ImageType          uint16             `json:"image_type"`
+	CurrentFileVersion uint32             `json:"current_file_version"`
+	NewFileVersion     uint32             `json:"new_file_version"`
+	Offset             uint32             `json:"offset"` // [bytes] how far the device has downloaded
+	Size               uint32             `json:"size"`   // [bytes]
+	Started            *time.Time         `json:"started,omitempty"`
+	Updated            time.Time          `json:"updated"`
+	Error              string             `json:"error,omitempty"`
+}
+
+// 0-100
+func (o OtaProgress) Percent() int {
+	switch {
+	case o.State == OtaStateDone:
+		return 100
+	case o.Size == 0:
+		return 0
+	default:
+		return int(uint64(o.Offset) * 100 / uint64(o.Size))
+	}
+}
+
+// estimated time remaining based on download rate so far. zero if unknown
+func (o OtaProgress) Remaining() time.Duration {
+	if o.State != OtaStateUpdating || o.Started == nil || o.Offset == 0 {
+		return 0
+	}
+
+	elapsed := o.Updated.Sub(*o.Started)
+
+	return time.Duration(float64(elapsed) * float64(o.Size-o.Offset) / float64(o.Offset))
+}
+
+type otaServer struct {
+	images       *ota.Store
+	progress     map[zigbee.IEEEAddress]*OtaProgress
+	lastBlock    map[zigbee.IEEEAddress]time.Time
+	lastNotified map[zigbee.IEEEAddress]time.Time
+	changes      chan OtaProgress
+	mu           sync.Mutex
+}
+
+// starts serving OTA images to devices. call before Run()
+func (s *Stack) EnableOta(images *ota.Store) {
+	s.ota = &otaServer{
+		images:       images,
+		progress:     map[zigbee.IEEEAddress]*OtaProgress{},
+		lastBlock:    map[zigbee.IEEEAddress]time.Time{},
+		lastNotified: map[zigbee.IEEEAddress]time.Time{},
+		changes:      s.channels.onOtaProgress,
+	}
+}
+
+// snapshot of OTA progress of all devices we know to have an update available (or have updated).
+// empty if OTA is not enabled
+func (s *Stack) OtaProgress() []OtaProgress {
+	if s.ota == nil {
+		return []OtaProgress{}
+	}
+
+	s.ota.mu.Lock()
+	defer s.ota.mu.Unlock()
+
+	progresses := []OtaProgress{}
+	for _, progress := range s.ota.progress {
+		progresses = append(progresses, *progress)
+	}
+
+	return progresses
+}
+
+// tells device that there's a new image available, prompting it to query for it. devices
+// usually query on their own only once a day or so
+func (s *Stack) NotifyOtaImage(device *Device) error {
+	if s.ota == nil {
+		return fmt.Errorf("NotifyOtaImage: OTA not enabled")
+	}
+
+	endpoint := device.OtaClientEndpoint()
+	if endpoint == nil {
+		return fmt.Errorf("NotifyOtaImage: %s doesn't implement OTA client", device.IEEEAddress)
+	}
+
+	notify := &cluster.GenOtaImageNotifyCommand{
+		PayloadType:      cluster.OtaImageNotifyJitterManufacturer,
+		QueryJitter:      cluster.OtaQueryJitterMax,
+		ManufacturerCode: device.ManufacturerId,
+	}
+
+	// if we know which image the device would get, be more specific
+	s.ota.mu.Lock()
+	if progress, found := s.ota.progress[device.IEEEAddress]; found && progress.NewFileVersion != 0 {
+		notify.PayloadType = cluster.OtaImageNotifyJitterManufacturerImageTypeVersion
+		notify.ImageType = progress.ImageType
+		notify.NewFileVersion = progress.NewFileVersion
+	}
+	s.ota.lastNotified[device.IEEEAddress] = time.Now()
+	s.ota.mu.Unlock()
+
+	frm, err := frame.New().
+		DisableDefaultResponse(true).
+		FrameType(frame.FrameTypeLocal).
+		Direction(frame.DirectionServerClient).
+		CommandId(0x00).
+		Command(notify).
+		Build()
+	if err != nil {
+		return err
+	}
+
+	return s.coordinator.DataRequestNoResponse(
+		device.NetworkAddress,
+		endpoint.Id,
+		coordinatorEndpoint,
+		uint16(cluster.IdGenOta),
+		&znp.AfDataRequestOptions{},
+		15,
+		binstruct.Encode(frm))
+}
+
+// called for each message from a device. returns true if message was OTA-related and thus
+// handled here
+func (s *Stack) otaProcessIncomingMessage(device *Device, msg *zcl.ZclIncomingMessage) bool {
+	if s.ota == nil {
+		return false
+	}
+
+	if msg.ClusterID != cluster.IdGenOta {
+		// sleepy devices only listen right after they've sent something, so this is our chance
+		if !device.MainPowered && s.ota.shouldNotify(device) {
+			go func() {
+				if err := s.NotifyOtaImage(device); err != nil {
+					logl.Error.Printf("NotifyOtaImage: %s", err.Error())
+				}
+			}()
+		}
+
+		return false
+	}
+
+	go func() { // don't block the main loop, responding requires a roundtrip to the coordinator
+		if err := s.otaHandle(device, msg); err != nil {
+			logl.Error.Printf("OTA %s: %s", device.IEEEAddress, err.Error())
+		}
+	}()
+
+	return true
+}
+
+func (s *Stack) otaHandle(device *Device, msg *zcl.ZclIncomingMessage) error {
+	switch req := msg.Data.Command.(type) {
+	case *cluster.GenOtaQueryNextImageRequest:
+		return s.otaRespond(msg, s.ota.queryNextImage(device, req))
+	case *cluster.GenOtaImageBlockRequest:
+		return s.otaRespond(msg, s.ota.imageBlock(device, req))
+	case *cluster.GenOtaUpgradeEndRequest:
+		response := s.ota.upgradeEnd(device, req)
+		if response == nil { // failed download => spec says to not respond
+			return nil
+		}
+		return s.otaRespond(msg, response)
+	default:
+		return fmt.Errorf("unsupported OTA command: %T", msg.Data.Command)
+	}
+}
+
+// responses use the request's transaction sequence number
+func (s *Stack) otaRespond(request *zcl.ZclIncomingMessage, response cluster.LocalCommand) error {
+	_, commandId := response.CommandClusterAndId()
+
+	frm, err := frame.New().
+		IdGenerator(func() uint8 { return request.Data.TransactionSequenceNumber }).
+		DisableDefaultResponse(true).
+		FrameType(frame.FrameTypeLocal).
+		Direction(frame.DirectionServerClient).
+		CommandId(commandId).
+		Command(response).
+		Build()
+	if err != nil {
+		return err
+	}
+
+	return s.coordinator.DataRequestNoResponse(
+		request.SrcAddr,
+		request.SrcEndpoint,
+		request.DstEndpoint,
+		uint16(cluster.IdGenOta),
+		&znp.AfDataRequestOptions{},
+		15,
+		binstruct.Encode(frm))
+}
+
+func (o *otaServer) queryNextImage(device *Device, req *cluster.GenOtaQueryNextImageRequest) cluster.LocalCommand {
+	var hardwareVersion *uint16
+	if req.FieldControl == 1 {
+		hardwareVersion = &req.HardwareVersion
+	}
+
+	image := o.images.FindUpgrade(req.ManufacturerCode, req.ImageType, req.CurrentFileVersion, hardwareVersion)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if image == nil {
+		// device might've been updated by us earlier. keep "done" state so it's visible
+		if progress, found := o.progress[device.IEEEAddress]; found && progress.State != OtaStateDone {
+			delete(o.progress, device.IEEEAddress)
+		}
+
+		return &cluster.GenOtaQueryNextImageResponse{Status: cluster.ZclStatusNoImageAvailable}
+	}
+
+	if progress, found := o.progress[device.IEEEAddress]; found && progress.State == OtaStateUpdating {
+		// device resumes an interrupted download. keep the progress
+		return queryNextImageResponse(image)
+	}
+
+	o.progress[device.IEEEAddress] = &OtaProgress{
+		Device:             device.IEEEAddress,
+		State:              OtaStateAvailable,
+		ImageType:          image.Header.ImageType,
+		CurrentFileVersion: req.CurrentFileVersion,
+		NewFileVersion:     image.Header.FileVersion,
+		Size:               uint32(len(image.Data)),
+		Updated:            time.Now(),
+	}
+	o.publish(o.progress[device.IEEEAddress])
+
+	return queryNextImageResponse(image)
+}
+
+func queryNextImageResponse(image *ota.Image) *cluster.GenOtaQueryNextImageResponse {
+	return &cluster.GenOtaQueryNextImageResponse{
+		Status:           cluster.ZclStatusSuccess,
+		ManufacturerCode: image.Header.ManufacturerCode,
+		ImageType:        image.Header.ImageType,
+		FileVersion:      image.Header.FileVersion,
+		ImageSize:        uint32(len(image.Data)),
+	}
+}
+
+func (o *otaServer) imageBlock(device *Device, req *cluster.GenOtaImageBlockRequest) cluster.LocalCommand {
+	image := o.findExact(req.ManufacturerCode, req.ImageType, req.FileVersion)
+	if image == nil || req.FileOffset > uint32(len(image.Data)) {
+		return &cluster.GenOtaImageBlockResponse{Status: cluster.ZclStatusAbort}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+
+	if !device.MainPowered {
+		if since := now.Sub(o.lastBlock[device.IEEEAddress]); since < otaSleepyMinimumBlockPeriod {
+			return &cluster.GenOtaImageBlockResponse{
+				Status:             cluster.ZclStatusWaitForData,
+				CurrentTime:        0,
+				RequestTime:        1, // [s]
+				MinimumBlockPeriod: uint16(otaSleepyMinimumBlockPeriod / time.Millisecond),
+			}
+		}
+	}
+
+	o.lastBlock[device.IEEEAddress] = now
+
+	dataSize := uint32(req.MaximumDataSize)
+	if dataSize > otaMaxDataSize {
+		dataSize = otaMaxDataSize
+	}
+
+	end := req.FileOffset + dataSize
+	if end > uint32(len(image.Data)) {
+		end = uint32(len(image.Data))
+	}
+
+	progress := o.progressFor(device, image)
+	percentBefore := progress.Percent()
+	if progress.State != OtaStateUpdating {
+		progress.State = OtaStateUpdating
+		progress.Started = &now
+		progress.Error = ""
+		percentBefore = -1 // force publish
+	}
+	progress.Offset = end
+	progress.Updated = now
+
+	if progress.Percent() != percentBefore { // not for every block, that'd be way too chatty
+		o.publish(progress)
+	}
+
+	return &cluster.GenOtaImageBlockResponse{
+		Status:           cluster.ZclStatusSuccess,
+		ManufacturerCode: req.ManufacturerCode,
+		ImageType:        req.ImageType,
+		FileVersion:      req.FileVersion,
+		FileOffset:       req.FileOffset,
+		ImageData:        image.Data[req.FileOffset:end],
+	}
+}
+
+// returns nil if the device reported a failed upgrade, in which case we must not respond
+func (o *otaServer) upgradeEnd(device *Device, req *cluster.GenOtaUpgradeEndRequest) cluster.LocalCommand {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.lastBlock, device.IEEEAddress)
+
+	image := o.findExact(req.ManufacturerCode, req.ImageType, req.FileVersion)
+
+	progress := o.progressFor(device, image)
+	progress.Updated = time.Now()
+
+	if err := req.Status.Error(); err != nil {
+		progress.State = OtaStateFailed
+		progress.Error = err.Error()
+		o.publish(progress)
+		return nil
+	}
+
+	progress.State = OtaStateDone
+	progress.Offset = progress.Size
+	progress.CurrentFileVersion = req.FileVersion
+	o.publish(progress)
+
+	return &cluster.GenOtaUpgradeEndResponse{
+		ManufacturerCode: req.ManufacturerCode,
+		ImageType:        req.ImageType,
+		FileVersion:      req.FileVersion,
+		CurrentTime:      0,
+		UpgradeTime:      0, // now
+	}
+}
+
+// needs lock. *image* can be nil
+func (o *otaServer) progressFor(device *Device, image *ota.Image) *OtaProgress {
+	progress, found := o.progress[device.IEEEAddress]
+	if !found {
+		progress = &OtaProgress{
+			Device: device.IEEEAddress,
+			State:  OtaStateAvailable,
+		}
+		o.progress[device.IEEEAddress] = progress
+	}
+
+	if image != nil {
+		progress.ImageType = image.Header.ImageType
+		progress.NewFileVersion = image.Header.FileVersion
+		progress.Size = uint32(len(image.Data))
+	}
+
+	return progress
+}
+
+// needs lock
+func (o *otaServer) publish(progress *OtaProgress) {
+	select {
+	case o.changes <- *progress:
+	default:
+		logl.Error.Println("onOtaProgress channel has no capacity. Maybe channel has no subscribers")
+	}
+}
+
+func (o *otaServer) findExact(manufacturerCode uint16, imageType uint16, fileVersion uint32) *ota.Image {
+	for _, image := range o.images.Images() {
+		header := image.Header // shorthand
+
+		if header.ManufacturerCode == manufacturerCode && header.ImageType == imageType && header.FileVersion == fileVersion {
+			return image
+		}
+	}
+
+	return nil
+}
+
+// we can't know the device's image type & current version before it queries us, so for devices
+// that haven't queried we notify if we have any image from its manufacturer
+func (o *otaServer) shouldNotify(device *Device) bool {
+	if device.OtaClientEndpoint() == nil {
+		return false
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if time.Since(o.lastNotified[device.IEEEAddress]) < otaNotifyInterval {
+		return false
+	}
+
+	if progress, found := o.progress[device.IEEEAddress]; found {
+		return progress.State == OtaStateAvailable || progress.State == OtaStateFailed
+	}
+
+	for _, image := range o.images.Images() {
+		if image.Header.ManufacturerCode == device.ManufacturerId {
+			return true
+		}
+	}
+
+	return false
+}
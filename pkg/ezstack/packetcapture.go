@@ -1,42 +1,275 @@
 package ezstack
 
-// captures ZNP frames and writes them to a textual log file so you can reverse-engineer
-// communications / capture messages for use with tests.
+// captures ZNP frames (both directions) to a pcap-ng file that can be opened in Wireshark, so you
+// can reverse-engineer communications / capture messages for use with tests.
 //
-// TODO: research if we can utilize pcap-ng format so the packets could be opened in Wireshark?
+// the capture has two interfaces:
+// - "zigbee": application data (ZCL) wrapped in synthesized 802.15.4 + NWK + APS headers so that
+//   Wireshark's Zigbee dissectors decode it. we don't see the real radio frames (the ZNP hides
+//   them from us), so addressing is reconstructed and security is not shown.
+// - "znp": every raw ZNP frame as-is (cmd0, cmd1, payload), annotated with the command in the
+//   packet comment. Wireshark has no ZNP dissector, so these show up as plain bytes.
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"os"
-	"time"
+	"strconv"
 
+	"github.com/function61/hautomo/pkg/ezstack/binstruct"
+	"github.com/function61/hautomo/pkg/ezstack/pcapng"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
 	"github.com/function61/hautomo/pkg/ezstack/znp"
+	"github.com/function61/hautomo/pkg/ezstack/znp/unp"
 )
 
-func runPacketCapture(ctx context.Context, packetCaptureFilename string, networkProcessor *znp.Znp) error {
+const (
+	afDataRequestId    = 0x01
+	afDataRequestExtId = 0x02
+)
+
+// *networkProcessors* gets a new ZNP each time we (re)connect to the radio. the capture continues
+// in the same file.
+func runPacketCapture(
+	ctx context.Context,
+	packetCaptureFilename string,
+	networkProcessors <-chan *znp.Znp,
+	panId zigbee.PANID,
+) error {
 	packetCaptureFile, err := os.Create(packetCaptureFilename)
 	if err != nil {
 		return err
 	}
 	defer packetCaptureFile.Close()
 
+	capture, err := newPacketCapture(packetCaptureFile, panId)
+	if err != nil {
+		return err
+	}
+
+	// nil until connected (receiving from nil channel blocks)
+	var inFramesLog, outFramesLog chan *znp.LoggedFrame
+
 	for {
 		select {
 		case <-ctx.Done():
 			return packetCaptureFile.Close() // double close intentional
-		case frame := <-networkProcessor.InFramesLog():
-			if _, err := fmt.Fprintf(
-				packetCaptureFile,
-				"%s CommandType=%d Subsystem=%d Command=%d Payload=%x\n",
-				time.Now().Format(time.RFC3339Nano),
-				frame.CommandType,
-				frame.Subsystem,
-				frame.Command,
-				frame.Payload,
-			); err != nil {
+		case networkProcessor := <-networkProcessors:
+			inFramesLog, outFramesLog = networkProcessor.InFramesLog(), networkProcessor.OutFramesLog()
+		case logged := <-inFramesLog:
+			if err := capture.write(logged, pcapng.DirectionInbound); err != nil {
+				return err
+			}
+		case logged := <-outFramesLog:
+			if err := capture.write(logged, pcapng.DirectionOutbound); err != nil {
 				return err
 			}
 		}
 	}
 }
+
+type packetCapture struct {
+	writer          *pcapng.Writer
+	zigbeeInterface int
+	znpInterface    int
+	panId           zigbee.PANID
+	sequenceNumber  uint8 // for synthesized MAC + NWK headers
+}
+
+func newPacketCapture(file *os.File, panId zigbee.PANID) (*packetCapture, error) {
+	writer, err := pcapng.NewWriter(file, "hautomo ezstack")
+	if err != nil {
+		return nil, err
+	}
+
+	zigbeeInterface, err := writer.AddInterface(pcapng.LinkTypeIEEE802154NoFcs, "zigbee")
+	if err != nil {
+		return nil, err
+	}
+
+	znpInterface, err := writer.AddInterface(pcapng.LinkTypeUser0, "znp")
+	if err != nil {
+		return nil, err
+	}
+
+	return &packetCapture{
+		writer:          writer,
+		zigbeeInterface: zigbeeInterface,
+		znpInterface:    znpInterface,
+		panId:           panId,
+	}, nil
+}
+
+func (p *packetCapture) write(logged *znp.LoggedFrame, direction pcapng.Direction) error {
+	frame := logged.Frame
+
+	raw := append([]byte{
+		(byte(frame.CommandType<<5) & 0xe0) | (byte(frame.Subsystem) & 0x1f), // same as UNP's cmd0
+		frame.Command,
+	}, frame.Payload...)
+
+	if err := p.writer.WritePacket(
+		p.znpInterface,
+		logged.Timestamp,
+		direction,
+		raw,
+		describeZnpFrame(frame),
+	); err != nil {
+		return err
+	}
+
+	radioFrame, comment := p.synthesizeRadioFrame(frame)
+	if radioFrame == nil { // not application data
+		return nil
+	}
+
+	return p.writer.WritePacket(
+		p.zigbeeInterface,
+		logged.Timestamp,
+		direction,
+		radioFrame,
+		comment)
+}
+
+// reconstructs the over-the-air frame for application data we sent or received
+func (p *packetCapture) synthesizeRadioFrame(frame *unp.Frame) ([]byte, string) {
+	if frame.Subsystem != unp.S_AF {
+		return nil, ""
+	}
+
+	switch {
+	case frame.CommandType == unp.C_AREQ && frame.Command == znp.AfIncomingMessageId:
+		msg := &znp.AfIncomingMessage{}
+		if err := binstruct.Decode(frame.Payload, msg); err != nil {
+			return nil, ""
+		}
+
+		return p.radioFrame(
+			msg.SrcAddr,
+			zigbee.CoordinatorNwkAddr,
+			apsAddressing{dstEndpoint: msg.DstEndpoint},
+			msg.SrcEndpoint,
+			msg.ClusterID,
+			msg.TransSeqNumber,
+			msg.Data), fmt.Sprintf("LQI %d", msg.LinkQuality)
+	case frame.CommandType == unp.C_SREQ && frame.Command == afDataRequestId:
+		req := &znp.AfDataRequest{}
+		if err := binstruct.Decode(frame.Payload, req); err != nil {
+			return nil, ""
+		}
+
+		return p.radioFrame(
+			zigbee.CoordinatorNwkAddr,
+			req.DstAddr,
+			apsAddressing{dstEndpoint: req.DstEndpoint},
+			req.SrcEndpoint,
+			req.ClusterID,
+			req.TransID,
+			req.Data), ""
+	case frame.CommandType == unp.C_SREQ && frame.Command == afDataRequestExtId:
+		req := &znp.AfDataRequestExt{}
+		if err := binstruct.Decode(frame.Payload, req); err != nil {
+			return nil, ""
+		}
+
+		if req.DstAddrMode != znp.AddrModeAddrGroup { // we only use the ext variant for groupcasts
+			return nil, ""
+		}
+
+		group := parseHexAddress(req.DstAddr)
+
+		return p.radioFrame(
+			zigbee.CoordinatorNwkAddr,
+			zigbee.BroadcastAllRouters,
+			apsAddressing{group: &group},
+			req.SrcEndpoint,
+			req.ClusterID,
+			req.TransID,
+			req.Data), fmt.Sprintf("group %d", group)
+	default:
+		return nil, ""
+	}
+}
+
+type apsAddressing struct {
+	dstEndpoint zigbee.EndpointId
+	group       *uint16 // groupcast if set
+}
+
+func (p *packetCapture) radioFrame(
+	src string, // network address
+	dst string, // network address
+	aps apsAddressing,
+	srcEndpoint zigbee.EndpointId,
+	clusterId uint16,
+	apsCounter uint8,
+	payload []byte,
+) []byte {
+	p.sequenceNumber++
+
+	frame := []byte{}
+	le16 := func(val uint16) {
+		frame = append(frame, 0, 0)
+		binary.LittleEndian.PutUint16(frame[len(frame)-2:], val)
+	}
+
+	// MAC: data frame, PAN ID compression, short dst & src addresses
+	le16(0x8841)
+	frame = append(frame, p.sequenceNumber)
+	le16(uint16(p.panId))
+	le16(parseHexAddress(dst))
+	le16(parseHexAddress(src))
+
+	// NWK: data frame, protocol version 2, no security (the ZNP decrypted it for us)
+	le16(0x0008)
+	le16(parseHexAddress(dst))
+	le16(parseHexAddress(src))
+	frame = append(frame, 30, p.sequenceNumber) // radius, sequence number
+
+	// APS: data frame, unicast or group delivery
+	if aps.group != nil {
+		frame = append(frame, 0x0c)
+		le16(*aps.group)
+	} else {
+		frame = append(frame, 0x00, byte(aps.dstEndpoint))
+	}
+	le16(clusterId)
+	le16(uint16(zigbee.ProfileHomeAutomation))
+	frame = append(frame, byte(srcEndpoint), apsCounter)
+
+	return append(frame, payload...)
+}
+
+func describeZnpFrame(frame *unp.Frame) string {
+	commandType := map[unp.CommandType]string{
+		unp.C_POLL: "POLL",
+		unp.C_SREQ: "SREQ",
+		unp.C_AREQ: "AREQ",
+		unp.C_SRSP: "SRSP",
+	}[frame.CommandType]
+
+	description := fmt.Sprintf("%s subsystem=%d command=0x%02x", commandType, frame.Subsystem, frame.Command)
+
+	if frame.CommandType == unp.C_AREQ {
+		if command, err := znp.NewConcreteAsyncCommand(frame.Subsystem, frame.Command); err == nil {
+			description += fmt.Sprintf(" (%T)", command)
+		}
+	}
+
+	return description
+}
+
+// "0x1234" => 0x1234. unparseable => 0xffff (never a valid device address)
+func parseHexAddress(address string) uint16 {
+	if len(address) < 2 {
+		return 0xffff
+	}
+
+	val, err := strconv.ParseUint(address[2:], 16, 64)
+	if err != nil {
+		return 0xffff
+	}
+
+	return uint16(val)
+}
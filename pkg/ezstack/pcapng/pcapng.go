@@ -0,0 +1,166 @@
+// Minimal pcap-ng writer, enough for Wireshark to open our captures.
+// https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-03.html
+package pcapng
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+type LinkType uint16
+
+// https://www.tcpdump.org/linktypes.html
+const (
+	LinkTypeUser0           LinkType = 147 // private use. we use this for raw ZNP frames
+	LinkTypeIEEE802154NoFcs LinkType = 230
+)
+
+type Direction uint32
+
+// values for epb_flags' "inbound / outbound" bits
+const (
+	DirectionUnknown  Direction = 0
+	DirectionInbound  Direction = 1
+	DirectionOutbound Direction = 2
+)
+
+const (
+	blockTypeSectionHeader         = 0x0a0d0d0a
+	blockTypeInterfaceDesc         = 0x00000001
+	blockTypeEnhancedPacket        = 0x00000006
+	byteOrderMagic                 = 0x1a2b3c4d
+	optEndOfOpt             uint16 = 0
+	optComment              uint16 = 1
+	optShbUserAppl          uint16 = 4
+	optIfName               uint16 = 2
+	optIfTsresol            uint16 = 9
+	optEpbFlags             uint16 = 2
+	tsresolNanoseconds             = 9 // 10^-9
+)
+
+var byteOrder = binary.LittleEndian
+
+type Writer struct {
+	output     io.Writer
+	interfaces int
+}
+
+// writes the section header. *application* is recorded as the capturing application
+func NewWriter(output io.Writer, application string) (*Writer, error) {
+	body := make([]byte, 16)
+	byteOrder.PutUint32(body[0:], byteOrderMagic)
+	byteOrder.PutUint16(body[4:], 1)                  // major version
+	byteOrder.PutUint16(body[6:], 0)                  // minor version
+	byteOrder.PutUint64(body[8:], 0xffffffffffffffff) // section length not specified
+
+	body = appendOptions(body, option{optShbUserAppl, []byte(application)})
+
+	if err := writeBlock(output, blockTypeSectionHeader, body); err != nil {
+		return nil, err
+	}
+
+	return &Writer{output: output}, nil
+}
+
+// adds an interface and returns its id, which you give to WritePacket()
+func (w *Writer) AddInterface(linkType LinkType, name string) (int, error) {
+	body := make([]byte, 8)
+	byteOrder.PutUint16(body[0:], uint16(linkType))
+	byteOrder.PutUint16(body[2:], 0) // reserved
+	byteOrder.PutUint32(body[4:], 0) // snaplen (0 = no limit)
+
+	body = appendOptions(
+		body,
+		option{optIfName, []byte(name)},
+		option{optIfTsresol, []byte{tsresolNanoseconds}})
+
+	if err := writeBlock(w.output, blockTypeInterfaceDesc, body); err != nil {
+		return 0, err
+	}
+
+	id := w.interfaces
+	w.interfaces++
+
+	return id, nil
+}
+
+// writes an enhanced packet block. *comment* is optional and shows up in Wireshark's packet comments
+func (w *Writer) WritePacket(
+	interfaceId int,
+	timestamp time.Time,
+	direction Direction,
+	data []byte,
+	comment string,
+) error {
+	ts := uint64(timestamp.UnixNano())
+
+	body := make([]byte, 20, 20+len(data)+64)
+	byteOrder.PutUint32(body[0:], uint32(interfaceId))
+	byteOrder.PutUint32(body[4:], uint32(ts>>32))
+	byteOrder.PutUint32(body[8:], uint32(ts))
+	byteOrder.PutUint32(body[12:], uint32(len(data))) // captured length
+	byteOrder.PutUint32(body[16:], uint32(len(data))) // original length
+	body = append(body, pad(data)...)
+
+	options := []option{}
+	if comment != "" {
+		options = append(options, option{optComment, []byte(comment)})
+	}
+	if direction != DirectionUnknown {
+		flags := make([]byte, 4)
+		byteOrder.PutUint32(flags, uint32(direction))
+		options = append(options, option{optEpbFlags, flags})
+	}
+
+	body = appendOptions(body, options...)
+
+	return writeBlock(w.output, blockTypeEnhancedPacket, body)
+}
+
+type option struct {
+	code  uint16
+	value []byte
+}
+
+func appendOptions(body []byte, options ...option) []byte {
+	if len(options) == 0 {
+		return body
+	}
+
+	header := make([]byte, 4)
+	for _, opt := range options {
+		byteOrder.PutUint16(header[0:], opt.code)
+		byteOrder.PutUint16(header[2:], uint16(len(opt.value)))
+		body = append(body, header...)
+		body = append(body, pad(opt.value)...)
+	}
+
+	byteOrder.PutUint16(header[0:], optEndOfOpt)
+	byteOrder.PutUint16(header[2:], 0)
+	return append(body, header...)
+}
+
+// block layout: type, total length, body, total length (again, so the file can be read backwards)
+func writeBlock(output io.Writer, blockType uint32, body []byte) error {
+	totalLength := uint32(12 + len(body))
+
+	block := make([]byte, 8, totalLength)
+	byteOrder.PutUint32(block[0:], blockType)
+	byteOrder.PutUint32(block[4:], totalLength)
+	block = append(block, body...)
+	block = append(block, 0, 0, 0, 0)
+	byteOrder.PutUint32(block[len(block)-4:], totalLength)
+
+	_, err := output.Write(block)
+	return err
+}
+
+// everything in pcap-ng is aligned to 32 bits
+func pad(data []byte) []byte {
+	if remainder := len(data) % 4; remainder != 0 {
+		return append(append([]byte{}, data...), make([]byte, 4-remainder)...)
+	}
+
+	return data
+}
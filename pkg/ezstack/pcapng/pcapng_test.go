@@ -0,0 +1,39 @@
+package pcapng
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/function61/gokit/testing/assert"
+)
+
+func TestWriter(t *testing.T) {
+	output := &bytes.Buffer{}
+
+	writer, err := NewWriter(output, "test")
+	assert.Ok(t, err)
+
+	ifaceId, err := writer.AddInterface(LinkTypeUser0, "znp")
+	assert.Ok(t, err)
+	assert.EqualInt(t, ifaceId, 0)
+
+	assert.Ok(t, writer.WritePacket(
+		ifaceId,
+		time.Unix(1, 2),
+		DirectionOutbound,
+		[]byte{0x01, 0x02, 0x03},
+		"hi"))
+
+	assert.EqualString(t, hex.EncodeToString(output.Bytes()), ""+
+		// section header block
+		"0a0d0d0a"+"28000000"+"4d3c2b1a"+"0100"+"0000"+"ffffffffffffffff"+
+		"0400"+"0400"+"74657374"+"00000000"+"28000000"+
+		// interface description block
+		"01000000"+"28000000"+"9300"+"0000"+"00000000"+
+		"0200"+"0300"+"7a6e7000"+"0900"+"0100"+"09000000"+"00000000"+"28000000"+
+		// enhanced packet block
+		"06000000"+"38000000"+"00000000"+"00000000"+"02ca9a3b"+"03000000"+"03000000"+"01020300"+
+		"0100"+"0200"+"68690000"+"0200"+"0400"+"02000000"+"00000000"+"38000000")
+}
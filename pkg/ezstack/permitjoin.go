@@ -0,0 +1,93 @@
+package ezstack
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+)
+
+type PermitJoinStatus struct {
+	Enabled   bool
+	Remaining time.Duration
+	Router    *zigbee.IEEEAddress // nil = all routers
+}
+
+// the radio can't tell us whether joining is on, so we keep track ourselves
+type permitJoinState struct {
+	until  time.Time
+	router *zigbee.IEEEAddress
+	mu     sync.Mutex
+}
+
+// allows new devices to join for *duration* (max coordinator.PermitJoinMaxDuration). zero
+// duration disables joining. *router* can be nil (= join via any router or the coordinator).
+func (s *Stack) PermitJoin(duration time.Duration, router *Device) error {
+	routerNwkAddress := ""
+	var routerAddress *zigbee.IEEEAddress
+	if router != nil {
+		routerNwkAddress = router.NetworkAddress
+		routerAddress = &router.IEEEAddress
+	}
+
+	// disabling is always done for the whole network, so that a join previously opened
+	// via a different router doesn't stay open
+	if duration == 0 {
+		routerNwkAddress = ""
+		routerAddress = nil
+	}
+
+	if err := s.coordinator.PermitJoin(duration, routerNwkAddress); err != nil {
+		return err
+	}
+
+	s.permitJoin.set(duration, routerAddress)
+
+	logl.Info.Printf("permit join: %s", s.PermitJoinStatus().String())
+
+	return nil
+}
+
+func (s *Stack) PermitJoinStatus() PermitJoinStatus {
+	s.permitJoin.mu.Lock()
+	defer s.permitJoin.mu.Unlock()
+
+	remaining := time.Until(s.permitJoin.until)
+	if remaining <= 0 {
+		return PermitJoinStatus{}
+	}
+
+	return PermitJoinStatus{
+		Enabled:   true,
+		Remaining: remaining.Round(time.Second),
+		Router:    s.permitJoin.router,
+	}
+}
+
+func (p PermitJoinStatus) String() string {
+	switch {
+	case !p.Enabled:
+		return "disabled"
+	case p.Router != nil:
+		return "enabled for " + p.Remaining.String() + " via " + p.Router.HexPrefixedString()
+	default:
+		return "enabled for " + p.Remaining.String()
+	}
+}
+
+func (p PermitJoinStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Enabled   bool                `json:"enabled"`
+		Remaining int                 `json:"remaining"` // [s]
+		Router    *zigbee.IEEEAddress `json:"router,omitempty"`
+	}{p.Enabled, int(p.Remaining.Seconds()), p.Router})
+}
+
+func (p *permitJoinState) set(duration time.Duration, router *zigbee.IEEEAddress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.until = time.Now().Add(duration)
+	p.router = router
+}
@@ -0,0 +1,198 @@
+package ezstack
+
+// connection to the radio. usually it's behind a local serial port, but network-attached
+// coordinators (ser2net, LAN gateways like ZigStar or Tube's) expose the same byte stream over TCP.
+// a network link can drop while the radio keeps running, so for TCP we reconnect and re-run
+// coordinator startup to get back in sync with the radio.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/function61/gokit/log/logex"
+	"github.com/function61/gokit/sync/taskrunner"
+	"github.com/function61/hautomo/pkg/ezstack/znp"
+	"github.com/function61/hautomo/pkg/ezstack/znp/unp"
+	"go.bug.st/serial"
+)
+
+const (
+	tcpDialTimeout     = 10 * time.Second
+	tcpKeepAlivePeriod = 15 * time.Second // OS-level probes, detects a peer that lost power
+	radioPingInterval  = 30 * time.Second // ZNP-level, detects a link that's up but has no radio behind it
+	reconnectDelay     = 5 * time.Second
+)
+
+// transport to the radio failed (as opposed to e.g. radio having wrong settings), so reconnecting
+// can help
+type linkError struct {
+	error
+}
+
+func (s *Stack) runRadio(ctx context.Context, settingsFlash bool, connected chan<- *znp.Znp) error {
+	for {
+		err := s.runRadioConnection(ctx, settingsFlash, connected)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if _, isLinkError := err.(*linkError); !isLinkError || !s.transportReconnectable() {
+			return err
+		}
+
+		logl.Error.Printf("radio link: %v. reconnecting in %s", err, reconnectDelay)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func (s *Stack) runRadioConnection(ctx context.Context, settingsFlash bool, connected chan<- *znp.Znp) error {
+	port, err := s.openTransport()
+	if err != nil {
+		return &linkError{err}
+	}
+	defer port.Close()
+
+	// connect to ZNP using UNP protocol with serial port (usually) as a transport
+	networkProcessor := znp.New(unp.NewWith8BitsPayloadLength(port), logex.Prefix("znp", logger))
+
+	if connected != nil {
+		select {
+		case connected <- networkProcessor:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	tasks := taskrunner.New(ctx, log)
+
+	linkFailed := int32(0) // 1 = set by a task (concurrently, so atomically). read after they've all exited
+
+	// multiple ways for us to need port closing, so this is mainly a hack
+	tasks.Start("portcloser", func(ctx context.Context) error {
+		<-ctx.Done()
+
+		// ZNP is most likely blocking on an UNP read
+		return port.Close() // double close intentional
+	})
+
+	tasks.Start("znp", func(ctx context.Context) error {
+		if err := networkProcessor.Run(ctx); err != nil {
+			atomic.StoreInt32(&linkFailed, 1)
+			return err
+		}
+
+		return nil
+	})
+
+	// also on reconnect the radio might've restarted, so startup is always done in full. joining
+	// is restored for the remaining duration (only network-wide joining, to not open it wider than
+	// asked for a router we might not reach anymore)
+	permitJoin := func() time.Duration {
+		status := s.PermitJoinStatus()
+		if !status.Enabled || status.Router != nil {
+			s.permitJoin.set(0, nil)
+			return 0
+		}
+
+		return status.Remaining
+	}()
+
+	tasks.Start("coordinator", func(ctx context.Context) error {
+		return s.coordinator.Run(ctx, permitJoin, networkProcessor, settingsFlash)
+	})
+
+	if s.transportReconnectable() {
+		tasks.Start("ping", func(ctx context.Context) error {
+			ping := time.NewTicker(radioPingInterval)
+			defer ping.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-ping.C:
+					if _, err := networkProcessor.SysPing(); err != nil {
+						atomic.StoreInt32(&linkFailed, 1)
+						return fmt.Errorf("SysPing: %w", err)
+					}
+				}
+			}
+		})
+	}
+
+	if err := tasks.Wait(); err != nil {
+		if atomic.LoadInt32(&linkFailed) == 1 {
+			return &linkError{err}
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// a local serial port going away usually means the USB stick was unplugged, so it's not worth
+// retrying. a network link however can drop while the radio stays up.
+func (s *Stack) transportReconnectable() bool {
+	_, isTcp := s.configuration.Serial.TcpAddress()
+
+	return s.transport == nil && isTcp
+}
+
+func (s *Stack) openTransport() (io.ReadWriteCloser, error) {
+	if s.transport != nil {
+		return s.transport, nil
+	}
+
+	if address, isTcp := s.configuration.Serial.TcpAddress(); isTcp {
+		logl.Debug.Printf("connecting to Zigbee radio at %s", address)
+
+		conn, err := dialTcp(address)
+		if err != nil {
+			return nil, fmt.Errorf("dialTcp: %s: %w", address, err)
+		}
+
+		return conn, nil
+	}
+
+	logl.Debug.Printf(
+		"opening Zigbee radio %s at %d bauds/s",
+		s.configuration.Serial.Port,
+		s.configuration.Serial.BaudRateOrDefault())
+
+	port, err := openPort(
+		s.configuration.Serial.Port,
+		s.configuration.Serial.BaudRateOrDefault())
+	if err != nil {
+		return nil, fmt.Errorf("openPort: %s: %w", s.configuration.Serial.Port, err)
+	}
+
+	return port, nil
+}
+
+func openPort(portName string, baudRate int) (port serial.Port, err error) {
+	port, err = serial.Open(portName, &serial.Mode{BaudRate: baudRate})
+	if err != nil {
+		return nil, err
+	}
+
+	return port, port.SetRTS(true)
+}
+
+func dialTcp(address string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   tcpDialTimeout,
+		KeepAlive: tcpKeepAlivePeriod,
+	}
+
+	return dialer.Dial("tcp", address)
+}
@@ -0,0 +1,183 @@
+package ezstack
+
+import (
+	"fmt"
+
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+)
+
+// after interview, we bind device's clusters to the coordinator and configure attribute
+// reporting, so the device pushes its state changes to us (instead of us having to poll).
+
+// coordinator's endpoint that device's reports are bound to
+const coordinatorEndpoint = zigbee.EndpointId(1)
+
+type AttributeReporting struct {
+	AttributeId      cluster.AttributeId
+	MinInterval      uint16 // [s] don't report more often than this
+	MaxInterval      uint16 // [s] report at least this often, even if the value didn't change
+	ReportableChange uint64 // [in units of the attribute] only for analog attributes. change required for a report
+}
+
+// cluster => attributes to report. a cluster with empty list means "don't configure this cluster"
+type ReportingConfiguration map[cluster.ClusterId][]AttributeReporting
+
+// returns per-model overrides (can be nil) that are merged on top of DefaultReportingConfiguration
+type ReportingOverrides func(model Model) ReportingConfiguration
+
+var DefaultReportingConfiguration = ReportingConfiguration{
+	cluster.IdGenOnOff: {
+		{AttributeId: 0x0000, MinInterval: 0, MaxInterval: 3600}, // onOff
+	},
+	cluster.IdGenLevelCtrl: {
+		{AttributeId: 0x0000, MinInterval: 1, MaxInterval: 3600, ReportableChange: 1}, // currentLevel
+	},
+	cluster.IdLightingColorCtrl: {
+		{AttributeId: 0x0003, MinInterval: 1, MaxInterval: 3600, ReportableChange: 1}, // currentX
+		{AttributeId: 0x0004, MinInterval: 1, MaxInterval: 3600, ReportableChange: 1}, // currentY
+		{AttributeId: 0x0007, MinInterval: 1, MaxInterval: 3600, ReportableChange: 1}, // colorTemperature
+	},
+	cluster.IdMsTemperatureMeasurement: {
+		{AttributeId: 0x0000, MinInterval: 10, MaxInterval: 3600, ReportableChange: 10}, // measuredValue [0.01 °C]
+	},
+	cluster.IdMsRelativeHumidity: {
+		{AttributeId: 0x0000, MinInterval: 10, MaxInterval: 3600, ReportableChange: 100}, // measuredValue [0.01 %]
+	},
+	cluster.IdMsPressureMeasurement: {
+		{AttributeId: 0x0000, MinInterval: 10, MaxInterval: 3600, ReportableChange: 1}, // measuredValue [hPa]
+	},
+	cluster.IdMsIlluminanceMeasurement: {
+		{AttributeId: 0x0000, MinInterval: 10, MaxInterval: 3600, ReportableChange: 500}, // measuredValue (logarithmic)
+	},
+	cluster.IdGenPowerCfg: {
+		{AttributeId: 0x0021, MinInterval: 3600, MaxInterval: 62000, ReportableChange: 0}, // batteryPercentageRemaining
+	},
+	cluster.IdClosuresWindowCovering: {
+		{AttributeId: 0x0008, MinInterval: 1, MaxInterval: 3600, ReportableChange: 1}, // currentPositionLiftPercentage
+		{AttributeId: 0x0009, MinInterval: 1, MaxInterval: 3600, ReportableChange: 1}, // currentPositionTiltPercentage
+	},
+	cluster.IdClosuresDoorLock: {
+		{AttributeId: 0x0000, MinInterval: 0, MaxInterval: 3600}, // lockState
+	},
+	cluster.IdHvacFanCtrl: {
+		{AttributeId: 0x0000, MinInterval: 0, MaxInterval: 3600}, // fanMode
+	},
+	// changes are in raw units (see MeasurementScaling). these suit the common divisors of 1-10 (power, voltage) and 1000 (current)
+	cluster.IdHaElectricalMeasurement: {
+		{AttributeId: 0x050b, MinInterval: 5, MaxInterval: 3600, ReportableChange: 10}, // activePower
+		{AttributeId: 0x0505, MinInterval: 5, MaxInterval: 3600, ReportableChange: 5},  // rmsVoltage
+		{AttributeId: 0x0508, MinInterval: 5, MaxInterval: 3600, ReportableChange: 50}, // rmsCurrent
+	},
+	cluster.IdSeMetering: {
+		{AttributeId: 0x0000, MinInterval: 5, MaxInterval: 3600, ReportableChange: 1}, // currentSummDelivered
+	},
+	cluster.IdHvacThermostat: {
+		{AttributeId: 0x0000, MinInterval: 10, MaxInterval: 3600, ReportableChange: 10}, // localTemp [0.01 °C]
+		{AttributeId: 0x0012, MinInterval: 0, MaxInterval: 3600, ReportableChange: 10},  // occupiedHeatingSetpoint [0.01 °C]
+		{AttributeId: 0x001c, MinInterval: 0, MaxInterval: 3600},                        // systemMode
+		{AttributeId: 0x0029, MinInterval: 0, MaxInterval: 3600},                        // runningState
+		{AttributeId: 0x0008, MinInterval: 10, MaxInterval: 3600, ReportableChange: 5},  // pIHeatingDemand [%]
+	},
+}
+
+// overrides replace defaults on a per-cluster basis
+func (r ReportingConfiguration) Merge(overrides ReportingConfiguration) ReportingConfiguration {
+	merged := ReportingConfiguration{}
+	for clusterId, attributes := range r {
+		merged[clusterId] = attributes
+	}
+	for clusterId, attributes := range overrides {
+		merged[clusterId] = attributes
+	}
+
+	return merged
+}
+
+// binds and configures reporting for each endpoint's input clusters that we have a reporting
+// configuration for. tries to configure all clusters even if some fail.
+func (s *Stack) configureReporting(device *Device) error {
+	configuration := DefaultReportingConfiguration
+	if s.reportingOverrides != nil {
+		configuration = configuration.Merge(s.reportingOverrides(device.Model))
+	}
+
+	errs := []error{}
+
+	for _, endpoint := range device.Endpoints {
+		for _, clusterId := range endpoint.InClusterList {
+			attributes := configuration[clusterId]
+			if len(attributes) == 0 {
+				continue
+			}
+
+			if err := s.bindAndConfigureReporting(device, endpoint.Id, clusterId, attributes); err != nil {
+				errs = append(errs, fmt.Errorf("endpoint %d cluster %d: %w", endpoint.Id, clusterId, err))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d failure(s), first: %w", len(errs), errs[0])
+	}
+
+	return nil
+}
+
+func (s *Stack) bindAndConfigureReporting(
+	device *Device,
+	endpointId zigbee.EndpointId,
+	clusterId cluster.ClusterId,
+	attributes []AttributeReporting,
+) error {
+	definition := cluster.FindDefinition(clusterId)
+	if definition == nil {
+		return fmt.Errorf("no definition for cluster %d", clusterId)
+	}
+
+	records := []*cluster.AttributeReportingConfigurationRecord{}
+	for _, attribute := range attributes {
+		attributeDefinition := definition.Attribute(attribute.AttributeId)
+		if attributeDefinition == nil {
+			return fmt.Errorf("no definition for attribute %d", attribute.AttributeId)
+		}
+
+		reportableChange, err := reportableChangeFor(attributeDefinition.Type, attribute.ReportableChange)
+		if err != nil {
+			return fmt.Errorf("%s: %w", attributeDefinition.Name, err)
+		}
+
+		records = append(records, &cluster.AttributeReportingConfigurationRecord{
+			Direction:                cluster.ReportDirectionAttributeReported,
+			AttributeName:            attributeDefinition.Name,
+			AttributeID:              uint16(attribute.AttributeId),
+			AttributeDataType:        attributeDefinition.Type,
+			MinimumReportingInterval: attribute.MinInterval,
+			MaximumReportingInterval: attribute.MaxInterval,
+			ReportableChange:         reportableChange,
+		})
+	}
+
+	if err := s.BindToCoordinator(device.IEEEAddress, endpointId, clusterId, coordinatorEndpoint); err != nil {
+		return fmt.Errorf("BindToCoordinator: %w", err)
+	}
+
+	if err := s.ConfigureReporting(device.NetworkAddress, clusterId, records...); err != nil {
+		return fmt.Errorf("ConfigureReporting: %w", err)
+	}
+
+	return nil
+}
+
+func reportableChangeFor(dataType cluster.ZclDataType, change uint64) (*cluster.ReportableChange, error) {
+	switch {
+	case !dataType.IsAnalog():
+		return nil, nil
+	case dataType >= cluster.ZclDataTypeUint8 && dataType <= cluster.ZclDataTypeUint64:
+		return &cluster.ReportableChange{DataType: dataType, Value: change}, nil
+	case dataType >= cluster.ZclDataTypeInt8 && dataType <= cluster.ZclDataTypeInt64:
+		return &cluster.ReportableChange{DataType: dataType, Value: int64(change)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported analog data type for reportable change: %d", dataType)
+	}
+}
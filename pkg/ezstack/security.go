@@ -0,0 +1,37 @@
+package ezstack
+
+import (
+	"context"
+	"time"
+
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+)
+
+// how long devices have to receive the new network key before we tell them to switch to it.
+// (nwkBroadcastDeliveryTime is 9 seconds)
+const networkKeySwitchDelay = 10 * time.Second
+
+// switches the network (incl. joined devices) to *key*. the caller must update its stored
+// configuration to the new key. works while Run() is running or inside Maintenance().
+func (s *Stack) RotateNetworkKey(key zigbee.NetworkKey) error {
+	if err := s.coordinator.RotateNetworkKey(key, s.networkKeySwitchDelay); err != nil {
+		return err
+	}
+
+	s.configuration.NetworkKey = key[:]
+
+	logl.Info.Println("network key rotated")
+
+	return nil
+}
+
+// registers *code* for *device* before it joins. works while Run() is running or inside Maintenance().
+func (s *Stack) AddInstallCode(device zigbee.IEEEAddress, code zigbee.InstallCode) error {
+	return s.coordinator.AddInstallCode(device, code)
+}
+
+// runs *fn* (which can use e.g. RotateNetworkKey()) against the radio without running the stack.
+// don't call while Run() is running (the radio can have only one user).
+func (s *Stack) Maintenance(ctx context.Context, fn func() error) error {
+	return s.withCoordinator(ctx, false, fn)
+}
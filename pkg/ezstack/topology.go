@@ -0,0 +1,181 @@
+package ezstack
+
+// Network topology scan. we walk the mesh starting from the coordinator: each router tells us its
+// neighbors (Mgmt_Lqi_req) and routes (Mgmt_Rtg_req), and the neighbors that are routers are
+// asked in turn. end devices are not asked, because they're usually sleepy and don't route anyway.
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+	"github.com/function61/hautomo/pkg/ezstack/znp"
+)
+
+type NeighborRelationship uint8
+
+// https://zigbeealliance.org/wp-content/uploads/2019/11/docs-05-3474-21-0csg-zigbee-specification.pdf
+// section 2.4.4.3.2.1
+const (
+	NeighborRelationshipParent NeighborRelationship = iota
+	NeighborRelationshipChild
+	NeighborRelationshipSibling
+	NeighborRelationshipNone
+	NeighborRelationshipPreviousChild
+)
+
+var neighborRelationshipStrings = map[NeighborRelationship]string{
+	NeighborRelationshipParent:        "parent",
+	NeighborRelationshipChild:         "child",
+	NeighborRelationshipSibling:       "sibling",
+	NeighborRelationshipNone:          "none",
+	NeighborRelationshipPreviousChild: "previous_child",
+}
+
+func (n NeighborRelationship) String() string {
+	return neighborRelationshipStrings[n]
+}
+
+type Topology struct {
+	Scanned time.Time                     `json:"scanned"`
+	Nodes   []TopologyNode                `json:"nodes"`
+	Links   []TopologyLink                `json:"links"`
+	Routes  []TopologyRoute               `json:"routes"`
+	Failed  map[zigbee.IEEEAddress]string `json:"failed,omitempty"` // routers we couldn't scan
+}
+
+type TopologyNode struct {
+	IEEEAddress    zigbee.IEEEAddress `json:"ieee_address"`
+	NetworkAddress string             `json:"network_address"`
+	LogicalType    zigbee.LogicalType `json:"logical_type"`
+	Depth          uint8              `json:"depth"` // hops from coordinator in the tree
+}
+
+// neighbor table entry. *Source* sees *Target* with link quality *LQI*
+type TopologyLink struct {
+	Source       zigbee.IEEEAddress   `json:"source"`
+	Target       zigbee.IEEEAddress   `json:"target"`
+	LQI          uint8                `json:"lqi"`
+	Depth        uint8                `json:"depth"`
+	Relationship NeighborRelationship `json:"relationship"`
+}
+
+// routing table entry of *Router*
+type TopologyRoute struct {
+	Router      zigbee.IEEEAddress `json:"router"`
+	Destination string             `json:"destination"` // network address
+	NextHop     string             `json:"next_hop"`    // network address
+	Status      znp.RouteStatus    `json:"status"`
+}
+
+func (t *Topology) Node(address zigbee.IEEEAddress) *TopologyNode {
+	for i := range t.Nodes {
+		if t.Nodes[i].IEEEAddress == address {
+			return &t.Nodes[i]
+		}
+	}
+
+	return nil
+}
+
+// walks the network. failures to scan individual routers are not fatal (they're reported in
+// the result), since a router can be offline
+func (s *Stack) ScanTopology() (*Topology, error) {
+	s.topologyScanning.Lock()
+	defer s.topologyScanning.Unlock()
+
+	topology := &Topology{
+		Scanned: time.Now().UTC(),
+		Nodes:   []TopologyNode{},
+		Links:   []TopologyLink{},
+		Routes:  []TopologyRoute{},
+		Failed:  map[zigbee.IEEEAddress]string{},
+	}
+
+	coordinatorAddress := s.configuration.NetworkConfiguration.IEEEAddress
+
+	topology.Nodes = append(topology.Nodes, TopologyNode{
+		IEEEAddress:    coordinatorAddress,
+		NetworkAddress: zigbee.CoordinatorNwkAddr,
+		LogicalType:    zigbee.LogicalTypeCoordinator,
+		Depth:          0,
+	})
+
+	type router struct {
+		ieeeAddress    zigbee.IEEEAddress
+		networkAddress string
+	}
+
+	queue := []router{{coordinatorAddress, zigbee.CoordinatorNwkAddr}}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		neighbors, err := s.coordinator.NeighborTable(current.networkAddress)
+		if err != nil {
+			if current.ieeeAddress == coordinatorAddress { // can't even start
+				return nil, fmt.Errorf("ScanTopology: %w", err)
+			}
+
+			topology.Failed[current.ieeeAddress] = err.Error()
+			continue
+		}
+
+		for _, neighbor := range neighbors {
+			neighborAddress := zigbee.IEEEAddress(neighbor.ExtendedAddress)
+
+			if !validNeighborAddress(neighborAddress) {
+				continue
+			}
+
+			topology.Links = append(topology.Links, TopologyLink{
+				Source:       current.ieeeAddress,
+				Target:       neighborAddress,
+				LQI:          neighbor.LQI,
+				Depth:        neighbor.Depth,
+				Relationship: NeighborRelationship(neighbor.Relationship),
+			})
+
+			if topology.Node(neighborAddress) != nil { // already seen
+				continue
+			}
+
+			logicalType := zigbee.LogicalType(neighbor.DeviceType)
+
+			topology.Nodes = append(topology.Nodes, TopologyNode{
+				IEEEAddress:    neighborAddress,
+				NetworkAddress: neighbor.NetworkAddress,
+				LogicalType:    logicalType,
+				Depth:          neighbor.Depth,
+			})
+
+			if logicalType == zigbee.LogicalTypeRouter {
+				queue = append(queue, router{neighborAddress, neighbor.NetworkAddress})
+			}
+		}
+
+		// not all routers support Mgmt_Rtg_req, so this isn't considered a failure
+		routes, err := s.coordinator.RoutingTable(current.networkAddress)
+		if err != nil {
+			logl.Debug.Printf("RoutingTable %s: %v", current.ieeeAddress, err)
+			continue
+		}
+
+		for _, route := range routes {
+			topology.Routes = append(topology.Routes, TopologyRoute{
+				Router:      current.ieeeAddress,
+				Destination: route.DestinationAddress,
+				NextHop:     route.NextHop,
+				Status:      route.Status,
+			})
+		}
+	}
+
+	return topology, nil
+}
+
+// some devices report neighbors whose address they don't know as all-zeroes or all-ones
+func validNeighborAddress(address zigbee.IEEEAddress) bool {
+	return address != "0x0000000000000000" && address != "0xffffffffffffffff"
+}
@@ -32,6 +32,9 @@ type Endpoint struct {
 
 	InClusterList  []cluster.ClusterId // input, i.e. what endpoint attributes the device can receive from us
 	OutClusterList []cluster.ClusterId // output, i.e. what endpoint attributes the device can send to us
+
+	MeasurementScaling *MeasurementScaling `json:",omitempty"` // only for metering endpoints (e.g. smart plugs)
+	IasZone            *IasZone            `json:",omitempty"` // only for alarm sensor endpoints
 }
 
 type DeviceIncomingMessage struct {
@@ -63,6 +66,20 @@ type Device struct {
 	Endpoints      []*Endpoint
 }
 
+// OTA client (= the device) is the endpoint that has OTA in its output clusters. nil if the
+// device doesn't support OTA updates
+func (d *Device) OtaClientEndpoint() *Endpoint {
+	for _, endpoint := range d.Endpoints {
+		for _, clusterId := range endpoint.OutClusterList {
+			if clusterId == cluster.IdGenOta {
+				return endpoint
+			}
+		}
+	}
+
+	return nil
+}
+
 var powerSourceStrings = map[PowerSource]string{
 	Unknown:                         "Unknown",
 	MainsSinglePhase:                "MainsSinglePhase",
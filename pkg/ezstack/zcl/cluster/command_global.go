@@ -81,11 +81,18 @@ type AttributeReportingConfigurationRecord struct {
 	Direction                ReportDirection
 	AttributeName            string `transient:"true"`
 	AttributeID              uint16
-	AttributeDataType        ZclDataType `cond:"uint:Direction==0"` // specified by the attribute
-	MinimumReportingInterval uint16      `cond:"uint:Direction==0"` // [s]
-	MaximumReportingInterval uint16      `cond:"uint:Direction==0"` // [s]
-	ReportableChange         *Attribute  `cond:"uint:Direction==0"` // [in units of the attribute]
-	TimeoutPeriod            uint16      `cond:"uint:Direction==1"`
+	AttributeDataType        ZclDataType       `cond:"uint:Direction==0"` // specified by the attribute
+	MinimumReportingInterval uint16            `cond:"uint:Direction==0"` // [s]
+	MaximumReportingInterval uint16            `cond:"uint:Direction==0"` // [s]
+	ReportableChange         *ReportableChange `cond:"uint:Direction==0"` // [in units of the attribute]. nil for discrete data types
+	TimeoutPeriod            uint16            `cond:"uint:Direction==1"`
+}
+
+// like Attribute, but serialized without the data type (it's implied by the record's AttributeDataType).
+// "If the data type of the attribute is discrete, this field is omitted."
+type ReportableChange struct {
+	DataType ZclDataType
+	Value    interface{}
 }
 
 type ConfigureReportingCommand struct {
@@ -244,8 +251,23 @@ func (a *Attribute) Serialize(w io.Writer) {
 	c.Flush()
 }
 
+// WARNING: this is called magically from dyrkin/bin
+func (r *ReportableChange) Serialize(w io.Writer) {
+	if r == nil { // omitted for discrete data types
+		return
+	}
+
+	c := composer.NewWithW(w)
+	writeAttributeValue(c, r.DataType, r.Value)
+	c.Flush()
+}
+
 func writeAttribute(c *composer.Composer, dataType ZclDataType, value interface{}) {
 	c.Uint8(uint8(dataType))
+	writeAttributeValue(c, dataType, value)
+}
+
+func writeAttributeValue(c *composer.Composer, dataType ZclDataType, value interface{}) {
 	switch dataType {
 	case ZclDataTypeNoData:
 	case ZclDataTypeData8:
@@ -1,5 +1,7 @@
 package cluster
 
+import "time"
+
 // Local command is cluster-specific command and its arguments.
 //
 // E.G. CommandClusterAndId=(cluster=On/Off command=Off With Effect) and its arguments
@@ -8,6 +10,12 @@ type LocalCommand interface {
 	CommandClusterAndId() (ClusterId, uint8)
 }
 
+// response of a command that has a response of its own (instead of DefaultResponse), but which
+// (like DefaultResponse) only tells whether the command succeeded
+type StatusResponse interface {
+	ResponseStatus() ZclStatus
+}
+
 // These struct fields, their order and their sizes are defined by ZCL spec.
 // E.G. for *TriggerEffectCommand* see ZCL spec section 3.5.2.3.3
 //
@@ -52,6 +60,69 @@ type IdentifyQueryResponse struct {
 	Timeout uint16
 }
 
+// -------- Cluster: Groups --------
+
+// ZCL spec section: 3.6.2.3.2
+type GenGroupsAddGroupCommand struct {
+	GroupId   uint16
+	GroupName string `size:"1"` // usually empty, because devices rarely support group names
+}
+
+func (c *GenGroupsAddGroupCommand) CommandClusterAndId() (ClusterId, uint8) {
+	return IdGenGroups, 0x00
+}
+
+var _ LocalCommand = (*GenGroupsAddGroupCommand)(nil)
+
+// ZCL spec section: 3.6.2.3.4
+type GenGroupsGetGroupMembershipCommand struct {
+	GroupList []uint16 `size:"1"` // empty list = ask for all groups the endpoint is member of
+}
+
+func (c *GenGroupsGetGroupMembershipCommand) CommandClusterAndId() (ClusterId, uint8) {
+	return IdGenGroups, 0x02
+}
+
+var _ LocalCommand = (*GenGroupsGetGroupMembershipCommand)(nil)
+
+// ZCL spec section: 3.6.2.3.5
+type GenGroupsRemoveGroupCommand struct {
+	GroupId uint16
+}
+
+func (c *GenGroupsRemoveGroupCommand) CommandClusterAndId() (ClusterId, uint8) {
+	return IdGenGroups, 0x03
+}
+
+var _ LocalCommand = (*GenGroupsRemoveGroupCommand)(nil)
+
+// ZCL spec section: 3.6.2.3.6
+type GenGroupsRemoveAllGroupsCommand struct{}
+
+func (c *GenGroupsRemoveAllGroupsCommand) CommandClusterAndId() (ClusterId, uint8) {
+	return IdGenGroups, 0x04
+}
+
+var _ LocalCommand = (*GenGroupsRemoveAllGroupsCommand)(nil)
+
+// ZCL spec section: 3.6.2.4.1
+type GenGroupsAddGroupResponse struct {
+	Status  ZclStatus
+	GroupId uint16
+}
+
+// ZCL spec section: 3.6.2.4.3
+type GenGroupsGetGroupMembershipResponse struct {
+	Capacity  uint8    // how many more groups the device can be added to. 0xff = unknown, 0xfe = at least one
+	GroupList []uint16 `size:"1"`
+}
+
+// ZCL spec section: 3.6.2.4.4
+type GenGroupsRemoveGroupResponse struct {
+	Status  ZclStatus
+	GroupId uint16
+}
+
 // -------- Cluster: GenOnOff --------
 
 // ZCL spec section: 3.8.2.3.1
@@ -215,6 +286,120 @@ func (c *LightingColorCtrlMoveToColorTemperature) CommandClusterAndId() (Cluster
 
 var _ LocalCommand = (*LightingColorCtrlMoveToColorTemperature)(nil)
 
+// -------- Cluster: Ota --------
+
+// OTA cluster is special in that the device is the client and we are the server. therefore the
+// requests come from the device and we send the responses (= they're "generated" commands)
+
+const (
+	OtaQueryJitterMax = 100 // all devices that receive ImageNotify shall respond
+)
+
+// ZCL spec section: 11.13.3
+type GenOtaImageNotifyCommand struct {
+	PayloadType      OtaImageNotifyPayloadType
+	QueryJitter      uint8
+	ManufacturerCode uint16 `cond:"uint:PayloadType!=0"`
+	ImageType        uint16 `cond:"uint:PayloadType!=0;uint:PayloadType!=1"`
+	NewFileVersion   uint32 `cond:"uint:PayloadType==3"`
+}
+
+type OtaImageNotifyPayloadType uint8
+
+const (
+	OtaImageNotifyJitter                             OtaImageNotifyPayloadType = 0x00
+	OtaImageNotifyJitterManufacturer                 OtaImageNotifyPayloadType = 0x01
+	OtaImageNotifyJitterManufacturerImageType        OtaImageNotifyPayloadType = 0x02
+	OtaImageNotifyJitterManufacturerImageTypeVersion OtaImageNotifyPayloadType = 0x03
+)
+
+func (c *GenOtaImageNotifyCommand) CommandClusterAndId() (ClusterId, uint8) {
+	return IdGenOta, 0x00
+}
+
+var _ LocalCommand = (*GenOtaImageNotifyCommand)(nil)
+
+// ZCL spec section: 11.13.4
+type GenOtaQueryNextImageRequest struct {
+	FieldControl       uint8 // bit 0 = hardware version present
+	ManufacturerCode   uint16
+	ImageType          uint16
+	CurrentFileVersion uint32
+	HardwareVersion    uint16 `cond:"uint:FieldControl==1"`
+}
+
+// ZCL spec section: 11.13.5
+type GenOtaQueryNextImageResponse struct {
+	Status           ZclStatus
+	ManufacturerCode uint16 `cond:"uint:Status==0"`
+	ImageType        uint16 `cond:"uint:Status==0"`
+	FileVersion      uint32 `cond:"uint:Status==0"`
+	ImageSize        uint32 `cond:"uint:Status==0"`
+}
+
+func (c *GenOtaQueryNextImageResponse) CommandClusterAndId() (ClusterId, uint8) {
+	return IdGenOta, 0x02
+}
+
+var _ LocalCommand = (*GenOtaQueryNextImageResponse)(nil)
+
+// ZCL spec section: 11.13.6
+//
+// optional request node address and minimum block period may follow, but we don't need them
+type GenOtaImageBlockRequest struct {
+	FieldControl     uint8
+	ManufacturerCode uint16
+	ImageType        uint16
+	FileVersion      uint32
+	FileOffset       uint32
+	MaximumDataSize  uint8
+}
+
+// ZCL spec section: 11.13.8
+//
+// status: success (fields up to ImageData present) or WaitForData (wait-related fields present)
+type GenOtaImageBlockResponse struct {
+	Status           ZclStatus
+	ManufacturerCode uint16 `cond:"uint:Status==0"`
+	ImageType        uint16 `cond:"uint:Status==0"`
+	FileVersion      uint32 `cond:"uint:Status==0"`
+	FileOffset       uint32 `cond:"uint:Status==0"`
+	ImageData        []byte `cond:"uint:Status==0" size:"1"`
+
+	CurrentTime        uint32 `cond:"uint:Status==151"` // UTC seconds. 0 = use relative RequestTime
+	RequestTime        uint32 `cond:"uint:Status==151"` // relative, if CurrentTime==0
+	MinimumBlockPeriod uint16 `cond:"uint:Status==151"` // [ms]
+}
+
+func (c *GenOtaImageBlockResponse) CommandClusterAndId() (ClusterId, uint8) {
+	return IdGenOta, 0x05
+}
+
+var _ LocalCommand = (*GenOtaImageBlockResponse)(nil)
+
+// ZCL spec section: 11.13.9
+type GenOtaUpgradeEndRequest struct {
+	Status           ZclStatus // success = image downloaded & verified
+	ManufacturerCode uint16
+	ImageType        uint16
+	FileVersion      uint32
+}
+
+// ZCL spec section: 11.13.10
+type GenOtaUpgradeEndResponse struct {
+	ManufacturerCode uint16
+	ImageType        uint16
+	FileVersion      uint32
+	CurrentTime      uint32 // 0 = relative UpgradeTime
+	UpgradeTime      uint32 // 0 (relative) = upgrade now
+}
+
+func (c *GenOtaUpgradeEndResponse) CommandClusterAndId() (ClusterId, uint8) {
+	return IdGenOta, 0x07
+}
+
+var _ LocalCommand = (*GenOtaUpgradeEndResponse)(nil)
+
 // -------- Cluster: IdClosuresWindowCovering --------
 
 // ZCL spec section: 7.4.2.2.1
@@ -255,6 +440,80 @@ func (c *ClosuresWindowCoveringGoToLiftPercentage) CommandClusterAndId() (Cluste
 
 var _ LocalCommand = (*ClosuresWindowCoveringGoToLiftPercentage)(nil)
 
+// ZCL spec section: 7.4.2.2.7
+type ClosuresWindowCoveringGoToTiltPercentage struct {
+	Value uint8 // 0-100
+}
+
+func (c *ClosuresWindowCoveringGoToTiltPercentage) CommandClusterAndId() (ClusterId, uint8) {
+	return IdClosuresWindowCovering, 0x08
+}
+
+var _ LocalCommand = (*ClosuresWindowCoveringGoToTiltPercentage)(nil)
+
+// -------- Cluster: IdClosuresDoorLock --------
+
+// PIN codes are optional for lock & unlock, depending on the lock's requirePinForRfOperation
+
+// ZCL spec section: 7.3.2.16.1
+type ClosuresDoorLockLockDoor struct {
+	PinCode string `size:"1"`
+}
+
+func (c *ClosuresDoorLockLockDoor) CommandClusterAndId() (ClusterId, uint8) {
+	return IdClosuresDoorLock, 0x00
+}
+
+var _ LocalCommand = (*ClosuresDoorLockLockDoor)(nil)
+
+// ZCL spec section: 7.3.2.16.2
+type ClosuresDoorLockUnlockDoor struct {
+	PinCode string `size:"1"`
+}
+
+func (c *ClosuresDoorLockUnlockDoor) CommandClusterAndId() (ClusterId, uint8) {
+	return IdClosuresDoorLock, 0x01
+}
+
+var _ LocalCommand = (*ClosuresDoorLockUnlockDoor)(nil)
+
+// ZCL spec section: 7.3.2.16.6
+type ClosuresDoorLockSetPinCode struct {
+	UserId     uint16
+	UserStatus DoorLockUserStatus
+	UserType   DoorLockUserType
+	PinCode    string `size:"1"`
+}
+
+func (c *ClosuresDoorLockSetPinCode) CommandClusterAndId() (ClusterId, uint8) {
+	return IdClosuresDoorLock, 0x05
+}
+
+var _ LocalCommand = (*ClosuresDoorLockSetPinCode)(nil)
+
+// ZCL spec section: 7.3.2.16.8
+type ClosuresDoorLockClearPinCode struct {
+	UserId uint16
+}
+
+func (c *ClosuresDoorLockClearPinCode) CommandClusterAndId() (ClusterId, uint8) {
+	return IdClosuresDoorLock, 0x07
+}
+
+var _ LocalCommand = (*ClosuresDoorLockClearPinCode)(nil)
+
+// response to lock, unlock and PIN code commands (they have distinct command IDs, but the same
+// payload). ZCL spec section: 7.3.2.17
+type ClosuresDoorLockStatusResponse struct {
+	Status ZclStatus // only success (0) or failure (1)
+}
+
+func (c *ClosuresDoorLockStatusResponse) ResponseStatus() ZclStatus {
+	return c.Status
+}
+
+var _ StatusResponse = (*ClosuresDoorLockStatusResponse)(nil)
+
 // -------- Cluster: Scenes --------
 
 // undocumented in ZCL spec...........
@@ -273,8 +532,25 @@ func (c *ScenesMysteryCommand7) Left() bool {
 
 var _ LocalCommand = (*ScenesMysteryCommand7)(nil)
 
-// the following are "generated" commands (end device -> coordinator direction)
+// -------- Cluster: IdSsIasZone --------
+
+// IAS (Intruder Alarm Systems) zone devices are alarm sensors (smoke, water, contact etc.). the device
+// is the server and we're the client ("CIE" = Control and Indicating Equipment), so the notifications
+// and enroll requests from the device are "generated" commands
+
+// ZCL spec section: 8.2.2.3.1
+type ZoneEnrollResponseCommand struct {
+	EnrollResponseCode IasZoneEnrollResponseCode
+	ZoneID             uint8
+}
+
+func (c *ZoneEnrollResponseCommand) CommandClusterAndId() (ClusterId, uint8) {
+	return IdSsIasZone, 0x00
+}
+
+var _ LocalCommand = (*ZoneEnrollResponseCommand)(nil)
 
+// ZCL spec section: 8.2.2.4.1
 type ZoneStatusChangeNotificationCommand struct {
 	ZoneStatus     ZoneStatus
 	ExtendedStatus uint8 // "SHALL be set to zero"
@@ -282,13 +558,105 @@ type ZoneStatusChangeNotificationCommand struct {
 	Delay          uint16
 }
 
+func (c *ZoneStatusChangeNotificationCommand) CommandClusterAndId() (ClusterId, uint8) {
+	return IdSsIasZone, 0x00
+}
+
+var _ LocalCommand = (*ZoneStatusChangeNotificationCommand)(nil)
+
+// ZCL spec section: 8.2.2.4.2
+type ZoneEnrollRequestCommand struct {
+	ZoneType         IasZoneType
+	ManufacturerCode uint16
+}
+
+func (c *ZoneEnrollRequestCommand) CommandClusterAndId() (ClusterId, uint8) {
+	return IdSsIasZone, 0x01
+}
+
+var _ LocalCommand = (*ZoneEnrollRequestCommand)(nil)
+
 // ZCL spec section: 8.2.2.2.1.3
 type ZoneStatus uint16
 
+// meaning depends on zone type (e.g. "fire" for a fire sensor)
 func (z ZoneStatus) Alarm1() bool {
 	return z&0b1 != 0
 }
 
+func (z ZoneStatus) Alarm2() bool {
+	return z&0b10 != 0
+}
+
+func (z ZoneStatus) Tamper() bool {
+	return z&0b100 != 0
+}
+
+func (z ZoneStatus) BatteryLow() bool {
+	return z&0b1000 != 0
+}
+
 func (z ZoneStatus) UnimplementedBitsSet() bool {
 	return z|0b1 != 1
 }
+
+// -------- Cluster: IdSsIasWd --------
+
+// IAS WD = warning device, i.e. a siren
+
+// ZCL spec section: 8.4.2.3.1
+type SsIasWdStartWarning struct {
+	WarningInfo     uint8  // bits 4-7: WarningMode, bits 2-3: strobe, bits 0-1: SirenLevel
+	WarningDuration uint16 // [s]
+	StrobeDutyCycle uint8  // [0-100 %] in steps of 10
+	StrobeLevel     SirenLevel
+}
+
+func NewSsIasWdStartWarning(mode WarningMode, level SirenLevel, strobe bool, duration time.Duration) *SsIasWdStartWarning {
+	strobeDutyCycle := uint8(0)
+	if strobe {
+		strobeDutyCycle = 50
+	}
+
+	return &SsIasWdStartWarning{
+		WarningInfo:     uint8(mode)<<4 | boolToBit(strobe)<<2 | uint8(level),
+		WarningDuration: uint16(duration.Seconds()),
+		StrobeDutyCycle: strobeDutyCycle,
+		StrobeLevel:     SirenLevelLow,
+	}
+}
+
+func (c *SsIasWdStartWarning) Mode() WarningMode {
+	return WarningMode(c.WarningInfo >> 4)
+}
+
+func (c *SsIasWdStartWarning) CommandClusterAndId() (ClusterId, uint8) {
+	return IdSsIasWd, 0x00
+}
+
+var _ LocalCommand = (*SsIasWdStartWarning)(nil)
+
+// ZCL spec section: 8.4.2.3.2
+type SsIasWdSquawk struct {
+	SquawkInfo uint8 // bits 4-7: SquawkMode, bit 3: strobe, bits 0-1: SirenLevel
+}
+
+func NewSsIasWdSquawk(mode SquawkMode, level SirenLevel, strobe bool) *SsIasWdSquawk {
+	return &SsIasWdSquawk{
+		SquawkInfo: uint8(mode)<<4 | boolToBit(strobe)<<3 | uint8(level),
+	}
+}
+
+func (c *SsIasWdSquawk) CommandClusterAndId() (ClusterId, uint8) {
+	return IdSsIasWd, 0x01
+}
+
+var _ LocalCommand = (*SsIasWdSquawk)(nil)
+
+func boolToBit(b bool) uint8 {
+	if b {
+		return 1
+	} else {
+		return 0
+	}
+}
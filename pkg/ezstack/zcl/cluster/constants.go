@@ -63,6 +63,21 @@ const (
 	ZclDataTypeUnknown       ZclDataType = 0xff
 )
 
+// analog data types are the ones where "change" makes sense (e.g. temperature changed by 0.5 °C),
+// as opposed to discrete (booleans, enums, bitmaps ..)
+func (z ZclDataType) IsAnalog() bool {
+	switch {
+	case z >= ZclDataTypeUint8 && z <= ZclDataTypeInt64:
+		return true
+	case z >= ZclDataTypeSemiPrec && z <= ZclDataTypeDoublePrec:
+		return true
+	case z >= ZclDataTypeTod && z <= ZclDataTypeUtc:
+		return true
+	default:
+		return false
+	}
+}
+
 type ZclStatus uint8
 
 const (
@@ -148,3 +163,283 @@ const (
 	ReportDirectionAttributeReported ReportDirection = 0x00
 	ReportDirectionAttributeReceived ReportDirection = 0x01
 )
+
+// hvacThermostat.systemMode. ZCL spec section: 6.3.2.2.2.9
+type ThermostatSystemMode uint8
+
+const (
+	ThermostatSystemModeOff              ThermostatSystemMode = 0x00
+	ThermostatSystemModeAuto             ThermostatSystemMode = 0x01
+	ThermostatSystemModeCool             ThermostatSystemMode = 0x03
+	ThermostatSystemModeHeat             ThermostatSystemMode = 0x04
+	ThermostatSystemModeEmergencyHeating ThermostatSystemMode = 0x05
+	ThermostatSystemModePrecooling       ThermostatSystemMode = 0x06
+	ThermostatSystemModeFanOnly          ThermostatSystemMode = 0x07
+	ThermostatSystemModeDry              ThermostatSystemMode = 0x08
+	ThermostatSystemModeSleep            ThermostatSystemMode = 0x09
+)
+
+// names are the ones zigbee2mqtt (and therefore Home Assistant) uses
+var thermostatSystemModeNames = map[ThermostatSystemMode]string{
+	ThermostatSystemModeOff:              "off",
+	ThermostatSystemModeAuto:             "auto",
+	ThermostatSystemModeCool:             "cool",
+	ThermostatSystemModeHeat:             "heat",
+	ThermostatSystemModeEmergencyHeating: "emergency_heating",
+	ThermostatSystemModePrecooling:       "precooling",
+	ThermostatSystemModeFanOnly:          "fan_only",
+	ThermostatSystemModeDry:              "dry",
+	ThermostatSystemModeSleep:            "sleep",
+}
+
+func (t ThermostatSystemMode) String() string {
+	if name, found := thermostatSystemModeNames[t]; found {
+		return name
+	}
+
+	return fmt.Sprintf("unknown(%d)", t)
+}
+
+// "heat" => ThermostatSystemModeHeat
+func ThermostatSystemModeFromString(name string) (ThermostatSystemMode, error) {
+	for mode, candidate := range thermostatSystemModeNames {
+		if candidate == name {
+			return mode, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown thermostat system mode: %s", name)
+}
+
+// ssIasZone.zoneType. tells what the alarm (ZoneStatus.Alarm1()) of an IAS zone device means.
+// ZCL spec section: 8.2.2.2.1.2
+type IasZoneType uint16
+
+const (
+	IasZoneTypeStandardCIE             IasZoneType = 0x0000
+	IasZoneTypeMotionSensor            IasZoneType = 0x000d
+	IasZoneTypeContactSwitch           IasZoneType = 0x0015
+	IasZoneTypeFireSensor              IasZoneType = 0x0028
+	IasZoneTypeWaterSensor             IasZoneType = 0x002a
+	IasZoneTypeCarbonMonoxideSensor    IasZoneType = 0x002b
+	IasZoneTypePersonalEmergencyDevice IasZoneType = 0x002c
+	IasZoneTypeVibrationMovementSensor IasZoneType = 0x002d
+	IasZoneTypeRemoteControl           IasZoneType = 0x010f
+	IasZoneTypeKeyFob                  IasZoneType = 0x0115
+	IasZoneTypeKeypad                  IasZoneType = 0x021d
+	IasZoneTypeStandardWarningDevice   IasZoneType = 0x0225
+	IasZoneTypeGlassBreakSensor        IasZoneType = 0x0226
+	IasZoneTypeSecurityRepeater        IasZoneType = 0x0229
+)
+
+func (i IasZoneType) String() string {
+	return fmt.Sprintf("0x%04x", uint16(i))
+}
+
+// ZCL spec section: 8.2.2.3.1.1
+type IasZoneEnrollResponseCode uint8
+
+const (
+	IasZoneEnrollResponseCodeSuccess        IasZoneEnrollResponseCode = 0x00
+	IasZoneEnrollResponseCodeNotSupported   IasZoneEnrollResponseCode = 0x01
+	IasZoneEnrollResponseCodeNoEnrollPermit IasZoneEnrollResponseCode = 0x02
+	IasZoneEnrollResponseCodeTooManyZones   IasZoneEnrollResponseCode = 0x03
+)
+
+// IAS WD (siren) warning mode. ZCL spec section: 8.4.2.3.1.1
+type WarningMode uint8
+
+const (
+	WarningModeStop           WarningMode = 0
+	WarningModeBurglar        WarningMode = 1
+	WarningModeFire           WarningMode = 2
+	WarningModeEmergency      WarningMode = 3
+	WarningModePolicePanic    WarningMode = 4
+	WarningModeFirePanic      WarningMode = 5
+	WarningModeEmergencyPanic WarningMode = 6
+)
+
+var warningModeNames = map[WarningMode]string{
+	WarningModeStop:           "stop",
+	WarningModeBurglar:        "burglar",
+	WarningModeFire:           "fire",
+	WarningModeEmergency:      "emergency",
+	WarningModePolicePanic:    "police_panic",
+	WarningModeFirePanic:      "fire_panic",
+	WarningModeEmergencyPanic: "emergency_panic",
+}
+
+func (w WarningMode) String() string {
+	if name, found := warningModeNames[w]; found {
+		return name
+	}
+
+	return fmt.Sprintf("unknown(%d)", w)
+}
+
+// "burglar" => WarningModeBurglar
+func WarningModeFromString(name string) (WarningMode, error) {
+	for mode, candidate := range warningModeNames {
+		if candidate == name {
+			return mode, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown warning mode: %s", name)
+}
+
+// IAS WD siren (and strobe) level. ZCL spec section: 8.4.2.3.1.3
+type SirenLevel uint8
+
+const (
+	SirenLevelLow      SirenLevel = 0
+	SirenLevelMedium   SirenLevel = 1
+	SirenLevelHigh     SirenLevel = 2
+	SirenLevelVeryHigh SirenLevel = 3
+)
+
+var sirenLevelNames = map[SirenLevel]string{
+	SirenLevelLow:      "low",
+	SirenLevelMedium:   "medium",
+	SirenLevelHigh:     "high",
+	SirenLevelVeryHigh: "very_high",
+}
+
+func (s SirenLevel) String() string {
+	if name, found := sirenLevelNames[s]; found {
+		return name
+	}
+
+	return fmt.Sprintf("unknown(%d)", s)
+}
+
+// "high" => SirenLevelHigh
+func SirenLevelFromString(name string) (SirenLevel, error) {
+	for level, candidate := range sirenLevelNames {
+		if candidate == name {
+			return level, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown siren level: %s", name)
+}
+
+// IAS WD squawk, i.e. a short beep to acknowledge (dis)arming. ZCL spec section: 8.4.2.3.2.1
+type SquawkMode uint8
+
+const (
+	SquawkModeSystemArmed    SquawkMode = 0
+	SquawkModeSystemDisarmed SquawkMode = 1
+)
+
+var squawkModeNames = map[SquawkMode]string{
+	SquawkModeSystemArmed:    "system_is_armed",
+	SquawkModeSystemDisarmed: "system_is_disarmed",
+}
+
+func (s SquawkMode) String() string {
+	if name, found := squawkModeNames[s]; found {
+		return name
+	}
+
+	return fmt.Sprintf("unknown(%d)", s)
+}
+
+// "system_is_armed" => SquawkModeSystemArmed
+func SquawkModeFromString(name string) (SquawkMode, error) {
+	for mode, candidate := range squawkModeNames {
+		if candidate == name {
+			return mode, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown squawk mode: %s", name)
+}
+
+// closuresDoorLock.lockState. ZCL spec section: 7.3.2.2.1
+type DoorLockState uint8
+
+const (
+	DoorLockStateNotFullyLocked DoorLockState = 0x00
+	DoorLockStateLocked         DoorLockState = 0x01
+	DoorLockStateUnlocked       DoorLockState = 0x02
+	DoorLockStateUndefined      DoorLockState = 0xff
+)
+
+// names are the ones zigbee2mqtt uses
+var doorLockStateNames = map[DoorLockState]string{
+	DoorLockStateNotFullyLocked: "not_fully_locked",
+	DoorLockStateLocked:         "locked",
+	DoorLockStateUnlocked:       "unlocked",
+}
+
+func (d DoorLockState) String() string {
+	if name, found := doorLockStateNames[d]; found {
+		return name
+	}
+
+	return fmt.Sprintf("unknown(%d)", d)
+}
+
+// ZCL spec section: 7.3.2.16.6
+type DoorLockUserStatus uint8
+
+const (
+	DoorLockUserStatusAvailable       DoorLockUserStatus = 0x00
+	DoorLockUserStatusOccupiedEnabled DoorLockUserStatus = 0x01
+	DoorLockUserStatusOccupiedDisable DoorLockUserStatus = 0x03
+)
+
+// ZCL spec section: 7.3.2.16.6
+type DoorLockUserType uint8
+
+const (
+	DoorLockUserTypeUnrestricted DoorLockUserType = 0x00
+	DoorLockUserTypeYearDay      DoorLockUserType = 0x01
+	DoorLockUserTypeWeekDay      DoorLockUserType = 0x02
+	DoorLockUserTypeMaster       DoorLockUserType = 0x03
+	DoorLockUserTypeNonAccess    DoorLockUserType = 0x04
+)
+
+// hvacFanCtrl.fanMode. ZCL spec section: 6.4.2.2.1
+type FanMode uint8
+
+const (
+	FanModeOff    FanMode = 0x00
+	FanModeLow    FanMode = 0x01
+	FanModeMedium FanMode = 0x02
+	FanModeHigh   FanMode = 0x03
+	FanModeOn     FanMode = 0x04
+	FanModeAuto   FanMode = 0x05
+	FanModeSmart  FanMode = 0x06
+)
+
+// names are the ones zigbee2mqtt (and therefore Home Assistant) uses
+var fanModeNames = map[FanMode]string{
+	FanModeOff:    "off",
+	FanModeLow:    "low",
+	FanModeMedium: "medium",
+	FanModeHigh:   "high",
+	FanModeOn:     "on",
+	FanModeAuto:   "auto",
+	FanModeSmart:  "smart",
+}
+
+func (f FanMode) String() string {
+	if name, found := fanModeNames[f]; found {
+		return name
+	}
+
+	return fmt.Sprintf("unknown(%d)", f)
+}
+
+// "high" => FanModeHigh
+func FanModeFromString(name string) (FanMode, error) {
+	for mode, candidate := range fanModeNames {
+		if candidate == name {
+			return mode, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown fan mode: %s", name)
+}
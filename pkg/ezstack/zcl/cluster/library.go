@@ -4,6 +4,17 @@ func FindDefinition(id ClusterId) *Definition {
 	return definitionLibrary[id]
 }
 
+// "genOnOff" => IdGenOnOff
+func FindDefinitionByName(name string) (ClusterId, *Definition) {
+	for id, definition := range definitionLibrary {
+		if definition.name == name {
+			return id, definition
+		}
+	}
+
+	return 0, nil
+}
+
 type CommandDescriptor struct {
 	Name    string
 	Command interface{}
@@ -139,6 +150,25 @@ func NewClusterLibrary() *ClusterLibrary {
 					},
 				},
 			},
+			IdGenGroups: {
+				Name: "Groups",
+				AttributeDescriptors: map[uint16]*AttributeDescriptor{
+					0x0000: {"NameSupport", ZclDataTypeBitmap8, Read},
+				},
+				CommandDescriptors: &CommandDescriptors{
+					Received: map[uint8]*CommandDescriptor{
+						0x00: {"AddGroup", &GenGroupsAddGroupCommand{}},
+						0x02: {"GetGroupMembership", &GenGroupsGetGroupMembershipCommand{}},
+						0x03: {"RemoveGroup", &GenGroupsRemoveGroupCommand{}},
+						0x04: {"RemoveAllGroups", &GenGroupsRemoveAllGroupsCommand{}},
+					},
+					Generated: map[uint8]*CommandDescriptor{
+						0x00: {"AddGroupResponse", &GenGroupsAddGroupResponse{}},
+						0x02: {"GetGroupMembershipResponse", &GenGroupsGetGroupMembershipResponse{}},
+						0x03: {"RemoveGroupResponse", &GenGroupsRemoveGroupResponse{}},
+					},
+				},
+			},
 			IdGenScenes: {
 				Name:                 "Scenes",
 				AttributeDescriptors: map[uint16]*AttributeDescriptor{},
@@ -219,6 +249,19 @@ func NewClusterLibrary() *ClusterLibrary {
 					0x0009: {"MinimumBlockPeriod ", ZclDataTypeUint16, Read},
 					0x000a: {"ImageStamp ", ZclDataTypeUint32, Read},
 				},
+				CommandDescriptors: &CommandDescriptors{
+					Received: map[uint8]*CommandDescriptor{
+						0x01: {"QueryNextImageRequest", &GenOtaQueryNextImageRequest{}},
+						0x03: {"ImageBlockRequest", &GenOtaImageBlockRequest{}},
+						0x06: {"UpgradeEndRequest", &GenOtaUpgradeEndRequest{}},
+					},
+					Generated: map[uint8]*CommandDescriptor{
+						0x00: {"ImageNotify", &GenOtaImageNotifyCommand{}},
+						0x02: {"QueryNextImageResponse", &GenOtaQueryNextImageResponse{}},
+						0x05: {"ImageBlockResponse", &GenOtaImageBlockResponse{}},
+						0x07: {"UpgradeEndResponse", &GenOtaUpgradeEndResponse{}},
+					},
+				},
 			},
 			IdClosuresWindowCovering: {
 				Name:                 "Window Covering",
@@ -231,16 +274,48 @@ func NewClusterLibrary() *ClusterLibrary {
 					Generated: map[uint8]*CommandDescriptor{},
 				},
 			},
+			IdClosuresDoorLock: {
+				Name:                 "Door Lock",
+				AttributeDescriptors: map[uint16]*AttributeDescriptor{},
+				CommandDescriptors: &CommandDescriptors{
+					Received: map[uint8]*CommandDescriptor{
+						0x00: {"LockDoor", &ClosuresDoorLockLockDoor{}},
+						0x01: {"UnlockDoor", &ClosuresDoorLockUnlockDoor{}},
+						0x05: {"SetPinCode", &ClosuresDoorLockSetPinCode{}},
+						0x07: {"ClearPinCode", &ClosuresDoorLockClearPinCode{}},
+					},
+					Generated: map[uint8]*CommandDescriptor{
+						0x00: {"LockDoorResponse", &ClosuresDoorLockStatusResponse{}},
+						0x01: {"UnlockDoorResponse", &ClosuresDoorLockStatusResponse{}},
+						0x05: {"SetPinCodeResponse", &ClosuresDoorLockStatusResponse{}},
+						0x07: {"ClearPinCodeResponse", &ClosuresDoorLockStatusResponse{}},
+					},
+				},
+			},
 			IdSsIasZone: { // SS = Security and Safety
 				Name:                 "IAS Zone",
 				AttributeDescriptors: map[uint16]*AttributeDescriptor{},
 				CommandDescriptors: &CommandDescriptors{
-					Received: map[uint8]*CommandDescriptor{},
+					Received: map[uint8]*CommandDescriptor{
+						0x00: {"ZoneEnrollResponse", &ZoneEnrollResponseCommand{}},
+					},
 					Generated: map[uint8]*CommandDescriptor{
 						0x00: {"ZoneStatusChangeNotification", &ZoneStatusChangeNotificationCommand{}},
+						0x01: {"ZoneEnrollRequest", &ZoneEnrollRequestCommand{}},
 					},
 				},
 			},
+			IdSsIasWd: { // WD = Warning Device
+				Name:                 "IAS WD",
+				AttributeDescriptors: map[uint16]*AttributeDescriptor{},
+				CommandDescriptors: &CommandDescriptors{
+					Received: map[uint8]*CommandDescriptor{
+						0x00: {"StartWarning", &SsIasWdStartWarning{}},
+						0x01: {"Squawk", &SsIasWdSquawk{}},
+					},
+					Generated: map[uint8]*CommandDescriptor{},
+				},
+			},
 		},
 	}
 }
@@ -15,6 +15,17 @@ func (d *Definition) Attribute(id AttributeId) *AttributeDescriptor {
 	return d.attributes[id]
 }
 
+// "onOff" => 0x0000
+func (d *Definition) AttributeByName(name string) (AttributeId, *AttributeDescriptor) {
+	for id, attribute := range d.attributes {
+		if attribute.Name == name {
+			return id, attribute
+		}
+	}
+
+	return 0, nil
+}
+
 type Access uint8
 
 const (
@@ -39,6 +50,39 @@ const (
 	AttrBasicManufacturerName AttributeId = 4
 	AttrBasicModelId          AttributeId = 5
 	AttrBasicPowerSource      AttributeId = 7
+
+	AttrSeMeteringCurrentSummDelivered AttributeId = 0x0000
+	AttrSeMeteringMultiplier           AttributeId = 0x0301
+	AttrSeMeteringDivisor              AttributeId = 0x0302
+	AttrSeMeteringInstantaneousDemand  AttributeId = 0x0400
+
+	AttrHaElectricalMeasurementRmsVoltage          AttributeId = 0x0505
+	AttrHaElectricalMeasurementRmsCurrent          AttributeId = 0x0508
+	AttrHaElectricalMeasurementActivePower         AttributeId = 0x050b
+	AttrHaElectricalMeasurementAcVoltageMultiplier AttributeId = 0x0600
+	AttrHaElectricalMeasurementAcVoltageDivisor    AttributeId = 0x0601
+	AttrHaElectricalMeasurementAcCurrentMultiplier AttributeId = 0x0602
+	AttrHaElectricalMeasurementAcCurrentDivisor    AttributeId = 0x0603
+	AttrHaElectricalMeasurementAcPowerMultiplier   AttributeId = 0x0604
+	AttrHaElectricalMeasurementAcPowerDivisor      AttributeId = 0x0605
+
+	AttrHvacThermostatLocalTemp               AttributeId = 0x0000
+	AttrHvacThermostatPIHeatingDemand         AttributeId = 0x0008
+	AttrHvacThermostatOccupiedHeatingSetpoint AttributeId = 0x0012
+	AttrHvacThermostatSystemMode              AttributeId = 0x001c
+	AttrHvacThermostatRunningState            AttributeId = 0x0029
+
+	AttrClosuresDoorLockLockState AttributeId = 0x0000
+
+	AttrClosuresWindowCoveringCurrentPositionTiltPercentage AttributeId = 0x0009
+
+	AttrHvacFanCtrlFanMode AttributeId = 0x0000
+
+	AttrSsIasZoneZoneState  AttributeId = 0x0000
+	AttrSsIasZoneZoneType   AttributeId = 0x0001
+	AttrSsIasZoneZoneStatus AttributeId = 0x0002
+	AttrSsIasZoneIasCieAddr AttributeId = 0x0010
+	AttrSsIasZoneZoneId     AttributeId = 0x0011
 )
 
 // Zigbee transition times are in units of 100 milliseconds
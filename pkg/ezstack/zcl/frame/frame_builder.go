@@ -23,6 +23,7 @@ type frameConfiguration struct {
 }
 
 type Builder interface {
+	IdGenerator(transactionIdProvider func() uint8) Builder
 	FrameType(frameType FrameType) Builder
 	ManufacturerCode(manufacturerCode uint16) Builder
 	Direction(direction Direction) Builder
@@ -0,0 +1,93 @@
+package zigbee
+
+// install codes are printed on (or shipped with) devices that refuse to join with the well-known
+// default link key. the trust center derives a per-device link key from the code.
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+type LinkKey [16]byte
+
+// install code incl. its trailing CRC (as printed on the device)
+type InstallCode []byte
+
+// accepts hex with optional spaces or dashes, e.g. "83FE D340 7A93 9723 A5C6 39B2 6916 D505 C3B5"
+func ParseInstallCode(input string) (InstallCode, error) {
+	code, err := hex.DecodeString(strings.NewReplacer(" ", "", "-", "").Replace(input))
+	if err != nil {
+		return nil, fmt.Errorf("ParseInstallCode: %w", err)
+	}
+
+	switch len(code) - 2 { // code lengths allowed by the spec (excl. CRC)
+	case 6, 8, 12, 16:
+	default:
+		return nil, fmt.Errorf("ParseInstallCode: invalid length %d bytes (incl. CRC)", len(code))
+	}
+
+	withoutCrc := code[:len(code)-2]
+
+	if crc := binary.LittleEndian.Uint16(code[len(code)-2:]); crc != crc16X25(withoutCrc) {
+		return nil, fmt.Errorf("ParseInstallCode: CRC mismatch (typo in the code?)")
+	}
+
+	return code, nil
+}
+
+func (i InstallCode) String() string {
+	return hex.EncodeToString(i)
+}
+
+// link key is the AES-MMO hash of the install code (incl. CRC)
+func (i InstallCode) LinkKey() LinkKey {
+	return LinkKey(aesMmoHash(i))
+}
+
+// Matyas-Meyer-Oseas hash with AES-128 (Zigbee spec B.6)
+func aesMmoHash(message []byte) [aes.BlockSize]byte {
+	// padding: 1 bit, zeros, and message length in bits as 16-bit big endian
+	padded := append(append([]byte{}, message...), 0x80)
+	for len(padded)%aes.BlockSize != aes.BlockSize-2 {
+		padded = append(padded, 0x00)
+	}
+	padded = append(padded, byte(len(message)*8>>8), byte(len(message)*8))
+
+	hash := [aes.BlockSize]byte{}
+
+	for block := padded; len(block) > 0; block = block[aes.BlockSize:] {
+		cipher, err := aes.NewCipher(hash[:])
+		if err != nil {
+			panic(err) // only fails with invalid key size
+		}
+
+		cipher.Encrypt(hash[:], block[:aes.BlockSize])
+
+		for idx := range hash {
+			hash[idx] ^= block[idx]
+		}
+	}
+
+	return hash
+}
+
+// CRC-16/X-25 (a.k.a. CRC-16/IBM-SDLC)
+func crc16X25(data []byte) uint16 {
+	crc := uint16(0xffff)
+
+	for _, b := range data {
+		crc ^= uint16(b)
+		for bit := 0; bit < 8; bit++ {
+			if crc&1 != 0 {
+				crc = (crc >> 1) ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+
+	return ^crc
+}
@@ -18,6 +18,11 @@ func (i IEEEAddress) HexPrefixedString() string {
 
 const (
 	CoordinatorNwkAddr = "0x0000" // https://www.eetimes.com/zigbee-applications-part-4-zigbee-addressing/
+	UnknownNwkAddr     = "0xfffe" // address manager's answer for a device it doesn't know
+
+	BroadcastAll          = "0xffff" // all devices, incl. sleepy ones
+	BroadcastAllRouters   = "0xfffc" // all routers and the coordinator
+	BroadcastRxOnWhenIdle = "0xfffd" // all devices that keep their receiver on (i.e. not sleepy ones)
 )
 
 type LogicalType uint8
@@ -81,3 +86,10 @@ const (
 	ProfilePersonalHomeAndHospitalCare  ProfileID = 0x0108
 	ProfileAdvancedMeteringInitiative   ProfileID = 0x0109
 )
+
+// Zigbee group. a message sent to a group is received by all of its members
+type GroupId uint16
+
+func (g GroupId) HexPrefixedString() string {
+	return fmt.Sprintf("0x%04x", uint16(g))
+}
@@ -0,0 +1,23 @@
+package znp
+
+import (
+	"encoding/binary"
+
+	"github.com/function61/hautomo/pkg/ezstack/binstruct"
+)
+
+// all 2.4 GHz Zigbee channels (11-26)
+const AllChannelsMask uint32 = 0x07fff800
+
+// bit n of *mask* = channel n
+func ChannelsFromMask(mask uint32) *Channels {
+	maskBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(maskBytes, mask)
+
+	channels := &Channels{}
+	if err := binstruct.Decode(maskBytes, channels); err != nil {
+		panic(err) // can't happen, *maskBytes* is always of right size
+	}
+
+	return channels
+}
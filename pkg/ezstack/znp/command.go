@@ -733,8 +733,7 @@ func (znp *Znp) ZdoEndDeviceBindReq(dstAddr string, localCoordinatorAddr string,
 // request an End Device Bind with the destination device.
 func (znp *Znp) ZdoBindReq(dstAddr string, srcAddress string, srcEndpoint zigbee.EndpointId, clusterId uint16,
 	dstAddrMode AddrMode, dstAddress string, dstEndpoint zigbee.EndpointId) (rsp *StatusResponse, err error) {
-	req := &ZdoBindUnbindReq{DstAddr: dstAddr, SrcAddress: srcAddress, SrcEndpoint: srcEndpoint, ClusterID: clusterId,
-		DstAddrMode: dstAddrMode, DstAddress: dstAddress, DstEndpoint: dstEndpoint}
+	req := newZdoBindUnbindReq(dstAddr, srcAddress, srcEndpoint, clusterId, dstAddrMode, dstAddress, dstEndpoint)
 	err = znp.SendSync(unp.S_ZDO, 0x21, req, &rsp)
 	return
 }
@@ -742,12 +741,22 @@ func (znp *Znp) ZdoBindReq(dstAddr string, srcAddress string, srcEndpoint zigbee
 // request a un-bind.
 func (znp *Znp) ZdoUnbindReq(dstAddr string, srcAddress string, srcEndpoint zigbee.EndpointId, clusterId uint16,
 	dstAddrMode AddrMode, dstAddress string, dstEndpoint zigbee.EndpointId) (rsp *StatusResponse, err error) {
-	req := &ZdoBindUnbindReq{DstAddr: dstAddr, SrcAddress: srcAddress, SrcEndpoint: srcEndpoint, ClusterID: clusterId,
-		DstAddrMode: dstAddrMode, DstAddress: dstAddress, DstEndpoint: dstEndpoint}
+	req := newZdoBindUnbindReq(dstAddr, srcAddress, srcEndpoint, clusterId, dstAddrMode, dstAddress, dstEndpoint)
 	err = znp.SendSync(unp.S_ZDO, 0x22, req, &rsp)
 	return
 }
 
+// *dstAddress* is IEEE address for AddrModeAddr64Bit and group address for AddrModeAddrGroup
+func newZdoBindUnbindReq(dstAddr string, srcAddress string, srcEndpoint zigbee.EndpointId, clusterId uint16,
+	dstAddrMode AddrMode, dstAddress string, dstEndpoint zigbee.EndpointId) *ZdoBindUnbindReq {
+	req := &ZdoBindUnbindReq{DstAddr: dstAddr, SrcAddress: srcAddress, SrcEndpoint: srcEndpoint, ClusterID: clusterId,
+		DstAddrMode: dstAddrMode, DstAddress: dstAddress, DstEndpoint: dstEndpoint}
+	if dstAddrMode == AddrModeAddrGroup { // group bindings don't have an endpoint, but the field is still sent
+		req.DstEndpoint = 0xff
+	}
+	return req
+}
+
 // request the destination device to perform a network discovery
 func (znp *Znp) ZdoMgmtNwkDiskReq(dstAddr string, scanChannels *Channels, scanDuration uint8, startIndex uint8) (rsp *StatusResponse, err error) {
 	req := &ZdoMgmtNwkDiskReq{DstAddr: dstAddr, ScanChannels: scanChannels, ScanDuration: scanDuration, StartIndex: startIndex}
@@ -801,8 +810,8 @@ func (znp *Znp) ZdoMgmtPermitJoinReq(addrMode AddrMode, dstAddr string, duration
 
 // is provided to allow updating of network configuration parameters or to request
 // information from devices on network conditions in the local operating environment.
-func (znp *Znp) ZdoMgmtNwkUpdateReq(dstAddr string, dstAddrMode AddrMode, channelMask *Channels, scanDuration uint8) (rsp *StatusResponse, err error) {
-	req := &ZdoMgmtNwkUpdateReq{DstAddr: dstAddr, DstAddrMode: dstAddrMode, ChannelMask: channelMask, ScanDuration: scanDuration}
+func (znp *Znp) ZdoMgmtNwkUpdateReq(dstAddr string, dstAddrMode AddrMode, channelMask *Channels, scanDuration uint8, scanCount uint8, nwkManagerAddr string) (rsp *StatusResponse, err error) {
+	req := &ZdoMgmtNwkUpdateReq{DstAddr: dstAddr, DstAddrMode: dstAddrMode, ChannelMask: channelMask, ScanDuration: scanDuration, ScanCount: scanCount, NwkManagerAddr: nwkManagerAddr}
 	err = znp.SendSync(unp.S_ZDO, 0x37, req, &rsp)
 	return
 }
@@ -959,7 +968,7 @@ func (znp *Znp) ZdoExtRxIdle(setFlag uint8, setValue uint8) (rsp *StatusResponse
 }
 
 // handles the ZDO security update network key extension message.
-func (znp *Znp) ZdoExtUpdateNwkKey(destinationAddress string, keySeqNum uint8, key [128]uint8) (rsp *StatusResponse, err error) {
+func (znp *Znp) ZdoExtUpdateNwkKey(destinationAddress string, keySeqNum uint8, key zigbee.NetworkKey) (rsp *StatusResponse, err error) {
 	req := &ZdoExtUpdateNwkKey{DestinationAddress: destinationAddress, KeySeqNum: keySeqNum, Key: key}
 	err = znp.SendSync(unp.S_ZDO, 0x4E, req, &rsp)
 	return
@@ -1182,6 +1191,7 @@ var asyncCommandAllocators = map[acKey]func() interface{}{
 	acKey{unp.S_ZDO, 0xB4}: func() interface{} { return &ZdoMgmtLeaveRsp{} },
 	acKey{unp.S_ZDO, 0xB5}: func() interface{} { return &ZdoMgmtDirectJoinRsp{} },
 	acKey{unp.S_ZDO, 0xB6}: func() interface{} { return &ZdoMgmtPermitJoinRsp{} },
+	acKey{unp.S_ZDO, 0xB8}: func() interface{} { return &ZdoMgmtNwkUpdateNotify{} },
 	acKey{unp.S_ZDO, 0xC0}: func() interface{} { return &ZdoStateChangeInd{} },
 	acKey{unp.S_ZDO, 0xC1}: func() interface{} { return &ZdoEndDeviceAnnceInd{} },
 	acKey{unp.S_ZDO, 0xC2}: func() interface{} { return &ZdoMatchDescRpsSent{} },
@@ -1190,6 +1200,7 @@ var asyncCommandAllocators = map[acKey]func() interface{}{
 	acKey{unp.S_ZDO, 0xC5}: func() interface{} { return &ZdoBeaconNotifyInd{} },
 	acKey{unp.S_ZDO, 0xC6}: func() interface{} { return &ZdoJoinCnf{} },
 	acKey{unp.S_ZDO, 0xC7}: func() interface{} { return &ZdoNwkDiscoveryCnf{} },
+	acKey{unp.S_ZDO, 0xC8}: func() interface{} { return &ZdoConcentratorInd{} },
 	acKey{unp.S_ZDO, 0xC9}: func() interface{} { return &ZdoLeaveInd{} },
 	acKey{unp.S_ZDO, 0xFF}: func() interface{} { return &ZdoMsgCbIncoming{} },
 	acKey{unp.S_ZDO, 0xCA}: func() interface{} { return &ZdoTcDevInd{} },
@@ -310,8 +310,8 @@ const (
 type InstallCodeFormat uint8
 
 const (
-	InstallCodeFormatCodePlusCrc               InstallCodeFormat = 0x00
-	InstallCodeFormatKeyDerivedFromInstallCode InstallCodeFormat = 0x01
+	InstallCodeFormatCodePlusCrc               InstallCodeFormat = 0x01
+	InstallCodeFormatKeyDerivedFromInstallCode InstallCodeFormat = 0x02
 )
 
 type CommissioningMode uint8
@@ -342,8 +342,9 @@ const _InstallCodeFormat_name = "InstallCodeFormatCodePlusCrcInstallCodeFormatKe
 var _InstallCodeFormat_index = [...]uint8{0, 28, 70}
 
 func (i InstallCodeFormat) String() string {
+	i -= 1
 	if i >= InstallCodeFormat(len(_InstallCodeFormat_index)-1) {
-		return "InstallCodeFormat(" + strconv.FormatInt(int64(i), 10) + ")"
+		return "InstallCodeFormat(" + strconv.FormatInt(int64(i+1), 10) + ")"
 	}
 	return _InstallCodeFormat_name[_InstallCodeFormat_index[i]:_InstallCodeFormat_index[i+1]]
 }
@@ -382,6 +382,32 @@ func (req *SysOsalNvWrite) Send(z *Znp) (rsp *StatusResponse, err error) {
 	return
 }
 
+// like SysOsalNvRead, but for offsets over 255
+type SysOsalNvReadExt struct {
+	ID     NVRAMItemId
+	Offset uint16
+}
+
+func (req *SysOsalNvReadExt) Send(z *Znp) (rsp *SysOsalNvReadResponse, err error) {
+	if err := z.SendSync(unp.S_SYS, 0x1c, req, &rsp); err != nil {
+		return nil, wrapIfError("SysOsalNvReadExt", err)
+	}
+
+	return rsp, wrapIfError("SysOsalNvReadExt", rsp.Status.Error())
+}
+
+// like SysOsalNvWrite, but for offsets over 255
+type SysOsalNvWriteExt struct {
+	ID     NVRAMItemId
+	Offset uint16
+	Value  []byte `size:"2"`
+}
+
+func (req *SysOsalNvWriteExt) Send(z *Znp) (rsp *StatusResponse, err error) {
+	err = z.SendSync(unp.S_SYS, 0x1d, req, &rsp)
+	return
+}
+
 type SysOsalNvItemInit struct {
 	ID       uint16
 	ItemLen  uint16
@@ -490,7 +516,7 @@ type SysNvLength struct {
 }
 
 type SysNvLengthResponse struct {
-	Length uint8
+	Length uint32 // 0 = item doesn't exist
 }
 
 type SysNvRead struct {
@@ -925,8 +951,8 @@ type ZdoBindUnbindReq struct {
 	SrcEndpoint zigbee.EndpointId
 	ClusterID   uint16
 	DstAddrMode AddrMode
-	DstAddress  string `hex:"8"` // TODO: this seems to imply we must use DstAddrMode=AddrModeAddr64Bit?
-	DstEndpoint zigbee.EndpointId
+	DstAddress  string            `hex:"8"` // always 8 bytes. for AddrModeAddrGroup the group id is in the low 2 bytes
+	DstEndpoint zigbee.EndpointId // ignored (0xff) for group bindings
 }
 
 type Channels struct {
@@ -945,7 +971,7 @@ type Channels struct {
 	Channel23 uint32 `bits:"0x00800000"`
 	Channel24 uint32 `bits:"0x01000000"`
 	Channel25 uint32 `bits:"0x02000000"`
-	Channel26 uint32 `bits:"0b04000000" bitmask:"end"`
+	Channel26 uint32 `bits:"0x04000000" bitmask:"end"`
 }
 
 type ZdoMgmtNwkDiskReq struct {
@@ -995,10 +1021,12 @@ type ZdoMgmtPermitJoinReq struct {
 }
 
 type ZdoMgmtNwkUpdateReq struct {
-	DstAddr      string `hex:"2"`
-	DstAddrMode  AddrMode
-	ChannelMask  *Channels
-	ScanDuration uint8
+	DstAddr        string `hex:"2"`
+	DstAddrMode    AddrMode
+	ChannelMask    *Channels
+	ScanDuration   uint8 // 0-5 = energy scan, 0xfe = change channel, 0xff = change network manager
+	ScanCount      uint8
+	NwkManagerAddr string `hex:"2"`
 }
 
 type ZdoMsgCbRegister struct {
@@ -1136,7 +1164,7 @@ type ZdoExtRxIdle struct {
 type ZdoExtUpdateNwkKey struct {
 	DestinationAddress string `hex:"2"`
 	KeySeqNum          uint8
-	Key                [128]uint8
+	Key                zigbee.NetworkKey
 }
 
 type ZdoExtSwitchNwkKey struct {
@@ -1146,11 +1174,12 @@ type ZdoExtSwitchNwkKey struct {
 
 type ZdoExtNwkInfoResponse struct {
 	ShortAddress          string `hex:"2"`
+	DeviceState           DeviceState
 	PanID                 uint16
 	ParentAddress         string `hex:"2"`
 	ExtendedPanID         uint64
 	ExtendedParentAddress string `hex:"8"`
-	Channel               uint16 //uint16 or uint8?????
+	Channel               uint8
 }
 
 type ZdoExtSeqApsRemoveReq struct {
@@ -1365,6 +1394,16 @@ type ZdoMgmtDirectJoinRsp struct {
 	Status  Status
 }
 
+// response to Mgmt_NWK_Update_req energy scan
+type ZdoMgmtNwkUpdateNotify struct {
+	SrcAddr              string `hex:"2"`
+	Status               Status
+	ScannedChannels      uint32 // bitmask
+	TotalTransmissions   uint16
+	TransmissionFailures uint16
+	EnergyValues         []uint8 `size:"1"` // one per scanned channel, in channel order
+}
+
 type ZdoMgmtPermitJoinRsp struct {
 	SrcAddr string `hex:"2"`
 	Status  Status
@@ -1397,6 +1436,13 @@ type ZdoSrcRtgInd struct {
 	RelayList []string `size:"1" hex:"2"`
 }
 
+// Z-Stack 3.x: a router announced itself as a concentrator (= many-to-one routing)
+type ZdoConcentratorInd struct {
+	NwkAddr string `hex:"2"`
+	ExtAddr string `hex:"8"`
+	PktCost uint8
+}
+
 type Beacon struct {
 	SrcAddr         string `hex:"2"`
 	PanID           uint16
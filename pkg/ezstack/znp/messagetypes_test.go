@@ -0,0 +1,21 @@
+package znp
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/function61/gokit/testing/assert"
+	"github.com/function61/hautomo/pkg/ezstack/binstruct"
+)
+
+// Z-Stack always reads 8 bytes of destination address followed by the endpoint, regardless of
+// the address mode, so the group variant must have the same layout
+func TestZdoBindUnbindReqEncoding(t *testing.T) {
+	toDevice := newZdoBindUnbindReq("0x1234", "0x00158d0000000001", 1, 0x0006, AddrModeAddr64Bit, "0x00124b0000000002", 1)
+
+	assert.EqualString(t, fmt.Sprintf("%x", binstruct.Encode(toDevice)), "341201000000008d15000106000302000000004b120001")
+
+	toGroup := newZdoBindUnbindReq("0x1234", "0x00158d0000000001", 1, 0x0006, AddrModeAddrGroup, "0x0005", 0)
+
+	assert.EqualString(t, fmt.Sprintf("%x", binstruct.Encode(toGroup)), "341201000000008d1500010600010500000000000000ff")
+}
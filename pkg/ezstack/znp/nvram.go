@@ -1,6 +1,7 @@
 package znp
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/function61/hautomo/pkg/ezstack/binstruct"
@@ -11,6 +12,39 @@ import (
 
 type NVRAMItemId uint16
 
+// items that we don't have structs for, but that we save and restore as-is in backups
+const (
+	NVRAMItemNib                 NVRAMItemId = 0x0021 // "network information base", i.e. network state
+	NVRAMItemDeviceList          NVRAMItemId = 0x0022 // association table (our direct children)
+	NVRAMItemAddrMgr             NVRAMItemId = 0x0023 // address manager: IEEE <-> network address
+	NVRAMItemNwkAlternKeyInfo    NVRAMItemId = 0x003b
+	NVRAMItemNwkSecMaterialTable NVRAMItemId = 0x0075 // table: one item per entry
+	NVRAMItemTclkTable           NVRAMItemId = 0x0101 // table: trust center link keys
+	NVRAMItemApsLinkKeyDataTable NVRAMItemId = 0x0201 // table: APS link keys
+)
+
+// Z-Stack 3.x.0 moved tables to extended NVRAM, where an item is addressed by (system, item, sub-item).
+// tables have one sub-item per entry.
+type ExtendedNVRAMItemId uint16
+
+const (
+	ExtendedNVRAMItemAddrMgr             ExtendedNVRAMItemId = 0x0001
+	ExtendedNVRAMItemBindingTable        ExtendedNVRAMItemId = 0x0002
+	ExtendedNVRAMItemDeviceList          ExtendedNVRAMItemId = 0x0003
+	ExtendedNVRAMItemTclkTable           ExtendedNVRAMItemId = 0x0004
+	ExtendedNVRAMItemTclkIcTable         ExtendedNVRAMItemId = 0x0005
+	ExtendedNVRAMItemApsKeyDataTable     ExtendedNVRAMItemId = 0x0006
+	ExtendedNVRAMItemNwkSecMaterialTable ExtendedNVRAMItemId = 0x0007
+)
+
+const (
+	nvramItemMaxChunk       = 200  // bytes per read/write, well within frame size limit
+	nvramItemMaxSmallOffset = 0xff // SysOsalNvRead/Write offset is uint8
+	nvramSysIdZStack        = 0x01 // extended NVRAM items of the stack itself (vs. application's)
+)
+
+var ErrNVRAMItemNotFound = errors.New("NVRAM item not found")
+
 type NVRAMItem interface {
 	// returns the item ID ("slot ID") where this particular parameter is stored in the NVRAM.
 	// used as ID for reads / writes.
@@ -40,8 +74,209 @@ func (z *Znp) NVRAMWrite(item NVRAMItem) error {
 	return resp.Status.Error()
 }
 
+// reads an item regardless of its length, in multiple chunks if needed
+func (z *Znp) NVRAMReadRaw(id NVRAMItemId) ([]byte, error) {
+	length, err := z.SysOsalNvLength(uint16(id))
+	if err != nil {
+		return nil, fmt.Errorf("NVRAMReadRaw: %d: %w", id, err)
+	}
+
+	if length.Length == 0 {
+		return nil, fmt.Errorf("NVRAMReadRaw: %d: %w", id, ErrNVRAMItemNotFound)
+	}
+
+	value := []byte{}
+	for len(value) < int(length.Length) {
+		chunk, err := z.nvramReadChunk(id, len(value))
+		if err != nil {
+			return nil, fmt.Errorf("NVRAMReadRaw: %d: %w", id, err)
+		}
+
+		if len(chunk) == 0 { // shouldn't happen, but guard against looping forever
+			return nil, fmt.Errorf("NVRAMReadRaw: %d: empty read at %d", id, len(value))
+		}
+
+		value = append(value, chunk...)
+	}
+
+	return value[:length.Length], nil
+}
+
+// writes an item in chunks if needed. the item is created if it doesn't exist.
+func (z *Znp) NVRAMWriteRaw(id NVRAMItemId, value []byte) error {
+	length, err := z.SysOsalNvLength(uint16(id))
+	if err != nil {
+		return fmt.Errorf("NVRAMWriteRaw: %d: %w", id, err)
+	}
+
+	switch int(length.Length) {
+	case len(value): // happy path
+	case 0:
+		resp, err := z.SysOsalNvItemInit(uint16(id), uint16(len(value)), []byte{})
+		if err != nil {
+			return fmt.Errorf("NVRAMWriteRaw: %d: SysOsalNvItemInit: %w", id, err)
+		}
+
+		// "created" is the success status here
+		if resp.Status != StatusItemCreatedAndInitialized && resp.Status != StatusSuccess {
+			return fmt.Errorf("NVRAMWriteRaw: %d: SysOsalNvItemInit: %w", id, resp.Status.Error())
+		}
+	default:
+		return fmt.Errorf("NVRAMWriteRaw: %d: length %d in radio, writing %d", id, length.Length, len(value))
+	}
+
+	for offset := 0; offset < len(value); offset += nvramItemMaxChunk {
+		end := offset + nvramItemMaxChunk
+		if end > len(value) {
+			end = len(value)
+		}
+
+		if err := z.nvramWriteChunk(id, offset, value[offset:end]); err != nil {
+			return fmt.Errorf("NVRAMWriteRaw: %d: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+// offsets over 255 need the "ext" variants of read/write
+func (z *Znp) nvramReadChunk(id NVRAMItemId, offset int) ([]byte, error) {
+	if offset <= nvramItemMaxSmallOffset {
+		resp, err := (&SysOsalNvRead{
+			ID:     id,
+			Offset: uint8(offset),
+		}).Send(z)
+		if err != nil {
+			return nil, err
+		}
+
+		return resp.Value, nil
+	}
+
+	resp, err := (&SysOsalNvReadExt{
+		ID:     id,
+		Offset: uint16(offset),
+	}).Send(z)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Value, nil
+}
+
+func (z *Znp) nvramWriteChunk(id NVRAMItemId, offset int, chunk []byte) error {
+	if offset <= nvramItemMaxSmallOffset {
+		resp, err := (&SysOsalNvWrite{
+			ID:     id,
+			Offset: uint8(offset),
+			Value:  chunk,
+		}).Send(z)
+		if err != nil {
+			return err
+		}
+
+		return resp.Status.Error()
+	}
+
+	resp, err := (&SysOsalNvWriteExt{
+		ID:     id,
+		Offset: uint16(offset),
+		Value:  chunk,
+	}).Send(z)
+	if err != nil {
+		return err
+	}
+
+	return resp.Status.Error()
+}
+
+// reads an extended item (see ExtendedNVRAMItemId). needs Z-Stack 3.x.0
+func (z *Znp) ExtendedNVRAMReadRaw(id ExtendedNVRAMItemId, subId uint16) ([]byte, error) {
+	length, err := z.SysNvLength(nvramSysIdZStack, uint16(id), subId)
+	if err != nil {
+		return nil, fmt.Errorf("ExtendedNVRAMReadRaw: %d/%d: %w", id, subId, err)
+	}
+
+	if length.Length == 0 {
+		return nil, fmt.Errorf("ExtendedNVRAMReadRaw: %d/%d: %w", id, subId, ErrNVRAMItemNotFound)
+	}
+
+	value := []byte{}
+	for len(value) < int(length.Length) {
+		chunkLength := int(length.Length) - len(value)
+		if chunkLength > nvramItemMaxChunk {
+			chunkLength = nvramItemMaxChunk
+		}
+
+		resp, err := z.SysNvRead(nvramSysIdZStack, uint16(id), subId, uint16(len(value)), uint8(chunkLength))
+		if err != nil {
+			return nil, fmt.Errorf("ExtendedNVRAMReadRaw: %d/%d: %w", id, subId, err)
+		}
+
+		if err := resp.Status.Error(); err != nil {
+			return nil, fmt.Errorf("ExtendedNVRAMReadRaw: %d/%d: %w", id, subId, err)
+		}
+
+		if len(resp.Value) == 0 { // shouldn't happen, but guard against looping forever
+			return nil, fmt.Errorf("ExtendedNVRAMReadRaw: %d/%d: empty read at %d", id, subId, len(value))
+		}
+
+		value = append(value, resp.Value...)
+	}
+
+	return value[:length.Length], nil
+}
+
+// writes an extended item in chunks if needed. the item is created if it doesn't exist.
+func (z *Znp) ExtendedNVRAMWriteRaw(id ExtendedNVRAMItemId, subId uint16, value []byte) error {
+	length, err := z.SysNvLength(nvramSysIdZStack, uint16(id), subId)
+	if err != nil {
+		return fmt.Errorf("ExtendedNVRAMWriteRaw: %d/%d: %w", id, subId, err)
+	}
+
+	switch int(length.Length) {
+	case len(value): // happy path
+	case 0:
+		resp, err := z.SysNvCreate(nvramSysIdZStack, uint16(id), subId, uint32(len(value)))
+		if err != nil {
+			return fmt.Errorf("ExtendedNVRAMWriteRaw: %d/%d: SysNvCreate: %w", id, subId, err)
+		}
+
+		// "created" is the success status here
+		if resp.Status != StatusItemCreatedAndInitialized && resp.Status != StatusSuccess {
+			return fmt.Errorf("ExtendedNVRAMWriteRaw: %d/%d: SysNvCreate: %w", id, subId, resp.Status.Error())
+		}
+	default:
+		return fmt.Errorf("ExtendedNVRAMWriteRaw: %d/%d: length %d in radio, writing %d", id, subId, length.Length, len(value))
+	}
+
+	for offset := 0; offset < len(value); offset += nvramItemMaxChunk {
+		end := offset + nvramItemMaxChunk
+		if end > len(value) {
+			end = len(value)
+		}
+
+		resp, err := z.SysNvWrite(nvramSysIdZStack, uint16(id), subId, uint16(offset), value[offset:end])
+		if err != nil {
+			return fmt.Errorf("ExtendedNVRAMWriteRaw: %d/%d: %w", id, subId, err)
+		}
+
+		if err := resp.Status.Error(); err != nil {
+			return fmt.Errorf("ExtendedNVRAMWriteRaw: %d/%d: %w", id, subId, err)
+		}
+	}
+
+	return nil
+}
+
+// bits of ZCDNVStartUpOption. radio acts on them at next boot, and then clears them
+const (
+	StartOptionClearConfig uint8 = 0x01 // back to factory settings
+	StartOptionClearState  uint8 = 0x02 // forget network state (= leave the network)
+)
+
 type ZCDNVStartUpOption struct {
-	StartOption uint8 // is supposed to be 0x03, though I don't know what it means
+	StartOption uint8 // StartOption* bits
 }
 
 func (n *ZCDNVStartUpOption) ItemID() NVRAMItemId {
@@ -114,6 +349,15 @@ func (n *ZCDNVExtPANID) ItemID() NVRAMItemId {
 	return 0x002d
 }
 
+// Z-Stack 3: whether BDB commissioning has formed (or joined) a network
+type ZCDNVBDBNodeIsOnANetwork struct {
+	OnANetwork uint8
+}
+
+func (n *ZCDNVBDBNodeIsOnANetwork) ItemID() NVRAMItemId {
+	return 0x0055
+}
+
 type ZCDNVUseDefaultTCLK struct {
 	Enabled uint8 // supposed to be required for ZStack < 3.x
 }
@@ -121,3 +365,31 @@ type ZCDNVUseDefaultTCLK struct {
 func (n *ZCDNVUseDefaultTCLK) ItemID() NVRAMItemId {
 	return 0x006d
 }
+
+// network key in use, and its sequence number
+type ZCDNVNwkActiveKeyInfo struct {
+	KeySeqNum uint8
+	Key       zigbee.NetworkKey
+}
+
+func (n *ZCDNVNwkActiveKeyInfo) ItemID() NVRAMItemId {
+	return 0x003a
+}
+
+// network key in use, with outgoing frame counter
+type ZCDNVNwkKey struct {
+	KeySeqNum    uint8
+	Key          zigbee.NetworkKey
+	FrameCounter uint32
+}
+
+func (n *ZCDNVNwkKey) ItemID() NVRAMItemId {
+	return 0x0082
+}
+
+// entry of NVRAMItemNwkSecMaterialTable. the radio keeps the frame counter here per network
+// (all-0xff ExtendedPANID = generic entry)
+type NwkSecMaterialDescriptor struct {
+	FrameCounter  uint32
+	ExtendedPANID zigbee.ExtendedPANID
+}
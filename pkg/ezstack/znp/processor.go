@@ -20,11 +20,20 @@ type Znp struct {
 	inbound      chan *unp.Frame
 	asyncInbound chan interface{}
 	errors       chan error
-	inFramesLog  chan *unp.Frame
-	outFramesLog chan *unp.Frame
+	inFramesLog  chan *LoggedFrame
+	outFramesLog chan *LoggedFrame
+	stopped      chan struct{} // closed when Run() returns
 	logger       *log.Logger
 }
 
+var ErrStopped = errors.New("ZNP stopped (transport to radio closed?)")
+
+// frame that was received from or sent to the radio
+type LoggedFrame struct {
+	Frame     *unp.Frame
+	Timestamp time.Time // when the frame was read or written
+}
+
 func New(unifiedProcessor *unp.Unp, logger *log.Logger) *Znp {
 	return &Znp{
 		unp:          unifiedProcessor,
@@ -32,8 +41,9 @@ func New(unifiedProcessor *unp.Unp, logger *log.Logger) *Znp {
 		inbound:      make(chan *unp.Frame),
 		asyncInbound: make(chan interface{}, 10), // capacity fixes: https://github.com/dyrkin/znp-go/issues/1
 		errors:       make(chan error, 100),
-		inFramesLog:  make(chan *unp.Frame, 100),
-		outFramesLog: make(chan *unp.Frame, 100),
+		inFramesLog:  make(chan *LoggedFrame, 100),
+		outFramesLog: make(chan *LoggedFrame, 100),
+		stopped:      make(chan struct{}),
 		logger:       logger,
 	}
 }
@@ -46,15 +56,18 @@ func (z *Znp) AsyncInbound() chan interface{} {
 	return z.asyncInbound
 }
 
-func (z *Znp) InFramesLog() chan *unp.Frame {
+func (z *Znp) InFramesLog() chan *LoggedFrame {
 	return z.inFramesLog
 }
 
-func (z *Znp) OutFramesLog() chan *unp.Frame {
+func (z *Znp) OutFramesLog() chan *LoggedFrame {
 	return z.outFramesLog
 }
 
 func (z *Znp) Run(ctx context.Context) error {
+	// requests made after we've stopped fail instead of blocking forever
+	defer close(z.stopped)
+
 	// there can be at most one sync request in-flight at a time
 	var inflightSyncRequest *Sync
 
@@ -142,7 +155,10 @@ func (z *Znp) Run(ctx context.Context) error {
 
 					// don't send out next outbound frame (even async ones. TODO: is this required?)
 					// until we've received response to current in-flight sync request (or it was errored in WriteFrame() )
-					<-req.ctx.Done()
+					select {
+					case <-req.ctx.Done():
+					case <-ctx.Done(): // stopping. requester gets ErrStopped
+					}
 
 					inflightSyncRequest = nil
 				case *Async:
@@ -182,7 +198,11 @@ func (z *Znp) Run(ctx context.Context) error {
 
 			logInboundOrOutboundFrame(frame, z.inFramesLog)
 
-			z.inbound <- frame
+			select {
+			case z.inbound <- frame:
+			case <-ctx.Done():
+				return nil
+			}
 		}
 	})
 
@@ -202,9 +222,15 @@ func (z *Znp) SendSync(subsystem unp.Subsystem, command byte, req interface{}, r
 
 	// it will be now queued for sending. if previous sync request is waiting, it will only be
 	// sent after the previous one has had a response.
-	z.outbound <- outgoing
+	select {
+	case z.outbound <- outgoing:
+	case <-z.stopped:
+		return ErrStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 
-	responseFrame, err := outgoing.WaitForResponse(ctx)
+	responseFrame, err := outgoing.WaitForResponse(ctx, z.stopped)
 	if err != nil { // error can also be transport error between us and the radio (= not the end device)
 		return err
 	} else {
@@ -213,19 +239,22 @@ func (z *Znp) SendSync(subsystem unp.Subsystem, command byte, req interface{}, r
 }
 
 func (z *Znp) SendAsync(subsystem unp.Subsystem, command byte, req interface{}, resp interface{}) {
-	z.outbound <- NewAsync(&unp.Frame{
+	select {
+	case z.outbound <- NewAsync(&unp.Frame{
 		CommandType: unp.C_AREQ,
 		Subsystem:   subsystem,
 		Command:     command,
 		Payload:     binstruct.Encode(req),
-	})
+	}):
+	case <-z.stopped: // async requests don't report TX errors anyway
+	}
 }
 
-func logInboundOrOutboundFrame(frame *unp.Frame, logger chan *unp.Frame) {
-	go func() {
-		select {
-		case logger <- frame:
-		default:
-		}
-	}()
+// timestamp is taken here (and not by the consumer) so it's accurate. no goroutine, so that the
+// frames stay in order
+func logInboundOrOutboundFrame(frame *unp.Frame, logger chan *LoggedFrame) {
+	select {
+	case logger <- &LoggedFrame{Frame: frame, Timestamp: time.Now()}:
+	default: // nobody is listening (or is too slow)
+	}
 }
@@ -49,10 +49,13 @@ func (s *Sync) MarkDone(response *unp.Frame, err error) {
 }
 
 // NOTE: this can be called only once!
-func (s *Sync) WaitForResponse(ctx context.Context) (*unp.Frame, error) {
+// *stopped* lets us give up early if the ZNP stopped while we waited
+func (s *Sync) WaitForResponse(ctx context.Context, stopped <-chan struct{}) (*unp.Frame, error) {
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
+	case <-stopped:
+		return nil, ErrStopped
 	case err := <-s.doneErr:
 		return s.response, err
 	}
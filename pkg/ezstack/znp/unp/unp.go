@@ -178,11 +178,13 @@ func (u *Unp) receiver() {
 		n, err := io.ReadFull(u.Transceiver, buf[:])
 		if n > 0 {
 			u.incoming <- buf[0]
-		} else if err != io.EOF {
+		} else if err != nil {
+			// EOF too, because the transport can't produce any more frames (e.g. simulator or
+			// TCP connection went away). looping on it would only spin the CPU
 			u.errors <- err
+			return
 		}
 	}
-
 }
 
 // values documented in https://dev.ti.com/tirex/content/simplelink_cc13x2_sdk_2_30_00_45/docs/zstack/html/zigbee/znp_interface.html
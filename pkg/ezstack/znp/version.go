@@ -0,0 +1,40 @@
+package znp
+
+// Z-Stack 1.2 (CC2530/CC2531) and 3.x (CC26x2/CC1352, also CC2531 with "Zigbee 3.0" firmware) speak
+// mostly the same ZNP, but they differ in how a network is formed and where things are in NVRAM
+
+// from SysVersionResponse.Product
+type ZStackVersion uint8
+
+const (
+	ZStackVersion12  ZStackVersion = 0 // Z-Stack Home 1.2
+	ZStackVersion3x0 ZStackVersion = 1 // Z-Stack 3.x.0 (CC26x2, CC1352)
+	ZStackVersion30x ZStackVersion = 2 // Z-Stack 3.0.x (CC2530/CC2531 "Zigbee 3.0" firmware)
+)
+
+func (v ZStackVersion) String() string {
+	switch v {
+	case ZStackVersion12:
+		return "Z-Stack 1.2"
+	case ZStackVersion3x0:
+		return "Z-Stack 3.x.0"
+	case ZStackVersion30x:
+		return "Z-Stack 3.0.x"
+	default:
+		return "Z-Stack (unknown)"
+	}
+}
+
+// Z-Stack 3 forms networks with "base device behaviour" (BDB) commissioning
+func (v ZStackVersion) IsZStack3() bool {
+	return v == ZStackVersion3x0 || v == ZStackVersion30x
+}
+
+// Z-Stack 3.x.0 keeps its tables in extended NVRAM items (see SysNvRead() etc.)
+func (v ZStackVersion) HasExtendedNVRAM() bool {
+	return v == ZStackVersion3x0
+}
+
+func (s *SysVersionResponse) ZStackVersion() ZStackVersion {
+	return ZStackVersion(s.Product)
+}
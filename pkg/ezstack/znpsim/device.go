@@ -0,0 +1,515 @@
+package znpsim
+
+// scripted virtual devices that live in the simulated network
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/function61/hautomo/pkg/ezstack/binstruct"
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+	"github.com/function61/hautomo/pkg/ezstack/zcl/frame"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+	"github.com/function61/hautomo/pkg/ezstack/znp"
+	"github.com/function61/hautomo/pkg/ezstack/znp/unp"
+)
+
+var clusterLibrary = cluster.NewClusterLibrary()
+
+type Device struct {
+	IEEEAddress      zigbee.IEEEAddress
+	NetworkAddress   string
+	LogicalType      zigbee.LogicalType
+	MainPowered      bool
+	ManufacturerCode uint16
+	LinkQuality      uint8
+	Joined           bool // already in the network. if not, announces itself once joining is permitted
+	Endpoints        []*Endpoint
+
+	// cluster-specific commands sent to the device. *command* is decoded, e.g. *cluster.OnCommand.
+	// if nil, device supports no commands.
+	OnCommand func(device *Device, endpoint *Endpoint, clusterId cluster.ClusterId, command interface{}) cluster.ZclStatus
+
+	sim      *Simulator // set when added to the network
+	bindings []*znp.Binding
+	pinCodes map[uint16]string // door locks. user => PIN code
+	mu       sync.Mutex        // attributes, bindings, pinCodes, Joined
+}
+
+type Endpoint struct {
+	Id          zigbee.EndpointId
+	ProfileId   uint16
+	DeviceId    uint16
+	InClusters  []cluster.ClusterId
+	OutClusters []cluster.ClusterId
+
+	attributes map[cluster.ClusterId]map[cluster.AttributeId]*cluster.Attribute
+}
+
+func (s *Simulator) AddDevice(device *Device) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device.sim = s
+	s.devices = append(s.devices, device)
+
+	if s.permitJoinActive() {
+		s.announceUnjoined()
+	}
+}
+
+// device leaves the network on its own (e.g. factory reset)
+func (d *Device) Leave() error {
+	d.mu.Lock()
+	d.Joined = false
+	d.mu.Unlock()
+
+	return d.sim.send(d.leaveIndication())
+}
+
+// *value* is in the form the ZCL library uses: uint64 for unsigned types, int64 for signed,
+// string for strings and bool for booleans. data type comes from the cluster definition.
+func (d *Device) SetAttribute(
+	endpointId zigbee.EndpointId,
+	clusterId cluster.ClusterId,
+	attributeId cluster.AttributeId,
+	value interface{},
+) error {
+	endpoint := d.endpoint(endpointId)
+	if endpoint == nil {
+		return fmt.Errorf("SetAttribute: no endpoint %d", endpointId)
+	}
+
+	definition := cluster.FindDefinition(clusterId)
+	if definition == nil || definition.Attribute(attributeId) == nil {
+		return fmt.Errorf("SetAttribute: unknown attribute %d/%d", clusterId, attributeId)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if endpoint.attributes == nil {
+		endpoint.attributes = map[cluster.ClusterId]map[cluster.AttributeId]*cluster.Attribute{}
+	}
+
+	if endpoint.attributes[clusterId] == nil {
+		endpoint.attributes[clusterId] = map[cluster.AttributeId]*cluster.Attribute{}
+	}
+
+	endpoint.attributes[clusterId][attributeId] = &cluster.Attribute{
+		DataType: definition.Attribute(attributeId).Type,
+		Value:    value,
+	}
+
+	return nil
+}
+
+func (d *Device) Attribute(
+	endpointId zigbee.EndpointId,
+	clusterId cluster.ClusterId,
+	attributeId cluster.AttributeId,
+) *cluster.Attribute {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if endpoint := d.endpoint(endpointId); endpoint != nil {
+		return endpoint.attributes[clusterId][attributeId]
+	}
+
+	return nil
+}
+
+// sends current values of attributes to the coordinator, like a device does after you've
+// configured reporting for them
+func (d *Device) ReportAttributes(
+	endpointId zigbee.EndpointId,
+	clusterId cluster.ClusterId,
+	attributeIds ...cluster.AttributeId,
+) error {
+	reports := []*cluster.AttributeReport{}
+	for _, attributeId := range attributeIds {
+		attribute := d.Attribute(endpointId, clusterId, attributeId)
+		if attribute == nil {
+			return fmt.Errorf("ReportAttributes: attribute not set: %d/%d", clusterId, attributeId)
+		}
+
+		reports = append(reports, &cluster.AttributeReport{
+			AttributeID: uint16(attributeId),
+			Attribute:   attribute,
+		})
+	}
+
+	return d.sendFrame(endpointId, uint16(clusterId), frame.New().
+		IdGenerator(d.sim.nextZclSequence).
+		FrameType(frame.FrameTypeGlobal).
+		Direction(frame.DirectionServerClient).
+		DisableDefaultResponse(true).
+		CommandId(0x0a).
+		Command(&cluster.ReportAttributesCommand{AttributeReports: reports}))
+}
+
+// sends a cluster-specific command to the coordinator, like a remote does when you press its button
+func (d *Device) SendCommand(endpointId zigbee.EndpointId, command cluster.LocalCommand) error {
+	clusterId, commandId := command.CommandClusterAndId()
+
+	return d.sendFrame(endpointId, uint16(clusterId), frame.New().
+		IdGenerator(d.sim.nextZclSequence).
+		FrameType(frame.FrameTypeLocal).
+		Direction(frame.DirectionClientServer).
+		DisableDefaultResponse(true).
+		CommandId(commandId).
+		Command(command))
+}
+
+// sends a command of a cluster the device is the server of, like an alarm sensor's zone status
+// change notification
+func (d *Device) SendServerCommand(endpointId zigbee.EndpointId, command cluster.LocalCommand) error {
+	clusterId, commandId := command.CommandClusterAndId()
+
+	return d.sendFrame(endpointId, uint16(clusterId), frame.New().
+		IdGenerator(d.sim.nextZclSequence).
+		FrameType(frame.FrameTypeLocal).
+		Direction(frame.DirectionServerClient).
+		DisableDefaultResponse(true).
+		CommandId(commandId).
+		Command(command))
+}
+
+func (d *Device) sendFrame(endpointId zigbee.EndpointId, clusterId uint16, builder frame.Builder) error {
+	frm, err := builder.Build()
+	if err != nil {
+		return err
+	}
+
+	d.sim.mu.Lock()
+	msg := d.sim.incomingMessage(d, endpointId, clusterId, frame.Encode(frm))
+	d.sim.mu.Unlock()
+
+	return d.sim.send(msg)
+}
+
+func (d *Device) endpoint(id zigbee.EndpointId) *Endpoint {
+	for _, endpoint := range d.Endpoints {
+		if endpoint.Id == id {
+			return endpoint
+		}
+	}
+
+	return nil
+}
+
+// global commands are sent to endpoint 255, so they go to the first endpoint that implements the cluster
+func (d *Device) endpointForCluster(id zigbee.EndpointId, clusterId cluster.ClusterId) *Endpoint {
+	if id != 0xff {
+		return d.endpoint(id)
+	}
+
+	for _, endpoint := range d.Endpoints {
+		for _, inCluster := range endpoint.InClusters {
+			if inCluster == clusterId {
+				return endpoint
+			}
+		}
+	}
+
+	return nil
+}
+
+func (d *Device) isJoined() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.Joined
+}
+
+func (d *Device) announcement() *unp.Frame {
+	return areq(unp.S_ZDO, 0xc1, &znp.ZdoEndDeviceAnnceInd{
+		SrcAddr:  d.NetworkAddress,
+		NwkAddr:  d.NetworkAddress,
+		IEEEAddr: d.IEEEAddress.HexPrefixedString(),
+		Capabilities: &znp.CapInfo{
+			Router:             boolToUint8(d.LogicalType == zigbee.LogicalTypeRouter),
+			MainPowered:        boolToUint8(d.MainPowered),
+			ReceiverOnWhenIdle: boolToUint8(d.MainPowered),
+			AllocAddr:          1,
+		},
+	})
+}
+
+func (d *Device) leaveIndication() *unp.Frame {
+	return areq(unp.S_ZDO, 0xc9, &znp.ZdoLeaveInd{
+		SrcAddr: d.NetworkAddress,
+		ExtAddr: d.IEEEAddress.HexPrefixedString(),
+	})
+}
+
+func (s *Simulator) permitJoinActive() bool {
+	return s.permitJoinUntil.After(time.Now())
+}
+
+// caller must hold the lock
+func (s *Simulator) announceUnjoined() {
+	for _, device := range s.devices {
+		if device.isJoined() {
+			continue
+		}
+
+		device.mu.Lock()
+		device.Joined = true
+		device.mu.Unlock()
+
+		announcement := device.announcement()
+		go func() { // can't write while our caller might be responding
+			if err := s.send(announcement); err != nil {
+				log.Error.Printf("announce: %v", err)
+			}
+		}()
+	}
+}
+
+func (s *Simulator) joinedDevices() []*Device {
+	joined := []*Device{}
+	for _, device := range s.devices {
+		if device.isJoined() {
+			joined = append(joined, device)
+		}
+	}
+
+	return joined
+}
+
+// caller must hold the lock
+func (s *Simulator) deviceByNetworkAddress(nwkAddress string) *Device {
+	for _, device := range s.devices {
+		if device.NetworkAddress == nwkAddress && device.isJoined() {
+			return device
+		}
+	}
+
+	return nil
+}
+
+func (s *Simulator) nextZclSequence() uint8 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.zclSequence++
+	return s.zclSequence
+}
+
+// caller must hold the lock
+func (s *Simulator) incomingMessage(
+	device *Device,
+	endpointId zigbee.EndpointId,
+	clusterId uint16,
+	data []byte,
+) *unp.Frame {
+	s.afSequence++
+
+	return areq(unp.S_AF, 0x81, &znp.AfIncomingMessage{
+		ClusterID:      clusterId,
+		SrcAddr:        device.NetworkAddress,
+		SrcEndpoint:    endpointId,
+		DstEndpoint:    1, // coordinator registers its HA endpoint as 1
+		LinkQuality:    device.LinkQuality,
+		Timestamp:      uint32(time.Now().Unix()),
+		TransSeqNumber: s.afSequence,
+		Data:           data,
+	})
+}
+
+// AF_DATA_REQUEST: radio confirms sending, then the device responds
+func (s *Simulator) afDataRequest(req *znp.AfDataRequest) []*unp.Frame {
+	confirm := func(status znp.Status) *unp.Frame {
+		return areq(unp.S_AF, 0x80, &znp.AfDataConfirm{
+			Status:   status,
+			Endpoint: req.SrcEndpoint,
+			TransID:  req.TransID,
+		})
+	}
+
+	s.mu.Lock()
+	device := s.deviceByNetworkAddress(req.DstAddr)
+	s.mu.Unlock()
+
+	if device == nil {
+		return []*unp.Frame{confirm(znp.StatusNwkNoRoute)}
+	}
+
+	request, err := frame.Decode(req.Data)
+	if err != nil {
+		log.Error.Printf("afDataRequest: %v", err)
+		return []*unp.Frame{confirm(znp.StatusSuccess)} // it was sent, but the device won't understand it
+	}
+
+	clusterId := cluster.ClusterId(req.ClusterID)
+
+	endpoint := device.endpointForCluster(req.DstEndpoint, clusterId)
+	if endpoint == nil {
+		return []*unp.Frame{confirm(znp.StatusSuccess)} // real devices also silently ignore these
+	}
+
+	commandId, response := device.processZcl(endpoint, clusterId, request)
+	if response == nil {
+		return []*unp.Frame{confirm(znp.StatusSuccess)}
+	}
+
+	frameType := frame.FrameTypeGlobal
+	if _, clusterSpecific := response.(cluster.StatusResponse); clusterSpecific {
+		frameType = frame.FrameTypeLocal
+	}
+
+	builder := frame.New().
+		IdGenerator(func() uint8 { return request.TransactionSequenceNumber }).
+		FrameType(frameType).
+		Direction(frame.DirectionServerClient).
+		DisableDefaultResponse(true).
+		CommandId(commandId).
+		Command(response)
+	if request.FrameControl.ManufacturerSpecific == 1 {
+		builder = builder.ManufacturerCode(request.ManufacturerCode)
+	}
+
+	responseFrame, err := builder.Build()
+	if err != nil {
+		log.Error.Printf("afDataRequest: %v", err)
+		return []*unp.Frame{confirm(znp.StatusSuccess)}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return []*unp.Frame{
+		confirm(znp.StatusSuccess),
+		s.incomingMessage(device, endpoint.Id, req.ClusterID, frame.Encode(responseFrame)),
+	}
+}
+
+// returns response command (and its id) for a ZCL request. nil response if none is to be sent
+func (d *Device) processZcl(endpoint *Endpoint, clusterId cluster.ClusterId, request *frame.Frame) (uint8, interface{}) {
+	defaultResponse := func(status cluster.ZclStatus) (uint8, interface{}) {
+		if request.FrameControl.DisableDefaultResponse == 1 && status == cluster.ZclStatusSuccess {
+			return 0, nil
+		}
+
+		return 0x0b, &cluster.DefaultResponseCommand{
+			CommandID: request.CommandIdentifier,
+			Status:    status,
+		}
+	}
+
+	if request.FrameControl.FrameType == frame.FrameTypeLocal {
+		if d.OnCommand == nil {
+			return defaultResponse(cluster.ZclStatusUnsupClusterCommand)
+		}
+
+		command, err := decodeLocalCommand(clusterId, request)
+		if err != nil {
+			log.Debug.Printf("decodeLocalCommand: %v", err)
+			return defaultResponse(cluster.ZclStatusUnsupClusterCommand)
+		}
+
+		status := d.OnCommand(d, endpoint, clusterId, command)
+
+		// some clusters answer with a cluster-specific response (having the request's command id)
+		// instead of a default response
+		if newStatusResponse, has := statusResponses[clusterId]; has {
+			return request.CommandIdentifier, newStatusResponse(status)
+		}
+
+		return defaultResponse(status)
+	}
+
+	switch request.CommandIdentifier {
+	case 0x00: // read attributes
+		req := &cluster.ReadAttributesCommand{}
+		if err := binstruct.Decode(request.Payload, req); err != nil {
+			return defaultResponse(cluster.ZclStatusMalformedCommand)
+		}
+
+		statuses := []*cluster.ReadAttributeStatus{}
+		for _, attributeId := range req.AttributeIDs {
+			attribute := d.Attribute(endpoint.Id, clusterId, cluster.AttributeId(attributeId))
+			if attribute == nil {
+				statuses = append(statuses, &cluster.ReadAttributeStatus{
+					AttributeID: attributeId,
+					Status:      cluster.ZclStatusUnsupportedAttribute,
+				})
+				continue
+			}
+
+			statuses = append(statuses, &cluster.ReadAttributeStatus{
+				AttributeID: attributeId,
+				Status:      cluster.ZclStatusSuccess,
+				Attribute:   attribute,
+			})
+		}
+
+		return 0x01, &cluster.ReadAttributesResponse{ReadAttributeStatuses: statuses}
+	case 0x02, 0x05: // write attributes (with and without response)
+		req := &cluster.WriteAttributesCommand{}
+		if err := binstruct.Decode(request.Payload, req); err != nil {
+			return defaultResponse(cluster.ZclStatusMalformedCommand)
+		}
+
+		failed := []*cluster.WriteAttributeStatus{}
+		for _, record := range req.WriteAttributeRecords {
+			if err := d.SetAttribute(endpoint.Id, clusterId, cluster.AttributeId(record.AttributeID), record.Attribute.Value); err != nil {
+				failed = append(failed, &cluster.WriteAttributeStatus{
+					Status:      cluster.ZclStatusUnsupportedAttribute,
+					AttributeID: record.AttributeID,
+				})
+			}
+		}
+
+		if request.CommandIdentifier == 0x05 {
+			return 0, nil
+		}
+
+		if len(failed) == 0 { // "only a single status is returned" on success
+			return 0x04, &cluster.WriteAttributesResponse{WriteAttributeStatuses: []*cluster.WriteAttributeStatus{
+				{Status: cluster.ZclStatusSuccess},
+			}}
+		}
+
+		return 0x04, &cluster.WriteAttributesResponse{WriteAttributeStatuses: failed}
+	case 0x06: // configure reporting. we don't report on our own, scripts call ReportAttributes()
+		return 0x07, &cluster.ConfigureReportingResponse{AttributeStatusRecords: []*cluster.AttributeStatusRecord{
+			{Status: cluster.ZclStatusSuccess},
+		}}
+	default:
+		return defaultResponse(cluster.ZclStatusUnsupGeneralCommand)
+	}
+}
+
+var statusResponses = map[cluster.ClusterId]func(status cluster.ZclStatus) cluster.StatusResponse{
+	cluster.IdClosuresDoorLock: func(status cluster.ZclStatus) cluster.StatusResponse {
+		return &cluster.ClosuresDoorLockStatusResponse{Status: status}
+	},
+}
+
+func decodeLocalCommand(clusterId cluster.ClusterId, request *frame.Frame) (interface{}, error) {
+	clusterDefinition, found := clusterLibrary.Clusters()[clusterId]
+	if !found {
+		return nil, fmt.Errorf("unknown cluster %d", clusterId)
+	}
+
+	descriptor, found := clusterDefinition.CommandDescriptors.Received[request.CommandIdentifier]
+	if !found {
+		return nil, fmt.Errorf("cluster %d has no command %d", clusterId, request.CommandIdentifier)
+	}
+
+	command := reflect.New(reflect.TypeOf(descriptor.Command).Elem()).Interface()
+
+	return command, binstruct.Decode(request.Payload, command)
+}
+
+func boolToUint8(b bool) uint8 {
+	if b {
+		return 1
+	} else {
+		return 0
+	}
+}
@@ -0,0 +1,381 @@
+package znpsim
+
+// ready-made devices for tests and "$ hautomo ezhub --serial-sim"
+
+import (
+	"github.com/function61/hautomo/pkg/ezstack/zcl/cluster"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+)
+
+const (
+	ModelOnOffLight        = "hautomo.sim.light"
+	ModelTemperatureSensor = "hautomo.sim.temperature"
+	ModelSmartPlug         = "hautomo.sim.plug"
+	ModelThermostat        = "hautomo.sim.thermostat"
+	ModelSmokeSensor       = "hautomo.sim.smoke"
+	ModelDoorLock          = "hautomo.sim.lock"
+
+	manufacturerName   = "hautomo"
+	powerSourceMains   = 0x01
+	powerSourceBattery = 0x03
+)
+
+// mains-powered router that turns on/off and reports its state like a real bulb does
+func OnOffLight(ieeeAddress zigbee.IEEEAddress, nwkAddress string) *Device {
+	light := &Device{
+		IEEEAddress:    ieeeAddress,
+		NetworkAddress: nwkAddress,
+		LogicalType:    zigbee.LogicalTypeRouter,
+		MainPowered:    true,
+		LinkQuality:    200,
+		Endpoints: []*Endpoint{
+			{
+				Id:          1,
+				ProfileId:   uint16(zigbee.ProfileHomeAutomation),
+				DeviceId:    0x0100, // "On/Off Light"
+				InClusters:  []cluster.ClusterId{cluster.IdGenBasic, cluster.IdGenOnOff},
+				OutClusters: []cluster.ClusterId{},
+			},
+		},
+		OnCommand: onOffCommand,
+	}
+
+	mustSetBasic(light, ModelOnOffLight, powerSourceMains)
+	mustSetAttribute(light.SetAttribute(1, cluster.IdGenOnOff, 0x0000, false))
+
+	return light
+}
+
+// battery-powered end device. report changes with SetTemperature()
+func TemperatureSensor(ieeeAddress zigbee.IEEEAddress, nwkAddress string) *Device {
+	sensor := &Device{
+		IEEEAddress:    ieeeAddress,
+		NetworkAddress: nwkAddress,
+		LogicalType:    zigbee.LogicalTypeEndDevice,
+		MainPowered:    false,
+		LinkQuality:    120,
+		Endpoints: []*Endpoint{
+			{
+				Id:          1,
+				ProfileId:   uint16(zigbee.ProfileHomeAutomation),
+				DeviceId:    0x0302, // "Temperature Sensor"
+				InClusters:  []cluster.ClusterId{cluster.IdGenBasic, cluster.IdMsTemperatureMeasurement},
+				OutClusters: []cluster.ClusterId{},
+			},
+		},
+	}
+
+	mustSetBasic(sensor, ModelTemperatureSensor, powerSourceBattery)
+	mustSetAttribute(sensor.SetAttribute(1, cluster.IdMsTemperatureMeasurement, 0x0000, int64(2150)))
+
+	return sensor
+}
+
+// sets and reports temperature (unit: 0.01 °C) of a TemperatureSensor()
+func SetTemperature(sensor *Device, centidegrees int64) error {
+	if err := sensor.SetAttribute(1, cluster.IdMsTemperatureMeasurement, 0x0000, centidegrees); err != nil {
+		return err
+	}
+
+	return sensor.ReportAttributes(1, cluster.IdMsTemperatureMeasurement, 0x0000)
+}
+
+// mains-powered router with power metering (and on/off), like a smart plug. change readings with SetPowerReadings()
+func SmartPlug(ieeeAddress zigbee.IEEEAddress, nwkAddress string) *Device {
+	plug := &Device{
+		IEEEAddress:    ieeeAddress,
+		NetworkAddress: nwkAddress,
+		LogicalType:    zigbee.LogicalTypeRouter,
+		MainPowered:    true,
+		LinkQuality:    180,
+		Endpoints: []*Endpoint{
+			{
+				Id:        1,
+				ProfileId: uint16(zigbee.ProfileHomeAutomation),
+				DeviceId:  0x0051, // "Smart Plug"
+				InClusters: []cluster.ClusterId{
+					cluster.IdGenBasic,
+					cluster.IdGenOnOff,
+					cluster.IdSeMetering,
+					cluster.IdHaElectricalMeasurement,
+				},
+				OutClusters: []cluster.ClusterId{},
+			},
+		},
+		OnCommand: onOffCommand,
+	}
+
+	mustSetBasic(plug, ModelSmartPlug, powerSourceMains)
+	mustSetAttribute(plug.SetAttribute(1, cluster.IdGenOnOff, 0x0000, false))
+
+	// power in 0.1 W, voltage in V, current in mA, energy in 0.01 kWh (like many real plugs)
+	for attributeId, value := range map[cluster.AttributeId]interface{}{
+		cluster.AttrHaElectricalMeasurementActivePower:         int64(0),
+		cluster.AttrHaElectricalMeasurementRmsVoltage:          uint64(230),
+		cluster.AttrHaElectricalMeasurementRmsCurrent:          uint64(0),
+		cluster.AttrHaElectricalMeasurementAcPowerMultiplier:   uint64(1),
+		cluster.AttrHaElectricalMeasurementAcPowerDivisor:      uint64(10),
+		cluster.AttrHaElectricalMeasurementAcVoltageMultiplier: uint64(1),
+		cluster.AttrHaElectricalMeasurementAcVoltageDivisor:    uint64(1),
+		cluster.AttrHaElectricalMeasurementAcCurrentMultiplier: uint64(1),
+		cluster.AttrHaElectricalMeasurementAcCurrentDivisor:    uint64(1000),
+	} {
+		mustSetAttribute(plug.SetAttribute(1, cluster.IdHaElectricalMeasurement, attributeId, value))
+	}
+
+	mustSetAttribute(plug.SetAttribute(1, cluster.IdSeMetering, cluster.AttrSeMeteringCurrentSummDelivered, uint64(0)))
+	mustSetAttribute(plug.SetAttribute(1, cluster.IdSeMetering, cluster.AttrSeMeteringMultiplier, uint64(1)))
+	mustSetAttribute(plug.SetAttribute(1, cluster.IdSeMetering, cluster.AttrSeMeteringDivisor, uint64(100)))
+
+	return plug
+}
+
+// sets and reports power (unit: 0.1 W) and cumulative energy (unit: 0.01 kWh) of a SmartPlug()
+func SetPowerReadings(plug *Device, deciwatts int64, centiKwh uint64) error {
+	if err := plug.SetAttribute(1, cluster.IdHaElectricalMeasurement, cluster.AttrHaElectricalMeasurementActivePower, deciwatts); err != nil {
+		return err
+	}
+
+	if err := plug.SetAttribute(1, cluster.IdSeMetering, cluster.AttrSeMeteringCurrentSummDelivered, centiKwh); err != nil {
+		return err
+	}
+
+	if err := plug.ReportAttributes(1, cluster.IdHaElectricalMeasurement, cluster.AttrHaElectricalMeasurementActivePower); err != nil {
+		return err
+	}
+
+	return plug.ReportAttributes(1, cluster.IdSeMetering, cluster.AttrSeMeteringCurrentSummDelivered)
+}
+
+// battery-powered radiator valve. setpoint and system mode are written by the coordinator
+func Thermostat(ieeeAddress zigbee.IEEEAddress, nwkAddress string) *Device {
+	thermostat := &Device{
+		IEEEAddress:    ieeeAddress,
+		NetworkAddress: nwkAddress,
+		LogicalType:    zigbee.LogicalTypeEndDevice,
+		MainPowered:    false,
+		LinkQuality:    140,
+		Endpoints: []*Endpoint{
+			{
+				Id:          1,
+				ProfileId:   uint16(zigbee.ProfileHomeAutomation),
+				DeviceId:    0x0301, // "Thermostat"
+				InClusters:  []cluster.ClusterId{cluster.IdGenBasic, cluster.IdHvacThermostat},
+				OutClusters: []cluster.ClusterId{},
+			},
+		},
+	}
+
+	mustSetBasic(thermostat, ModelThermostat, powerSourceBattery)
+
+	// temperatures in 0.01 °C
+	for attributeId, value := range map[cluster.AttributeId]interface{}{
+		cluster.AttrHvacThermostatLocalTemp:               int64(1950),
+		cluster.AttrHvacThermostatOccupiedHeatingSetpoint: int64(2000),
+		cluster.AttrHvacThermostatSystemMode:              uint64(cluster.ThermostatSystemModeHeat),
+		cluster.AttrHvacThermostatRunningState:            uint64(0b1), // heating
+		cluster.AttrHvacThermostatPIHeatingDemand:         uint64(30),
+	} {
+		mustSetAttribute(thermostat.SetAttribute(1, cluster.IdHvacThermostat, attributeId, value))
+	}
+
+	return thermostat
+}
+
+// battery-powered IAS zone device. starts out unenrolled, alarms with SetSmoke()
+func SmokeSensor(ieeeAddress zigbee.IEEEAddress, nwkAddress string) *Device {
+	sensor := &Device{
+		IEEEAddress:    ieeeAddress,
+		NetworkAddress: nwkAddress,
+		LogicalType:    zigbee.LogicalTypeEndDevice,
+		MainPowered:    false,
+		LinkQuality:    160,
+		Endpoints: []*Endpoint{
+			{
+				Id:          1,
+				ProfileId:   uint16(zigbee.ProfileHomeAutomation),
+				DeviceId:    0x0402, // "IAS Zone"
+				InClusters:  []cluster.ClusterId{cluster.IdGenBasic, cluster.IdSsIasZone},
+				OutClusters: []cluster.ClusterId{},
+			},
+		},
+		OnCommand: iasZoneCommand,
+	}
+
+	mustSetBasic(sensor, ModelSmokeSensor, powerSourceBattery)
+
+	for attributeId, value := range map[cluster.AttributeId]interface{}{
+		cluster.AttrSsIasZoneZoneState:  uint64(0), // not enrolled
+		cluster.AttrSsIasZoneZoneType:   uint64(cluster.IasZoneTypeFireSensor),
+		cluster.AttrSsIasZoneZoneStatus: uint64(0),
+		cluster.AttrSsIasZoneIasCieAddr: "0x0000000000000000",
+		cluster.AttrSsIasZoneZoneId:     uint64(0xff),
+	} {
+		mustSetAttribute(sensor.SetAttribute(1, cluster.IdSsIasZone, attributeId, value))
+	}
+
+	return sensor
+}
+
+// sends alarm (or its end) of a SmokeSensor()
+func SetSmoke(sensor *Device, smoke bool) error {
+	zoneStatus := cluster.ZoneStatus(boolToUint8(smoke))
+
+	if err := sensor.SetAttribute(1, cluster.IdSsIasZone, cluster.AttrSsIasZoneZoneStatus, uint64(zoneStatus)); err != nil {
+		return err
+	}
+
+	zoneId, _ := sensor.Attribute(1, cluster.IdSsIasZone, cluster.AttrSsIasZoneZoneId).Value.(uint64)
+
+	return sensor.SendServerCommand(1, &cluster.ZoneStatusChangeNotificationCommand{
+		ZoneStatus: zoneStatus,
+		ZoneID:     uint8(zoneId),
+	})
+}
+
+// IAS zone device gets enrolled when the CIE responds to it
+func iasZoneCommand(device *Device, endpoint *Endpoint, clusterId cluster.ClusterId, command interface{}) cluster.ZclStatus {
+	response, ok := command.(*cluster.ZoneEnrollResponseCommand)
+	if !ok {
+		return cluster.ZclStatusUnsupClusterCommand
+	}
+
+	if response.EnrollResponseCode != cluster.IasZoneEnrollResponseCodeSuccess {
+		return cluster.ZclStatusSuccess // the command itself was fine
+	}
+
+	if err := device.SetAttribute(endpoint.Id, cluster.IdSsIasZone, cluster.AttrSsIasZoneZoneState, uint64(1)); err != nil {
+		return cluster.ZclStatusFailure
+	}
+
+	if err := device.SetAttribute(endpoint.Id, cluster.IdSsIasZone, cluster.AttrSsIasZoneZoneId, uint64(response.ZoneID)); err != nil {
+		return cluster.ZclStatusFailure
+	}
+
+	return cluster.ZclStatusSuccess
+}
+
+// battery-powered door lock. starts out unlocked. PIN codes it was given can be checked with DoorLockPinCode()
+func DoorLock(ieeeAddress zigbee.IEEEAddress, nwkAddress string) *Device {
+	lock := &Device{
+		IEEEAddress:    ieeeAddress,
+		NetworkAddress: nwkAddress,
+		LogicalType:    zigbee.LogicalTypeEndDevice,
+		MainPowered:    false,
+		LinkQuality:    150,
+		Endpoints: []*Endpoint{
+			{
+				Id:          1,
+				ProfileId:   uint16(zigbee.ProfileHomeAutomation),
+				DeviceId:    0x000a, // "Door Lock"
+				InClusters:  []cluster.ClusterId{cluster.IdGenBasic, cluster.IdClosuresDoorLock},
+				OutClusters: []cluster.ClusterId{},
+			},
+		},
+		OnCommand: doorLockCommand,
+		pinCodes:  map[uint16]string{},
+	}
+
+	mustSetBasic(lock, ModelDoorLock, powerSourceBattery)
+	mustSetAttribute(lock.SetAttribute(1, cluster.IdClosuresDoorLock, cluster.AttrClosuresDoorLockLockState, uint64(cluster.DoorLockStateUnlocked)))
+
+	return lock
+}
+
+// PIN code of *user* of a DoorLock(). false if user has none
+func DoorLockPinCode(lock *Device, user uint16) (string, bool) {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+
+	pin, found := lock.pinCodes[user]
+	return pin, found
+}
+
+// locks/unlocks and stores PIN codes. like real locks, refuses PIN codes shorter than 4 digits
+func doorLockCommand(device *Device, endpoint *Endpoint, clusterId cluster.ClusterId, command interface{}) cluster.ZclStatus {
+	setLockState := func(state cluster.DoorLockState) cluster.ZclStatus {
+		if err := device.SetAttribute(endpoint.Id, cluster.IdClosuresDoorLock, cluster.AttrClosuresDoorLockLockState, uint64(state)); err != nil {
+			return cluster.ZclStatusFailure
+		}
+
+		if err := device.ReportAttributes(endpoint.Id, cluster.IdClosuresDoorLock, cluster.AttrClosuresDoorLockLockState); err != nil {
+			log.Error.Printf("doorLockCommand: %v", err)
+		}
+
+		return cluster.ZclStatusSuccess
+	}
+
+	switch cmd := command.(type) {
+	case *cluster.ClosuresDoorLockLockDoor:
+		return setLockState(cluster.DoorLockStateLocked)
+	case *cluster.ClosuresDoorLockUnlockDoor:
+		return setLockState(cluster.DoorLockStateUnlocked)
+	case *cluster.ClosuresDoorLockSetPinCode:
+		if len(cmd.PinCode) < 4 {
+			return cluster.ZclStatusFailure
+		}
+
+		device.mu.Lock()
+		defer device.mu.Unlock()
+
+		device.pinCodes[cmd.UserId] = cmd.PinCode
+
+		return cluster.ZclStatusSuccess
+	case *cluster.ClosuresDoorLockClearPinCode:
+		device.mu.Lock()
+		defer device.mu.Unlock()
+
+		delete(device.pinCodes, cmd.UserId)
+
+		return cluster.ZclStatusSuccess
+	default:
+		return cluster.ZclStatusUnsupClusterCommand
+	}
+}
+
+// turns genOnOff on/off and reports the new state like a real bulb (or plug) does
+func onOffCommand(device *Device, endpoint *Endpoint, clusterId cluster.ClusterId, command interface{}) cluster.ZclStatus {
+	on := func() *bool {
+		switch command.(type) {
+		case *cluster.GenOnOffOnCommand:
+			return boolPtr(true)
+		case *cluster.GenOnOffOffCommand:
+			return boolPtr(false)
+		case *cluster.GenOnOffToggleCommand:
+			current, _ := device.Attribute(endpoint.Id, cluster.IdGenOnOff, 0x0000).Value.(bool)
+			return boolPtr(!current)
+		default:
+			return nil
+		}
+	}()
+	if on == nil {
+		return cluster.ZclStatusUnsupClusterCommand
+	}
+
+	if err := device.SetAttribute(endpoint.Id, cluster.IdGenOnOff, 0x0000, *on); err != nil {
+		return cluster.ZclStatusFailure
+	}
+
+	if err := device.ReportAttributes(endpoint.Id, cluster.IdGenOnOff, 0x0000); err != nil {
+		log.Error.Printf("onOffCommand: %v", err)
+	}
+
+	return cluster.ZclStatusSuccess
+}
+
+func mustSetBasic(device *Device, model string, powerSource uint64) {
+	mustSetAttribute(device.SetAttribute(1, cluster.IdGenBasic, cluster.AttrBasicManufacturerName, manufacturerName))
+	mustSetAttribute(device.SetAttribute(1, cluster.IdGenBasic, cluster.AttrBasicModelId, model))
+	mustSetAttribute(device.SetAttribute(1, cluster.IdGenBasic, cluster.AttrBasicPowerSource, powerSource))
+}
+
+// templates only set attributes that we know exist
+func mustSetAttribute(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
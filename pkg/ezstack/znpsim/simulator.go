@@ -0,0 +1,661 @@
+// Simulated ZNP (the Zigbee radio) with scripted virtual devices, so that the whole stack can be
+// run without hardware - in tests or with "$ hautomo ezhub --serial-sim".
+//
+// The simulator speaks UNP framing over an io.ReadWriter, just like a CC2531 does over the serial
+// port, so everything from the stack down to UNP gets exercised.
+package znpsim
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/function61/gokit/log/logex"
+	"github.com/function61/hautomo/pkg/ezstack/binstruct"
+	"github.com/function61/hautomo/pkg/ezstack/coordinator"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+	"github.com/function61/hautomo/pkg/ezstack/znp"
+	"github.com/function61/hautomo/pkg/ezstack/znp/unp"
+)
+
+var log = logex.Levels(logex.Prefix("znpsim", logex.StandardLogger()))
+
+const (
+	maxNvReadLength    = 246 // what fits in a frame
+	factoryIEEEAddress = zigbee.IEEEAddress("0x00124b00fac70000")
+)
+
+// CC2531 running Z-Stack Home 1.2 (the default)
+var FirmwareZStack12 = znp.SysVersionResponse{
+	TransportRev: 2,
+	Product:      uint8(znp.ZStackVersion12),
+	MajorRel:     2,
+	MinorRel:     6,
+	MaintRel:     3,
+}
+
+// CC26x2 / CC1352 running Z-Stack 3.x.0
+var FirmwareZStack3x0 = znp.SysVersionResponse{
+	TransportRev: 2,
+	Product:      uint8(znp.ZStackVersion3x0),
+	MajorRel:     2,
+	MinorRel:     7,
+	MaintRel:     1,
+}
+
+type Simulator struct {
+	unp             *unp.Unp
+	port            io.ReadWriteCloser // our end of the pipe
+	hostPort        io.ReadWriteCloser // stack's end of the pipe
+	firmware        znp.SysVersionResponse
+	network         coordinator.NetworkConfiguration // as configured (= NVRAM)
+	nwkChannel      uint8                            // channel the network is on. differs from configured after a channel change
+	channelEnergy   map[uint8]uint8
+	nv              map[znp.NVRAMItemId][]byte
+	nvExtended      map[nvExtendedKey][]byte
+	linkKeys        map[zigbee.IEEEAddress]zigbee.LinkKey // from install codes
+	devices         []*Device
+	permitJoinUntil time.Time
+	afSequence      uint8
+	zclSequence     uint8
+	mu              sync.Mutex
+	writeMu         sync.Mutex // frames from Run() and from device scripts must not interleave
+}
+
+// simulated radio that has already been configured (= flashed) for *network*. give zero value to
+// simulate a factory-fresh radio
+func New(network coordinator.NetworkConfiguration) *Simulator {
+	return NewWithFirmware(network, FirmwareZStack12)
+}
+
+// like New(), but for a different firmware (e.g. FirmwareZStack3x0)
+func NewWithFirmware(network coordinator.NetworkConfiguration, firmware znp.SysVersionResponse) *Simulator {
+	hostPort, port := net.Pipe()
+
+	factoryFresh := network.IEEEAddress == ""
+	if factoryFresh { // even a factory-fresh radio has its address
+		network.IEEEAddress = factoryIEEEAddress
+	}
+
+	s := &Simulator{
+		unp:           unp.NewWith8BitsPayloadLength(port),
+		port:          port,
+		hostPort:      hostPort,
+		firmware:      firmware,
+		network:       network,
+		nwkChannel:    network.Channel,
+		channelEnergy: map[uint8]uint8{},
+		nv:            map[znp.NVRAMItemId][]byte{},
+		nvExtended:    map[nvExtendedKey][]byte{},
+		linkKeys:      map[zigbee.IEEEAddress]zigbee.LinkKey{},
+	}
+
+	s.setOnANetwork(!factoryFresh)
+	s.storeNetworkItems()
+	s.storeActiveKey()
+
+	return s
+}
+
+// give this to the stack in place of a serial port
+func (s *Simulator) Port() io.ReadWriteCloser {
+	return s.hostPort
+}
+
+// radio's network configuration. changes if the stack flashes new settings
+func (s *Simulator) Network() coordinator.NetworkConfiguration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.network
+}
+
+// what an energy scan reports for *channel* (default 0 = no interference)
+func (s *Simulator) SetChannelEnergy(channel uint8, energy uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.channelEnergy[channel] = energy
+}
+
+// raw NVRAM item, e.g. for setting up firmware-specific items that backups carry. nil if not found
+func (s *Simulator) NVRAM(id znp.NVRAMItemId) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]byte(nil), s.nv[id]...)
+}
+
+func (s *Simulator) SetNVRAM(id znp.NVRAMItemId, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nv[id] = append([]byte{}, value...)
+}
+
+func (s *Simulator) zstack3() bool {
+	return s.firmware.ZStackVersion().IsZStack3()
+}
+
+// extended NVRAM item (Z-Stack 3.x.0). nil if not found
+func (s *Simulator) ExtendedNVRAM(id znp.ExtendedNVRAMItemId, subId uint16) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]byte(nil), s.nvExtended[nvExtendedKey{uint16(id), subId}]...)
+}
+
+func (s *Simulator) SetExtendedNVRAM(id znp.ExtendedNVRAMItemId, subId uint16, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nvExtended[nvExtendedKey{uint16(id), subId}] = append([]byte{}, value...)
+}
+
+// link key a device can join with (registered from an install code)
+func (s *Simulator) LinkKey(device zigbee.IEEEAddress) (zigbee.LinkKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, found := s.linkKeys[device]
+	return key, found
+}
+
+// Z-Stack 1.2 is always in its configured network. Z-Stack 3 joins one with BDB commissioning
+func (s *Simulator) setOnANetwork(onANetwork bool) {
+	s.nv[(&znp.ZCDNVBDBNodeIsOnANetwork{}).ItemID()] = []byte{boolToUint8(onANetwork)}
+}
+
+func (s *Simulator) onANetwork() bool {
+	return !s.zstack3() || s.nv[(&znp.ZCDNVBDBNodeIsOnANetwork{}).ItemID()][0] != 0
+}
+
+// NVRAM items that mirror s.network, so the stack sees the same settings whichever way (UtilGetNvInfo
+// or NVRAM reads) it reads them
+func (s *Simulator) networkItems() []znp.NVRAMItem {
+	key := zigbee.NetworkKey{}
+	copy(key[:], s.network.NetworkKey)
+
+	channels := [4]byte{}
+	binary.LittleEndian.PutUint32(channels[:], 1<<s.network.Channel)
+
+	return []znp.NVRAMItem{
+		&znp.ZCDNVPANID{PANID: s.network.PanId},
+		&znp.ZCDNVExtPANID{ExtendedPANID: s.network.ExtPanId},
+		&znp.ZCDNVChanList{Channels: channels},
+		&znp.ZCDNVPreCfgKey{NetworkKey: key},
+	}
+}
+
+func (s *Simulator) storeNetworkItems() {
+	for _, item := range s.networkItems() {
+		s.nv[item.ItemID()] = binstruct.Encode(item)
+	}
+}
+
+// after the stack wrote an NVRAM item, which might be one of networkItems()
+func (s *Simulator) loadNetworkItem(id znp.NVRAMItemId) error {
+	for _, item := range s.networkItems() {
+		if item.ItemID() != id {
+			continue
+		}
+
+		if err := binstruct.Decode(s.nv[id], item); err != nil {
+			return err
+		}
+
+		switch item := item.(type) {
+		case *znp.ZCDNVPANID:
+			s.network.PanId = item.PANID
+		case *znp.ZCDNVExtPANID:
+			s.network.ExtPanId = item.ExtendedPANID
+		case *znp.ZCDNVChanList:
+			s.network.Channel = uint8(bits.TrailingZeros32(binary.LittleEndian.Uint32(item.Channels[:])))
+		case *znp.ZCDNVPreCfgKey:
+			s.network.NetworkKey = append([]byte{}, item.NetworkKey[:]...)
+		}
+	}
+
+	return nil
+}
+
+// a real radio moves the pre-configured key into use when it forms the network
+func (s *Simulator) storeActiveKey() {
+	key := zigbee.NetworkKey{}
+	copy(key[:], s.network.NetworkKey)
+
+	s.nv[(&znp.ZCDNVNwkActiveKeyInfo{}).ItemID()] = binstruct.Encode(&znp.ZCDNVNwkActiveKeyInfo{
+		Key: key,
+	})
+}
+
+// serves requests from the stack until *ctx* is canceled or the stack closes its port
+func (s *Simulator) Run(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		s.port.Close() // unblocks ReadFrame()
+	}()
+
+	for {
+		request, err := s.unp.ReadFrame()
+		if err != nil {
+			return s.hungUpOr(ctx, err)
+		}
+
+		responses, err := s.handle(request)
+		if err != nil {
+			return err
+		}
+
+		if err := s.send(responses...); err != nil {
+			return s.hungUpOr(ctx, err)
+		}
+	}
+}
+
+// we or the stack hanging up is a normal way to stop
+func (s *Simulator) hungUpOr(ctx context.Context, err error) error {
+	if ctx.Err() != nil || errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+		return nil
+	}
+
+	return err
+}
+
+// returns frames to send in response: SRSP first (for sync requests), then possible AREQs
+func (s *Simulator) handle(request *unp.Frame) ([]*unp.Frame, error) {
+	switch request.CommandType {
+	case unp.C_SREQ:
+		key := handlerKey{request.Subsystem, request.Command}
+
+		handler, found := syncHandlers[key]
+		if s.zstack3() && zstack12OnlyCommands[key] || !s.zstack3() && zstack3OnlyCommands[key] {
+			found = false
+		}
+
+		if !found {
+			log.Debug.Printf("unsupported SREQ: subsystem=%d command=0x%02x", request.Subsystem, request.Command)
+
+			return []*unp.Frame{{
+				CommandType: unp.C_SRSP,
+				Subsystem:   unp.S_RES0,
+				Command:     0,
+				Payload:     []byte{2}, // "Invalid command ID"
+			}}, nil
+		}
+
+		response, followUps, err := handler(s, request.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("subsystem=%d command=0x%02x: %w", request.Subsystem, request.Command, err)
+		}
+
+		return append([]*unp.Frame{{
+			CommandType: unp.C_SRSP,
+			Subsystem:   request.Subsystem,
+			Command:     request.Command,
+			Payload:     binstruct.Encode(response),
+		}}, followUps...), nil
+	case unp.C_AREQ:
+		switch {
+		case request.Subsystem == unp.S_SYS && request.Command == 0x00: // SysResetReq
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			s.permitJoinUntil = time.Time{}
+
+			startupOption := (&znp.ZCDNVStartUpOption{}).ItemID()
+			if option := s.nv[startupOption]; len(option) > 0 && option[0]&znp.StartOptionClearState != 0 {
+				s.setOnANetwork(false)
+				delete(s.nv, startupOption)
+			}
+
+			return []*unp.Frame{areq(unp.S_SYS, 0x80, &znp.SysResetInd{
+				Reason:       znp.ReasonExternal,
+				TransportRev: s.firmware.TransportRev,
+				Product:      s.firmware.Product,
+				MinorRel:     s.firmware.MinorRel,
+				HwRev:        s.firmware.MaintRel,
+			})}, nil
+		default:
+			log.Debug.Printf("unsupported AREQ: subsystem=%d command=0x%02x", request.Subsystem, request.Command)
+			return nil, nil
+		}
+	default:
+		return nil, fmt.Errorf("unexpected frame type from host: %d", request.CommandType)
+	}
+}
+
+type handlerKey struct {
+	subsystem unp.Subsystem
+	command   byte
+}
+
+// returns the SRSP payload and AREQs to send after it
+type syncHandler func(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error)
+
+var syncHandlers = map[handlerKey]syncHandler{
+	// SYS
+	{unp.S_SYS, 0x02}: locked(func(s *Simulator, _ []byte) (interface{}, []*unp.Frame, error) { // SysVersion
+		version := s.firmware
+		return &version, nil, nil
+	}),
+	{unp.S_SYS, 0x03}: locked(func(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) { // SysSetExtAddr
+		req := &znp.SysSetExtAddr{}
+		if err := binstruct.Decode(payload, req); err != nil {
+			return nil, nil, err
+		}
+
+		s.network.IEEEAddress = zigbee.IEEEAddress(req.ExtAddress)
+
+		return success(), nil, nil
+	}),
+	{unp.S_SYS, 0x08}: locked(func(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) { // SysOsalNvRead
+		req := &znp.SysOsalNvRead{}
+		if err := binstruct.Decode(payload, req); err != nil {
+			return nil, nil, err
+		}
+
+		return s.nvRead(req.ID, int(req.Offset)), nil, nil
+	}),
+	{unp.S_SYS, 0x09}: locked(func(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) { // SysOsalNvWrite
+		req := &znp.SysOsalNvWrite{}
+		if err := binstruct.Decode(payload, req); err != nil {
+			return nil, nil, err
+		}
+
+		s.nvWrite(req.ID, int(req.Offset), req.Value)
+
+		if err := s.loadNetworkItem(req.ID); err != nil {
+			return nil, nil, err
+		}
+
+		return success(), nil, nil
+	}),
+	{unp.S_SYS, 0x07}: locked(func(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) { // SysOsalNvItemInit
+		req := &znp.SysOsalNvItemInit{}
+		if err := binstruct.Decode(payload, req); err != nil {
+			return nil, nil, err
+		}
+
+		if _, exists := s.nv[znp.NVRAMItemId(req.ID)]; exists {
+			return success(), nil, nil
+		}
+
+		s.nv[znp.NVRAMItemId(req.ID)] = make([]byte, req.ItemLen)
+		s.nvWrite(znp.NVRAMItemId(req.ID), 0, req.InitData)
+
+		return &znp.StatusResponse{Status: znp.StatusItemCreatedAndInitialized}, nil, nil
+	}),
+	{unp.S_SYS, 0x13}: locked(func(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) { // SysOsalNvLength
+		req := &znp.SysOsalNvLength{}
+		if err := binstruct.Decode(payload, req); err != nil {
+			return nil, nil, err
+		}
+
+		return &znp.SysOsalNvLengthResponse{Length: uint16(len(s.nv[znp.NVRAMItemId(req.ID)]))}, nil, nil
+	}),
+	{unp.S_SYS, 0x1c}: locked(func(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) { // SysOsalNvReadExt
+		req := &znp.SysOsalNvReadExt{}
+		if err := binstruct.Decode(payload, req); err != nil {
+			return nil, nil, err
+		}
+
+		return s.nvRead(req.ID, int(req.Offset)), nil, nil
+	}),
+	{unp.S_SYS, 0x1d}: locked(func(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) { // SysOsalNvWriteExt
+		req := &znp.SysOsalNvWriteExt{}
+		if err := binstruct.Decode(payload, req); err != nil {
+			return nil, nil, err
+		}
+
+		s.nvWrite(req.ID, int(req.Offset), req.Value)
+
+		return success(), nil, nil
+	}),
+	{unp.S_SYS, 0x10}: locked(func(s *Simulator, _ []byte) (interface{}, []*unp.Frame, error) { // SysSetTime
+		return success(), nil, nil
+	}),
+
+	// UTIL
+	{unp.S_UTIL, 0x00}: locked(func(s *Simulator, _ []byte) (interface{}, []*unp.Frame, error) { // UtilGetDeviceInfo
+		associated := []string{}
+		for _, device := range s.joinedDevices() {
+			associated = append(associated, device.NetworkAddress)
+		}
+
+		return &znp.UtilGetDeviceInfoResponse{
+			Status:           znp.StatusSuccess,
+			IEEEAddr:         s.network.IEEEAddress.HexPrefixedString(),
+			ShortAddr:        zigbee.CoordinatorNwkAddr,
+			DeviceType:       &znp.DeviceType{Coordinator: 1},
+			DeviceState:      znp.DeviceStateStartedAsZigBeeCoordinator,
+			AssocDevicesList: associated,
+		}, nil, nil
+	}),
+	{unp.S_UTIL, 0x01}: locked(func(s *Simulator, _ []byte) (interface{}, []*unp.Frame, error) { // UtilGetNvInfo
+		key := zigbee.NetworkKey{}
+		copy(key[:], s.network.NetworkKey)
+
+		return &znp.UtilGetNvInfoResponse{
+			Status:        &znp.NvInfoStatus{},
+			IEEEAddr:      s.network.IEEEAddress.HexPrefixedString(),
+			ScanChannels:  bits.ReverseBytes32(1 << s.network.Channel), // see readNetworkConfigFromNVRAM()
+			PanID:         s.network.PanId,
+			SecurityLevel: 5,
+			PreConfigKey:  key,
+		}, nil, nil
+	}),
+	{unp.S_UTIL, 0x02}: locked(func(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) { // UtilSetPanId
+		req := &znp.UtilSetPanId{}
+		if err := binstruct.Decode(payload, req); err != nil {
+			return nil, nil, err
+		}
+
+		s.network.PanId = req.PanID
+		s.storeNetworkItems()
+
+		return success(), nil, nil
+	}),
+	{unp.S_UTIL, 0x03}: locked(func(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) { // UtilSetChannels
+		mask := struct{ Channels uint32 }{}
+		if err := binstruct.Decode(payload, &mask); err != nil {
+			return nil, nil, err
+		}
+
+		s.network.Channel = uint8(bits.TrailingZeros32(mask.Channels))
+		s.nwkChannel = s.network.Channel // simplification: we don't require a reset
+		s.storeNetworkItems()
+
+		return success(), nil, nil
+	}),
+	{unp.S_UTIL, 0x05}: locked(func(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) { // UtilSetPreCfgKey
+		req := &znp.UtilSetPreCfgKey{}
+		if err := binstruct.Decode(payload, req); err != nil {
+			return nil, nil, err
+		}
+
+		s.network.NetworkKey = append([]byte{}, req.PreCfgKey[:]...)
+		s.storeNetworkItems()
+		s.storeActiveKey()
+
+		return success(), nil, nil
+	}),
+	{unp.S_UTIL, 0x06}: locked(func(s *Simulator, _ []byte) (interface{}, []*unp.Frame, error) { // UtilCallbackSubCmd
+		return success(), nil, nil
+	}),
+	{unp.S_UTIL, 0x40}: locked(func(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) { // UtilAddrMgrExtAddrLookup
+		req := &znp.UtilAddrMgrExtAddrLookup{}
+		if err := binstruct.Decode(payload, req); err != nil {
+			return nil, nil, err
+		}
+
+		for _, device := range s.joinedDevices() {
+			if device.IEEEAddress.HexPrefixedString() == req.ExtAddr {
+				return &znp.UtilAddrMgrExtAddrLookupResponse{NwkAddr: device.NetworkAddress}, nil, nil
+			}
+		}
+
+		return &znp.UtilAddrMgrExtAddrLookupResponse{NwkAddr: zigbee.UnknownNwkAddr}, nil, nil
+	}),
+	{unp.S_UTIL, 0x0a}: locked(func(s *Simulator, _ []byte) (interface{}, []*unp.Frame, error) { // UtilLedControl
+		return success(), nil, nil
+	}),
+
+	// SAPI
+	{unp.S_SAPI, 0x00}: locked(func(s *Simulator, _ []byte) (interface{}, []*unp.Frame, error) { // SapiZbStartRequest
+		return &znp.EmptyResponse{}, nil, nil
+	}),
+	{unp.S_SAPI, 0x05}: locked(func(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) { // SapiZbWriteConfiguration
+		req := &znp.SapiZbWriteConfiguration{}
+		if err := binstruct.Decode(payload, req); err != nil {
+			return nil, nil, err
+		}
+
+		s.nv[znp.NVRAMItemId(req.ConfigID)] = req.Value
+
+		return success(), nil, nil
+	}),
+
+	// AF
+	{unp.S_AF, 0x00}: func(s *Simulator, _ []byte) (interface{}, []*unp.Frame, error) { // AfRegister
+		return success(), nil, nil
+	},
+	{unp.S_AF, 0x01}: func(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) { // AfDataRequest
+		req := &znp.AfDataRequest{}
+		if err := binstruct.Decode(payload, req); err != nil {
+			return nil, nil, err
+		}
+
+		return success(), s.afDataRequest(req), nil
+	},
+	{unp.S_AF, 0x02}: func(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) { // AfDataRequestExt
+		req := &znp.AfDataRequestExt{}
+		if err := binstruct.Decode(payload, req); err != nil {
+			return nil, nil, err
+		}
+
+		// groupcasts have no responses, so the radio just confirms having sent it
+		return success(), []*unp.Frame{areq(unp.S_AF, 0x80, &znp.AfDataConfirm{
+			Status:   znp.StatusSuccess,
+			Endpoint: req.SrcEndpoint,
+			TransID:  req.TransID,
+		})}, nil
+	},
+
+	// ZDO
+	{unp.S_ZDO, 0x02}: locked(zdoNodeDescReq),
+	{unp.S_ZDO, 0x04}: locked(zdoSimpleDescReq),
+	{unp.S_ZDO, 0x05}: locked(zdoActiveEpReq),
+	{unp.S_ZDO, 0x21}: locked(zdoBindReq),
+	{unp.S_ZDO, 0x22}: locked(zdoUnbindReq),
+	{unp.S_ZDO, 0x31}: locked(zdoMgmtLqiReq),
+	{unp.S_ZDO, 0x32}: locked(zdoMgmtRtgReq),
+	{unp.S_ZDO, 0x33}: locked(zdoMgmtBindReq),
+	{unp.S_ZDO, 0x34}: locked(zdoMgmtLeaveReq),
+	{unp.S_ZDO, 0x36}: locked(zdoMgmtPermitJoinReq),
+	{unp.S_ZDO, 0x37}: locked(zdoMgmtNwkUpdateReq),
+	{unp.S_ZDO, 0x40}: locked(zdoStartupFromApp),
+	{unp.S_ZDO, 0x4e}: locked(zdoExtUpdateNwkKey),
+	{unp.S_ZDO, 0x4f}: locked(zdoExtSwitchNwkKey),
+	{unp.S_ZDO, 0x50}: locked(zdoExtNwkInfo),
+
+	// APP_CNF
+	{unp.S_APP_CNF, 0x04}: locked(appCnfBdbAddInstallCode),
+	{unp.S_APP_CNF, 0x05}: locked(appCnfBdbStartCommissioning),
+	{unp.S_APP_CNF, 0x08}: locked(appCnfBdbSetChannel),
+
+	// SYS extended NVRAM
+	{unp.S_SYS, 0x30}: locked(sysNvCreate),
+	{unp.S_SYS, 0x31}: locked(sysNvDelete),
+	{unp.S_SYS, 0x32}: locked(sysNvLength),
+	{unp.S_SYS, 0x33}: locked(sysNvRead),
+	{unp.S_SYS, 0x34}: locked(sysNvWrite),
+}
+
+// Z-Stack 3 doesn't have these
+var zstack12OnlyCommands = map[handlerKey]bool{
+	{unp.S_UTIL, 0x01}: true, // UtilGetNvInfo
+	{unp.S_SAPI, 0x00}: true, // SapiZbStartRequest
+	{unp.S_SAPI, 0x05}: true, // SapiZbWriteConfiguration
+}
+
+var zstack3OnlyCommands = map[handlerKey]bool{
+	{unp.S_APP_CNF, 0x04}: true,
+	{unp.S_APP_CNF, 0x05}: true,
+	{unp.S_APP_CNF, 0x08}: true,
+	{unp.S_SYS, 0x30}:     true,
+	{unp.S_SYS, 0x31}:     true,
+	{unp.S_SYS, 0x32}:     true,
+	{unp.S_SYS, 0x33}:     true,
+	{unp.S_SYS, 0x34}:     true,
+}
+
+// for handlers that touch the radio's state
+func locked(handler syncHandler) syncHandler {
+	return func(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		return handler(s, payload)
+	}
+}
+
+// like the real thing, returns at most maxNvReadLength bytes per read
+func (s *Simulator) nvRead(id znp.NVRAMItemId, offset int) *znp.SysOsalNvReadResponse {
+	value, found := s.nv[id]
+	if !found || offset > len(value) {
+		return &znp.SysOsalNvReadResponse{Status: znp.StatusInvalidParameter, Value: []byte{}}
+	}
+
+	value = value[offset:]
+	if len(value) > maxNvReadLength {
+		value = value[:maxNvReadLength]
+	}
+
+	return &znp.SysOsalNvReadResponse{Status: znp.StatusSuccess, Value: value}
+}
+
+// items are created on demand (unlike in a real radio), so tests needn't set up each item
+func (s *Simulator) nvWrite(id znp.NVRAMItemId, offset int, value []byte) {
+	item := s.nv[id]
+	if end := offset + len(value); end > len(item) {
+		item = append(item, make([]byte, end-len(item))...)
+	}
+
+	copy(item[offset:], value)
+
+	s.nv[id] = item
+}
+
+func success() *znp.StatusResponse {
+	return &znp.StatusResponse{Status: znp.StatusSuccess}
+}
+
+func areq(subsystem unp.Subsystem, command byte, payload interface{}) *unp.Frame {
+	return &unp.Frame{
+		CommandType: unp.C_AREQ,
+		Subsystem:   subsystem,
+		Command:     command,
+		Payload:     binstruct.Encode(payload),
+	}
+}
+
+// also for AREQs that we send on our own initiative (e.g. device reporting its sensor values)
+func (s *Simulator) send(frames ...*unp.Frame) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	for _, frame := range frames {
+		if err := s.unp.WriteFrame(frame); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
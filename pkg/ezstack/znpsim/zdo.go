@@ -0,0 +1,403 @@
+package znpsim
+
+// ZDO requests: device descriptors, bindings and network management. the radio acknowledges
+// the request (SRSP) and the device's answer arrives later as an AREQ
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"time"
+
+	"github.com/function61/hautomo/pkg/ezstack/binstruct"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+	"github.com/function61/hautomo/pkg/ezstack/znp"
+	"github.com/function61/hautomo/pkg/ezstack/znp/unp"
+)
+
+func zdoNodeDescReq(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.ZdoNodeDescReq{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	device := s.deviceByNetworkAddress(req.NWKAddrOfInterest)
+	if device == nil {
+		return &znp.StatusResponse{Status: znp.StatusNwkNoRoute}, nil, nil
+	}
+
+	return success(), []*unp.Frame{areq(unp.S_ZDO, 0x82, &znp.ZdoNodeDescRsp{
+		SrcAddr:           device.NetworkAddress,
+		Status:            znp.StatusSuccess,
+		NWKAddrOfInterest: device.NetworkAddress,
+		LogicalType:       device.LogicalType,
+		FrequencyBand:     0b010, // 2.4 GHz
+		MacCapabilitiesFlags: &znp.CapInfo{
+			Router:             boolToUint8(device.LogicalType == zigbee.LogicalTypeRouter),
+			MainPowered:        boolToUint8(device.MainPowered),
+			ReceiverOnWhenIdle: boolToUint8(device.MainPowered),
+			AllocAddr:          1,
+		},
+		ManufacturerCode:   device.ManufacturerCode,
+		MaxBufferSize:      80,
+		MaxInTransferSize:  160,
+		ServerMask:         &znp.ServerMask{},
+		MaxOutTransferSize: 160,
+	})}, nil
+}
+
+func zdoActiveEpReq(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.ZdoActiveEpReq{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	device := s.deviceByNetworkAddress(req.NWKAddrOfInterest)
+	if device == nil {
+		return &znp.StatusResponse{Status: znp.StatusNwkNoRoute}, nil, nil
+	}
+
+	endpoints := []zigbee.EndpointId{}
+	for _, endpoint := range device.Endpoints {
+		endpoints = append(endpoints, endpoint.Id)
+	}
+
+	return success(), []*unp.Frame{areq(unp.S_ZDO, 0x85, &znp.ZdoActiveEpRsp{
+		SrcAddr:      device.NetworkAddress,
+		Status:       znp.StatusSuccess,
+		NWKAddr:      device.NetworkAddress,
+		ActiveEPList: endpoints,
+	})}, nil
+}
+
+func zdoSimpleDescReq(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.ZdoSimpleDescReq{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	device := s.deviceByNetworkAddress(req.NWKAddrOfInterest)
+	if device == nil {
+		return &znp.StatusResponse{Status: znp.StatusNwkNoRoute}, nil, nil
+	}
+
+	endpoint := device.endpoint(req.Endpoint)
+	if endpoint == nil {
+		return success(), []*unp.Frame{areq(unp.S_ZDO, 0x84, &znp.ZdoSimpleDescRsp{
+			SrcAddr:        device.NetworkAddress,
+			Status:         znp.StatusZdpNotActive,
+			NWKAddr:        device.NetworkAddress,
+			Endpoint:       req.Endpoint,
+			InClusterList:  []uint16{},
+			OutClusterList: []uint16{},
+		})}, nil
+	}
+
+	inClusters := []uint16{}
+	for _, clusterId := range endpoint.InClusters {
+		inClusters = append(inClusters, uint16(clusterId))
+	}
+
+	outClusters := []uint16{}
+	for _, clusterId := range endpoint.OutClusters {
+		outClusters = append(outClusters, uint16(clusterId))
+	}
+
+	return success(), []*unp.Frame{areq(unp.S_ZDO, 0x84, &znp.ZdoSimpleDescRsp{
+		SrcAddr:        device.NetworkAddress,
+		Status:         znp.StatusSuccess,
+		NWKAddr:        device.NetworkAddress,
+		Len:            uint8(8 + 2*len(inClusters) + 2*len(outClusters)),
+		Endpoint:       endpoint.Id,
+		ProfileID:      endpoint.ProfileId,
+		DeviceID:       endpoint.DeviceId,
+		InClusterList:  inClusters,
+		OutClusterList: outClusters,
+	})}, nil
+}
+
+func zdoBindReq(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.ZdoBindUnbindReq{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	device := s.deviceByNetworkAddress(req.DstAddr)
+	if device == nil {
+		return &znp.StatusResponse{Status: znp.StatusNwkNoRoute}, nil, nil
+	}
+
+	device.mu.Lock()
+	device.bindings = append(device.bindings, bindingFromRequest(req))
+	device.mu.Unlock()
+
+	return success(), []*unp.Frame{areq(unp.S_ZDO, 0xa1, &znp.ZdoBindRsp{
+		SrcAddr: device.NetworkAddress,
+		Status:  znp.StatusSuccess,
+	})}, nil
+}
+
+func zdoUnbindReq(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.ZdoBindUnbindReq{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	device := s.deviceByNetworkAddress(req.DstAddr)
+	if device == nil {
+		return &znp.StatusResponse{Status: znp.StatusNwkNoRoute}, nil, nil
+	}
+
+	unbound := bindingFromRequest(req)
+	status := znp.StatusZdpNoEntry
+
+	device.mu.Lock()
+	for i, binding := range device.bindings {
+		if binding.SrcEndpoint == unbound.SrcEndpoint &&
+			binding.ClusterID == unbound.ClusterID &&
+			*binding.DstAddr == *unbound.DstAddr {
+			device.bindings = append(device.bindings[:i], device.bindings[i+1:]...)
+			status = znp.StatusSuccess
+			break
+		}
+	}
+	device.mu.Unlock()
+
+	return success(), []*unp.Frame{areq(unp.S_ZDO, 0xa2, &znp.ZdoUnbindRsp{
+		SrcAddr: device.NetworkAddress,
+		Status:  status,
+	})}, nil
+}
+
+func bindingFromRequest(req *znp.ZdoBindUnbindReq) *znp.Binding {
+	dst := &znp.Addr{AddrMode: req.DstAddrMode}
+	if req.DstAddrMode == znp.AddrModeAddrGroup { // group id is in the low 2 bytes
+		groupId, _ := strconv.ParseUint(req.DstAddress[2:], 16, 64)
+		dst.ShortAddr = fmt.Sprintf("0x%04x", uint16(groupId))
+	} else {
+		dst.ExtendedAddr = req.DstAddress
+		dst.DstEndpoint = req.DstEndpoint
+	}
+
+	return &znp.Binding{
+		SrcAddr:     req.SrcAddress,
+		SrcEndpoint: req.SrcEndpoint,
+		ClusterID:   req.ClusterID,
+		DstAddr:     dst,
+	}
+}
+
+func zdoMgmtBindReq(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.ZdoMgmtBindReq{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	device := s.deviceByNetworkAddress(req.DstAddr)
+	if device == nil {
+		return &znp.StatusResponse{Status: znp.StatusNwkNoRoute}, nil, nil
+	}
+
+	device.mu.Lock()
+	bindings := device.bindings
+	device.mu.Unlock()
+
+	page := []*znp.Binding{}
+	if int(req.StartIndex) < len(bindings) {
+		page = bindings[req.StartIndex:]
+	}
+
+	return success(), []*unp.Frame{areq(unp.S_ZDO, 0xb3, &znp.ZdoMgmtBindRsp{
+		SrcAddr:          device.NetworkAddress,
+		Status:           znp.StatusSuccess,
+		BindTableEntries: uint8(len(bindings)),
+		StartIndex:       req.StartIndex,
+		BindTable:        page,
+	})}, nil
+}
+
+// coordinator sees all joined devices as its children. routers don't have neighbors (for now)
+func zdoMgmtLqiReq(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.ZdoMgmtLqiReq{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	neighbors := []*znp.NeighborLqi{}
+
+	if req.DstAddr == zigbee.CoordinatorNwkAddr {
+		for _, device := range s.joinedDevices() {
+			neighbors = append(neighbors, &znp.NeighborLqi{
+				ExtendedPanID:   uint64(s.network.ExtPanId),
+				ExtendedAddress: device.IEEEAddress.HexPrefixedString(),
+				NetworkAddress:  device.NetworkAddress,
+				DeviceType:      lqiDeviceType(device.LogicalType),
+				RxOnWhenIdle:    boolToUint8(device.MainPowered),
+				Relationship:    1, // child
+				Depth:           1,
+				LQI:             device.LinkQuality,
+			})
+		}
+	} else if s.deviceByNetworkAddress(req.DstAddr) == nil {
+		return &znp.StatusResponse{Status: znp.StatusNwkNoRoute}, nil, nil
+	}
+
+	page := []*znp.NeighborLqi{}
+	if int(req.StartIndex) < len(neighbors) {
+		page = neighbors[req.StartIndex:]
+	}
+
+	return success(), []*unp.Frame{areq(unp.S_ZDO, 0xb1, &znp.ZdoMgmtLqiRsp{
+		SrcAddr:              req.DstAddr,
+		Status:               znp.StatusSuccess,
+		NeighborTableEntries: uint8(len(neighbors)),
+		StartIndex:           req.StartIndex,
+		NeighborLqiList:      page,
+	})}, nil
+}
+
+func lqiDeviceType(logicalType zigbee.LogicalType) znp.LqiDeviceType {
+	switch logicalType {
+	case zigbee.LogicalTypeCoordinator:
+		return znp.LqiDeviceTypeCoordinator
+	case zigbee.LogicalTypeRouter:
+		return znp.LqiDeviceTypeRouter
+	default:
+		return znp.LqiDeviceTypeEndDevice
+	}
+}
+
+func zdoMgmtRtgReq(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.ZdoMgmtRtgReq{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	return success(), []*unp.Frame{areq(unp.S_ZDO, 0xb2, &znp.ZdoMgmtRtgRsp{
+		SrcAddr:      req.DstAddr,
+		Status:       znp.StatusZdpNotSupported,
+		RoutingTable: []*znp.Route{},
+	})}, nil
+}
+
+func zdoMgmtLeaveReq(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.ZdoMgmtLeaveReq{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	device := s.deviceByNetworkAddress(req.DstAddr)
+	if device == nil {
+		return &znp.StatusResponse{Status: znp.StatusNwkNoRoute}, nil, nil
+	}
+
+	device.mu.Lock()
+	device.Joined = false
+	device.mu.Unlock()
+
+	return success(), []*unp.Frame{
+		areq(unp.S_ZDO, 0xb4, &znp.ZdoMgmtLeaveRsp{
+			SrcAddr: device.NetworkAddress,
+			Status:  znp.StatusSuccess,
+		}),
+		device.leaveIndication(),
+	}, nil
+}
+
+func zdoMgmtPermitJoinReq(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.ZdoMgmtPermitJoinReq{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	s.permitJoinUntil = time.Now().Add(time.Duration(req.Duration) * time.Second)
+
+	if s.permitJoinActive() {
+		s.announceUnjoined()
+	}
+
+	return success(), []*unp.Frame{areq(unp.S_ZDO, 0xb6, &znp.ZdoMgmtPermitJoinRsp{
+		SrcAddr: zigbee.CoordinatorNwkAddr,
+		Status:  znp.StatusSuccess,
+	})}, nil
+}
+
+func zdoMgmtNwkUpdateReq(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := struct {
+		DstAddr        string `hex:"2"`
+		DstAddrMode    znp.AddrMode
+		ChannelMask    uint32
+		ScanDuration   uint8
+		ScanCount      uint8
+		NwkManagerAddr string `hex:"2"`
+	}{}
+	if err := binstruct.Decode(payload, &req); err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case req.ScanDuration == 0xfe: // change channel. (we only simulate the coordinator switching)
+		s.nwkChannel = uint8(bits.TrailingZeros32(req.ChannelMask))
+
+		return success(), nil, nil
+	case req.ScanDuration <= 5 && req.DstAddr == zigbee.CoordinatorNwkAddr: // energy scan
+		energies := []uint8{}
+		for channel := uint8(0); channel < 32; channel++ {
+			if req.ChannelMask&(1<<channel) != 0 {
+				energies = append(energies, s.channelEnergy[channel])
+			}
+		}
+
+		return success(), []*unp.Frame{areq(unp.S_ZDO, 0xb8, &znp.ZdoMgmtNwkUpdateNotify{
+			SrcAddr:         zigbee.CoordinatorNwkAddr,
+			Status:          znp.StatusSuccess,
+			ScannedChannels: req.ChannelMask,
+			EnergyValues:    energies,
+		})}, nil
+	default:
+		return &znp.StatusResponse{Status: znp.StatusInvalidParameter}, nil, nil
+	}
+}
+
+func zdoExtNwkInfo(s *Simulator, _ []byte) (interface{}, []*unp.Frame, error) {
+	return &znp.ZdoExtNwkInfoResponse{
+		ShortAddress:          zigbee.CoordinatorNwkAddr,
+		DeviceState:           znp.DeviceStateStartedAsZigBeeCoordinator,
+		PanID:                 uint16(s.network.PanId),
+		ParentAddress:         zigbee.CoordinatorNwkAddr,
+		ExtendedPanID:         uint64(s.network.ExtPanId),
+		ExtendedParentAddress: s.network.IEEEAddress.HexPrefixedString(),
+		Channel:               s.nwkChannel,
+	}, nil, nil
+}
+
+// stores the key as the alternate key, like the radio does for itself (we don't simulate devices' keys)
+func zdoExtUpdateNwkKey(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.ZdoExtUpdateNwkKey{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	s.nv[znp.NVRAMItemNwkAlternKeyInfo] = binstruct.Encode(&znp.ZCDNVNwkActiveKeyInfo{
+		KeySeqNum: req.KeySeqNum,
+		Key:       req.Key,
+	})
+
+	return success(), nil, nil
+}
+
+func zdoExtSwitchNwkKey(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.ZdoExtSwitchNwkKey{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	alternate := &znp.ZCDNVNwkActiveKeyInfo{}
+	if err := binstruct.Decode(s.nv[znp.NVRAMItemNwkAlternKeyInfo], alternate); err != nil || alternate.KeySeqNum != req.KeySeqNum {
+		return &znp.StatusResponse{Status: znp.StatusInvalidParameter}, nil, nil
+	}
+
+	s.nv[alternate.ItemID()] = binstruct.Encode(alternate)
+
+	return success(), nil, nil
+}
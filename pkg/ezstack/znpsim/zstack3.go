@@ -0,0 +1,178 @@
+package znpsim
+
+// Z-Stack 3 specifics: network formation with BDB commissioning, starting with ZdoStartupFromApp
+// and the extended NVRAM (tables in Z-Stack 3.x.0)
+
+import (
+	"math/bits"
+
+	"github.com/function61/hautomo/pkg/ezstack/binstruct"
+	"github.com/function61/hautomo/pkg/ezstack/zigbee"
+	"github.com/function61/hautomo/pkg/ezstack/znp"
+	"github.com/function61/hautomo/pkg/ezstack/znp/unp"
+)
+
+const nvSysIdZStack = 0x01 // we only simulate the stack's items, not the application's
+
+type nvExtendedKey struct {
+	item  uint16
+	subId uint16
+}
+
+func zdoStartupFromApp(s *Simulator, _ []byte) (interface{}, []*unp.Frame, error) {
+	if !s.onANetwork() { // BDB commissioning forms (or joins) the network
+		return &znp.ZdoStartupFromAppResponse{Status: znp.StartupFromAppStatusNewNetworkState}, nil, nil
+	}
+
+	return &znp.ZdoStartupFromAppResponse{Status: znp.StartupFromAppStatusRestoredNetworkState}, []*unp.Frame{
+		areq(unp.S_ZDO, 0xc0, &znp.ZdoStateChangeInd{State: znp.DeviceStateStartedAsZigBeeCoordinator}),
+	}, nil
+}
+
+func appCnfBdbStartCommissioning(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.AppCnfBdbStartCommissioning{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	notification := func(status znp.CommissioningStatus) *unp.Frame {
+		return areq(unp.S_APP_CNF, 0x80, &znp.AppCnfBdbCommissioningNotification{
+			CommissioningStatus:         status,
+			CommissioningMode:           req.CommissioningMode,
+			RemainingCommissioningModes: &znp.RemainingCommissioningModes{},
+		})
+	}
+
+	if req.CommissioningMode&znp.CommissioningModeNetworkFormation == 0 {
+		return success(), []*unp.Frame{notification(znp.CommissioningStatusNoNetwork)}, nil
+	}
+
+	s.setOnANetwork(true)
+	s.nwkChannel = s.network.Channel
+	s.storeActiveKey()
+
+	return success(), []*unp.Frame{
+		notification(znp.CommissioningStatusInProgress),
+		areq(unp.S_ZDO, 0xc0, &znp.ZdoStateChangeInd{State: znp.DeviceStateStartedAsZigBeeCoordinator}),
+		notification(znp.CommissioningStatusSuccess),
+	}, nil
+}
+
+func appCnfBdbSetChannel(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := struct {
+		IsPrimary uint8
+		Channels  uint32
+	}{}
+	if err := binstruct.Decode(payload, &req); err != nil {
+		return nil, nil, err
+	}
+
+	// formation uses the primary channel. we don't simulate scanning the secondary ones
+	if req.IsPrimary == 1 && req.Channels != 0 {
+		s.network.Channel = uint8(bits.TrailingZeros32(req.Channels))
+		s.storeNetworkItems()
+	}
+
+	return success(), nil, nil
+}
+
+func sysNvCreate(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.SysNvCreate{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	key := nvExtendedKey{req.ItemID, req.SubID}
+
+	if _, exists := s.nvExtended[key]; exists || req.SysID != nvSysIdZStack {
+		return success(), nil, nil
+	}
+
+	s.nvExtended[key] = make([]byte, req.Length)
+
+	return &znp.StatusResponse{Status: znp.StatusItemCreatedAndInitialized}, nil, nil
+}
+
+func sysNvDelete(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.SysNvDelete{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	delete(s.nvExtended, nvExtendedKey{req.ItemID, req.SubID})
+
+	return success(), nil, nil
+}
+
+func sysNvLength(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.SysNvLength{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	if req.SysID != nvSysIdZStack {
+		return &znp.SysNvLengthResponse{Length: 0}, nil, nil
+	}
+
+	return &znp.SysNvLengthResponse{Length: uint32(len(s.nvExtended[nvExtendedKey{req.ItemID, req.SubID}]))}, nil, nil
+}
+
+func sysNvRead(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.SysNvRead{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	value, found := s.nvExtended[nvExtendedKey{req.ItemID, req.SubID}]
+	if !found || req.SysID != nvSysIdZStack || int(req.Offset) > len(value) {
+		return &znp.SysNvReadResponse{Status: znp.StatusInvalidParameter, Value: []byte{}}, nil, nil
+	}
+
+	value = value[req.Offset:]
+	if len(value) > int(req.Length) {
+		value = value[:req.Length]
+	}
+
+	return &znp.SysNvReadResponse{Status: znp.StatusSuccess, Value: value}, nil, nil
+}
+
+func sysNvWrite(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.SysNvWrite{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	key := nvExtendedKey{req.ItemID, req.SubID}
+
+	// unlike the legacy items, these have to be created first
+	item, found := s.nvExtended[key]
+	if !found || req.SysID != nvSysIdZStack || int(req.Offset)+len(req.Value) > len(item) {
+		return &znp.StatusResponse{Status: znp.StatusInvalidParameter}, nil, nil
+	}
+
+	copy(item[req.Offset:], req.Value)
+
+	return success(), nil, nil
+}
+
+func appCnfBdbAddInstallCode(s *Simulator, payload []byte) (interface{}, []*unp.Frame, error) {
+	req := &znp.AppCnfBdbAddInstallCode{}
+	if err := binstruct.Decode(payload, req); err != nil {
+		return nil, nil, err
+	}
+
+	key := zigbee.LinkKey{}
+
+	switch {
+	case req.InstallCodeFormat == znp.InstallCodeFormatKeyDerivedFromInstallCode && len(req.InstallCode) == len(key):
+		copy(key[:], req.InstallCode)
+	case req.InstallCodeFormat == znp.InstallCodeFormatCodePlusCrc && len(req.InstallCode) == 18:
+		key = zigbee.InstallCode(req.InstallCode).LinkKey()
+	default:
+		return &znp.StatusResponse{Status: znp.StatusInvalidParameter}, nil, nil
+	}
+
+	s.linkKeys[zigbee.IEEEAddress(req.IEEEAddr)] = key
+
+	return success(), nil, nil
+}
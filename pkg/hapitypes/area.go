@@ -0,0 +1,76 @@
+package hapitypes
+
+import (
+	"time"
+)
+
+// room or other logical part of the home, e.g. "kitchen"
+type AreaConfig struct {
+	Id                      string `json:"id"`
+	Name                    string `json:"name"`
+	OccupancyTimeoutSeconds int    `json:"occupancy_timeout_seconds,omitempty"` // area stays occupied this long after last motion. if zero, DefaultOccupancyTimeout is used
+}
+
+const DefaultOccupancyTimeout = 15 * time.Minute
+
+func (a AreaConfig) OccupancyTimeout() time.Duration {
+	if a.OccupancyTimeoutSeconds == 0 {
+		return DefaultOccupancyTimeout
+	}
+
+	return time.Duration(a.OccupancyTimeoutSeconds) * time.Second
+}
+
+type Area struct {
+	Conf    AreaConfig
+	Devices []*Device
+
+	Occupied bool // as of last UpdateOccupied()
+}
+
+func NewArea(conf AreaConfig) *Area {
+	return &Area{
+		Conf:    conf,
+		Devices: []*Device{},
+	}
+}
+
+// area is occupied if any motion sensor in it has seen motion recently. returns true if
+// occupancy changed since last call.
+func (a *Area) UpdateOccupied(now time.Time) bool {
+	occupied := false
+
+	for _, device := range a.Devices {
+		if device.LastMotion != nil && now.Sub(*device.LastMotion) < a.Conf.OccupancyTimeout() {
+			occupied = true
+			break
+		}
+	}
+
+	changed := occupied != a.Occupied
+	a.Occupied = occupied
+
+	return changed
+}
+
+// average of latest temperature readings of devices in the area. ok=false if no device in the
+// area has reported temperature.
+func (a *Area) AverageTemperature() (float64, bool) {
+	sum := 0.0
+	count := 0
+
+	for _, device := range a.Devices {
+		if device.LastTemperatureHumidityPressureEvent == nil {
+			continue
+		}
+
+		sum += device.LastTemperatureHumidityPressureEvent.Temperature
+		count++
+	}
+
+	if count == 0 {
+		return 0, false
+	}
+
+	return sum / float64(count), true
+}
@@ -0,0 +1,45 @@
+package hapitypes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/function61/gokit/testing/assert"
+)
+
+func TestAreaOccupancy(t *testing.T) {
+	t0 := time.Date(2021, 5, 14, 12, 0, 0, 0, time.UTC)
+
+	motionSensor := &Device{}
+	kitchen := NewArea(AreaConfig{Id: "kitchen", OccupancyTimeoutSeconds: 60})
+	kitchen.Devices = append(kitchen.Devices, &Device{}, motionSensor)
+
+	assert.Assert(t, !kitchen.UpdateOccupied(t0))
+	assert.Assert(t, !kitchen.Occupied)
+
+	motionSensor.LastMotion = &t0
+
+	assert.Assert(t, kitchen.UpdateOccupied(t0.Add(59*time.Second)))
+	assert.Assert(t, kitchen.Occupied)
+	assert.Assert(t, !kitchen.UpdateOccupied(t0.Add(59*time.Second))) // no change
+
+	assert.Assert(t, kitchen.UpdateOccupied(t0.Add(60*time.Second)))
+	assert.Assert(t, !kitchen.Occupied)
+}
+
+func TestAreaAverageTemperature(t *testing.T) {
+	livingRoom := NewArea(AreaConfig{Id: "livingRoom"})
+
+	_, ok := livingRoom.AverageTemperature()
+	assert.Assert(t, !ok)
+
+	livingRoom.Devices = append(
+		livingRoom.Devices,
+		&Device{LastTemperatureHumidityPressureEvent: NewTemperatureHumidityPressureEvent("a", 20.5, 0, 0)},
+		&Device{},
+		&Device{LastTemperatureHumidityPressureEvent: NewTemperatureHumidityPressureEvent("b", 22.5, 0, 0)})
+
+	avg, ok := livingRoom.AverageTemperature()
+	assert.Assert(t, ok)
+	assert.Assert(t, avg == 21.5)
+}
@@ -60,6 +60,8 @@ type DeviceConfig struct {
 
 	VoiceAssistant bool `json:"voice_assistant,omitempty"`
 
+	Area string `json:"area,omitempty"` // references AreaConfig.Id
+
 	EventghostAddr   string `json:"eventghost_addr,omitempty"` // if specified, we connect to the PC direction for sending events
 	EventghostSecret string `json:"eventghost_secret,omitempty"`
 }
@@ -85,12 +87,15 @@ func (d *DeviceConfig) Class() (*DeviceClass, error) {
 	}
 }
 
-// these are transparently generated to adapter + device combo
+// these are transparently generated to adapter + device combo. the generated device's type
+// is computed from its members (see ResolveDeviceType())
 type DeviceGroupConfig struct {
-	DeviceId string   `json:"device_id"`
-	Name     string   `json:"name"`
-	Devices  []string `json:"devices"`
-	// TODO: opt-in to voice assistants
+	DeviceId       string   `json:"device_id"`
+	Name           string   `json:"name"`
+	Devices        []string `json:"devices"`                // can also contain IDs of other device groups
+	DeviceClassId  string   `json:"device_class,omitempty"` // if not set, computed from members
+	VoiceAssistant bool     `json:"voice_assistant,omitempty"`
+	Area           string   `json:"area,omitempty"`
 }
 
 type Person struct {
@@ -106,6 +111,9 @@ type ActionConfig struct {
 	PlaybackAction  string `json:"playback_action"`  // used by: playback
 	NotifyMessage   string `json:"notify_message"`   // used by: notify
 	SpeakPhrase     string `json:"speak_phrase"`     // used by: speak
+	Priority        string `json:"priority"`         // used by: notify/speak. normal|critical (critical overrides quiet hours)
+	Area            string `json:"area"`             // used by: powerOn/powerOff/powerToggle/blink/playback/cover_up/cover_down. targets all capable devices in the area instead of *device*
+	DeviceClass     string `json:"device_class"`     // used by: area actions, to only target e.g. "Light" devices
 }
 
 type ConditionConfig struct {
@@ -122,10 +130,12 @@ type SubscribeConfig struct {
 
 type ConfigFile struct {
 	Adapters      []AdapterConfig     `json:"adapter"`
+	Areas         []AreaConfig        `json:"area"`
 	Devices       []DeviceConfig      `json:"device"`
 	DeviceGroups  []DeviceGroupConfig `json:"devicegroup"`
 	Persons       []Person            `json:"person"`
 	Subscriptions []SubscribeConfig   `json:"subscribe"`
+	QuietHours    []QuietHoursConfig  `json:"quiethours"` // if none defined, DefaultQuietHours is used
 }
 
 func (c *ConfigFile) FindDeviceConfigByAdaptersDeviceId(adaptersDeviceId string) *DeviceConfig {
@@ -137,3 +147,33 @@ func (c *ConfigFile) FindDeviceConfigByAdaptersDeviceId(adaptersDeviceId string)
 
 	return nil
 }
+
+func (c *ConfigFile) FindDeviceConfig(deviceId string) *DeviceConfig {
+	for _, deviceConfig := range c.Devices {
+		if deviceConfig.DeviceId == deviceId {
+			return &deviceConfig
+		}
+	}
+
+	return nil
+}
+
+func (c *ConfigFile) FindDeviceGroupConfig(deviceId string) *DeviceGroupConfig {
+	for _, deviceGroupConfig := range c.DeviceGroups {
+		if deviceGroupConfig.DeviceId == deviceId {
+			return &deviceGroupConfig
+		}
+	}
+
+	return nil
+}
+
+func (c *ConfigFile) FindAreaConfig(areaId string) *AreaConfig {
+	for _, areaConfig := range c.Areas {
+		if areaConfig.Id == areaId {
+			return &areaConfig
+		}
+	}
+
+	return nil
+}
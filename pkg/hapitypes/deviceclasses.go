@@ -30,6 +30,7 @@ var (
 	DeviceClassDisplay        = &DeviceClass{"Display", homeassistant.IconMonitor, "SCREEN"}
 	DeviceClassSmartPlug      = &DeviceClass{"Smart plug", homeassistant.IconPower, "SMARTPLUG"} // used only if user doesn't specify a specific device connected to the smart plug
 	DeviceClassSleepingSensor = &DeviceClass{"Sleeping sensor", homeassistant.IconSleep, "WEARABLE"}
+	DeviceClassThermostat     = &DeviceClass{"Thermostat", homeassistant.IconThermostat, "THERMOSTAT"}
 )
 
 var DeviceClassById = map[string]*DeviceClass{
@@ -48,4 +49,5 @@ var DeviceClassById = map[string]*DeviceClass{
 	"Display":        DeviceClassDisplay,
 	"SmartPlug":      DeviceClassSmartPlug,
 	"SleepingSensor": DeviceClassSleepingSensor,
+	"Thermostat":     DeviceClassThermostat,
 }
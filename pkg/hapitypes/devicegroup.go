@@ -0,0 +1,120 @@
+package hapitypes
+
+import (
+	"fmt"
+)
+
+// device groups are exposed as devices of this type. the actual type (capabilities, class) is
+// computed from the group's members.
+const DeviceTypeDeviceGroup = "devicegroup"
+
+// resolves device's type. for regular devices this is the same as ResolveDeviceType(), but for
+// device groups the type is computed from the members.
+func (c *ConfigFile) ResolveDeviceType(deviceConf DeviceConfig) (*DeviceType, error) {
+	if deviceConf.Type != DeviceTypeDeviceGroup {
+		return ResolveDeviceType(deviceConf.Type)
+	}
+
+	typ, _, err := c.resolveDeviceGroup(deviceConf.DeviceId, map[string]bool{})
+	return typ, err
+}
+
+// group's capabilities are the intersection of its members' capabilities, so that any command
+// the group accepts can be carried out by all of its members. if all members are of the same
+// device class, the group is of that class as well.
+func (c *ConfigFile) resolveDeviceGroup(
+	groupId string,
+	visiting map[string]bool, // for detecting cycles
+) (*DeviceType, *DeviceClass, error) {
+	group := c.FindDeviceGroupConfig(groupId)
+	if group == nil {
+		return nil, nil, fmt.Errorf("device group not found: %s", groupId)
+	}
+
+	if visiting[groupId] {
+		return nil, nil, fmt.Errorf("device group %s contains itself", groupId)
+	}
+	visiting[groupId] = true
+	defer delete(visiting, groupId)
+
+	if len(group.Devices) == 0 {
+		return nil, nil, fmt.Errorf("device group %s has no devices", groupId)
+	}
+
+	var caps Capabilities
+	var commonClass *DeviceClass
+
+	for idx, memberId := range group.Devices {
+		memberType, memberClass, err := c.resolveDeviceGroupMember(memberId, visiting)
+		if err != nil {
+			return nil, nil, fmt.Errorf("device group %s: %w", groupId, err)
+		}
+
+		if idx == 0 {
+			caps = memberType.Capabilities
+			commonClass = memberClass
+		} else {
+			caps = caps.Intersect(memberType.Capabilities)
+
+			if memberClass != commonClass {
+				commonClass = DeviceClassGeneric
+			}
+		}
+	}
+
+	// the group only relays commands to its members - it doesn't report anything by itself
+	caps.ReportsTemperature = false
+
+	groupType := &DeviceType{
+		Name:         "Device group",
+		Manufacturer: "function61.com",
+		Class:        commonClass,
+		Capabilities: caps,
+	}
+
+	groupClass, err := deviceClassOrOverride(groupType, group.DeviceClassId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("device group %s: %w", groupId, err)
+	}
+
+	return groupType, groupClass, nil
+}
+
+func (c *ConfigFile) resolveDeviceGroupMember(
+	memberId string,
+	visiting map[string]bool,
+) (*DeviceType, *DeviceClass, error) {
+	if c.FindDeviceGroupConfig(memberId) != nil {
+		return c.resolveDeviceGroup(memberId, visiting)
+	}
+
+	member := c.FindDeviceConfig(memberId)
+	if member == nil {
+		return nil, nil, fmt.Errorf("member device not found: %s", memberId)
+	}
+
+	typ, err := ResolveDeviceType(member.Type)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	class, err := deviceClassOrOverride(typ, member.DeviceClassId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return typ, class, nil
+}
+
+func deviceClassOrOverride(typ *DeviceType, deviceClassId string) (*DeviceClass, error) {
+	if deviceClassId == "" {
+		return typ.Class, nil
+	}
+
+	class, found := DeviceClassById[deviceClassId]
+	if !found {
+		return nil, fmt.Errorf("device_class not found: %s", deviceClassId)
+	}
+
+	return class, nil
+}
@@ -0,0 +1,60 @@
+package hapitypes
+
+import (
+	"testing"
+
+	"github.com/function61/gokit/testing/assert"
+)
+
+func TestResolveDeviceGroupType(t *testing.T) {
+	conf := &ConfigFile{
+		Devices: []DeviceConfig{
+			{DeviceId: "rgb", Type: "ikea-trådfri-rgb"},
+			{DeviceId: "noncolored", Type: "ikea-trådfri-noncolored"},
+			{DeviceId: "plug", Type: "ikea-trådfri-smartplug"},
+			{DeviceId: "plugLight", Type: "ikea-trådfri-smartplug", DeviceClassId: "Light"},
+		},
+		DeviceGroups: []DeviceGroupConfig{
+			{DeviceId: "lights", Devices: []string{"rgb", "noncolored"}},
+			{DeviceId: "allLights", Devices: []string{"lights", "plugLight"}},
+			{DeviceId: "everything", Devices: []string{"allLights", "plug"}},
+			{DeviceId: "cycleA", Devices: []string{"cycleB"}},
+			{DeviceId: "cycleB", Devices: []string{"rgb", "cycleA"}},
+			{DeviceId: "broken", Devices: []string{"rgb", "notFound"}},
+		},
+	}
+
+	resolve := func(groupId string) (*DeviceType, error) {
+		return conf.ResolveDeviceType(DeviceConfig{DeviceId: groupId, Type: DeviceTypeDeviceGroup})
+	}
+
+	lights, err := resolve("lights")
+	assert.Ok(t, err)
+	assert.Assert(t, lights.Class == DeviceClassLight)
+	assert.Assert(t, lights.Capabilities == Capabilities{
+		Power:            true,
+		Brightness:       true,
+		ColorTemperature: true,
+	})
+
+	allLights, err := resolve("allLights")
+	assert.Ok(t, err)
+	assert.Assert(t, allLights.Class == DeviceClassLight) // smart plug overridden as light
+	assert.Assert(t, allLights.Capabilities == Capabilities{Power: true})
+
+	everything, err := resolve("everything")
+	assert.Ok(t, err)
+	assert.Assert(t, everything.Class == DeviceClassGeneric)
+	assert.Assert(t, everything.Capabilities == Capabilities{Power: true})
+
+	_, err = resolve("cycleA")
+	assert.EqualString(t, err.Error(), "device group cycleA: device group cycleB: device group cycleA contains itself")
+
+	_, err = resolve("broken")
+	assert.EqualString(t, err.Error(), "device group broken: member device not found: notFound")
+
+	// regular devices resolve as usual
+	plug, err := conf.ResolveDeviceType(conf.Devices[2])
+	assert.Ok(t, err)
+	assert.EqualString(t, plug.Name, "Trådfri smartplug")
+}
@@ -157,6 +157,34 @@ var deviceTypes = map[string]*DeviceType{
 		BatteryType:  "CR2032",
 		Class:        DeviceClassRemote,
 	},
+	"eurotronic-spirit": &DeviceType{
+		Name:         "Spirit Zigbee radiator valve",
+		Manufacturer: "Eurotronic",
+		Model:        "SPZB0001",
+		BatteryType:  "2x AA",
+		Class:        DeviceClassThermostat,
+		Capabilities: Capabilities{
+			ReportsTemperature: true,
+			Heating:            true,
+		},
+	},
+	"danfoss-ally": &DeviceType{
+		Name:         "Ally radiator valve",
+		Manufacturer: "Danfoss",
+		Model:        "014G2461",
+		BatteryType:  "2x AA",
+		Class:        DeviceClassThermostat,
+		Capabilities: Capabilities{
+			ReportsTemperature: true,
+			Heating:            true,
+		},
+	},
+	"heiman-gas-sensor": &DeviceType{
+		Name:         "Combustible gas sensor",
+		Manufacturer: "HEIMAN",
+		Model:        "HS3CG",
+		Class:        DeviceClassSensor,
+	},
 	"eventghostClient": &DeviceType{
 		Name:         "EventGhost client",
 		Manufacturer: "EventGhost",
@@ -211,4 +239,21 @@ type Capabilities struct {
 	ReportsTemperature        bool `json:"reports_temperature"`
 	VirtualSwitch             bool `json:"virtual_switch"` // can send fake contact sensor triggers to Alexa to trigger routines
 	CoverPosition             bool `json:"cover_position"`
+	Heating                   bool `json:"heating"` // thermostat or radiator valve with a controllable setpoint
+}
+
+// capabilities that both have
+func (c Capabilities) Intersect(other Capabilities) Capabilities {
+	return Capabilities{
+		Power:                     c.Power && other.Power,
+		Brightness:                c.Brightness && other.Brightness,
+		Color:                     c.Color && other.Color,
+		ColorTemperature:          c.ColorTemperature && other.ColorTemperature,
+		ColorSeparateWhiteChannel: c.ColorSeparateWhiteChannel && other.ColorSeparateWhiteChannel,
+		Playback:                  c.Playback && other.Playback,
+		ReportsTemperature:        c.ReportsTemperature && other.ReportsTemperature,
+		VirtualSwitch:             c.VirtualSwitch && other.VirtualSwitch,
+		CoverPosition:             c.CoverPosition && other.CoverPosition,
+		Heating:                   c.Heating && other.Heating,
+	}
 }
@@ -3,10 +3,10 @@ package hapitypes
 type MotionEvent struct {
 	Device      string
 	Movement    bool
-	Illuminance uint
+	Illuminance *uint // nil if the sensor has no lux sensor
 }
 
-func NewMotionEvent(deviceId string, movement bool, illuminance uint) *MotionEvent {
+func NewMotionEvent(deviceId string, movement bool, illuminance *uint) *MotionEvent {
 	return &MotionEvent{
 		Device:      deviceId,
 		Movement:    movement,
@@ -1,12 +1,13 @@
 package hapitypes
 
 type NotificationEvent struct {
-	Device  string
-	Message string
+	Device   string
+	Message  string
+	Priority Priority
 }
 
-func NewNotificationEvent(device string, message string) *NotificationEvent {
-	return &NotificationEvent{device, message}
+func NewNotificationEvent(device string, message string, priority Priority) *NotificationEvent {
+	return &NotificationEvent{device, message, priority}
 }
 
 func (e *NotificationEvent) InboundEventType() string {
@@ -18,5 +19,5 @@ func (e *NotificationEvent) OutboundEventType() string {
 }
 
 func (e *NotificationEvent) RedirectInbound(toDeviceId string) InboundEvent {
-	return NewNotificationEvent(toDeviceId, e.Message)
+	return NewNotificationEvent(toDeviceId, e.Message, e.Priority)
 }
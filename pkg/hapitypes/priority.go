@@ -0,0 +1,25 @@
+package hapitypes
+
+import (
+	"fmt"
+)
+
+// how urgent a message for a human (speech, notification) is
+type Priority int
+
+const (
+	PriorityNormal   Priority = iota // subject to quiet hours
+	PriorityCritical                 // delivered even during quiet hours (e.g. water leak)
+)
+
+// "" is accepted as normal priority so that config doesn't have to specify it
+func ParsePriority(priority string) (Priority, error) {
+	switch priority {
+	case "", "normal":
+		return PriorityNormal, nil
+	case "critical":
+		return PriorityCritical, nil
+	default:
+		return PriorityNormal, fmt.Errorf("unknown priority: %s", priority)
+	}
+}
@@ -0,0 +1,121 @@
+package hapitypes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// during quiet hours we don't want speech or notifications (unless they're critical) to
+// wake up people. a quiet hours window is active either by time of day or by a boolean.
+type QuietHoursConfig struct {
+	Days    []string `json:"days,omitempty"`    // "mon", "tue", ... if empty, applies to every day. for windows crossing midnight, the day is the one the window starts on
+	Start   string   `json:"start,omitempty"`   // "22:00" (local time)
+	End     string   `json:"end,omitempty"`     // "08:00" (local time). can be smaller than start, meaning the window crosses midnight
+	Boolean string   `json:"boolean,omitempty"` // if set, window is active whenever this boolean is true (e.g. "guestsSleeping")
+}
+
+// used when there are no quiet hours configured. matches the historical hardcoded behaviour
+// of not disturbing anyone outside of 8:00-21:59.
+var DefaultQuietHours = []QuietHoursConfig{
+	{Start: "22:00", End: "08:00"},
+}
+
+func (q QuietHoursConfig) Valid() error {
+	if q.Boolean != "" {
+		if q.Start != "" || q.End != "" || len(q.Days) > 0 {
+			return fmt.Errorf("quiethours: boolean '%s' cannot be combined with time of day", q.Boolean)
+		}
+
+		return nil
+	}
+
+	if _, err := parseTimeOfDay(q.Start); err != nil {
+		return fmt.Errorf("quiethours: start: %w", err)
+	}
+
+	if _, err := parseTimeOfDay(q.End); err != nil {
+		return fmt.Errorf("quiethours: end: %w", err)
+	}
+
+	for _, day := range q.Days {
+		if _, err := parseWeekday(day); err != nil {
+			return fmt.Errorf("quiethours: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// returns true if *t* is within this time-of-day window. boolean-based windows never match
+// here - they have to be evaluated by someone who knows the booleans' state.
+func (q QuietHoursConfig) CoversTime(t time.Time) bool {
+	if q.Boolean != "" {
+		return false
+	}
+
+	// errors already checked by Valid()
+	start, _ := parseTimeOfDay(q.Start)
+	end, _ := parseTimeOfDay(q.End)
+
+	t = t.Local()
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+
+	if start <= end { // same-day window, e.g. 13:00-15:00
+		return now >= start && now < end && q.appliesOn(t.Weekday())
+	}
+
+	// crosses midnight, e.g. 22:00-08:00. the part after midnight belongs to the previous day
+	if now >= start {
+		return q.appliesOn(t.Weekday())
+	}
+
+	if now < end {
+		return q.appliesOn(t.AddDate(0, 0, -1).Weekday())
+	}
+
+	return false
+}
+
+func (q QuietHoursConfig) appliesOn(weekday time.Weekday) bool {
+	if len(q.Days) == 0 {
+		return true
+	}
+
+	for _, day := range q.Days {
+		if candidate, _ := parseWeekday(day); candidate == weekday {
+			return true
+		}
+	}
+
+	return false
+}
+
+// "22:30" => 22h30m
+func parseTimeOfDay(hhmm string) (time.Duration, error) {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day (expecting HH:MM): %s", hhmm)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+var weekdayByName = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func parseWeekday(day string) (time.Weekday, error) {
+	weekday, found := weekdayByName[strings.ToLower(day)]
+	if !found {
+		return time.Sunday, fmt.Errorf("unknown day: %s", day)
+	}
+
+	return weekday, nil
+}
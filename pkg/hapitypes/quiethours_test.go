@@ -0,0 +1,60 @@
+package hapitypes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/function61/gokit/testing/assert"
+)
+
+func TestQuietHoursCoversTime(t *testing.T) {
+	// 2021-05-14 is a friday
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2021, 5, day, hour, minute, 0, 0, time.Local)
+	}
+
+	nightly := QuietHoursConfig{Start: "22:00", End: "08:00"}
+	assert.Ok(t, nightly.Valid())
+
+	assert.Assert(t, !nightly.CoversTime(at(14, 21, 59)))
+	assert.Assert(t, nightly.CoversTime(at(14, 22, 0)))
+	assert.Assert(t, nightly.CoversTime(at(15, 7, 59)))
+	assert.Assert(t, !nightly.CoversTime(at(15, 8, 0)))
+
+	weekendMornings := QuietHoursConfig{Days: []string{"sat", "sun"}, Start: "23:00", End: "10:00"}
+	assert.Ok(t, weekendMornings.Valid())
+
+	assert.Assert(t, !weekendMornings.CoversTime(at(14, 23, 30))) // fri night
+	assert.Assert(t, !weekendMornings.CoversTime(at(15, 9, 0)))   // sat morning, but window started on friday
+	assert.Assert(t, weekendMornings.CoversTime(at(15, 23, 30)))  // sat night
+	assert.Assert(t, weekendMornings.CoversTime(at(16, 9, 0)))    // sun morning
+	assert.Assert(t, weekendMornings.CoversTime(at(17, 9, 0)))    // mon morning, but window started on sunday
+	assert.Assert(t, !weekendMornings.CoversTime(at(18, 9, 0)))   // tue morning
+
+	afternoonNap := QuietHoursConfig{Start: "13:00", End: "15:00"}
+	assert.Assert(t, afternoonNap.CoversTime(at(14, 14, 0)))
+	assert.Assert(t, !afternoonNap.CoversTime(at(14, 15, 0)))
+
+	guests := QuietHoursConfig{Boolean: "guestsSleeping"}
+	assert.Ok(t, guests.Valid())
+	assert.Assert(t, !guests.CoversTime(at(14, 23, 0)))
+}
+
+func TestQuietHoursValid(t *testing.T) {
+	assert.EqualString(t, QuietHoursConfig{Start: "22", End: "08:00"}.Valid().Error(), "quiethours: start: invalid time of day (expecting HH:MM): 22")
+	assert.EqualString(t, QuietHoursConfig{Start: "22:00", End: "08:00", Days: []string{"caturday"}}.Valid().Error(), "quiethours: unknown day: caturday")
+	assert.EqualString(t, QuietHoursConfig{Boolean: "guestsSleeping", Start: "22:00"}.Valid().Error(), "quiethours: boolean 'guestsSleeping' cannot be combined with time of day")
+}
+
+func TestParsePriority(t *testing.T) {
+	priority, err := ParsePriority("")
+	assert.Ok(t, err)
+	assert.Assert(t, priority == PriorityNormal)
+
+	priority, err = ParsePriority("critical")
+	assert.Ok(t, err)
+	assert.Assert(t, priority == PriorityCritical)
+
+	_, err = ParsePriority("urgent")
+	assert.EqualString(t, err.Error(), "unknown priority: urgent")
+}
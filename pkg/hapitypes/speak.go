@@ -1,12 +1,13 @@
 package hapitypes
 
 type SpeakEvent struct {
-	Device  string
-	Message string
+	Device   string
+	Message  string
+	Priority Priority
 }
 
-func NewSpeakEvent(deviceId string, message string) *SpeakEvent {
-	return &SpeakEvent{deviceId, message}
+func NewSpeakEvent(deviceId string, message string, priority Priority) *SpeakEvent {
+	return &SpeakEvent{deviceId, message, priority}
 }
 
 func (e *SpeakEvent) InboundEventType() string {
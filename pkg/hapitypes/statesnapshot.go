@@ -1,15 +1,26 @@
 package hapitypes
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"time"
 )
 
+// bump this when changing the statefile format in a way that needs a migration in statefileMigrations
+const StatefileVersion = 2
+
 type Statefile struct {
+	Version int                            `json:"version"`
 	Devices map[string]DeviceStateSnapshot `json:"device_state_snapshots_by_id"`
 }
 
 func NewStatefile() Statefile {
 	return Statefile{
+		Version: StatefileVersion,
 		Devices: map[string]DeviceStateSnapshot{},
 	}
 }
@@ -19,19 +30,40 @@ func NewStatefile() Statefile {
 type DeviceStateSnapshot struct {
 	ProbablyTurnedOn                     bool                              `json:"probably_turned_on"`
 	LastColor                            RGB                               `json:"last_color"`
+	LastBrightness                       *uint                             `json:"last_brightness,omitempty"`
 	LastTemperatureHumidityPressureEvent *TemperatureHumidityPressureEvent `json:"last_temperaturehumiditypressure"`
 	LastOnline                           *time.Time                        `json:"last_online"`
+	LastMotion                           *time.Time                        `json:"last_motion,omitempty"`
+	LastContact                          *ContactSnapshot                  `json:"last_contact,omitempty"`
+	LastExplicitPowerEvent               *time.Time                        `json:"last_explicit_power_event,omitempty"`
 	LinkQuality                          uint                              `json:"link_quality_pct"`
 	BatteryPct                           uint                              `json:"battery_pct"`
 	BatteryVoltage                       uint                              `json:"battery_voltage_mv"`
 }
 
+type ContactSnapshot struct {
+	Contact bool      `json:"contact"`
+	When    time.Time `json:"when"`
+}
+
 func (d *Device) SnapshotState() (*DeviceStateSnapshot, error) {
+	var lastContact *ContactSnapshot
+	if d.LastContact != nil {
+		lastContact = &ContactSnapshot{
+			Contact: d.LastContact.Contact,
+			When:    d.LastContact.When,
+		}
+	}
+
 	return &DeviceStateSnapshot{
 		ProbablyTurnedOn:                     d.ProbablyTurnedOn,
 		LastColor:                            d.LastColor,
+		LastBrightness:                       d.LastBrightness,
 		LastTemperatureHumidityPressureEvent: d.LastTemperatureHumidityPressureEvent,
 		LastOnline:                           d.LastOnline,
+		LastMotion:                           d.LastMotion,
+		LastContact:                          lastContact,
+		LastExplicitPowerEvent:               d.LastExplicitPowerEvent,
 		LinkQuality:                          d.LinkQuality,
 		BatteryPct:                           d.BatteryPct,
 		BatteryVoltage:                       d.BatteryVoltage,
@@ -41,11 +73,123 @@ func (d *Device) SnapshotState() (*DeviceStateSnapshot, error) {
 func (d *Device) RestoreStateFromSnapshot(snapshot DeviceStateSnapshot) error {
 	d.ProbablyTurnedOn = snapshot.ProbablyTurnedOn
 	d.LastColor = snapshot.LastColor
+	d.LastBrightness = snapshot.LastBrightness
 	d.LastTemperatureHumidityPressureEvent = snapshot.LastTemperatureHumidityPressureEvent
 	d.LastOnline = snapshot.LastOnline
+	d.LastMotion = snapshot.LastMotion
+	d.LastExplicitPowerEvent = snapshot.LastExplicitPowerEvent
 	d.LinkQuality = snapshot.LinkQuality
 	d.BatteryPct = snapshot.BatteryPct
 	d.BatteryVoltage = snapshot.BatteryVoltage
 
+	d.LastContact = nil
+	if snapshot.LastContact != nil {
+		d.LastContact = NewContactEvent(
+			d.Conf.DeviceId,
+			snapshot.LastContact.Contact,
+			snapshot.LastContact.When)
+	}
+
 	return nil
 }
+
+// migrates raw JSON from version <key> to <key>+1. operating on raw JSON means we don't have to
+// keep Go structs around for old versions.
+var statefileMigrations = map[int]func(raw map[string]interface{}) error{
+	// v1 had no "version" field. v2 added last_brightness, last_motion, last_contact and
+	// last_explicit_power_event, all of which are optional.
+	1: func(raw map[string]interface{}) error {
+		return nil
+	},
+}
+
+// older versions are migrated to StatefileVersion. unknown fields are allowed, so adding
+// fields doesn't break reading files written by newer versions.
+func ReadStatefile(path string) (*Statefile, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("ReadStatefile: %w", err)
+	}
+
+	version := 1 // v1 didn't have the version field
+	if versionRaw, has := raw["version"]; has {
+		versionFloat, ok := versionRaw.(float64)
+		if !ok {
+			return nil, fmt.Errorf("ReadStatefile: invalid version: %v", versionRaw)
+		}
+
+		version = int(versionFloat)
+	}
+
+	if version > StatefileVersion {
+		return nil, fmt.Errorf(
+			"ReadStatefile: file is version %d but we only support up to %d",
+			version,
+			StatefileVersion)
+	}
+
+	for ; version < StatefileVersion; version++ {
+		migrate, found := statefileMigrations[version]
+		if !found {
+			return nil, fmt.Errorf("ReadStatefile: no migration from version %d", version)
+		}
+
+		if err := migrate(raw); err != nil {
+			return nil, fmt.Errorf("ReadStatefile: migrating from version %d: %w", version, err)
+		}
+	}
+
+	raw["version"] = StatefileVersion
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	statefile := NewStatefile()
+	if err := json.Unmarshal(migrated, &statefile); err != nil {
+		return nil, fmt.Errorf("ReadStatefile: %w", err)
+	}
+
+	return &statefile, nil
+}
+
+// writes to a temp file first and then renames it over the old one, so that we never end up
+// with a half-written statefile if we crash while writing
+func WriteStatefile(path string, statefile Statefile) error {
+	statefile.Version = StatefileVersion
+
+	content := &bytes.Buffer{}
+	enc := json.NewEncoder(content)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&statefile); err != nil {
+		return err
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name()) // no-op if rename succeeded
+
+	if _, err := tempFile.Write(content.Bytes()); err != nil {
+		tempFile.Close()
+		return err
+	}
+
+	if err := tempFile.Sync(); err != nil {
+		tempFile.Close()
+		return err
+	}
+
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tempFile.Name(), path)
+}
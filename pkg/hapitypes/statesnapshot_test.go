@@ -0,0 +1,91 @@
+package hapitypes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/function61/gokit/testing/assert"
+)
+
+func TestStatefileMigrationFromV1(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statefile")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state-snapshot.json")
+
+	// v1 didn't have version field, and has an unknown field that must not break reading
+	assert.Ok(t, ioutil.WriteFile(path, []byte(`{
+  "device_state_snapshots_by_id": {
+    "kitchenLight": {
+      "probably_turned_on": true,
+      "last_color": {"Red": 255, "Green": 0, "Blue": 0},
+      "removed_field": 123
+    }
+  }
+}`), 0600))
+
+	statefile, err := ReadStatefile(path)
+	assert.Ok(t, err)
+	assert.Assert(t, statefile.Version == StatefileVersion)
+	assert.Assert(t, statefile.Devices["kitchenLight"].ProbablyTurnedOn)
+	assert.Assert(t, statefile.Devices["kitchenLight"].LastColor == NewRGB(255, 0, 0))
+}
+
+func TestStatefileRoundtrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statefile")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state-snapshot.json")
+
+	t0 := time.Date(2021, 5, 14, 12, 0, 0, 0, time.UTC)
+	brightness := uint(42)
+
+	device := &Device{Conf: DeviceConfig{DeviceId: "frontDoor"}}
+	device.LastMotion = &t0
+	device.LastExplicitPowerEvent = &t0
+	device.LastBrightness = &brightness
+	device.LastContact = NewContactEvent("frontDoor", true, t0)
+
+	snapshot, err := device.SnapshotState()
+	assert.Ok(t, err)
+
+	statefile := NewStatefile()
+	statefile.Devices["frontDoor"] = *snapshot
+	assert.Ok(t, WriteStatefile(path, statefile))
+
+	// no temp files left behind
+	files, err := ioutil.ReadDir(dir)
+	assert.Ok(t, err)
+	assert.Assert(t, len(files) == 1)
+
+	restoredStatefile, err := ReadStatefile(path)
+	assert.Ok(t, err)
+
+	restored := &Device{Conf: DeviceConfig{DeviceId: "frontDoor"}}
+	assert.Ok(t, restored.RestoreStateFromSnapshot(restoredStatefile.Devices["frontDoor"]))
+
+	assert.Assert(t, restored.LastMotion.Equal(t0))
+	assert.Assert(t, restored.LastExplicitPowerEvent.Equal(t0))
+	assert.Assert(t, *restored.LastBrightness == 42)
+	assert.EqualString(t, restored.LastContact.Device, "frontDoor")
+	assert.Assert(t, restored.LastContact.Contact)
+	assert.Assert(t, restored.LastContact.When.Equal(t0))
+}
+
+func TestStatefileFromFuture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statefile")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state-snapshot.json")
+
+	assert.Ok(t, ioutil.WriteFile(path, []byte(`{"version": 99}`), 0600))
+
+	_, err = ReadStatefile(path)
+	assert.EqualString(t, err.Error(), "ReadStatefile: file is version 99 but we only support up to 2")
+}
@@ -65,16 +65,24 @@ type Device struct {
 	// might be turned on even if false,
 	ProbablyTurnedOn bool
 
-	LastColor RGB
+	LastColor      RGB
+	LastBrightness *uint // 0..100 %. nil if never set
 
 	LastTemperatureHumidityPressureEvent *TemperatureHumidityPressureEvent
 
 	// metrics
-	LinkQualityMetric *constmetrics.Ref
-	TemperatureMetric *constmetrics.Ref
-	HumidityMetric    *constmetrics.Ref
-	PressureMetric    *constmetrics.Ref
-	BatteryPctMetric  *constmetrics.Ref
+	LinkQualityMetric  *constmetrics.Ref
+	TemperatureMetric  *constmetrics.Ref
+	HumidityMetric     *constmetrics.Ref
+	PressureMetric     *constmetrics.Ref
+	BatteryPctMetric   *constmetrics.Ref
+	IlluminanceMetric  *constmetrics.Ref
+	MotionMetric       *constmetrics.Ref
+	MotionCountMetric  *constmetrics.Ref
+	ContactMetric      *constmetrics.Ref
+	ContactCountMetric *constmetrics.Ref
+	WaterLeakMetric    *constmetrics.Ref
+	PowerMetric        *constmetrics.Ref
 
 	LastOnline             *time.Time
 	LastMotion             *time.Time
@@ -86,20 +94,29 @@ type Device struct {
 	BatteryVoltage uint // [mV]
 }
 
-func NewDevice(conf DeviceConfig, snapshot DeviceStateSnapshot) (*Device, error) {
-	deviceType, err := ResolveDeviceType(conf.Type)
-	if err != nil {
-		return nil, err
-	}
-
+// deviceType is given explicitly because device groups' types are computed (see ConfigFile.ResolveDeviceType())
+func NewDevice(conf DeviceConfig, deviceType DeviceType, snapshot DeviceStateSnapshot) (*Device, error) {
 	d := &Device{
 		Conf:       conf,
-		DeviceType: *deviceType,
+		DeviceType: deviceType,
 	}
 
 	return d, d.RestoreStateFromSnapshot(snapshot)
 }
 
+// device's explicitly set device class or if not set, device class from device type
+func (d *Device) Class() *DeviceClass {
+	if class, found := DeviceClassById[d.Conf.DeviceClassId]; found {
+		return class
+	}
+
+	return d.DeviceType.Class
+}
+
+func (d *Device) IsGroup() bool {
+	return d.Conf.Type == DeviceTypeDeviceGroup
+}
+
 type DeviceGroup struct {
 	Id        string
 	Name      string
@@ -15,6 +15,10 @@ const (
 	ComponentCamera        Component = "camera"
 	ComponentBinarySensor  Component = "binary_sensor"
 	ComponentDeviceTracker Component = "device_tracker"
+	ComponentClimate       Component = "climate"
+	ComponentSiren         Component = "siren"
+	ComponentLock          Component = "lock"
+	ComponentFan           Component = "fan"
 )
 
 // NOTE: device classes are platform-specific, i.e. "door" device class is only recognized by
@@ -27,9 +31,26 @@ const (
 	DeviceClassTemperature = "temperature" // component=sensor
 	DeviceClassHumidity    = "humidity"    // component=sensor
 	DeviceClassPressure    = "pressure"    // component=sensor
-	DeviceClassBattery     = "battery"     // component=sensor
+	DeviceClassBattery     = "battery"     // component=sensor | binary_sensor (on = low)
 	DeviceClassIlluminance = "illuminance" // component=sensor
 	DeviceClassShade       = "shade"       // component=cover
+	DeviceClassPower       = "power"       // component=sensor
+	DeviceClassVoltage     = "voltage"     // component=sensor
+	DeviceClassCurrent     = "current"     // component=sensor
+	DeviceClassEnergy      = "energy"      // component=sensor
+
+	// alarm sensors
+	DeviceClassSmoke          = "smoke"           // component=binary_sensor
+	DeviceClassCarbonMonoxide = "carbon_monoxide" // component=binary_sensor
+	DeviceClassGas            = "gas"             // component=binary_sensor
+	DeviceClassMoisture       = "moisture"        // component=binary_sensor
+	DeviceClassTamper         = "tamper"          // component=binary_sensor
+)
+
+// sensors with a state class get long-term statistics (needed e.g. for the energy dashboard)
+const (
+	StateClassMeasurement     = "measurement"      // current value, e.g. power
+	StateClassTotalIncreasing = "total_increasing" // meter reading, e.g. energy. drop to zero is taken as a meter reset
 )
 
 // keys: https://www.home-assistant.io/docs/mqtt/discovery/#configuration-variables
@@ -47,6 +68,7 @@ type DiscoveryOptions struct {
 	UniqueId            string      `json:"unique_id,omitempty"`   // really important to specify (otherwise you can't customize the entity in H-A)
 	Icon                IconId      `json:"icon,omitempty"`        // e.g. "mdi:gesture-double-tap"
 	UnitOfMeasurement   string      `json:"unit_of_measurement,omitempty"`
+	StateClass          string      `json:"state_class,omitempty"` // component=sensor
 
 	Schema string `json:"schema,omitempty"` // use 'json' to receive commands in JSON. only applicable for controllable things, like lights (at least not applicable for sensors)
 
@@ -57,6 +79,64 @@ type DiscoveryOptions struct {
 	PositionOpen   *int `json:"position_open,omitempty"`
 	PositionClosed *int `json:"position_closed,omitempty"`
 
+	// cover tilt. set *TiltMin* > *TiltMax* to invert the direction
+
+	TiltCommandTopic    string `json:"tilt_command_topic,omitempty"`
+	TiltCommandTemplate string `json:"tilt_command_template,omitempty"` // gets variable *tilt_position*
+	TiltStatusTopic     string `json:"tilt_status_topic,omitempty"`
+	TiltStatusTemplate  string `json:"tilt_status_template,omitempty"`
+	TiltMin             *int   `json:"tilt_min,omitempty"`
+	TiltMax             *int   `json:"tilt_max,omitempty"`
+	TiltOpenedValue     *int   `json:"tilt_opened_value,omitempty"`
+	TiltClosedValue     *int   `json:"tilt_closed_value,omitempty"`
+
+	// lock. state is read from *StateTopic* with *ValueTemplate*
+
+	PayloadLock   string `json:"payload_lock,omitempty"`
+	PayloadUnlock string `json:"payload_unlock,omitempty"`
+	StateLocked   string `json:"state_locked,omitempty"`
+	StateUnlocked string `json:"state_unlocked,omitempty"`
+
+	// fan. on/off state is read from *StateTopic* with *StateValueTemplate*
+
+	StateValueTemplate        string   `json:"state_value_template,omitempty"`
+	PercentageStateTopic      string   `json:"percentage_state_topic,omitempty"`
+	PercentageValueTemplate   string   `json:"percentage_value_template,omitempty"` // speed in *SpeedRangeMin*..*SpeedRangeMax*
+	PercentageCommandTopic    string   `json:"percentage_command_topic,omitempty"`
+	PercentageCommandTemplate string   `json:"percentage_command_template,omitempty"`
+	SpeedRangeMin             int      `json:"speed_range_min,omitempty"`
+	SpeedRangeMax             int      `json:"speed_range_max,omitempty"`
+	PresetModeStateTopic      string   `json:"preset_mode_state_topic,omitempty"`
+	PresetModeValueTemplate   string   `json:"preset_mode_value_template,omitempty"`
+	PresetModeCommandTopic    string   `json:"preset_mode_command_topic,omitempty"`
+	PresetModeCommandTemplate string   `json:"preset_mode_command_template,omitempty"`
+	PresetModes               []string `json:"preset_modes,omitempty"`
+
+	// climate. current values are read from *StateTopic* with the templates
+
+	ModeStateTemplate          string   `json:"mode_state_template,omitempty"`
+	ModeStateTopic             string   `json:"mode_state_topic,omitempty"`
+	ModeCommandTopic           string   `json:"mode_command_topic,omitempty"`
+	ModeCommandTemplate        string   `json:"mode_command_template,omitempty"`
+	Modes                      []string `json:"modes,omitempty"` // "off" | "auto" | "heat" | ...
+	TemperatureStateTopic      string   `json:"temperature_state_topic,omitempty"`
+	TemperatureStateTemplate   string   `json:"temperature_state_template,omitempty"` // setpoint
+	TemperatureCommandTopic    string   `json:"temperature_command_topic,omitempty"`
+	TemperatureCommandTemplate string   `json:"temperature_command_template,omitempty"`
+	CurrentTemperatureTopic    string   `json:"current_temperature_topic,omitempty"`
+	CurrentTemperatureTemplate string   `json:"current_temperature_template,omitempty"`
+	ActionTopic                string   `json:"action_topic,omitempty"`
+	ActionTemplate             string   `json:"action_template,omitempty"` // "off" | "heating" | "cooling" | "idle" | ...
+	MinTemp                    float64  `json:"min_temp,omitempty"`
+	MaxTemp                    float64  `json:"max_temp,omitempty"`
+	TempStep                   float64  `json:"temp_step,omitempty"`
+
+	// siren. command template gets variables *value* (payload on/off), *tone* and *duration*
+
+	CommandTemplate string   `json:"command_template,omitempty"`
+	AvailableTones  []string `json:"available_tones,omitempty"`
+	SupportDuration bool     `json:"support_duration,omitempty"`
+
 	// capabilities, when using these you probably need schema=json
 
 	Brightness bool `json:"brightness,omitempty"` // can control brightness
@@ -131,6 +211,26 @@ func NewCameraEntity(id string, name string, opts DiscoveryOptions) *Entity {
 	return NewEntityWithDiscoveryOpts(id, ComponentCamera, name, opts)
 }
 
+// https://www.home-assistant.io/integrations/climate.mqtt/
+func NewClimateEntity(id string, name string, opts DiscoveryOptions) *Entity {
+	return NewEntityWithDiscoveryOpts(id, ComponentClimate, name, opts)
+}
+
+// https://www.home-assistant.io/integrations/siren.mqtt/
+func NewSirenEntity(id string, name string, opts DiscoveryOptions) *Entity {
+	return NewEntityWithDiscoveryOpts(id, ComponentSiren, name, opts)
+}
+
+// https://www.home-assistant.io/integrations/lock.mqtt/
+func NewLockEntity(id string, name string, opts DiscoveryOptions) *Entity {
+	return NewEntityWithDiscoveryOpts(id, ComponentLock, name, opts)
+}
+
+// https://www.home-assistant.io/integrations/fan.mqtt/
+func NewFanEntity(id string, name string, opts DiscoveryOptions) *Entity {
+	return NewEntityWithDiscoveryOpts(id, ComponentFan, name, opts)
+}
+
 // https://www.home-assistant.io/integrations/device_tracker
 func NewDeviceTrackerEntity(id string, name string, opts DiscoveryOptions) *Entity {
 	return NewEntityWithDiscoveryOpts(id, ComponentDeviceTracker, name, opts)